@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/ziren926/van-nav/config"
+	"github.com/ziren926/van-nav/database"
+	"github.com/ziren926/van-nav/service"
+	"github.com/ziren926/van-nav/types"
+	"github.com/ziren926/van-nav/utils"
+)
+
+// 子命令，执行完就退出。返回 false 表示不是子命令，正常启动服务
+func runCommand(args []string) bool {
+	if len(args) == 0 {
+		return false
+	}
+	switch args[0] {
+	case "setup":
+		os.Exit(setupCommand(args[1:]))
+	case "migrate":
+		os.Exit(migrateCommand(args[1:]))
+	case "doctor":
+		os.Exit(doctorCommand(args[1:]))
+	case "backup":
+		os.Exit(backupCommand(args[1:]))
+	}
+	return false
+}
+
+// 子命令用和服务一样的配置文件和环境变量，命令行只支持 -config
+func loadCommandConfig(path string) bool {
+	cfg, err := config.LoadFile(path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "配置错误:", err)
+		return false
+	}
+	applyDatabaseConfig(cfg)
+	return true
+}
+
+const configUsage = "配置文件的路径，默认用 VAN_NAV_CONFIG 环境变量"
+
+// van-nav setup -name admin [-password xxx] [-title xxx]，不传 -password 时从标准输入读一行
+func setupCommand(args []string) int {
+	flags := flag.NewFlagSet("setup", flag.ExitOnError)
+	name := flags.String("name", "", "管理员用户名")
+	password := flags.String("password", "", "管理员密码，不传则从标准输入读取")
+	title := flags.String("title", "", "网站标题")
+	configPath := flags.String("config", "", configUsage)
+	flags.Parse(args)
+	if !loadCommandConfig(*configPath) {
+		return 1
+	}
+
+	if *password == "" {
+		fmt.Fprint(os.Stderr, "请输入密码: ")
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			fmt.Fprintln(os.Stderr, "读取密码失败:", err)
+			return 1
+		}
+		*password = strings.TrimRight(line, "\r\n")
+	}
+	if err := database.InitDB(); err != nil {
+		fmt.Fprintln(os.Stderr, "初始化数据库失败:", err)
+		return 1
+	}
+	user, err := service.CompleteSetup(types.SetupDto{Name: *name, Password: *password, Title: *title})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "初始化失败:", err)
+		return 1
+	}
+	fmt.Printf("初始化完成，管理员: %s\n", user.Name)
+	return 0
+}
+
+// van-nav migrate [status|up|down|force]，不带参数就是 status
+//
+//	up [-dry-run]              执行所有还没执行的迁移
+//	down [-steps 1] [-dry-run] 回滚最近的迁移
+//	force <版本号>             检查过数据库的实际结构后，标记成这个版本并清除 dirty
+func migrateCommand(args []string) int {
+	action := "status"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		action, args = args[0], args[1:]
+	}
+	flags := flag.NewFlagSet("migrate "+action, flag.ExitOnError)
+	dryRun := flags.Bool("dry-run", false, "只检查能不能执行成功，执行完就回滚")
+	steps := flags.Int("steps", 1, "回滚的迁移个数")
+	configPath := flags.String("config", "", configUsage)
+	flags.Parse(args)
+	if !loadCommandConfig(*configPath) {
+		return 1
+	}
+
+	if err := database.OpenDB(); err != nil {
+		fmt.Fprintln(os.Stderr, "打开数据库失败:", err)
+		return 1
+	}
+	var list []database.Migration
+	var err error
+	switch action {
+	case "status":
+		return migrateStatus()
+	case "up":
+		list, err = database.MigrateUp(*dryRun)
+	case "down":
+		if *steps < 1 {
+			fmt.Fprintln(os.Stderr, "-steps 至少是 1")
+			return 1
+		}
+		list, err = database.MigrateDown(*steps, *dryRun)
+	case "force":
+		version, convErr := strconv.Atoi(flags.Arg(0))
+		if convErr != nil {
+			fmt.Fprintln(os.Stderr, "用法: van-nav migrate force <版本号>")
+			return 1
+		}
+		if err = database.ForceSchemaVersion(version); err != nil {
+			fmt.Fprintln(os.Stderr, "修改版本失败:", err)
+			return 1
+		}
+		fmt.Printf("已将数据库版本标记为 %d\n", version)
+		return 0
+	default:
+		fmt.Fprintf(os.Stderr, "未知的操作: %s，可用的有 status、up、down、force\n", action)
+		return 1
+	}
+
+	// 真正执行时日志里已经有每个迁移了
+	if *dryRun {
+		verb := map[string]string{"up": "可以执行", "down": "可以回滚"}[action]
+		for _, migration := range list {
+			fmt.Printf("%s %d_%s\n", verb, migration.Version, migration.Name)
+		}
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if len(list) == 0 {
+		fmt.Println("没有需要处理的迁移")
+	} else if *dryRun {
+		fmt.Println("dry-run，没有修改数据库")
+	}
+	return 0
+}
+
+func migrateStatus() int {
+	version, dirty, err := database.SchemaVersion()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "读取数据库版本失败:", err)
+		return 1
+	}
+	statuses, err := database.MigrationsStatus()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "读取迁移记录失败:", err)
+		return 1
+	}
+	fmt.Printf("当前版本: %d，程序支持的最新版本: %d\n", version, database.LatestSchemaVersion())
+	for _, status := range statuses {
+		state := "未执行"
+		if status.Dirty {
+			state = "dirty，没有完成"
+		} else if status.Applied {
+			state = "已执行 " + status.AppliedAt.Format("2006-01-02 15:04:05")
+		}
+		fmt.Printf("%4d  %-24s %s\n", status.Version, status.Name, state)
+	}
+	if dirty {
+		fmt.Println("有没完成的迁移，服务不会启动。检查数据库后用 van-nav migrate force <版本号> 标记实际的版本")
+	}
+	return 0
+}
+
+// van-nav doctor [-fix]，检查数据库，-fix 时修复能自动修复的问题。有没解决的问题时退出码是 1
+func doctorCommand(args []string) int {
+	flags := flag.NewFlagSet("doctor", flag.ExitOnError)
+	fix := flags.Bool("fix", false, "修复能自动修复的问题：补上缺少的表、列和索引，补上工具引用的分类，删除没用的图标缓存")
+	configPath := flags.String("config", "", configUsage)
+	flags.Parse(args)
+	if !loadCommandConfig(*configPath) {
+		return 1
+	}
+
+	// 不执行迁移，迁移没完成的库也要能检查
+	if err := database.OpenDB(); err != nil {
+		fmt.Fprintln(os.Stderr, "打开数据库失败:", err)
+		return 1
+	}
+	report := service.RunDoctor(*fix)
+	fmt.Printf("数据库版本: %d，程序支持的最新版本: %d\n", report.SchemaVersion, report.LatestVersion)
+	fixable := 0
+	for _, issue := range report.Issues {
+		state := ""
+		switch {
+		case issue.Fixed:
+			state = "（已修复）"
+		case issue.FixError != "":
+			state = "（修复失败: " + issue.FixError + "）"
+		case issue.Fixable:
+			state = "（可修复）"
+			fixable++
+		}
+		fmt.Printf("[%s] %s%s\n", issue.Kind, issue.Message, state)
+	}
+	if len(report.Issues) == 0 {
+		fmt.Println("没有发现问题")
+	} else if fixable > 0 {
+		fmt.Printf("有 %d 个问题可以用 van-nav doctor -fix 自动修复，修复前请先备份数据库\n", fixable)
+	}
+	if !report.Healthy {
+		return 1
+	}
+	return 0
+}
+
+// van-nav backup decrypt <加密的备份> <输出文件>，口令用 VAN_NAV_BACKUP_PASSPHRASE 环境变量，没有时从标准输入读一行
+func backupCommand(args []string) int {
+	if len(args) != 3 || args[0] != "decrypt" {
+		fmt.Fprintln(os.Stderr, "用法: van-nav backup decrypt <加密的备份> <输出文件>")
+		return 1
+	}
+	passphrase := os.Getenv("VAN_NAV_BACKUP_PASSPHRASE")
+	if passphrase == "" {
+		fmt.Fprint(os.Stderr, "请输入备份口令: ")
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			fmt.Fprintln(os.Stderr, "读取口令失败:", err)
+			return 1
+		}
+		passphrase = strings.TrimRight(line, "\r\n")
+	}
+	in, err := os.Open(args[1])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "打开备份失败:", err)
+		return 1
+	}
+	defer in.Close()
+	out, err := os.OpenFile(args[2], os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "创建输出文件失败:", err)
+		return 1
+	}
+	err = utils.DecryptStream(out, in, passphrase)
+	out.Close()
+	if err != nil {
+		os.Remove(args[2])
+		fmt.Fprintln(os.Stderr, "解密失败:", err)
+		return 1
+	}
+	fmt.Printf("已解密到 %s\n", args[2])
+	return 0
+}
@@ -0,0 +1,271 @@
+package config
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/ziren926/van-nav/utils"
+)
+
+// Config 所有的运行参数。先用默认值，再依次用配置文件、VAN_NAV_* 环境变量和命令行参数覆盖。
+//
+// 环境变量的名称由 env 标签拼出来：结构体字段的 env 是前缀，其它字段的 env 是名称，
+// 以 VAN_NAV_ 开头的直接用。带 secret 标签的字段在后台接口里不显示原值
+type Config struct {
+	// 读取的配置文件，没有时为空
+	File string `yaml:"-" json:"file"`
+
+	Server    ServerConfig    `yaml:"server" json:"server"`
+	Database  DatabaseConfig  `yaml:"database" json:"database"`
+	Auth      AuthConfig      `yaml:"auth" json:"auth"`
+	Setup     SetupConfig     `yaml:"setup" json:"setup"`
+	OIDC      OIDCConfig      `yaml:"oidc" json:"oidc" env:"OIDC_"`
+	ProxyAuth ProxyAuthConfig `yaml:"proxyAuth" json:"proxyAuth" env:"AUTH_PROXY_"`
+	CORS      CORSConfig      `yaml:"cors" json:"cors" env:"CORS_"`
+	Scraper   ScraperConfig   `yaml:"scraper" json:"scraper" env:"SCRAPER_"`
+	Trash     TrashConfig     `yaml:"trash" json:"trash" env:"TRASH_"`
+	Backup    BackupConfig    `yaml:"backup" json:"backup" env:"BACKUP_"`
+}
+
+type ServerConfig struct {
+	Host string `yaml:"host" json:"host" env:"HOST"` // 为空时监听所有地址
+	Port string `yaml:"port" json:"port" env:"PORT"`
+	// gin 的模式：release、debug 或 test
+	Mode string `yaml:"mode" json:"mode" env:"MODE"`
+	// 只采信这些反向代理传过来的 X-Forwarded-For
+	TrustedProxies List `yaml:"trustedProxies" json:"trustedProxies" env:"TRUSTED_PROXIES"`
+}
+
+type DatabaseConfig struct {
+	// 数据目录，SQLite 数据库和 JWT 密钥文件都放在这里
+	DataDir string `yaml:"dataDir" json:"dataDir" env:"DATA_DIR"`
+	// 数据库地址，为空时用数据目录下的 sqlite.file
+	DSN    string       `yaml:"dsn" json:"dsn" env:"VAN_NAV_DB" secret:"url"`
+	SQLite SQLiteConfig `yaml:"sqlite" json:"sqlite" env:"SQLITE_"`
+}
+
+// SQLiteConfig DSN 里没有带连接参数时才用
+type SQLiteConfig struct {
+	File        string   `yaml:"file" json:"file" env:"FILE"`
+	JournalMode string   `yaml:"journalMode" json:"journalMode" env:"JOURNAL_MODE"`
+	BusyTimeout Duration `yaml:"busyTimeout" json:"busyTimeout" env:"BUSY_TIMEOUT"`
+}
+
+type AuthConfig struct {
+	// 不为空时用这个 JWT 密钥，不写入数据目录，多实例部署时共用
+	JWTSecret         string `yaml:"jwtSecret" json:"jwtSecret" env:"VAN_NAV_JWT_SECRET" secret:"true"`
+	JWTPreviousSecret List   `yaml:"jwtPreviousSecret" json:"jwtPreviousSecret" env:"VAN_NAV_JWT_PREVIOUS_SECRET" secret:"true"`
+	// 轮换后旧密钥还能继续验证的时间
+	JWTKeyGracePeriod Duration `yaml:"jwtKeyGracePeriod" json:"jwtKeyGracePeriod" env:"JWT_KEY_GRACE_PERIOD"`
+	SessionLifetime   Duration `yaml:"sessionLifetime" json:"sessionLifetime" env:"SESSION_LIFETIME"`
+	// 开启两步验证时，密码正确后多久内要输入验证码
+	PreAuthLifetime Duration `yaml:"preAuthLifetime" json:"preAuthLifetime" env:"PRE_AUTH_LIFETIME"`
+}
+
+// SetupConfig 无界面部署时用来创建第一个管理员，已经初始化过就忽略
+type SetupConfig struct {
+	AdminUser     string `yaml:"adminUser" json:"adminUser" env:"ADMIN_USER"`
+	AdminPassword string `yaml:"adminPassword" json:"adminPassword" env:"ADMIN_PASSWORD" secret:"true"`
+	SiteTitle     string `yaml:"siteTitle" json:"siteTitle" env:"SITE_TITLE"`
+}
+
+// GroupRoleConfig 外部身份的组和本地角色的对应关系
+type GroupRoleConfig struct {
+	OwnerGroups  List   `yaml:"ownerGroups" json:"ownerGroups" env:"OWNER_GROUPS"`
+	EditorGroups List   `yaml:"editorGroups" json:"editorGroups" env:"EDITOR_GROUPS"`
+	ViewerGroups List   `yaml:"viewerGroups" json:"viewerGroups" env:"VIEWER_GROUPS"`
+	DefaultRole  string `yaml:"defaultRole" json:"defaultRole" env:"DEFAULT_ROLE"` // 为空表示不在任何组里的用户不能登录
+}
+
+// OIDCConfig issuer 为空时不开启单点登录
+type OIDCConfig struct {
+	Issuer          string `yaml:"issuer" json:"issuer" env:"ISSUER"`
+	ClientId        string `yaml:"clientId" json:"clientId" env:"CLIENT_ID"`
+	ClientSecret    string `yaml:"clientSecret" json:"clientSecret" env:"CLIENT_SECRET" secret:"true"`
+	RedirectUrl     string `yaml:"redirectUrl" json:"redirectUrl" env:"REDIRECT_URL"`
+	Scopes          List   `yaml:"scopes" json:"scopes" env:"SCOPES" sep:"space"`
+	UsernameClaim   string `yaml:"usernameClaim" json:"usernameClaim" env:"USERNAME_CLAIM"`
+	GroupsClaim     string `yaml:"groupsClaim" json:"groupsClaim" env:"GROUPS_CLAIM"`
+	GroupRoleConfig `yaml:",inline"`
+	// 开启后只能用单点登录，api token 不受影响
+	DisablePasswordLogin bool `yaml:"disablePasswordLogin" json:"disablePasswordLogin" env:"VAN_NAV_DISABLE_PASSWORD_LOGIN"`
+}
+
+// ProxyAuthConfig cidrs 为空时不开启反向代理认证
+type ProxyAuthConfig struct {
+	CIDRs           List   `yaml:"cidrs" json:"cidrs" env:"CIDRS"`
+	UserHeader      string `yaml:"userHeader" json:"userHeader" env:"USER_HEADER"`
+	GroupsHeader    string `yaml:"groupsHeader" json:"groupsHeader" env:"GROUPS_HEADER"`
+	GroupRoleConfig `yaml:",inline"`
+}
+
+// CORSConfig 公开接口和管理接口分别配置，没有配置 origins 表示不允许跨域
+type CORSConfig struct {
+	Public CORSPolicyConfig `yaml:"public" json:"public"`
+	Admin  CORSPolicyConfig `yaml:"admin" json:"admin" env:"ADMIN_"`
+}
+
+type CORSPolicyConfig struct {
+	Origins     List `yaml:"origins" json:"origins" env:"ORIGINS"`
+	Methods     List `yaml:"methods" json:"methods" env:"METHODS"`
+	Headers     List `yaml:"headers" json:"headers" env:"HEADERS"`
+	Credentials bool `yaml:"credentials" json:"credentials" env:"CREDENTIALS"`
+	MaxAge      int  `yaml:"maxAge" json:"maxAge" env:"MAX_AGE"` // 预检结果缓存的秒数
+}
+
+// ScraperConfig 添加工具时抓取网页标题和图标
+type ScraperConfig struct {
+	Timeout      Duration `yaml:"timeout" json:"timeout" env:"TIMEOUT"`
+	ImageTimeout Duration `yaml:"imageTimeout" json:"imageTimeout" env:"IMAGE_TIMEOUT"`
+	MaxRedirect  int      `yaml:"maxRedirect" json:"maxRedirect" env:"MAX_REDIRECT"`
+}
+
+type TrashConfig struct {
+	// 回收站里的内容保留的天数，0 表示不自动删除
+	Days int `yaml:"days" json:"days" env:"DAYS"`
+}
+
+type BackupConfig struct {
+	Dir        string `yaml:"dir" json:"dir" env:"DIR"`                // 为空时用数据目录下的 backups
+	Schedule   string `yaml:"schedule" json:"schedule" env:"SCHEDULE"` // 为空时不定时备份
+	KeepDaily  int    `yaml:"keepDaily" json:"keepDaily" env:"KEEP_DAILY"`
+	KeepWeekly int    `yaml:"keepWeekly" json:"keepWeekly" env:"KEEP_WEEKLY"`
+	Passphrase string `yaml:"passphrase" json:"passphrase" env:"PASSPHRASE" secret:"true"`
+
+	S3     S3Config     `yaml:"s3" json:"s3" env:"S3_"`
+	WebDAV WebDAVConfig `yaml:"webdav" json:"webdav" env:"WEBDAV_"`
+}
+
+// S3Config endpoint 为空时不上传到 S3
+type S3Config struct {
+	Endpoint    string `yaml:"endpoint" json:"endpoint" env:"ENDPOINT"`
+	Region      string `yaml:"region" json:"region" env:"REGION"`
+	Bucket      string `yaml:"bucket" json:"bucket" env:"BUCKET"`
+	AccessKey   string `yaml:"accessKey" json:"accessKey" env:"ACCESS_KEY"`
+	SecretKey   string `yaml:"secretKey" json:"secretKey" env:"SECRET_KEY" secret:"true"`
+	Prefix      string `yaml:"prefix" json:"prefix" env:"PREFIX"`
+	VirtualHost bool   `yaml:"virtualHost" json:"virtualHost" env:"VIRTUAL_HOST"` // 用 bucket.endpoint 的形式，默认是 endpoint/bucket
+}
+
+// WebDAVConfig url 为空时不上传到 WebDAV
+type WebDAVConfig struct {
+	Url      string `yaml:"url" json:"url" env:"URL" secret:"url"`
+	User     string `yaml:"user" json:"user" env:"USER"`
+	Password string `yaml:"password" json:"password" env:"PASSWORD" secret:"true"`
+}
+
+// Default 默认配置，和以前不传参数时一样
+func Default() *Config {
+	return &Config{
+		Server: ServerConfig{
+			Port:           "6412",
+			Mode:           "release",
+			TrustedProxies: List{"127.0.0.1", "::1"},
+		},
+		Database: DatabaseConfig{
+			DataDir: "./data",
+			SQLite: SQLiteConfig{
+				File:        "nav.db",
+				JournalMode: "WAL",
+				BusyTimeout: Duration(5 * time.Second),
+			},
+		},
+		Auth: AuthConfig{
+			JWTKeyGracePeriod: Duration(7 * 24 * time.Hour),
+			SessionLifetime:   Duration(30 * 24 * time.Hour),
+			PreAuthLifetime:   Duration(5 * time.Minute),
+		},
+		OIDC: OIDCConfig{
+			Scopes:        List{"openid", "profile", "email", "groups"},
+			UsernameClaim: "preferred_username",
+			GroupsClaim:   "groups",
+		},
+		ProxyAuth: ProxyAuthConfig{
+			UserHeader:      "Remote-User",
+			GroupsHeader:    "Remote-Groups",
+			GroupRoleConfig: GroupRoleConfig{DefaultRole: "viewer"},
+		},
+		CORS: CORSConfig{
+			Public: CORSPolicyConfig{
+				Methods: List{"GET", "HEAD"},
+				Headers: List{"Authorization", "Content-Type"},
+				MaxAge:  600,
+			},
+			Admin: CORSPolicyConfig{
+				Methods: List{"GET", "POST", "PUT", "DELETE"},
+				Headers: List{"Authorization", "Content-Type"},
+				MaxAge:  600,
+			},
+		},
+		Scraper: ScraperConfig{
+			Timeout:      Duration(10 * time.Second),
+			ImageTimeout: Duration(10 * time.Second),
+			MaxRedirect:  5,
+		},
+		Trash: TrashConfig{Days: 30},
+		Backup: BackupConfig{
+			Schedule:   "0 3 * * *",
+			KeepDaily:  7,
+			KeepWeekly: 4,
+			S3:         S3Config{Region: "us-east-1"},
+		},
+	}
+}
+
+// Current 当前生效的配置，启动时设置
+var Current = Default()
+
+// Duration 写成 30s、15m、720h 这样的
+type Duration time.Duration
+
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+// Set 实现 flag.Value，环境变量也用它解析
+func (d *Duration) Set(value string) error {
+	parsed, err := time.ParseDuration(strings.TrimSpace(value))
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *Duration) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var value string
+	if err := unmarshal(&value); err != nil {
+		return err
+	}
+	return d.Set(value)
+}
+
+// List 配置文件里可以写成列表，也可以和环境变量、命令行参数一样用逗号分隔
+type List []string
+
+func (l List) String() string {
+	return strings.Join(l, ",")
+}
+
+func (l *List) Set(value string) error {
+	*l = utils.SplitAndTrim(value)
+	return nil
+}
+
+func (l *List) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var items []string
+	if err := unmarshal(&items); err == nil {
+		*l = items
+		return nil
+	}
+	var value string
+	if err := unmarshal(&value); err != nil {
+		return err
+	}
+	return l.Set(value)
+}
@@ -0,0 +1,274 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/ziren926/van-nav/utils"
+	"gopkg.in/yaml.v2"
+)
+
+const envPrefix = "VAN_NAV_"
+
+// 配置文件的路径，也可以用 -config 参数指定
+const configEnv = envPrefix + "CONFIG"
+
+// Load 读取配置文件和环境变量，再用命令行参数覆盖，最后检查一遍。name 是命令行帮助里显示的程序名
+func Load(name string, args []string) (*Config, error) {
+	// 先解析一遍命令行，只为了拿到 -config，其它参数要等配置文件和环境变量读完再覆盖上去
+	path := os.Getenv(configEnv)
+	if err := newFlagSet(name, Default(), &path).Parse(args); err != nil {
+		return nil, err
+	}
+	cfg, err := load(path)
+	if err != nil {
+		return nil, err
+	}
+	if err = newFlagSet(name, cfg, &path).Parse(args); err != nil {
+		return nil, err
+	}
+	if err = cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// LoadFile 只读取配置文件和环境变量，子命令用。path 为空时用 VAN_NAV_CONFIG 环境变量
+func LoadFile(path string) (*Config, error) {
+	if path == "" {
+		path = os.Getenv(configEnv)
+	}
+	cfg, err := load(path)
+	if err != nil {
+		return nil, err
+	}
+	if err = cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func load(path string) (*Config, error) {
+	cfg := Default()
+	if path != "" {
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("读取配置文件失败: %s", err)
+		}
+		// 写错的配置项直接报错，不然拼错了也发现不了
+		if err = yaml.UnmarshalStrict(content, cfg); err != nil {
+			return nil, fmt.Errorf("解析配置文件 %s 失败: %s", path, err)
+		}
+		cfg.File = path
+	}
+	if err := applyEnv(reflect.ValueOf(cfg).Elem(), envPrefix); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// 参数绑定到 cfg 上，默认值是 cfg 现在的值，所以只有传了的参数才会覆盖
+func newFlagSet(name string, cfg *Config, path *string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.StringVar(path, "config", *path, "配置文件的路径，YAML 格式，默认用 VAN_NAV_CONFIG 环境变量")
+	flags.StringVar(&cfg.Server.Host, "host", cfg.Server.Host, "监听的地址，默认监听所有地址")
+	flags.StringVar(&cfg.Server.Port, "port", cfg.Server.Port, "指定监听端口")
+	flags.StringVar(&cfg.Server.Mode, "mode", cfg.Server.Mode, "运行模式：release、debug 或 test")
+	flags.Var(&cfg.Server.TrustedProxies, "trusted-proxies", "信任的反向代理 IP 或网段，逗号分隔，只有来自这些地址的 X-Forwarded-For 才会被采信，留空表示都不信任")
+	flags.StringVar(&cfg.Database.DataDir, "data-dir", cfg.Database.DataDir, "数据目录，SQLite 数据库和 JWT 密钥文件都放在这里")
+	flags.StringVar(&cfg.Database.DSN, "db", cfg.Database.DSN, "数据库地址，默认用数据目录下的 nav.db。PostgreSQL 写 postgres://用户:密码@主机/库名?sslmode=disable")
+	flags.IntVar(&cfg.Trash.Days, "trash-days", cfg.Trash.Days, "回收站里的内容保留的天数，过期后自动永久删除，0 表示不自动删除")
+	flags.StringVar(&cfg.Backup.Dir, "backup-dir", cfg.Backup.Dir, "备份文件放在哪，默认是数据目录下的 backups")
+	flags.StringVar(&cfg.Backup.Schedule, "backup-schedule", cfg.Backup.Schedule, "定时备份的规则，五段式的 cron：分 时 日 月 周，也可以写 @daily 这样的，留空表示不定时备份")
+	flags.IntVar(&cfg.Backup.KeepDaily, "backup-keep-daily", cfg.Backup.KeepDaily, "定时备份按天保留几份")
+	flags.IntVar(&cfg.Backup.KeepWeekly, "backup-keep-weekly", cfg.Backup.KeepWeekly, "定时备份按周保留几份")
+	return flags
+}
+
+// 按 env 标签用环境变量覆盖，设置了但为空的环境变量也算，可以用来清空默认值
+func applyEnv(v reflect.Value, prefix string) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := field.Tag.Get("env")
+		if !strings.HasPrefix(name, envPrefix) {
+			name = prefix + name
+		}
+		if field.Type.Kind() == reflect.Struct {
+			if err := applyEnv(v.Field(i), name); err != nil {
+				return err
+			}
+			continue
+		}
+		if _, ok := field.Tag.Lookup("env"); !ok {
+			continue
+		}
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+		if err := setField(v.Field(i), value, field.Tag.Get("sep")); err != nil {
+			return fmt.Errorf("环境变量 %s 无效: %s", name, err)
+		}
+	}
+	return nil
+}
+
+func setField(v reflect.Value, value string, sep string) error {
+	switch p := v.Addr().Interface().(type) {
+	case *List:
+		if sep == "space" {
+			*p = strings.Fields(value)
+			return nil
+		}
+		return p.Set(value)
+	case flag.Value:
+		return p.Set(value)
+	case *string:
+		*p = value
+	case *int:
+		number, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil {
+			return errors.New("不是整数")
+		}
+		*p = number
+	case *bool:
+		switch strings.ToLower(strings.TrimSpace(value)) {
+		case "1", "true", "yes", "on":
+			*p = true
+		case "", "0", "false", "no", "off":
+			*p = false
+		default:
+			return errors.New("只能是 true 或 false")
+		}
+	default:
+		return fmt.Errorf("不支持的类型 %s", v.Type())
+	}
+	return nil
+}
+
+// Validate 检查各个值是否合法，只检查格式和范围，单点登录这类需要联网的到启动时再检查
+func (c *Config) Validate() error {
+	if port, err := strconv.Atoi(c.Server.Port); err != nil || port < 1 || port > 65535 {
+		return fmt.Errorf("无效的 server.port: %s", c.Server.Port)
+	}
+	if !utils.In(c.Server.Mode, []string{"release", "debug", "test"}) {
+		return fmt.Errorf("无效的 server.mode: %s，可选 release、debug、test", c.Server.Mode)
+	}
+	if _, err := utils.ParseNetworks(c.Server.TrustedProxies); err != nil {
+		return fmt.Errorf("server.trustedProxies 配置错误: %s", err)
+	}
+	if c.Database.DataDir == "" {
+		return errors.New("database.dataDir 不能为空")
+	}
+	if c.Database.SQLite.File == "" {
+		return errors.New("database.sqlite.file 不能为空")
+	}
+	c.Database.SQLite.JournalMode = strings.ToUpper(c.Database.SQLite.JournalMode)
+	if !utils.In(c.Database.SQLite.JournalMode, []string{"WAL", "DELETE", "TRUNCATE", "PERSIST"}) {
+		return fmt.Errorf("无效的 database.sqlite.journalMode: %s，可选 WAL、DELETE、TRUNCATE、PERSIST", c.Database.SQLite.JournalMode)
+	}
+	for name, value := range map[string]Duration{
+		"database.sqlite.busyTimeout": c.Database.SQLite.BusyTimeout,
+		"auth.sessionLifetime":        c.Auth.SessionLifetime,
+		"auth.preAuthLifetime":        c.Auth.PreAuthLifetime,
+		"scraper.timeout":             c.Scraper.Timeout,
+		"scraper.imageTimeout":        c.Scraper.ImageTimeout,
+	} {
+		if value <= 0 {
+			return fmt.Errorf("%s 必须大于 0", name)
+		}
+	}
+	if c.Auth.JWTKeyGracePeriod < 0 {
+		return errors.New("auth.jwtKeyGracePeriod 不能小于 0")
+	}
+	for name, value := range map[string]int{
+		"trash.days":         c.Trash.Days,
+		"backup.keepDaily":   c.Backup.KeepDaily,
+		"backup.keepWeekly":  c.Backup.KeepWeekly,
+		"cors.public.maxAge": c.CORS.Public.MaxAge,
+		"cors.admin.maxAge":  c.CORS.Admin.MaxAge,
+	} {
+		if value < 0 {
+			return fmt.Errorf("%s 不能小于 0", name)
+		}
+	}
+	if c.Scraper.MaxRedirect < 1 {
+		return errors.New("scraper.maxRedirect 至少是 1")
+	}
+	if c.Backup.Schedule != "" {
+		if _, err := utils.ParseCron(c.Backup.Schedule); err != nil {
+			return fmt.Errorf("backup.schedule 配置错误: %s", err)
+		}
+	}
+	if s3 := c.Backup.S3; s3.Endpoint != "" {
+		if u, err := url.Parse(s3.Endpoint); err != nil || u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("无效的 backup.s3.endpoint: %s", s3.Endpoint)
+		}
+		if s3.Bucket == "" || s3.AccessKey == "" || s3.SecretKey == "" {
+			return errors.New("备份到 S3 需要配置 backup.s3.bucket、backup.s3.accessKey 和 backup.s3.secretKey")
+		}
+	}
+	if davUrl := c.Backup.WebDAV.Url; davUrl != "" {
+		if u, err := url.Parse(davUrl); err != nil || u.Scheme == "" || u.Host == "" {
+			return errors.New("无效的 backup.webdav.url")
+		}
+	}
+	if c.OIDC.Issuer == "" {
+		if c.OIDC.DisablePasswordLogin {
+			return errors.New("没有配置单点登录时不能关闭密码登录")
+		}
+	} else if c.OIDC.ClientId == "" || c.OIDC.RedirectUrl == "" {
+		return errors.New("开启单点登录需要配置 oidc.clientId 和 oidc.redirectUrl")
+	}
+	if _, err := utils.ParseNetworks(c.ProxyAuth.CIDRs); err != nil {
+		return fmt.Errorf("proxyAuth.cidrs 配置错误: %s", err)
+	}
+	return nil
+}
+
+// 代替密码和密钥显示的内容
+const redacted = "******"
+
+// Redacted 复制一份，带 secret 标签的值换成 ******，没设置的保持为空，能看出来有没有配置
+func (c *Config) Redacted() *Config {
+	copied := *c
+	redact(reflect.ValueOf(&copied).Elem())
+	return &copied
+}
+
+func redact(v reflect.Value) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		value := v.Field(i)
+		if field.Type.Kind() == reflect.Struct {
+			redact(value)
+			continue
+		}
+		switch field.Tag.Get("secret") {
+		case "true":
+			if list, ok := value.Interface().(List); ok {
+				masked := make(List, len(list))
+				for j := range masked {
+					masked[j] = redacted
+				}
+				value.Set(reflect.ValueOf(masked))
+			} else if value.String() != "" {
+				value.SetString(redacted)
+			}
+		case "url":
+			// 只去掉地址里的密码，其它的留着方便排查
+			if u, err := url.Parse(value.String()); err == nil {
+				value.SetString(u.Redacted())
+			}
+		}
+	}
+}
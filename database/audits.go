@@ -0,0 +1,86 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/ziren926/van-nav/types"
+)
+
+type auditRepository struct {
+	db *sql.DB
+}
+
+func (r auditRepository) Add(entry types.AuditLog) error {
+	changes, err := json.Marshal(entry.Changes)
+	if err != nil {
+		return err
+	}
+	_, err = r.db.Exec(`
+		INSERT INTO nav_audit (created_at, actor, actor_type, actor_id, action, target_type, target_id, changes, ip)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?);
+		`, entry.CreatedAt.Unix(), entry.Actor, entry.ActorType, entry.ActorId,
+		entry.Action, entry.TargetType, entry.TargetId, string(changes), entry.Ip)
+	return err
+}
+
+func (r auditRepository) Query(query types.AuditQueryDto) ([]types.AuditLog, int, error) {
+	conditions := make([]string, 0)
+	args := make([]interface{}, 0)
+	for column, value := range map[string]string{
+		"actor":       query.Actor,
+		"action":      query.Action,
+		"target_type": query.TargetType,
+		"target_id":   query.TargetId,
+	} {
+		if value != "" {
+			conditions = append(conditions, column+" = ?")
+			args = append(args, value)
+		}
+	}
+	if query.From > 0 {
+		conditions = append(conditions, "created_at >= ?")
+		args = append(args, query.From)
+	}
+	if query.To > 0 {
+		conditions = append(conditions, "created_at <= ?")
+		args = append(args, query.To)
+	}
+	where := ""
+	if len(conditions) > 0 {
+		where = "WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	var total int
+	if err := r.db.QueryRow(`SELECT COUNT(*) FROM nav_audit `+where+`;`, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+	args = append(args, query.PageSize, (query.Page-1)*query.PageSize)
+	rows, err := r.db.Query(`
+		SELECT id, created_at, actor, actor_type, actor_id, action, target_type, target_id, changes, ip
+		FROM nav_audit `+where+`
+		ORDER BY id DESC
+		LIMIT ? OFFSET ?;
+		`, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+	results := make([]types.AuditLog, 0)
+	for rows.Next() {
+		var entry types.AuditLog
+		var createdAt int64
+		var changes string
+		err = rows.Scan(&entry.Id, &createdAt, &entry.Actor, &entry.ActorType, &entry.ActorId,
+			&entry.Action, &entry.TargetType, &entry.TargetId, &changes, &entry.Ip)
+		if err != nil {
+			return nil, 0, err
+		}
+		entry.CreatedAt = time.Unix(createdAt, 0)
+		json.Unmarshal([]byte(changes), &entry.Changes)
+		results = append(results, entry)
+	}
+	return results, total, rows.Err()
+}
@@ -0,0 +1,107 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"modernc.org/sqlite"
+)
+
+// PostgreSQL 有自己的备份工具
+var ErrBackupUnsupported = errors.New("只有 SQLite 支持在线备份，PostgreSQL 请用 pg_dump")
+
+// 恢复时数据库一直被别的连接占着，最多重试这么多次
+const restoreBusyRetries = 50
+
+// BackupTo 用 VACUUM INTO 把当前数据库复制到 path，得到的是一致的快照，备份期间不影响读写。
+// path 不能已经存在
+func BackupTo(path string) error {
+	if CurrentDialect != DialectSQLite {
+		return ErrBackupUnsupported
+	}
+	_, err := DB.Exec(`VACUUM INTO ?;`, path)
+	return err
+}
+
+// CheckBackup 检查 path 是不是完整的 van-nav 数据库，并且不比程序新，返回它的版本号
+func CheckBackup(path string) (int, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+	var result string
+	if err = db.QueryRow(`PRAGMA integrity_check;`).Scan(&result); err != nil {
+		return 0, fmt.Errorf("不是有效的数据库文件: %s", err)
+	}
+	if result != "ok" {
+		return 0, fmt.Errorf("数据库文件已损坏: %s", result)
+	}
+	var version, dirty sql.NullInt64
+	err = db.QueryRow(`SELECT MAX(version), MAX(dirty) FROM schema_version;`).Scan(&version, &dirty)
+	if err != nil || !version.Valid {
+		return 0, errors.New("不是 van-nav 的数据库备份")
+	}
+	if dirty.Int64 != 0 {
+		return 0, errors.New("备份时有迁移没有完成，不能恢复")
+	}
+	if int(version.Int64) > LatestSchemaVersion() {
+		return 0, fmt.Errorf("备份的数据库版本是 %d，比程序支持的 %d 新，请先升级程序", version.Int64, LatestSchemaVersion())
+	}
+	return int(version.Int64), nil
+}
+
+// RestoreFrom 用 SQLite 的备份接口把 path 整个复制到当前数据库，别的连接要等复制完，不会看到一半的数据。
+// 复制完执行还没执行的迁移，旧版本的备份恢复后也能直接用
+func RestoreFrom(path string) error {
+	if CurrentDialect != DialectSQLite {
+		return ErrBackupUnsupported
+	}
+	if _, err := CheckBackup(path); err != nil {
+		return err
+	}
+	conn, err := DB.Conn(context.Background())
+	if err != nil {
+		return err
+	}
+	err = conn.Raw(func(driverConn interface{}) error {
+		restorer, ok := driverConn.(interface {
+			NewRestore(srcUri string) (*sqlite.Backup, error)
+		})
+		if !ok {
+			return ErrBackupUnsupported
+		}
+		backup, err := restorer.NewRestore(path)
+		if err != nil {
+			return err
+		}
+		for retry := 0; ; retry++ {
+			// -1 表示一次复制完，中间不会放开锁
+			_, err = backup.Step(-1)
+			if err == nil || retry >= restoreBusyRetries || !isBusy(err) {
+				break
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+		if finishErr := backup.Finish(); err == nil {
+			err = finishErr
+		}
+		return err
+	})
+	conn.Close()
+	if err != nil {
+		return err
+	}
+	_, err = MigrateUp(false)
+	return err
+}
+
+func isBusy(err error) bool {
+	message := err.Error()
+	return strings.Contains(message, "SQLITE_BUSY") || strings.Contains(message, "SQLITE_LOCKED") ||
+		strings.Contains(message, "database is locked")
+}
@@ -0,0 +1,84 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/ziren926/van-nav/types"
+)
+
+type catelogRepository struct {
+	db      *sql.DB
+	dialect dialect
+}
+
+func (r catelogRepository) GetAll() ([]types.Catelog, error) {
+	rows, err := r.db.Query(`SELECT id, COALESCE(name, ''), sort, hide FROM nav_catelog WHERE deleted_at IS NULL ORDER BY sort;`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	results := make([]types.Catelog, 0)
+	for rows.Next() {
+		var catelog types.Catelog
+		var hide sql.NullBool
+		if err = rows.Scan(&catelog.Id, &catelog.Name, &catelog.Sort, &hide); err != nil {
+			return nil, err
+		}
+		catelog.Hide = hide.Bool
+		results = append(results, catelog)
+	}
+	return results, rows.Err()
+}
+
+func (r catelogRepository) GetByName(name string) (types.Catelog, error) {
+	var catelog types.Catelog
+	var hide sql.NullBool
+	err := r.db.QueryRow(`SELECT id, name, sort, hide FROM nav_catelog WHERE name = ? AND deleted_at IS NULL ORDER BY id LIMIT 1;`, name).
+		Scan(&catelog.Id, &catelog.Name, &catelog.Sort, &hide)
+	catelog.Hide = hide.Bool
+	return catelog, notFound(err)
+}
+
+func (r catelogRepository) Add(catelog types.Catelog) (int, error) {
+	id, err := r.dialect.insert(r.db, `INSERT INTO nav_catelog (name, sort, hide) VALUES (?, ?, ?);`,
+		catelog.Name, catelog.Sort, catelog.Hide)
+	return int(id), err
+}
+
+func (r catelogRepository) Update(catelog types.Catelog) error {
+	return checkAffected(r.db.Exec(`UPDATE nav_catelog SET name = ?, sort = ?, hide = ? WHERE id = ? AND deleted_at IS NULL;`,
+		catelog.Name, catelog.Sort, catelog.Hide, catelog.Id))
+}
+
+// 分类和连带删除的工具用同一个 deleted_at，恢复分类时靠它找回这些工具
+func (r catelogRepository) Delete(id int, mode string, target int, now time.Time) (int64, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	var count int64
+	err = tx.QueryRow(`SELECT COUNT(*) FROM nav_table WHERE catelog_id = ? AND deleted_at IS NULL;`, id).Scan(&count)
+	if err != nil {
+		return 0, err
+	}
+	if count > 0 {
+		switch mode {
+		case types.CatelogDeleteMove:
+			_, err = tx.Exec(`UPDATE nav_table SET catelog_id = ? WHERE catelog_id = ? AND deleted_at IS NULL;`, target, id)
+		case types.CatelogDeleteCascade:
+			_, err = tx.Exec(`UPDATE nav_table SET deleted_at = ? WHERE catelog_id = ? AND deleted_at IS NULL;`, now.Unix(), id)
+		default:
+			return 0, ErrCatelogNotEmpty
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	err = checkAffected(tx.Exec(`UPDATE nav_catelog SET deleted_at = ? WHERE id = ? AND deleted_at IS NULL;`, now.Unix(), id))
+	if err != nil {
+		return 0, err
+	}
+	return count, tx.Commit()
+}
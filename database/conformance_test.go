@@ -0,0 +1,780 @@
+package database
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/ziren926/van-nav/types"
+)
+
+// 同一套用例分别在 SQLite 和 PostgreSQL 上跑，两种数据库上仓库的行为要一致。
+// 每个用例用一个新的库
+var conformanceCases = []struct {
+	name string
+	run  func(t *testing.T)
+}{
+	{"Tools", testTools},
+	{"Catelogs", testCatelogs},
+	{"Users", testUsers},
+	{"Tokens", testTokens},
+	{"Trash", testTrash},
+	{"Revisions", testRevisions},
+	{"Import", testImport},
+	{"Sessions", testSessions},
+	{"Shares", testShares},
+	{"Audits", testAudits},
+	{"Doctor", testDoctor},
+}
+
+func TestConformance(t *testing.T) {
+	t.Run("sqlite", func(t *testing.T) {
+		for _, c := range conformanceCases {
+			t.Run(c.name, func(t *testing.T) {
+				openTestDB(t, "sqlite://"+filepath.Join(t.TempDir(), "nav.db"))
+				c.run(t)
+			})
+		}
+	})
+	t.Run("postgres", func(t *testing.T) {
+		newDatabase := startPostgres(t)
+		for _, c := range conformanceCases {
+			t.Run(c.name, func(t *testing.T) {
+				openTestDB(t, newDatabase(t))
+				c.run(t)
+			})
+		}
+	})
+}
+
+func openTestDB(t *testing.T, dsn string) {
+	DataDir = t.TempDir()
+	DSN = dsn
+	if err := InitDB(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { DB.Close() })
+}
+
+// 用 initdb 在临时目录里建一个 PostgreSQL 实例，返回新建数据库的函数。
+// 没有 initdb 时跳过；设置了 VAN_NAV_TEST_POSTGRES=1 时一定要跑，没有 initdb 就失败，CI 里用
+func startPostgres(t *testing.T) func(t *testing.T) string {
+	initdb, err := exec.LookPath("initdb")
+	if err != nil {
+		if os.Getenv("VAN_NAV_TEST_POSTGRES") == "1" {
+			t.Fatal("设置了 VAN_NAV_TEST_POSTGRES=1，但是找不到 initdb")
+		}
+		t.Skip("没有 initdb，跳过 PostgreSQL")
+	}
+	dir := t.TempDir()
+	data := filepath.Join(dir, "data")
+	if out, err := exec.Command(initdb, "-D", data, "-U", "postgres", "-A", "trust", "-E", "UTF8", "--no-sync").CombinedOutput(); err != nil {
+		t.Fatalf("initdb 失败: %v\n%s", err, out)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := strconv.Itoa(listener.Addr().(*net.TCPAddr).Port)
+	listener.Close()
+
+	var logs bytes.Buffer
+	server := exec.Command(filepath.Join(filepath.Dir(initdb), "postgres"),
+		"-D", data, "-p", port, "-k", dir,
+		"-c", "listen_addresses=127.0.0.1", "-c", "fsync=off")
+	server.Stdout = &logs
+	server.Stderr = &logs
+	if err = server.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		server.Process.Signal(os.Interrupt)
+		server.Wait()
+	})
+
+	dsn := func(name string) string {
+		return "postgres://postgres@127.0.0.1:" + port + "/" + name + "?sslmode=disable"
+	}
+	admin, err := sql.Open("postgres", dsn("postgres"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { admin.Close() })
+	deadline := time.Now().Add(time.Second * 15)
+	for {
+		if err = admin.Ping(); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("PostgreSQL 没有启动: %v\n%s", err, logs.String())
+		}
+		time.Sleep(time.Millisecond * 100)
+	}
+
+	count := 0
+	return func(t *testing.T) string {
+		count++
+		name := fmt.Sprintf("conformance_%d", count)
+		if _, err := admin.Exec(`CREATE DATABASE ` + name + `;`); err != nil {
+			t.Fatal(err)
+		}
+		return dsn(name)
+	}
+}
+
+// 库里的时间只精确到秒
+var testNow = time.Unix(1700000000, 0)
+
+func must(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func mustNotFound(t *testing.T, err error) {
+	t.Helper()
+	if err != ErrNotFound {
+		t.Fatalf("应该返回 ErrNotFound，实际是 %v", err)
+	}
+}
+
+func addTestTool(t *testing.T, tool types.Tool) int64 {
+	t.Helper()
+	if tool.PostCreatedAt.IsZero() {
+		tool.PostCreatedAt = testNow
+		tool.PostUpdatedAt = testNow
+	}
+	id, err := Tools.Add(tool)
+	must(t, err)
+	return id
+}
+
+func testTools(t *testing.T) {
+	catelogId, err := Catelogs.Add(types.Catelog{Name: "开发", Sort: 1})
+	must(t, err)
+	id := addTestTool(t, types.Tool{Name: "Go", Url: "https://go.dev", Logo: "https://go.dev/favicon.ico", Desc: "Go 语言", CatelogId: catelogId, Sort: 2})
+
+	tool, err := Tools.GetById(id)
+	must(t, err)
+	if tool.Name != "Go" || tool.Catelog != "开发" || tool.CatelogId != catelogId || tool.Sort != 2 || tool.Desc != "Go 语言" {
+		t.Fatalf("读出来的工具不对: %+v", tool)
+	}
+	all, err := Tools.GetAll()
+	must(t, err)
+	if len(all) != 1 || all[0].Id != id {
+		t.Fatalf("GetAll 返回 %+v", all)
+	}
+
+	tool.Name = "Golang"
+	tool.PostUpdatedAt = testNow.Add(time.Hour)
+	must(t, Tools.Update(tool, "root"))
+	tool, err = Tools.GetById(id)
+	must(t, err)
+	if tool.Name != "Golang" || !tool.PostUpdatedAt.Equal(testNow.Add(time.Hour)) {
+		t.Fatalf("更新后的工具是 %+v", tool)
+	}
+
+	must(t, Tools.UpdateSort([]types.UpdateToolsSortDto{{Id: int(id), Sort: 9}}))
+	tool, err = Tools.GetById(id)
+	must(t, err)
+	if tool.Sort != 9 {
+		t.Fatalf("排序是 %d，应该是 9", tool.Sort)
+	}
+
+	must(t, Tools.Delete(id, testNow))
+	_, err = Tools.GetById(id)
+	mustNotFound(t, err)
+	mustNotFound(t, Tools.Update(tool, "root"))
+	mustNotFound(t, Tools.Delete(id, testNow))
+	// 回收站里的工具还在用图标缓存
+	inUse, err := Tools.LogoInUse("https://go.dev/favicon.ico")
+	must(t, err)
+	if !inUse {
+		t.Fatal("回收站里的工具的图标应该还在用")
+	}
+}
+
+func testCatelogs(t *testing.T) {
+	a, err := Catelogs.Add(types.Catelog{Name: "a"})
+	must(t, err)
+	b, err := Catelogs.Add(types.Catelog{Name: "b"})
+	must(t, err)
+	catelog, err := Catelogs.GetByName("a")
+	must(t, err)
+	if catelog.Id != a {
+		t.Fatalf("GetByName 返回 %+v", catelog)
+	}
+	tool := addTestTool(t, types.Tool{Name: "x", Url: "https://x.example.com", CatelogId: a})
+	_, err = Tokens.Add(types.Token{Name: "a 专用", CatelogId: a}, "hash")
+	must(t, err)
+
+	if _, err = Catelogs.Delete(a, types.CatelogDeleteReject, 0, testNow); err != ErrCatelogNotEmpty {
+		t.Fatalf("分类下还有工具时应该返回 ErrCatelogNotEmpty，实际是 %v", err)
+	}
+
+	// 限定了这个分类的 token 按 id 关联，改名后还是这个分类
+	must(t, Catelogs.Update(types.Catelog{Id: a, Name: "a2"}))
+	_, err = Catelogs.GetByName("a")
+	mustNotFound(t, err)
+	tokens, err := Tokens.GetAll()
+	must(t, err)
+	if len(tokens) != 1 || tokens[0].CatelogId != a || tokens[0].Catelog != "a2" {
+		t.Fatalf("分类改名后 token 是 %+v", tokens)
+	}
+
+	moved, err := Catelogs.Delete(a, types.CatelogDeleteMove, b, testNow)
+	must(t, err)
+	if moved != 1 {
+		t.Fatalf("移走了 %d 个工具，应该是 1", moved)
+	}
+	got, err := Tools.GetById(tool)
+	must(t, err)
+	if got.CatelogId != b || got.Catelog != "b" {
+		t.Fatalf("工具应该移到分类 b: %+v", got)
+	}
+
+	deleted, err := Catelogs.Delete(b, types.CatelogDeleteCascade, 0, testNow)
+	must(t, err)
+	if deleted != 1 {
+		t.Fatalf("删掉了 %d 个工具，应该是 1", deleted)
+	}
+	tools, err := Tools.GetAll()
+	must(t, err)
+	catelogs, err := Catelogs.GetAll()
+	must(t, err)
+	if len(tools) != 0 || len(catelogs) != 0 {
+		t.Fatalf("连带删除后还有工具 %+v 和分类 %+v", tools, catelogs)
+	}
+	_, err = Catelogs.Delete(b, types.CatelogDeleteCascade, 0, testNow)
+	mustNotFound(t, err)
+}
+
+func testUsers(t *testing.T) {
+	root, err := Users.AddFirst(types.User{Name: "root", Password: "hash", Role: types.RoleOwner}, "我的导航")
+	must(t, err)
+	if _, err = Users.AddFirst(types.User{Name: "other", Password: "hash", Role: types.RoleOwner}, ""); err != ErrUsersExist {
+		t.Fatalf("已经有用户时应该返回 ErrUsersExist，实际是 %v", err)
+	}
+	setting, err := Settings.Get()
+	must(t, err)
+	if setting.Title != "我的导航" {
+		t.Fatalf("网站标题是 %s", setting.Title)
+	}
+
+	bob, err := Users.Add(types.User{Name: "bob", Password: "hash", Role: types.RoleEditor})
+	must(t, err)
+	user, err := Users.GetByName("bob")
+	must(t, err)
+	if user.Id != bob || user.Role != types.RoleEditor || user.Password != "hash" {
+		t.Fatalf("GetByName 返回 %+v", user)
+	}
+	taken, err := Users.NameTaken("bob", 0)
+	must(t, err)
+	notTaken, err := Users.NameTaken("bob", bob)
+	must(t, err)
+	if !taken || notTaken {
+		t.Fatal("NameTaken 不对")
+	}
+	count, err := Users.Count()
+	must(t, err)
+	owners, err := Users.CountByRole(types.RoleOwner)
+	must(t, err)
+	if count != 2 || owners != 1 {
+		t.Fatalf("有 %d 个用户、%d 个 owner", count, owners)
+	}
+
+	// 外部身份两边都只能关联一次
+	must(t, Users.LinkSubject(bob, "https://id.example.com|1"))
+	must(t, Users.LinkSubject(bob, "https://id.example.com|1"))
+	user, err = Users.GetBySubject("https://id.example.com|1")
+	must(t, err)
+	if user.Id != bob {
+		t.Fatalf("GetBySubject 返回 %+v", user)
+	}
+	if err = Users.LinkSubject(root, "https://id.example.com|1"); err != ErrSubjectLinked {
+		t.Fatalf("外部身份已经关联了别的用户时应该返回 ErrSubjectLinked，实际是 %v", err)
+	}
+	if err = Users.LinkSubject(bob, "https://id.example.com|2"); err != ErrSubjectLinked {
+		t.Fatalf("用户已经关联过时应该返回 ErrSubjectLinked，实际是 %v", err)
+	}
+	mustNotFound(t, Users.LinkSubject(999, "https://id.example.com|3"))
+	must(t, Users.UnlinkSubject(bob))
+	_, err = Users.GetBySubject("https://id.example.com|1")
+	mustNotFound(t, err)
+
+	must(t, Users.SetRole(bob, types.RoleViewer))
+	user, err = Users.GetById(bob)
+	must(t, err)
+	if user.Name != "bob" || user.Role != types.RoleViewer {
+		t.Fatalf("修改后的用户是 %+v", user)
+	}
+	must(t, Users.Update(bob, "bobby", types.RoleEditor, "hash2"))
+	user, err = Users.GetById(bob)
+	must(t, err)
+	if user.Name != "bobby" || user.Role != types.RoleEditor || user.Password != "hash2" {
+		t.Fatalf("一起修改后的用户是 %+v", user)
+	}
+	// 角色和密码为空时不改
+	must(t, Users.Update(bob, "bob", "", ""))
+	user, err = Users.GetById(bob)
+	must(t, err)
+	if user.Name != "bob" || user.Role != types.RoleEditor || user.Password != "hash2" {
+		t.Fatalf("只改用户名后的用户是 %+v", user)
+	}
+	mustNotFound(t, Users.Update(999, "nobody", "", ""))
+	must(t, Users.Delete(bob))
+	_, err = Users.GetById(bob)
+	mustNotFound(t, err)
+}
+
+func testTokens(t *testing.T) {
+	catelogId, err := Catelogs.Add(types.Catelog{Name: "开发"})
+	must(t, err)
+	expires := testNow.Add(time.Hour)
+	id, err := Tokens.Add(types.Token{
+		Name:      "ci",
+		Scopes:    []string{types.ScopeRead, types.ScopeToolsWrite},
+		CatelogId: catelogId,
+		ExpiresAt: &expires,
+		CreatedAt: &testNow,
+	}, "hash1")
+	must(t, err)
+
+	token, err := Tokens.GetByHash("hash1", testNow)
+	must(t, err)
+	if token.Id != id || token.Name != "ci" || token.CatelogId != catelogId || token.Catelog != "开发" ||
+		!reflect.DeepEqual(token.Scopes, []string{types.ScopeRead, types.ScopeToolsWrite}) {
+		t.Fatalf("GetByHash 返回 %+v", token)
+	}
+	_, err = Tokens.GetByHash("hash1", expires)
+	mustNotFound(t, err)
+	_, err = Tokens.GetByHash("hash2", testNow)
+	mustNotFound(t, err)
+
+	must(t, Tokens.Touch(id, "10.0.0.1", testNow.Add(time.Minute)))
+	// 一分钟内同一个 ip 不再更新
+	must(t, Tokens.Touch(id, "10.0.0.1", testNow.Add(time.Minute+time.Second)))
+	tokens, err := Tokens.GetAll()
+	must(t, err)
+	if len(tokens) != 1 {
+		t.Fatalf("GetAll 返回 %+v", tokens)
+	}
+	token = tokens[0]
+	if token.LastUsedIp != "10.0.0.1" || token.LastUsedAt == nil || !token.LastUsedAt.Equal(testNow.Add(time.Minute)) ||
+		token.ExpiresAt == nil || !token.ExpiresAt.Equal(expires) || token.CreatedAt == nil || !token.CreatedAt.Equal(testNow) {
+		t.Fatalf("GetAll 返回 %+v", token)
+	}
+
+	must(t, Tokens.Disable(id))
+	_, err = Tokens.GetByHash("hash1", testNow)
+	mustNotFound(t, err)
+	mustNotFound(t, Tokens.Disable(999))
+
+	_, err = Tokens.Add(types.Token{Name: "deploy", Scopes: []string{types.ScopeRead}}, "hash3")
+	must(t, err)
+	hashes, err := Tokens.DisabledHashes()
+	must(t, err)
+	if !reflect.DeepEqual(hashes, []string{"hash1"}) {
+		t.Fatalf("已经删除的 token 是 %v", hashes)
+	}
+	must(t, Tokens.DisableByHash("hash3"))
+	_, err = Tokens.GetByHash("hash3", testNow)
+	mustNotFound(t, err)
+	mustNotFound(t, Tokens.DisableByHash("hash3"))
+	mustNotFound(t, Tokens.DisableByHash("missing"))
+}
+
+func testTrash(t *testing.T) {
+	c, err := Catelogs.Add(types.Catelog{Name: "c"})
+	must(t, err)
+	inCatelog := addTestTool(t, types.Tool{Name: "t1", Url: "https://t1.example.com", Logo: "l1", CatelogId: c})
+	alone := addTestTool(t, types.Tool{Name: "t2", Url: "https://t2.example.com", Logo: "l2"})
+	post, err := Posts.Add(types.Post{Title: "p", Content: "内容", CreateTime: testNow, UpdateTime: testNow})
+	must(t, err)
+	_, err = Tokens.Add(types.Token{Name: "c 专用", CatelogId: c}, "hash")
+	must(t, err)
+
+	must(t, Tools.Delete(alone, testNow))
+	must(t, Posts.Delete(post, testNow.Add(time.Second)))
+	_, err = Catelogs.Delete(c, types.CatelogDeleteCascade, 0, testNow.Add(time.Minute))
+	must(t, err)
+	items, err := Trash.List()
+	must(t, err)
+	if len(items) != 4 {
+		t.Fatalf("回收站里有 %+v", items)
+	}
+	// 按删除时间倒序
+	if last := items[3]; last.Type != types.TrashTool || last.Id != alone || !last.DeletedAt.Equal(testNow) {
+		t.Fatalf("最早删除的应该是 t2: %+v", last)
+	}
+
+	// 恢复工具时它的分类一起恢复
+	must(t, Trash.Restore(types.TrashTool, inCatelog))
+	tool, err := Tools.GetById(inCatelog)
+	must(t, err)
+	if tool.Catelog != "c" {
+		t.Fatalf("恢复的工具在分类 %q", tool.Catelog)
+	}
+	mustNotFound(t, Trash.Restore(types.TrashTool, inCatelog))
+	must(t, Trash.Restore(types.TrashPost, post))
+	_, err = Posts.GetById(post)
+	must(t, err)
+
+	_, err = Catelogs.Delete(c, types.CatelogDeleteCascade, 0, testNow.Add(time.Minute*2))
+	must(t, err)
+	_, err = Catelogs.Add(types.Catelog{Name: "c"})
+	must(t, err)
+	if err = Trash.Restore(types.TrashCatelog, int64(c)); err != ErrCatelogNameTaken {
+		t.Fatalf("已经有同名的分类时应该返回 ErrCatelogNameTaken，实际是 %v", err)
+	}
+
+	logos, err := Trash.Purge(types.TrashTool, alone)
+	must(t, err)
+	if !reflect.DeepEqual(logos, []string{"l2"}) {
+		t.Fatalf("永久删除返回的图标是 %v", logos)
+	}
+	_, err = Trash.Purge(types.TrashTool, alone)
+	mustNotFound(t, err)
+
+	purged, logos, err := Trash.PurgeBefore(testNow.Add(time.Hour))
+	must(t, err)
+	if purged != 2 || !reflect.DeepEqual(logos, []string{"l1"}) {
+		t.Fatalf("清理了 %d 条，图标 %v", purged, logos)
+	}
+	items, err = Trash.List()
+	must(t, err)
+	if len(items) != 0 {
+		t.Fatalf("清理后回收站里还有 %+v", items)
+	}
+	// 限定了被删分类的 token 一起停用
+	_, err = Tokens.GetByHash("hash", testNow)
+	mustNotFound(t, err)
+}
+
+func testRevisions(t *testing.T) {
+	id := addTestTool(t, types.Tool{Name: "v1", Url: "https://v.example.com"})
+	for i, name := range []string{"v2", "v3"} {
+		tool, err := Tools.GetById(id)
+		must(t, err)
+		tool.Name = name
+		tool.PostUpdatedAt = testNow.Add(time.Duration(i+1) * time.Hour)
+		must(t, Tools.Update(tool, "root"))
+	}
+	// 没有改动时不存历史
+	tool, err := Tools.GetById(id)
+	must(t, err)
+	must(t, Tools.Update(tool, "root"))
+
+	revisions, err := Revisions.List(types.RevisionTool, id)
+	must(t, err)
+	if len(revisions) != 2 {
+		t.Fatalf("修改历史是 %+v", revisions)
+	}
+	// 按时间倒序，最新的是 v2 被替换掉的那次
+	latest := revisions[0]
+	if latest.CreatedBy != "root" || !latest.CreatedAt.Equal(testNow.Add(time.Hour*2)) || latest.Data != nil {
+		t.Fatalf("最新的历史是 %+v", latest)
+	}
+	revision, err := Revisions.GetById(types.RevisionTool, id, latest.Id)
+	must(t, err)
+	var previous types.Tool
+	must(t, json.Unmarshal(revision.Data, &previous))
+	if previous.Name != "v2" {
+		t.Fatalf("历史里的旧版本是 %+v", previous)
+	}
+	_, err = Revisions.GetById(types.RevisionPost, id, latest.Id)
+	mustNotFound(t, err)
+
+	// 写帖子也会存整个工具的历史
+	must(t, Tools.AddPost(id, types.Post{Title: "帖子", Content: "内容", CreateTime: testNow, UpdateTime: testNow.Add(time.Hour * 3)}, "editor"))
+	revisions, err = Revisions.List(types.RevisionTool, id)
+	must(t, err)
+	if len(revisions) != 3 || revisions[0].CreatedBy != "editor" {
+		t.Fatalf("写帖子后的修改历史是 %+v", revisions)
+	}
+
+	postId, err := Posts.Add(types.Post{Title: "p1", Content: "内容", CreateTime: testNow, UpdateTime: testNow})
+	must(t, err)
+	must(t, Posts.Update(types.Post{ID: postId, Title: "p2", Content: "内容", UpdateTime: testNow.Add(time.Hour)}, "root"))
+	revisions, err = Revisions.List(types.RevisionPost, postId)
+	must(t, err)
+	if len(revisions) != 1 {
+		t.Fatalf("帖子的修改历史是 %+v", revisions)
+	}
+}
+
+func testImport(t *testing.T) {
+	c, err := Catelogs.Add(types.Catelog{Name: "开发"})
+	must(t, err)
+	existing := addTestTool(t, types.Tool{Name: "Go", Url: "https://go.dev/", Desc: "旧的描述", CatelogId: c})
+	tools := []types.Tool{
+		{Name: "Go 官网", Url: "http://GO.dev", Catelog: "开发", Desc: "新的描述"},
+		{Name: "Rust", Url: "https://www.rust-lang.org", Catelog: "新分类"},
+		{Id: 100, Name: "Zig", Url: "https://ziglang.org"},
+		{Name: "", Url: "https://empty.example.com"},
+	}
+	options := types.ImportOptions{Strategy: types.ImportOverwrite, MatchBy: types.ImportMatchUrl, DryRun: true}
+
+	// 预览时什么都不写
+	report, err := Tools.Import(tools, options, "root", testNow)
+	must(t, err)
+	if report.Created != 2 || report.Updated != 1 || report.Skipped != 1 || report.Conflicts != 0 ||
+		!reflect.DeepEqual(report.NewCatelogs, []string{"新分类"}) || report.Items[1].Id != 0 || report.Items[0].MatchedId != existing {
+		t.Fatalf("导入计划是 %+v", report)
+	}
+	all, err := Tools.GetAll()
+	must(t, err)
+	if len(all) != 1 {
+		t.Fatalf("预览后有 %d 个工具", len(all))
+	}
+	_, err = Catelogs.GetByName("新分类")
+	mustNotFound(t, err)
+
+	options.DryRun = false
+	report, err = Tools.Import(tools, options, "root", testNow)
+	must(t, err)
+	if report.Created != 2 || report.Updated != 1 || report.Skipped != 1 {
+		t.Fatalf("导入结果是 %+v", report)
+	}
+	tool, err := Tools.GetById(existing)
+	must(t, err)
+	if tool.Name != "Go 官网" || tool.Desc != "新的描述" {
+		t.Fatalf("覆盖后的工具是 %+v", tool)
+	}
+	revisions, err := Revisions.List(types.RevisionTool, existing)
+	must(t, err)
+	if len(revisions) != 1 || revisions[0].CreatedBy != "root" {
+		t.Fatalf("覆盖时的修改历史是 %+v", revisions)
+	}
+	tool, err = Tools.GetById(100)
+	must(t, err)
+	if tool.Name != "Zig" {
+		t.Fatalf("沿用 id 的工具是 %+v", tool)
+	}
+	tool, err = Tools.GetById(report.Items[1].Id)
+	must(t, err)
+	if tool.Catelog != "新分类" {
+		t.Fatalf("新分类里的工具是 %+v", tool)
+	}
+	// 沿用了 id 之后，自增 id 不能撞上
+	next := addTestTool(t, types.Tool{Name: "next", Url: "https://next.example.com"})
+	if next <= 100 {
+		t.Fatalf("新工具的 id 是 %d", next)
+	}
+
+	report, err = Tools.Import(tools[:1], types.ImportOptions{Strategy: types.ImportSkip}, "root", testNow)
+	must(t, err)
+	if report.Skipped != 1 || report.Created != 0 {
+		t.Fatalf("跳过已有的工具时导入结果是 %+v", report)
+	}
+
+	// 同一批里重复的网址，覆盖时不知道用哪一行
+	report, err = Tools.Import([]types.Tool{
+		{Name: "a", Url: "https://dup.example.com"},
+		{Name: "b", Url: "https://dup.example.com/"},
+	}, types.ImportOptions{Strategy: types.ImportOverwrite}, "root", testNow)
+	must(t, err)
+	if report.Created != 1 || report.Conflicts != 1 {
+		t.Fatalf("重复的网址导入结果是 %+v", report)
+	}
+
+	// 按 id 匹配，限定分类时只和这个分类里的工具比较
+	report, err = Tools.Import([]types.Tool{{Id: 100, Name: "Zig 2", Url: "https://ziglang.org"}},
+		types.ImportOptions{Strategy: types.ImportOverwrite, MatchBy: types.ImportMatchId, CatelogId: c}, "root", testNow)
+	must(t, err)
+	if report.Created != 1 || report.Items[0].Id == 100 {
+		t.Fatalf("限定分类导入的结果是 %+v", report)
+	}
+	// 预览时不动自增序列，手动指定的 id 后面跟着不带 id 的行也不会撞上
+	last := addTestTool(t, types.Tool{Name: "last", Url: "https://last.example.com"})
+	report, err = Tools.Import([]types.Tool{
+		{Id: last + 1, Name: "p1", Url: "https://p1.example.com"},
+		{Name: "p2", Url: "https://p2.example.com"},
+	}, types.ImportOptions{Strategy: types.ImportSkip, DryRun: true}, "root", testNow)
+	must(t, err)
+	if report.Created != 2 {
+		t.Fatalf("预览的导入计划是 %+v", report)
+	}
+	if next := addTestTool(t, types.Tool{Name: "after", Url: "https://after.example.com"}); next != last+1 {
+		t.Fatalf("预览后新工具的 id 是 %d，应该是 %d", next, last+1)
+	}
+}
+
+func testSessions(t *testing.T) {
+	for _, session := range []types.Session{
+		{Id: "old", UserId: 1, IssuedAt: testNow.Add(-time.Hour * 2), ExpiresAt: testNow.Add(-time.Hour)},
+		{Id: "a", UserId: 1, IssuedAt: testNow, ExpiresAt: testNow.Add(time.Hour), Ip: "10.0.0.1", UserAgent: "curl"},
+		{Id: "b", UserId: 1, IssuedAt: testNow.Add(time.Minute), ExpiresAt: testNow.Add(time.Hour)},
+		{Id: "c", UserId: 2, IssuedAt: testNow, ExpiresAt: testNow.Add(time.Hour)},
+	} {
+		must(t, Sessions.Add(session))
+	}
+	active, err := Sessions.IsActive("a", testNow)
+	must(t, err)
+	if !active {
+		t.Fatal("会话 a 应该有效")
+	}
+	for _, id := range []string{"old", "missing"} {
+		active, err = Sessions.IsActive(id, testNow)
+		must(t, err)
+		if active {
+			t.Fatalf("会话 %s 不应该有效", id)
+		}
+	}
+	sessions, err := Sessions.GetActive(1, testNow)
+	must(t, err)
+	if len(sessions) != 2 || sessions[0].Id != "b" || sessions[1].Ip != "10.0.0.1" || sessions[1].UserAgent != "curl" ||
+		!sessions[1].IssuedAt.Equal(testNow) {
+		t.Fatalf("有效的会话是 %+v", sessions)
+	}
+
+	// 只能吊销自己的会话
+	mustNotFound(t, Sessions.Revoke(2, "a", testNow))
+	must(t, Sessions.Revoke(1, "a", testNow))
+	mustNotFound(t, Sessions.Revoke(1, "a", testNow))
+	// 过期了但没吊销的也算
+	revoked, err := Sessions.RevokeUser(1, testNow)
+	must(t, err)
+	if revoked != 2 {
+		t.Fatalf("吊销了 %d 个会话，应该是 2", revoked)
+	}
+	sessions, err = Sessions.GetActive(1, testNow)
+	must(t, err)
+	if len(sessions) != 0 {
+		t.Fatalf("吊销后还有会话 %+v", sessions)
+	}
+
+	// 这个时间之前签发的全部吊销，只有 c 还没吊销过
+	revoked, err = Sessions.RevokeIssuedBefore(testNow, testNow)
+	must(t, err)
+	if revoked != 1 {
+		t.Fatalf("吊销了 %d 个会话，应该是 1", revoked)
+	}
+	active, err = Sessions.IsActive("c", testNow)
+	must(t, err)
+	if active {
+		t.Fatal("会话 c 应该已经吊销")
+	}
+
+	must(t, Sessions.DeleteExpired(testNow))
+	var count int
+	must(t, DB.QueryRow(`SELECT COUNT(*) FROM nav_session;`).Scan(&count))
+	if count != 3 {
+		t.Fatalf("清理过期会话后还有 %d 个，应该是 3", count)
+	}
+}
+
+func testShares(t *testing.T) {
+	share := types.Share{
+		Id:        "s1",
+		Name:      "分享",
+		Type:      types.ShareTool,
+		TargetId:  1,
+		CreatedBy: "root",
+		CreatedAt: testNow,
+		ExpiresAt: testNow.Add(time.Hour),
+	}
+	must(t, Shares.Add(share))
+	later := share
+	later.Id = "s2"
+	later.CreatedAt = testNow.Add(time.Minute)
+	must(t, Shares.Add(later))
+
+	got, err := Shares.GetById("s1")
+	must(t, err)
+	if !reflect.DeepEqual(got, share) {
+		t.Fatalf("GetById 返回 %+v", got)
+	}
+	_, err = Shares.GetById("missing")
+	mustNotFound(t, err)
+
+	must(t, Shares.Revoke("s1", testNow.Add(time.Second)))
+	mustNotFound(t, Shares.Revoke("s1", testNow.Add(time.Second)))
+	mustNotFound(t, Shares.Revoke("missing", testNow))
+	shares, err := Shares.GetAll()
+	must(t, err)
+	if len(shares) != 2 || shares[0].Id != "s2" || shares[1].RevokedAt == nil || !shares[1].RevokedAt.Equal(testNow.Add(time.Second)) {
+		t.Fatalf("GetAll 返回 %+v", shares)
+	}
+}
+
+func testAudits(t *testing.T) {
+	for i, action := range []string{"tool.add", "tool.update", "catelog.add"} {
+		must(t, Audits.Add(types.AuditLog{
+			CreatedAt:  testNow.Add(time.Duration(i) * time.Minute),
+			Actor:      "root",
+			ActorType:  "user",
+			ActorId:    1,
+			Action:     action,
+			TargetType: "tool",
+			TargetId:   strconv.Itoa(i + 1),
+			Changes:    map[string]types.AuditChange{"name": {Before: "a", After: "b"}},
+			Ip:         "10.0.0.1",
+		}))
+	}
+	list, total, err := Audits.Query(types.AuditQueryDto{Page: 1, PageSize: 2})
+	must(t, err)
+	if total != 3 || len(list) != 2 || list[0].Action != "catelog.add" || !list[0].CreatedAt.Equal(testNow.Add(time.Minute*2)) ||
+		list[0].Changes["name"].After != "b" {
+		t.Fatalf("第一页是 %d 条里的 %+v", total, list)
+	}
+	list, _, err = Audits.Query(types.AuditQueryDto{Page: 2, PageSize: 2})
+	must(t, err)
+	if len(list) != 1 || list[0].Action != "tool.add" {
+		t.Fatalf("第二页是 %+v", list)
+	}
+	list, total, err = Audits.Query(types.AuditQueryDto{
+		Page: 1, PageSize: 20, Action: "tool.update", From: testNow.Unix(), To: testNow.Add(time.Hour).Unix(),
+	})
+	must(t, err)
+	if total != 1 || len(list) != 1 || list[0].TargetId != "2" {
+		t.Fatalf("按条件查询返回 %d 条里的 %+v", total, list)
+	}
+}
+
+func testDoctor(t *testing.T) {
+	c, err := Catelogs.Add(types.Catelog{Name: "c"})
+	must(t, err)
+	logo := "https://a.example.com/logo.png"
+	addTestTool(t, types.Tool{Name: "ok", Url: "https://ok.example.com", Logo: logo, CatelogId: c})
+	orphan := addTestTool(t, types.Tool{Name: "orphan", Url: "https://orphan.example.com"})
+
+	tools, err := Doctor.OrphanTools()
+	must(t, err)
+	if len(tools) != 1 || tools[0].Id != orphan || tools[0].Name != "orphan" || tools[0].CatelogId != 0 {
+		t.Fatalf("没有分类的工具是 %+v", tools)
+	}
+	must(t, Doctor.MoveTool(orphan, c))
+	mustNotFound(t, Doctor.MoveTool(999, c))
+	tools, err = Doctor.OrphanTools()
+	must(t, err)
+	if len(tools) != 0 {
+		t.Fatalf("修复后还有没有分类的工具 %+v", tools)
+	}
+
+	must(t, Imgs.Add(url.QueryEscape(logo), "data"))
+	must(t, Imgs.Add(url.QueryEscape("https://b.example.com/logo.png"), "data"))
+	imgs, err := Doctor.DanglingImgs()
+	must(t, err)
+	if len(imgs) != 1 || imgs[0].Url != url.QueryEscape("https://b.example.com/logo.png") {
+		t.Fatalf("没用的图标缓存是 %+v", imgs)
+	}
+	must(t, Doctor.DeleteImg(imgs[0].Id))
+	mustNotFound(t, Doctor.DeleteImg(imgs[0].Id))
+	imgs, err = Doctor.DanglingImgs()
+	must(t, err)
+	if len(imgs) != 0 {
+		t.Fatalf("删除后还有没用的图标缓存 %+v", imgs)
+	}
+}
@@ -0,0 +1,83 @@
+package database
+
+import (
+	"database/sql"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Dialect 数据库类型
+type Dialect string
+
+const (
+	DialectSQLite   Dialect = "sqlite"
+	DialectPostgres Dialect = "postgres"
+)
+
+// 数据库地址，为空时用数据目录下的 SQLiteFile。
+// postgres:// 或 postgresql:// 开头的是 PostgreSQL，sqlite:// 开头或者直接写文件路径的是 SQLite
+var DSN = ""
+
+// 当前使用的数据库类型，OpenDB 之后才有意义
+var CurrentDialect = DialectSQLite
+
+// 两种数据库不一样的地方。SQL 语句统一用 ? 做占位符，PostgreSQL 的连接会自动换成 $1、$2
+type dialect interface {
+	name() Dialect
+	open(source string) (*sql.DB, error)
+	migrations() []Migration
+	// 插入一行并返回自增的 id，PostgreSQL 的驱动不支持 LastInsertId
+	insert(q execQueryer, query string, args ...interface{}) (int64, error)
+	// 插入时手动指定了 id 之后，让自增序列跟上，不然下次插入会冲突
+	syncSequence(q execQueryer, table string) error
+}
+
+// *sql.DB 和 *sql.Tx 都可以
+type execQueryer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// 按 DSN 判断数据库类型，返回驱动要用的连接串
+func parseDSN(dsn string) (dialect, string) {
+	switch {
+	case strings.HasPrefix(dsn, "postgres://"), strings.HasPrefix(dsn, "postgresql://"):
+		return postgresDialect{}, dsn
+	case strings.HasPrefix(dsn, "sqlite://"):
+		return sqliteDialect{}, strings.TrimPrefix(dsn, "sqlite://")
+	case dsn == "":
+		return sqliteDialect{}, filepath.Join(DataDir, SQLiteFile)
+	default:
+		return sqliteDialect{}, dsn
+	}
+}
+
+// 把 ? 占位符换成 $1、$2……，跳过引号里的内容
+func rebind(query string) string {
+	if !strings.Contains(query, "?") {
+		return query
+	}
+	var builder strings.Builder
+	builder.Grow(len(query) + 8)
+	var quote rune
+	n := 0
+	for _, r := range query {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '\'' || r == '"':
+			quote = r
+		case r == '?':
+			n++
+			builder.WriteByte('$')
+			builder.WriteString(strconv.Itoa(n))
+			continue
+		}
+		builder.WriteRune(r)
+	}
+	return builder.String()
+}
@@ -0,0 +1,356 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"net/url"
+	"sort"
+
+	"github.com/ziren926/van-nav/types"
+)
+
+// 一张表期望的结构
+type expectedTable struct {
+	name    string
+	sql     string
+	columns []expectedColumn
+}
+
+type expectedColumn struct {
+	name       string
+	definition string // ADD COLUMN 用的类型、NOT NULL 和默认值
+}
+
+type expectedIndex struct {
+	name  string
+	table string
+	sql   string
+}
+
+// 在内存数据库里执行到 version 为止的所有迁移，得到这个版本应该有的表结构。
+// 还没执行的迁移不算，那是 migrate up 的事
+func expectedSchema(version int) ([]expectedTable, []expectedIndex, error) {
+	ref, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		return nil, nil, err
+	}
+	defer ref.Close()
+	// 内存数据库每个连接都是独立的，只能用一个连接
+	ref.SetMaxOpenConns(1)
+	if _, err = ref.Exec(sql_create_schema_version); err != nil {
+		return nil, nil, err
+	}
+	for _, migration := range migrations {
+		if migration.Version > version {
+			break
+		}
+		tx, err := ref.Begin()
+		if err != nil {
+			return nil, nil, err
+		}
+		if err = migration.Up(tx); err != nil {
+			tx.Rollback()
+			return nil, nil, fmt.Errorf("迁移 %d_%s: %s", migration.Version, migration.Name, err)
+		}
+		if err = tx.Commit(); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	tables := make([]expectedTable, 0)
+	rows, err := ref.Query(`SELECT name, sql FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%' ORDER BY name;`)
+	if err != nil {
+		return nil, nil, err
+	}
+	for rows.Next() {
+		var table expectedTable
+		if err = rows.Scan(&table.name, &table.sql); err != nil {
+			rows.Close()
+			return nil, nil, err
+		}
+		tables = append(tables, table)
+	}
+	rows.Close()
+	for i := range tables {
+		if tables[i].columns, err = expectedColumns(ref, tables[i].name); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	indexes := make([]expectedIndex, 0)
+	rows, err = ref.Query(`SELECT name, tbl_name, sql FROM sqlite_master WHERE type = 'index' AND sql IS NOT NULL ORDER BY name;`)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var index expectedIndex
+		if err = rows.Scan(&index.name, &index.table, &index.sql); err != nil {
+			return nil, nil, err
+		}
+		indexes = append(indexes, index)
+	}
+	return tables, indexes, rows.Err()
+}
+
+func expectedColumns(ref *sql.DB, table string) ([]expectedColumn, error) {
+	rows, err := ref.Query(`SELECT name, type, "notnull", dflt_value FROM pragma_table_info(?);`, table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	columns := make([]expectedColumn, 0)
+	for rows.Next() {
+		var name, columnType string
+		var notNull bool
+		var defaultValue sql.NullString
+		if err = rows.Scan(&name, &columnType, &notNull, &defaultValue); err != nil {
+			return nil, err
+		}
+		definition := columnType
+		// ADD COLUMN 加 NOT NULL 必须有默认值
+		if notNull && defaultValue.Valid {
+			definition += " NOT NULL"
+		}
+		if defaultValue.Valid {
+			definition += " DEFAULT " + defaultValue.String
+		}
+		columns = append(columns, expectedColumn{name: name, definition: definition})
+	}
+	return columns, rows.Err()
+}
+
+// 现有的表和每张表的列
+func liveSchema() (map[string]map[string]bool, map[string]bool, error) {
+	tables := make(map[string]map[string]bool)
+	indexes := make(map[string]bool)
+	rows, err := DB.Query(`SELECT type, name FROM sqlite_master WHERE type IN ('table', 'index');`)
+	if err != nil {
+		return nil, nil, err
+	}
+	for rows.Next() {
+		var kind, name string
+		if err = rows.Scan(&kind, &name); err != nil {
+			rows.Close()
+			return nil, nil, err
+		}
+		if kind == "table" {
+			tables[name] = make(map[string]bool)
+		} else {
+			indexes[name] = true
+		}
+	}
+	rows.Close()
+	for table, columns := range tables {
+		rows, err := DB.Query(`SELECT name FROM pragma_table_info(?);`, table)
+		if err != nil {
+			return nil, nil, err
+		}
+		for rows.Next() {
+			var name string
+			if err = rows.Scan(&name); err != nil {
+				rows.Close()
+				return nil, nil, err
+			}
+			columns[name] = true
+		}
+		rows.Close()
+	}
+	return tables, indexes, nil
+}
+
+// CheckSchema 和当前版本应有的结构比较，找出缺少的表、列和索引，只支持 SQLite。多出来的不管。
+// fix 时按表、列、索引的顺序补上，不会删除任何东西
+func CheckSchema(version int, fix bool) ([]types.DoctorIssue, error) {
+	// 期望的结构是在 SQLite 的内存库里生成的，PostgreSQL 的结构由迁移保证
+	if CurrentDialect != DialectSQLite {
+		return make([]types.DoctorIssue, 0), nil
+	}
+	tables, indexes, err := expectedSchema(version)
+	if err != nil {
+		return nil, fmt.Errorf("生成期望的表结构失败: %s", err)
+	}
+	liveTables, liveIndexes, err := liveSchema()
+	if err != nil {
+		return nil, fmt.Errorf("读取数据库结构失败: %s", err)
+	}
+
+	issues := make([]types.DoctorIssue, 0)
+	statements := make([]string, 0)
+	for _, table := range tables {
+		columns, ok := liveTables[table.name]
+		if !ok {
+			issues = append(issues, types.DoctorIssue{
+				Kind:    types.DoctorMissingTable,
+				Target:  table.name,
+				Message: "缺少表 " + table.name,
+				Fixable: true,
+			})
+			statements = append(statements, table.sql)
+			continue
+		}
+		for _, column := range table.columns {
+			if columns[column.name] {
+				continue
+			}
+			issues = append(issues, types.DoctorIssue{
+				Kind:    types.DoctorMissingColumn,
+				Target:  table.name + "." + column.name,
+				Message: fmt.Sprintf("表 %s 缺少列 %s", table.name, column.name),
+				Fixable: true,
+			})
+			statements = append(statements, `ALTER TABLE `+table.name+` ADD COLUMN `+column.name+` `+column.definition+`;`)
+		}
+	}
+	for _, index := range indexes {
+		if liveIndexes[index.name] {
+			continue
+		}
+		issues = append(issues, types.DoctorIssue{
+			Kind:    types.DoctorMissingIndex,
+			Target:  index.name,
+			Message: fmt.Sprintf("表 %s 缺少索引 %s", index.table, index.name),
+			Fixable: true,
+		})
+		statements = append(statements, index.sql)
+	}
+
+	if fix {
+		for i, statement := range statements {
+			if _, err := DB.Exec(statement); err != nil {
+				issues[i].FixError = err.Error()
+				continue
+			}
+			issues[i].Fixed = true
+		}
+	}
+	return issues, nil
+}
+
+// CheckIntegrity 执行 SQLite 的 integrity_check 和 foreign_key_check，这类问题只能从备份恢复，不提供修复
+func CheckIntegrity() ([]types.DoctorIssue, error) {
+	issues := make([]types.DoctorIssue, 0)
+	// PostgreSQL 没有这两个检查，数据文件的问题交给它自己的工具
+	if CurrentDialect != DialectSQLite {
+		return issues, nil
+	}
+	rows, err := DB.Query(`PRAGMA integrity_check;`)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var result string
+		if err = rows.Scan(&result); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if result != "ok" {
+			issues = append(issues, types.DoctorIssue{Kind: types.DoctorIntegrity, Target: "integrity_check", Message: result})
+		}
+	}
+	rows.Close()
+
+	rows, err = DB.Query(`PRAGMA foreign_key_check;`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	// 按表和引用的表汇总，不一行一行列出来
+	type reference struct{ table, parent string }
+	violations := make(map[reference]int)
+	for rows.Next() {
+		var ref reference
+		var rowId sql.NullInt64
+		var fkId int
+		if err = rows.Scan(&ref.table, &rowId, &ref.parent, &fkId); err != nil {
+			return nil, err
+		}
+		violations[ref]++
+	}
+	refs := make([]reference, 0, len(violations))
+	for ref := range violations {
+		refs = append(refs, ref)
+	}
+	sort.Slice(refs, func(i, j int) bool {
+		return refs[i].table+refs[i].parent < refs[j].table+refs[j].parent
+	})
+	for _, ref := range refs {
+		issues = append(issues, types.DoctorIssue{
+			Kind:    types.DoctorIntegrity,
+			Target:  "foreign_key_check",
+			Message: fmt.Sprintf("表 %s 有 %d 行引用的 %s 记录不存在", ref.table, violations[ref], ref.parent),
+		})
+	}
+	return issues, rows.Err()
+}
+
+type doctorRepository struct {
+	db *sql.DB
+}
+
+func (r doctorRepository) OrphanTools() ([]types.Tool, error) {
+	rows, err := r.db.Query(`
+		SELECT id, COALESCE(name, ''), COALESCE(catelog_id, 0) FROM nav_table
+		WHERE deleted_at IS NULL
+			AND (catelog_id IS NULL OR catelog_id NOT IN (SELECT id FROM nav_catelog WHERE deleted_at IS NULL))
+		ORDER BY id;
+		`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	results := make([]types.Tool, 0)
+	for rows.Next() {
+		var tool types.Tool
+		if err = rows.Scan(&tool.Id, &tool.Name, &tool.CatelogId); err != nil {
+			return nil, err
+		}
+		results = append(results, tool)
+	}
+	return results, rows.Err()
+}
+
+func (r doctorRepository) MoveTool(id int64, catelogId int) error {
+	return checkAffected(r.db.Exec(`UPDATE nav_table SET catelog_id = ? WHERE id = ?;`, catelogId, id))
+}
+
+func (r doctorRepository) DanglingImgs() ([]types.Img, error) {
+	used := make(map[string]bool)
+	rows, err := r.db.Query(`SELECT DISTINCT logo FROM nav_table WHERE logo IS NOT NULL AND logo != '';`)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var logo string
+		if err = rows.Scan(&logo); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		used[url.QueryEscape(logo)] = true
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = r.db.Query(`SELECT id, COALESCE(url, '') FROM nav_img ORDER BY id;`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	results := make([]types.Img, 0)
+	for rows.Next() {
+		var img types.Img
+		if err = rows.Scan(&img.Id, &img.Url); err != nil {
+			return nil, err
+		}
+		if !used[img.Url] {
+			results = append(results, img)
+		}
+	}
+	return results, rows.Err()
+}
+
+func (r doctorRepository) DeleteImg(id int) error {
+	return checkAffected(r.db.Exec(`DELETE FROM nav_img WHERE id = ?;`, id))
+}
@@ -0,0 +1,28 @@
+package database
+
+import (
+	"database/sql"
+
+	"github.com/ziren926/van-nav/types"
+)
+
+type imgRepository struct {
+	db *sql.DB
+}
+
+func (r imgRepository) GetByUrl(url string) (types.Img, error) {
+	var img types.Img
+	err := r.db.QueryRow(`SELECT id, COALESCE(url, ''), COALESCE(value, '') FROM nav_img WHERE url = ?;`, url).
+		Scan(&img.Id, &img.Url, &img.Value)
+	return img, notFound(err)
+}
+
+func (r imgRepository) Add(url string, value string) error {
+	_, err := r.db.Exec(`INSERT INTO nav_img (url, value) VALUES (?, ?);`, url, value)
+	return err
+}
+
+func (r imgRepository) DeleteByUrl(url string) error {
+	_, err := r.db.Exec(`DELETE FROM nav_img WHERE url = ?;`, url)
+	return err
+}
@@ -0,0 +1,334 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ziren926/van-nav/types"
+	"github.com/ziren926/van-nav/utils"
+)
+
+// 导入时可以被匹配到的工具，row 是 0 表示库里已有的，否则是导入数据里的第几行
+type importTarget struct {
+	tool types.Tool
+	row  int
+}
+
+// 一次导入，先在事务里把已有的工具、分类和用过的 id 读出来，再一行一行处理
+type importer struct {
+	tx       *sql.Tx
+	dialect  dialect
+	options  types.ImportOptions
+	by       string
+	now      time.Time
+	report   *types.ImportReport
+	targets  map[string][]*importTarget
+	catelogs map[string]int
+	usedIds  map[int64]bool
+}
+
+// Import 按 options 导入工具，所有改动在一个事务里，DryRun 时最后回滚，只返回导入计划。
+// 覆盖已有的工具时先把旧版本存进修改历史，by 是操作人
+func (r toolRepository) Import(tools []types.Tool, options types.ImportOptions, by string, now time.Time) (types.ImportReport, error) {
+	report := types.ImportReport{
+		DryRun:      options.DryRun,
+		Strategy:    options.Strategy,
+		MatchBy:     options.MatchBy,
+		NewCatelogs: make([]string, 0),
+		Items:       make([]types.ImportItem, 0, len(tools)),
+	}
+	tx, err := r.db.Begin()
+	if err != nil {
+		return report, err
+	}
+	defer tx.Rollback()
+	im := &importer{
+		tx:       tx,
+		dialect:  r.dialect,
+		options:  options,
+		by:       by,
+		now:      now,
+		report:   &report,
+		targets:  make(map[string][]*importTarget),
+		catelogs: make(map[string]int),
+		usedIds:  make(map[int64]bool),
+	}
+	if err = im.load(); err != nil {
+		return report, err
+	}
+	for i, tool := range tools {
+		item, err := im.importRow(i+1, tool)
+		if err != nil {
+			return report, fmt.Errorf("导入第 %d 行失败: %s", i+1, err)
+		}
+		switch item.Action {
+		case types.ImportCreated:
+			report.Created++
+		case types.ImportUpdated:
+			report.Updated++
+		case types.ImportSkipped:
+			report.Skipped++
+		case types.ImportConflict:
+			report.Conflicts++
+		}
+		report.Items = append(report.Items, item)
+	}
+	if options.DryRun {
+		// 回滚之后新建的 id 都不算数
+		for i := range report.Items {
+			if report.Items[i].Action == types.ImportCreated {
+				report.Items[i].Id = 0
+			}
+		}
+		return report, nil
+	}
+	return report, tx.Commit()
+}
+
+func (im *importer) load() error {
+	query := `SELECT ` + toolColumns + toolFrom + ` WHERE t.deleted_at IS NULL`
+	args := make([]interface{}, 0)
+	if im.options.CatelogId != 0 {
+		query += ` AND t.catelog_id = ?`
+		args = append(args, im.options.CatelogId)
+	}
+	rows, err := im.tx.Query(query+` ORDER BY t.id;`, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		tool, err := scanTool(rows)
+		if err != nil {
+			return err
+		}
+		im.addTarget(tool, 0)
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	// 重名的分类用 id 最小的，和 GetByName 一样
+	catelogRows, err := im.tx.Query(`SELECT id, COALESCE(name, '') FROM nav_catelog WHERE deleted_at IS NULL ORDER BY id DESC;`)
+	if err != nil {
+		return err
+	}
+	defer catelogRows.Close()
+	for catelogRows.Next() {
+		var id int
+		var name string
+		if err = catelogRows.Scan(&id, &name); err != nil {
+			return err
+		}
+		im.catelogs[name] = id
+	}
+	if err = catelogRows.Err(); err != nil {
+		return err
+	}
+
+	// 回收站里的工具也占着 id
+	idRows, err := im.tx.Query(`SELECT id FROM nav_table;`)
+	if err != nil {
+		return err
+	}
+	defer idRows.Close()
+	for idRows.Next() {
+		var id int64
+		if err = idRows.Scan(&id); err != nil {
+			return err
+		}
+		im.usedIds[id] = true
+	}
+	return idRows.Err()
+}
+
+// 判断是不是同一个工具用的值，为空表示不和任何工具匹配
+func (im *importer) matchKey(tool types.Tool) string {
+	if im.options.MatchBy == types.ImportMatchId {
+		if tool.Id <= 0 {
+			return ""
+		}
+		return strconv.FormatInt(tool.Id, 10)
+	}
+	return utils.NormalizeUrl(tool.Url)
+}
+
+func (im *importer) addTarget(tool types.Tool, row int) {
+	if key := im.matchKey(tool); key != "" {
+		im.targets[key] = append(im.targets[key], &importTarget{tool: tool, row: row})
+	}
+}
+
+func (im *importer) importRow(row int, tool types.Tool) (types.ImportItem, error) {
+	item := types.ImportItem{Row: row, Name: tool.Name, Url: tool.Url, Catelog: tool.Catelog}
+	if strings.TrimSpace(tool.Name) == "" || strings.TrimSpace(tool.Url) == "" {
+		item.Action = types.ImportSkipped
+		item.Reason = "名称或网址为空"
+		return item, nil
+	}
+	key := im.matchKey(tool)
+	matches := im.targets[key]
+	if key == "" || len(matches) == 0 {
+		return im.create(item, tool)
+	}
+	first := matches[0]
+	if first.row == 0 {
+		item.MatchedId = first.tool.Id
+	}
+	switch im.options.Strategy {
+	case types.ImportKeepBoth:
+		return im.create(item, tool)
+	case types.ImportOverwrite:
+		if len(matches) > 1 {
+			item.Action = types.ImportConflict
+			item.MatchedId = 0
+			item.Reason = fmt.Sprintf("匹配到 %d 个工具，不知道覆盖哪一个", len(matches))
+			return item, nil
+		}
+		if first.row != 0 {
+			item.Action = types.ImportConflict
+			item.Reason = fmt.Sprintf("和第 %d 行是同一个工具", first.row)
+			return item, nil
+		}
+		return im.overwrite(item, tool, first)
+	default:
+		item.Action = types.ImportSkipped
+		if first.row != 0 {
+			item.Reason = fmt.Sprintf("和第 %d 行重复", first.row)
+		} else {
+			item.Reason = "已经有这个工具"
+		}
+		return item, nil
+	}
+}
+
+// 分类不存在时在事务里新建
+func (im *importer) catelogId(name string) (int, error) {
+	if name == "" {
+		return 0, nil
+	}
+	if id, ok := im.catelogs[name]; ok {
+		return id, nil
+	}
+	id, err := im.dialect.insert(im.tx, `INSERT INTO nav_catelog (name, sort, hide) VALUES (?, ?, ?);`, name, 0, false)
+	if err != nil {
+		return 0, err
+	}
+	im.catelogs[name] = int(id)
+	im.report.NewCatelogs = append(im.report.NewCatelogs, name)
+	return int(id), nil
+}
+
+// 导入数据里的 id 没被用过时沿用，导出再导入到空的实例里 id 不变
+func (im *importer) create(item types.ImportItem, tool types.Tool) (types.ImportItem, error) {
+	catelogId, err := im.catelogId(tool.Catelog)
+	if err != nil {
+		return item, err
+	}
+	if tool.PostCreatedAt.IsZero() {
+		tool.PostCreatedAt = im.now
+	}
+	if tool.PostUpdatedAt.IsZero() {
+		tool.PostUpdatedAt = tool.PostCreatedAt
+	}
+	args := []interface{}{
+		tool.Name, tool.Url, tool.Logo, tool.Desc, catelogIdValue(catelogId),
+		tool.Sort, tool.Hide, tool.Content,
+		tool.PostTitle, tool.PostContent,
+		tool.PostCreatedAt, tool.PostUpdatedAt,
+	}
+	const columns = `name, url, logo, "desc", catelog_id,
+			sort, hide, content,
+			post_title, post_content,
+			post_created_at, post_updated_at`
+	const insertWithId = `INSERT INTO nav_table (id, ` + columns + `) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);`
+	var id int64
+	switch {
+	case tool.Id > 0 && !im.usedIds[tool.Id]:
+		id = tool.Id
+		_, err = im.tx.Exec(insertWithId, append([]interface{}{id}, args...)...)
+		// 马上同步自增序列，不然后面不带 id 的行在 PostgreSQL 上可能撞上。
+		// PostgreSQL 的 setval 不跟着事务回滚，试运行时不能同步
+		if err == nil && !im.options.DryRun {
+			err = im.dialect.syncSequence(im.tx, "nav_table")
+		}
+	case im.options.DryRun:
+		// 试运行时序列没同步，不带 id 的行用现有的最大 id 加一，免得和前面手动指定的 id 撞上
+		if err = im.tx.QueryRow(`SELECT COALESCE(MAX(id), 0) + 1 FROM nav_table;`).Scan(&id); err == nil {
+			_, err = im.tx.Exec(insertWithId, append([]interface{}{id}, args...)...)
+		}
+	default:
+		id, err = im.dialect.insert(im.tx, `INSERT INTO nav_table (`+columns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);`, args...)
+	}
+	if err != nil {
+		return item, err
+	}
+	im.usedIds[id] = true
+	im.addTarget(tool, item.Row)
+	item.Action = types.ImportCreated
+	item.Id = id
+	return item, nil
+}
+
+// 覆盖工具的内容。导入数据里为空的字段不覆盖，排序和隐藏保留原来的，
+// 这样书签这类只有名称和网址的数据不会把原来写的内容清掉
+func (im *importer) overwrite(item types.ImportItem, tool types.Tool, target *importTarget) (types.ImportItem, error) {
+	current := target.tool
+	next := current
+	next.Name = tool.Name
+	next.Url = tool.Url
+	if tool.Catelog != "" {
+		catelogId, err := im.catelogId(tool.Catelog)
+		if err != nil {
+			return item, err
+		}
+		next.CatelogId = catelogId
+	}
+	for _, field := range []struct {
+		to   *string
+		from string
+	}{
+		{&next.Logo, tool.Logo},
+		{&next.Desc, tool.Desc},
+		{&next.Content, tool.Content},
+		{&next.PostTitle, tool.PostTitle},
+		{&next.PostContent, tool.PostContent},
+	} {
+		if field.from != "" {
+			*field.to = field.from
+		}
+	}
+	// 这个工具被这一行占了，后面再匹配到它算冲突
+	target.row = item.Row
+	item.Id = current.Id
+	if sameToolContent(current, next) {
+		item.Action = types.ImportSkipped
+		item.Reason = "内容没有变化"
+		return item, nil
+	}
+	if err := addRevision(im.tx, types.RevisionTool, current.Id, current, im.by, im.now); err != nil {
+		return item, err
+	}
+	err := checkAffected(im.tx.Exec(`
+		UPDATE nav_table
+		SET name = ?, url = ?, logo = ?, catelog_id = ?, "desc" = ?,
+			sort = ?, hide = ?, content = ?,
+			post_title = ?, post_content = ?,
+			post_updated_at = ?
+		WHERE id = ? AND deleted_at IS NULL;
+		`,
+		next.Name, next.Url, next.Logo, catelogIdValue(next.CatelogId), next.Desc,
+		next.Sort, next.Hide, next.Content,
+		next.PostTitle, next.PostContent,
+		im.now,
+		current.Id,
+	))
+	if err != nil {
+		return item, err
+	}
+	item.Action = types.ImportUpdated
+	return item, nil
+}
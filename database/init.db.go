@@ -2,9 +2,6 @@ package database
 
 import (
 	"database/sql"
-	"path/filepath"
-
-	_ "modernc.org/sqlite"
 
 	"github.com/ziren926/van-nav/logger"
 	"github.com/ziren926/van-nav/utils"
@@ -12,194 +9,37 @@ import (
 
 var DB *sql.DB
 
-func columnExists(tableName string, columnName string) bool {
-	query := `SELECT COUNT(*) FROM pragma_table_info(?) WHERE name=?`
-	var count int
-	err := DB.QueryRow(query, tableName, columnName).Scan(&count)
-	if err != nil {
-		return false
-	}
-	return count > 0
-}
+// 数据目录，数据库和密钥文件都放在这里
+var DataDir = "./data"
 
-func InitDB() {
+// 只打开数据库，不执行迁移。migrate 命令用
+func OpenDB() error {
 	var err error
-	utils.PathExistsOrCreate("./data")
-	// 创建数据库
-	dir := "./data"
-	dbPath := filepath.Join(dir, "nav.db")
-	// 添加连接参数
-	dbPath = dbPath + "?_journal=WAL&_timeout=5000&_busy_timeout=5000&_txlock=immediate"
-	DB, err = sql.Open("sqlite", dbPath)
-	utils.CheckErr(err)
-	// user 表
-	sql_create_table := `
-		CREATE TABLE IF NOT EXISTS nav_user (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			name TEXT,
-			password TEXT
-		);
-		`
-	_, err = DB.Exec(sql_create_table)
-	utils.CheckErr(err)
-	// setting 表
-	sql_create_table = `
-	CREATE TABLE IF NOT EXISTS nav_setting (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		favicon TEXT,
-		title TEXT,
-		govRecord TEXT,
-		logo192 TEXT,
-		logo512 TEXT,
-		hideAdmin BOOLEAN,
-		hideGithub BOOLEAN,
-		jumpTargetBlank BOOLEAN
-	);
-	`
-	_, err = DB.Exec(sql_create_table)
-	utils.CheckErr(err)
-	// 检查并添加列
-	if !columnExists("nav_setting", "logo192") {
-		DB.Exec(`ALTER TABLE nav_setting ADD COLUMN logo192 TEXT;`)
-	}
-	if !columnExists("nav_setting", "logo512") {
-		DB.Exec(`ALTER TABLE nav_setting ADD COLUMN logo512 TEXT;`)
-	}
-	if !columnExists("nav_setting", "govRecord") {
-		DB.Exec(`ALTER TABLE nav_setting ADD COLUMN govRecord TEXT;`)
-	}
-	if !columnExists("nav_setting", "jumpTargetBlank") {
-		DB.Exec(`ALTER TABLE nav_setting ADD COLUMN jumpTargetBlank BOOLEAN;`)
-	}
-	// 设置表表结构升级-20230628
-	if !columnExists("nav_setting", "hideAdmin") {
-		DB.Exec(`ALTER TABLE nav_setting ADD COLUMN hideAdmin BOOLEAN;`)
-	}
-	// 设置表表结构升级-20230627
-	if !columnExists("nav_setting", "hideGithub") {
-		DB.Exec(`ALTER TABLE nav_setting ADD COLUMN hideGithub BOOLEAN;`)
-	}
-
-	// 默认 tools 用的 表
-	sql_create_table = `
-		CREATE TABLE IF NOT EXISTS nav_table (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			name TEXT,
-			url TEXT,
-			logo TEXT,
-			catelog TEXT,
-			desc TEXT
-		);
-		`
-	_, err = DB.Exec(sql_create_table)
-	utils.CheckErr(err)
-
-	// tools数据表结构升级-20230327
-	if !columnExists("nav_table", "sort") {
-		DB.Exec(`ALTER TABLE nav_table ADD COLUMN sort INTEGER;`)
-	}
-
-	// tools数据表结构升级-20230627
-	if !columnExists("nav_table", "hide") {
-		DB.Exec(`ALTER TABLE nav_table ADD COLUMN hide BOOLEAN;`)
-	}
-
-	// 分类表
-	sql_create_table = `
-		CREATE TABLE IF NOT EXISTS nav_catelog (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			name TEXT
-		);
-			`
-	_, err = DB.Exec(sql_create_table)
-	utils.CheckErr(err)
-
-	// 分类表表结构升级-20230327
-	if !columnExists("nav_catelog", "sort") {
-		DB.Exec(`ALTER TABLE nav_catelog ADD COLUMN sort INTEGER NOT NULL DEFAULT 0;`)
+	// 数据目录里还有密钥文件，用 PostgreSQL 时也要有
+	utils.PathExistsOrCreate(DataDir)
+	current, source := parseDSN(DSN)
+	DB, err = current.open(source)
+	if err != nil {
+		return err
 	}
+	CurrentDialect = current.name()
+	migrations = current.migrations()
+	initRepositories(DB, current)
+	return DB.Ping()
+}
 
-	// 分类表表结构升级-20241219-【隐藏分类】
-	if !columnExists("nav_catelog", "hide") {
-		DB.Exec(`ALTER TABLE nav_catelog ADD COLUMN hide BOOLEAN;`)
+// 打开数据库并执行还没执行的迁移。上次的迁移没有完成时返回错误，不能继续启动
+func InitDB() error {
+	if err := OpenDB(); err != nil {
+		return err
 	}
-	migration_2024_12_13() // 只涉及 nav_catelog 表，所以可以放在这里
-//     migration_2025_01_19()
-//     migration_2025_01_19_fix()
-//      migration_2025_01_20()
-
-	// api token 表
-	sql_create_table = `
-		CREATE TABLE IF NOT EXISTS nav_api_token (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			name TEXT,
-			value TEXT,
-			disabled INTEGER
-		);
-		`
-	_, err = DB.Exec(sql_create_table)
-	utils.CheckErr(err)
-	// img 表
-	sql_create_table = `
-		CREATE TABLE IF NOT EXISTS nav_img (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			url TEXT,
-			value TEXT
-		);
-		`
-
-	_, err = DB.Exec(sql_create_table)
-	utils.CheckErr(err)
-	// 如果不存在，就初始化用户
-	sql_get_user := `
-		SELECT * FROM nav_user;
-		`
-	rows, err := DB.Query(sql_get_user)
-	utils.CheckErr(err)
-	if !rows.Next() {
-		sql_add_user := `
-			INSERT INTO nav_user (id, name, password)
-			VALUES (?, ?, ?);
-			`
-		stmt, err := DB.Prepare(sql_add_user)
-		utils.CheckErr(err)
-		res, err := stmt.Exec(utils.GenerateId(), "admin", "admin")
-		utils.CheckErr(err)
-		_, err = res.LastInsertId()
-		utils.CheckErr(err)
+	applied, err := MigrateUp(false)
+	if err != nil {
+		return err
 	}
-	rows.Close()
-	// 如果不存在设置，就初始化
-	sql_get_setting := `
-		SELECT * FROM nav_setting;
-		`
-	rows, err = DB.Query(sql_get_setting)
-	utils.CheckErr(err)
-	if !rows.Next() {
-		sql_add_setting := `
-			INSERT INTO nav_setting (favicon, title, govRecord, logo192, logo512, hideAdmin, hideGithub, jumpTargetBlank)
-			VALUES (?, ?, ?, ?, ?, ?, ?, ?);
-			`
-		stmt, err := DB.Prepare(sql_add_setting)
-		utils.CheckErr(err)
-		res, err := stmt.Exec("favicon.ico", "Van Nav", "", "logo192.png", "logo512.png", false, false, true)
-		utils.CheckErr(err)
-		_, err = res.LastInsertId()
-		utils.CheckErr(err)
+	if len(applied) > 0 {
+		logger.LogInfo("数据库已升级到版本 %d", LatestSchemaVersion())
 	}
-	 _, err = DB.Exec(`
-        CREATE TABLE IF NOT EXISTS posts (
-            id INTEGER PRIMARY KEY AUTOINCREMENT,
-            title TEXT NOT NULL,
-            content TEXT NOT NULL,
-            create_time DATETIME DEFAULT CURRENT_TIMESTAMP,
-            update_time DATETIME DEFAULT CURRENT_TIMESTAMP
-        )`)
-        if err != nil {
-            logger.LogError("创建posts表失败: %s", err)
-            return
-        }
-
-	rows.Close()
 	logger.LogInfo("数据库初始化成功💗")
+	return nil
 }
@@ -0,0 +1,323 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ziren926/van-nav/logger"
+)
+
+// Migration 一次数据库结构变更。Version 从 1 开始连续编号，发布后就不要再改，
+// 有新的变更就在 migrations 末尾追加。Up 和 Down 都在事务里执行，Down 为空表示不能回滚
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(tx *sql.Tx) error
+	Down    func(tx *sql.Tx) error
+}
+
+// MigrationStatus 一个迁移的执行情况
+type MigrationStatus struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+	Dirty     bool
+}
+
+// schema_version 每执行一个迁移记一行。dirty 为 1 表示迁移开始了但没有确认完成，
+// 可能只执行了一半，这时候拒绝启动，需要人工检查后用 migrate force 处理
+const sql_create_schema_version = `
+	CREATE TABLE IF NOT EXISTS schema_version (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at INTEGER NOT NULL,
+		dirty INTEGER NOT NULL DEFAULT 0
+	);
+	`
+
+// 当前数据库类型的迁移，OpenDB 时按数据库类型设置
+var migrations = sqliteMigrations
+
+func ensureSchemaVersion() error {
+	_, err := DB.Exec(sql_create_schema_version)
+	return err
+}
+
+// 当前的版本号和是否 dirty，一个迁移都没执行过时是 0
+func SchemaVersion() (int, bool, error) {
+	if err := ensureSchemaVersion(); err != nil {
+		return 0, false, err
+	}
+	var version, dirty int
+	err := DB.QueryRow(`SELECT version, dirty FROM schema_version ORDER BY version DESC LIMIT 1;`).Scan(&version, &dirty)
+	if err == sql.ErrNoRows {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	return version, dirty != 0, nil
+}
+
+// 所有迁移的执行情况，按版本号从小到大
+func MigrationsStatus() ([]MigrationStatus, error) {
+	if err := ensureSchemaVersion(); err != nil {
+		return nil, err
+	}
+	applied := make(map[int]MigrationStatus)
+	rows, err := DB.Query(`SELECT version, applied_at, dirty FROM schema_version;`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var version, dirty int
+		var appliedAt int64
+		if err := rows.Scan(&version, &appliedAt, &dirty); err != nil {
+			return nil, err
+		}
+		applied[version] = MigrationStatus{Applied: true, AppliedAt: time.Unix(appliedAt, 0), Dirty: dirty != 0}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	results := make([]MigrationStatus, 0, len(migrations))
+	for _, migration := range migrations {
+		status := applied[migration.Version]
+		status.Migration = migration
+		results = append(results, status)
+	}
+	return results, nil
+}
+
+// 启动前检查：有没完成的迁移，或者数据库比程序新，都不能继续
+func checkSchemaVersion() (int, error) {
+	version, dirty, err := SchemaVersion()
+	if err != nil {
+		return 0, err
+	}
+	if dirty {
+		return version, fmt.Errorf("数据库迁移 %d 没有完成，数据库结构可能只改了一半。请先备份数据库，检查后用 van-nav migrate force <版本号> 标记实际的版本", version)
+	}
+	if latest := LatestSchemaVersion(); version > latest {
+		return version, fmt.Errorf("数据库版本 %d 比程序支持的版本 %d 新，请使用新版本的程序", version, latest)
+	}
+	return version, nil
+}
+
+// 程序支持的最新版本
+func LatestSchemaVersion() int {
+	if len(migrations) == 0 {
+		return 0
+	}
+	return migrations[len(migrations)-1].Version
+}
+
+// 还没有执行的迁移
+func pendingMigrations(version int) []Migration {
+	results := make([]Migration, 0)
+	for _, migration := range migrations {
+		if migration.Version > version {
+			results = append(results, migration)
+		}
+	}
+	return results
+}
+
+// MigrateUp 按顺序执行所有还没执行的迁移，每个迁移一个事务。
+// dryRun 时把所有迁移放在一个事务里执行完再回滚，只检查能不能执行成功。返回执行了（或者将要执行）的迁移
+func MigrateUp(dryRun bool) ([]Migration, error) {
+	version, err := checkSchemaVersion()
+	if err != nil {
+		return nil, err
+	}
+	pending := pendingMigrations(version)
+	if dryRun {
+		return pending, dryRunMigrations(pending, false)
+	}
+	for i, migration := range pending {
+		if err = applyMigration(migration); err != nil {
+			return pending[:i], fmt.Errorf("执行迁移 %d_%s 失败: %s", migration.Version, migration.Name, err)
+		}
+		logger.LogInfo("已执行数据库迁移 %d_%s", migration.Version, migration.Name)
+	}
+	return pending, nil
+}
+
+// MigrateDown 从最新的开始回滚 steps 个迁移，有不能回滚的迁移就一个都不回滚
+func MigrateDown(steps int, dryRun bool) ([]Migration, error) {
+	version, err := checkSchemaVersion()
+	if err != nil {
+		return nil, err
+	}
+	targets := make([]Migration, 0, steps)
+	for i := len(migrations) - 1; i >= 0 && len(targets) < steps; i-- {
+		if migrations[i].Version <= version {
+			targets = append(targets, migrations[i])
+		}
+	}
+	for _, migration := range targets {
+		if migration.Down == nil {
+			return nil, fmt.Errorf("迁移 %d_%s 不能回滚", migration.Version, migration.Name)
+		}
+	}
+	if dryRun {
+		return targets, dryRunMigrations(targets, true)
+	}
+	for i, migration := range targets {
+		if err = revertMigration(migration); err != nil {
+			return targets[:i], fmt.Errorf("回滚迁移 %d_%s 失败: %s", migration.Version, migration.Name, err)
+		}
+		logger.LogInfo("已回滚数据库迁移 %d_%s", migration.Version, migration.Name)
+	}
+	return targets, nil
+}
+
+// 先把版本记成 dirty 再开始，事务提交时一起清掉。事务正常回滚的话删掉这条记录；
+// 如果程序在中途退出或者回滚失败，dirty 会留下来，下次启动时拒绝继续
+func applyMigration(migration Migration) error {
+	_, err := DB.Exec(`
+		INSERT INTO schema_version (version, name, applied_at, dirty) VALUES (?, ?, ?, 1)
+		ON CONFLICT (version) DO UPDATE SET name = excluded.name, applied_at = excluded.applied_at, dirty = 1;
+		`, migration.Version, migration.Name, time.Now().Unix())
+	if err != nil {
+		return err
+	}
+	tx, err := DB.Begin()
+	if err != nil {
+		return clearDirty(migration.Version, false, err)
+	}
+	if err = migration.Up(tx); err == nil {
+		_, err = tx.Exec(`UPDATE schema_version SET dirty = 0, applied_at = ? WHERE version = ?;`, time.Now().Unix(), migration.Version)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil && rollbackErr != sql.ErrTxDone {
+			return fmt.Errorf("%s，回滚也失败了: %s", err, rollbackErr)
+		}
+		return clearDirty(migration.Version, false, err)
+	}
+	return nil
+}
+
+func revertMigration(migration Migration) error {
+	_, err := DB.Exec(`UPDATE schema_version SET dirty = 1 WHERE version = ?;`, migration.Version)
+	if err != nil {
+		return err
+	}
+	tx, err := DB.Begin()
+	if err != nil {
+		return clearDirty(migration.Version, true, err)
+	}
+	if err = migration.Down(tx); err == nil {
+		_, err = tx.Exec(`DELETE FROM schema_version WHERE version = ?;`, migration.Version)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil && rollbackErr != sql.ErrTxDone {
+			return fmt.Errorf("%s，回滚也失败了: %s", err, rollbackErr)
+		}
+		return clearDirty(migration.Version, true, err)
+	}
+	return nil
+}
+
+// 事务已经回滚，数据库还是原来的样子，把 dirty 标记撤掉。applied 表示原来是已执行的状态
+func clearDirty(version int, applied bool, cause error) error {
+	var err error
+	if applied {
+		_, err = DB.Exec(`UPDATE schema_version SET dirty = 0 WHERE version = ?;`, version)
+	} else {
+		_, err = DB.Exec(`DELETE FROM schema_version WHERE version = ? AND dirty = 1;`, version)
+	}
+	if err != nil {
+		return fmt.Errorf("%s，清除 dirty 标记也失败了: %s", cause, err)
+	}
+	return cause
+}
+
+// 在同一个事务里依次执行，最后回滚。后面的迁移可能依赖前面的，所以不能分开试。
+// SQLite 的结构变更也可以回滚
+func dryRunMigrations(list []Migration, down bool) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, migration := range list {
+		step := migration.Up
+		if down {
+			step = migration.Down
+		}
+		if err = step(tx); err != nil {
+			return fmt.Errorf("迁移 %d_%s 执行失败: %s", migration.Version, migration.Name, err)
+		}
+	}
+	return nil
+}
+
+// ForceSchemaVersion 人工确认数据库的实际版本后，把 schema_version 改成这个版本并清掉 dirty 标记，
+// 不会执行任何迁移
+func ForceSchemaVersion(version int) error {
+	if version < 0 || version > LatestSchemaVersion() {
+		return fmt.Errorf("版本号要在 0 到 %d 之间", LatestSchemaVersion())
+	}
+	if err := ensureSchemaVersion(); err != nil {
+		return err
+	}
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err = tx.Exec(`DELETE FROM schema_version WHERE version > ?;`, version); err != nil {
+		return err
+	}
+	now := time.Now().Unix()
+	for _, migration := range migrations {
+		if migration.Version > version {
+			break
+		}
+		_, err = tx.Exec(`
+			INSERT INTO schema_version (version, name, applied_at, dirty) VALUES (?, ?, ?, 0)
+			ON CONFLICT (version) DO UPDATE SET dirty = 0;
+			`, migration.Version, migration.Name, now)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// SQLite 的迁移里用的，tx 里查列是否存在，老版本的库可能已经手动加过
+func columnExists(tx *sql.Tx, tableName string, columnName string) (bool, error) {
+	var count int
+	err := tx.QueryRow(`SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?;`, tableName, columnName).Scan(&count)
+	return count > 0, err
+}
+
+// 列不存在才添加
+func addColumn(tx *sql.Tx, tableName string, columnName string, definition string) error {
+	exists, err := columnExists(tx, tableName, columnName)
+	if err != nil || exists {
+		return err
+	}
+	_, err = tx.Exec(`ALTER TABLE ` + tableName + ` ADD COLUMN ` + columnName + ` ` + definition + `;`)
+	return err
+}
+
+// 按顺序执行多条语句，任何一条出错就停下
+func execAll(tx *sql.Tx, statements ...string) error {
+	for _, statement := range statements {
+		if _, err := tx.Exec(statement); err != nil {
+			return errors.New(err.Error() + "\n" + statement)
+		}
+	}
+	return nil
+}
@@ -1,191 +1,462 @@
 package database
 
 import (
-    "time"
-    "github.com/ziren926/van-nav/logger"
+	"database/sql"
+
+	"github.com/ziren926/van-nav/logger"
+	"github.com/ziren926/van-nav/types"
+	"github.com/ziren926/van-nav/utils"
 )
 
-func migration_2024_12_13() {
-	// 1. 首先更新现有的 NULL 值为 0
-	sql_update_null_sort := `
-        UPDATE nav_catelog
-        SET sort = 0
-        WHERE sort IS NULL;
-    `
+// SQLite 的数据库迁移，按版本号顺序执行。已经发布的迁移不要再修改，新的变更往后追加，
+// 同一个版本号在 PostgreSQL 的迁移里也要有对应的变更
+var sqliteMigrations = []Migration{
+	{Version: 1, Name: "baseline", Up: migrationBaseline},
+	{Version: 2, Name: "tool_post_columns", Up: migrationToolPostColumnsUp, Down: migrationToolPostColumnsDown},
+	{Version: 3, Name: "tool_catelog_id", Up: migrationToolCatelogIdUp, Down: migrationToolCatelogIdDown},
+	{Version: 4, Name: "soft_delete", Up: migrationSoftDeleteUp, Down: migrationSoftDeleteDown},
+	{Version: 5, Name: "revisions", Up: migrationRevisionsUp, Down: migrationRevisionsDown},
+	{Version: 6, Name: "token_catelog_id", Up: migrationTokenCatelogIdUp, Down: migrationTokenCatelogIdDown},
+}
 
-	_, err := DB.Exec(sql_update_null_sort)
+// 引入版本化迁移之前的表结构。老版本的库在启动时零散地建表、加列，这里都按“不存在才创建”处理，
+// 新库和老库执行完都是一样的结构。要回滚就只能删库，所以不提供 Down
+func migrationBaseline(tx *sql.Tx) error {
+	err := execAll(tx,
+		// 用户表，已有用户都当作 owner。totp_last_step 是最后一次用过的时间步数，防止验证码重放；
+		// oidc_subject 记录 issuer|sub，用来关联身份提供方的账号
+		`CREATE TABLE IF NOT EXISTS nav_user (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT,
+			password TEXT,
+			role TEXT NOT NULL DEFAULT 'owner',
+			totp_secret TEXT NOT NULL DEFAULT '',
+			totp_enabled INTEGER NOT NULL DEFAULT 0,
+			totp_last_step INTEGER NOT NULL DEFAULT 0,
+			oidc_subject TEXT NOT NULL DEFAULT ''
+		);`,
+		// 两步验证的恢复码，只存哈希，用过一次就作废
+		`CREATE TABLE IF NOT EXISTS nav_recovery_code (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER,
+			code_hash TEXT,
+			used_at INTEGER
+		);`,
+		`CREATE TABLE IF NOT EXISTS nav_setting (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			favicon TEXT,
+			title TEXT,
+			govRecord TEXT,
+			logo192 TEXT,
+			logo512 TEXT,
+			hideAdmin BOOLEAN,
+			hideGithub BOOLEAN,
+			jumpTargetBlank BOOLEAN,
+			privateMode BOOLEAN,
+			privateAllowlist TEXT
+		);`,
+		// 工具表
+		`CREATE TABLE IF NOT EXISTS nav_table (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT,
+			url TEXT,
+			logo TEXT,
+			catelog TEXT,
+			desc TEXT,
+			sort INTEGER,
+			hide BOOLEAN
+		);`,
+		`CREATE TABLE IF NOT EXISTS nav_catelog (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT,
+			sort INTEGER NOT NULL DEFAULT 0,
+			hide BOOLEAN
+		);`,
+		// api token 只存哈希，value 只是为了兼容老数据留着
+		`CREATE TABLE IF NOT EXISTS nav_api_token (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT,
+			value TEXT,
+			disabled INTEGER,
+			token_hash TEXT,
+			scopes TEXT NOT NULL DEFAULT '',
+			catelog TEXT NOT NULL DEFAULT '',
+			expires_at INTEGER,
+			last_used_at INTEGER,
+			last_used_ip TEXT NOT NULL DEFAULT '',
+			created_at INTEGER
+		);`,
+		// 登录会话表，id 就是 jwt 里的 jti，时间都是 unix 秒
+		`CREATE TABLE IF NOT EXISTS nav_session (
+			id TEXT PRIMARY KEY,
+			user_id INTEGER,
+			issued_at INTEGER,
+			expires_at INTEGER,
+			ip TEXT,
+			user_agent TEXT,
+			revoked_at INTEGER
+		);`,
+		// 分享链接表，id 就是分享 token 里的 jti，时间都是 unix 秒
+		`CREATE TABLE IF NOT EXISTS nav_share (
+			id TEXT PRIMARY KEY,
+			name TEXT,
+			type TEXT,
+			target_id INTEGER,
+			created_by TEXT,
+			created_at INTEGER,
+			expires_at INTEGER,
+			revoked_at INTEGER
+		);`,
+		// 审计日志表，时间是 unix 秒，changes 是修改前后差异的 json
+		`CREATE TABLE IF NOT EXISTS nav_audit (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			created_at INTEGER,
+			actor TEXT,
+			actor_type TEXT,
+			actor_id INTEGER,
+			action TEXT,
+			target_type TEXT,
+			target_id TEXT,
+			changes TEXT,
+			ip TEXT
+		);`,
+		`CREATE INDEX IF NOT EXISTS idx_nav_audit_created_at ON nav_audit (created_at);`,
+		`CREATE TABLE IF NOT EXISTS nav_img (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			url TEXT,
+			value TEXT
+		);`,
+		`CREATE TABLE IF NOT EXISTS posts (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			title TEXT NOT NULL,
+			content TEXT NOT NULL,
+			create_time DATETIME DEFAULT CURRENT_TIMESTAMP,
+			update_time DATETIME DEFAULT CURRENT_TIMESTAMP
+		);`,
+	)
 	if err != nil {
-		panic(err)
+		return err
 	}
 
-	// 2. 创建新表
-	sql_create_new_table := `
-        CREATE TABLE nav_catelog_new (
-            id INTEGER PRIMARY KEY AUTOINCREMENT,
-            name TEXT,
-            sort INTEGER NOT NULL DEFAULT 0,
-						hide BOOLEAN
-        );
-    `
-
-	_, err = DB.Exec(sql_create_new_table)
+	// 老版本的库缺的列
+	legacyColumns := []struct {
+		table      string
+		column     string
+		definition string
+	}{
+		{"nav_user", "role", "TEXT NOT NULL DEFAULT 'owner'"},
+		{"nav_user", "totp_secret", "TEXT NOT NULL DEFAULT ''"},
+		{"nav_user", "totp_enabled", "INTEGER NOT NULL DEFAULT 0"},
+		{"nav_user", "totp_last_step", "INTEGER NOT NULL DEFAULT 0"},
+		{"nav_user", "oidc_subject", "TEXT NOT NULL DEFAULT ''"},
+		{"nav_setting", "logo192", "TEXT"},
+		{"nav_setting", "logo512", "TEXT"},
+		{"nav_setting", "govRecord", "TEXT"},
+		{"nav_setting", "jumpTargetBlank", "BOOLEAN"},
+		{"nav_setting", "hideAdmin", "BOOLEAN"},
+		{"nav_setting", "hideGithub", "BOOLEAN"},
+		{"nav_setting", "privateMode", "BOOLEAN"},
+		{"nav_setting", "privateAllowlist", "TEXT"},
+		{"nav_table", "sort", "INTEGER"},
+		{"nav_table", "hide", "BOOLEAN"},
+		{"nav_catelog", "sort", "INTEGER NOT NULL DEFAULT 0"},
+		{"nav_catelog", "hide", "BOOLEAN"},
+		{"nav_api_token", "token_hash", "TEXT"},
+		{"nav_api_token", "scopes", "TEXT NOT NULL DEFAULT ''"},
+		{"nav_api_token", "catelog", "TEXT NOT NULL DEFAULT ''"},
+		{"nav_api_token", "expires_at", "INTEGER"},
+		{"nav_api_token", "last_used_at", "INTEGER"},
+		{"nav_api_token", "last_used_ip", "TEXT NOT NULL DEFAULT ''"},
+		{"nav_api_token", "created_at", "INTEGER"},
+	}
+	for _, item := range legacyColumns {
+		if err = addColumn(tx, item.table, item.column, item.definition); err != nil {
+			return err
+		}
+	}
+	err = execAll(tx,
+		`CREATE UNIQUE INDEX IF NOT EXISTS idx_nav_api_token_hash ON nav_api_token (token_hash);`,
+		// 很老的库里分类的 sort 可能是空的
+		`UPDATE nav_catelog SET sort = 0 WHERE sort IS NULL;`,
+	)
 	if err != nil {
-		panic(err)
+		return err
+	}
+	if err = hashLegacyApiTokens(tx); err != nil {
+		return err
 	}
 
-	// 3. 复制数据
-	sql_copy_data := `
-        INSERT INTO nav_catelog_new (id, name, sort, hide)
-        SELECT id, name, sort, hide FROM nav_catelog;
-    `
+	// 如果不存在设置，就初始化
+	var count int
+	if err = tx.QueryRow(`SELECT COUNT(*) FROM nav_setting;`).Scan(&count); err != nil {
+		return err
+	}
+	if count == 0 {
+		_, err = tx.Exec(`
+			INSERT INTO nav_setting (favicon, title, govRecord, logo192, logo512, hideAdmin, hideGithub, jumpTargetBlank)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?);
+			`, "favicon.ico", "Van Nav", "", "logo192.png", "logo512.png", false, false, true)
+	}
+	return err
+}
 
-	_, err = DB.Exec(sql_copy_data)
+// 旧 token 明文存在 value 里，换成哈希后清空。旧 token 原来就是全部权限，所以给 *
+func hashLegacyApiTokens(tx *sql.Tx) error {
+	rows, err := tx.Query(`SELECT id, value FROM nav_api_token WHERE token_hash IS NULL AND value IS NOT NULL AND value != '';`)
 	if err != nil {
-		panic(err)
+		return err
+	}
+	legacy := make(map[int]string)
+	for rows.Next() {
+		var id int
+		var value string
+		if err := rows.Scan(&id, &value); err != nil {
+			rows.Close()
+			return err
+		}
+		legacy[id] = value
+	}
+	rows.Close()
+	for id, value := range legacy {
+		_, err = tx.Exec(`UPDATE nav_api_token SET token_hash = ?, value = '', scopes = ? WHERE id = ?;`,
+			utils.HashApiToken(value), types.ScopeAll, id)
+		if err != nil {
+			return err
+		}
 	}
+	if len(legacy) > 0 {
+		logger.LogInfo("已将 %d 个旧 api token 改为哈希存储", len(legacy))
+	}
+	return nil
+}
 
-	// 4. 删除旧表
-	sql_drop_old_table := `DROP TABLE nav_catelog;`
+// 工具的详情和帖子。以前的版本可能已经加过其中一部分
+var toolPostColumns = []struct {
+	column     string
+	definition string
+}{
+	{"content", "TEXT"},
+	{"post_title", "TEXT"},
+	{"post_content", "TEXT"},
+	{"post_created_at", "DATETIME"},
+	{"post_updated_at", "DATETIME"},
+	{"created_by", "TEXT"},
+	{"updated_by", "TEXT"},
+}
 
-	_, err = DB.Exec(sql_drop_old_table)
+func migrationToolPostColumnsUp(tx *sql.Tx) error {
+	for _, item := range toolPostColumns {
+		if err := addColumn(tx, "nav_table", item.column, item.definition); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func migrationToolPostColumnsDown(tx *sql.Tx) error {
+	for _, item := range toolPostColumns {
+		exists, err := columnExists(tx, "nav_table", item.column)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			continue
+		}
+		if _, err = tx.Exec(`ALTER TABLE nav_table DROP COLUMN ` + item.column + `;`); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 工具改成用分类 id 关联分类，按原来的分类名称回填，名称列不再保留
+func migrationToolCatelogIdUp(tx *sql.Tx) error {
+	if err := addColumn(tx, "nav_table", "catelog_id", "INTEGER REFERENCES nav_catelog (id)"); err != nil {
+		return err
+	}
+	if err := backfillToolCatelogIds(tx); err != nil {
+		return err
+	}
+	exists, err := columnExists(tx, "nav_table", "catelog")
 	if err != nil {
-		panic(err)
+		return err
+	}
+	if exists {
+		if _, err = tx.Exec(`ALTER TABLE nav_table DROP COLUMN catelog;`); err != nil {
+			return err
+		}
 	}
+	_, err = tx.Exec(`CREATE INDEX IF NOT EXISTS idx_nav_table_catelog_id ON nav_table (catelog_id);`)
+	return err
+}
 
-	// 5. 重命名新表
-	sql_rename_table := `ALTER TABLE nav_catelog_new RENAME TO nav_catelog;`
+// 分类名称不存在的先补上分类，补上的默认隐藏，免得把原来看不到的工具露出来。
+// 有重名的分类时用 id 最小的那个，没有分类名称的工具 catelog_id 留空
+func backfillToolCatelogIds(tx *sql.Tx) error {
+	res, err := tx.Exec(`
+		INSERT INTO nav_catelog (name, sort, hide)
+		SELECT DISTINCT catelog, 0, TRUE FROM nav_table
+		WHERE catelog IS NOT NULL AND catelog != ''
+			AND catelog NOT IN (SELECT name FROM nav_catelog WHERE name IS NOT NULL);
+		`)
+	if err != nil {
+		return err
+	}
+	if added, _ := res.RowsAffected(); added > 0 {
+		logger.LogInfo("已按工具的分类名称补上 %d 个分类（隐藏）", added)
+	}
+	_, err = tx.Exec(`
+		UPDATE nav_table
+		SET catelog_id = (SELECT MIN(id) FROM nav_catelog WHERE nav_catelog.name = nav_table.catelog)
+		WHERE catelog_id IS NULL;
+		`)
+	return err
+}
+
+// SQLite 不能删除外键用到的列，只能按版本 2 的结构重建工具表，分类名称从分类表里取回来
+func migrationToolCatelogIdDown(tx *sql.Tx) error {
+	return execAll(tx,
+		`CREATE TABLE nav_table_v2 (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT,
+			url TEXT,
+			logo TEXT,
+			catelog TEXT,
+			"desc" TEXT,
+			sort INTEGER,
+			hide BOOLEAN,
+			content TEXT,
+			post_title TEXT,
+			post_content TEXT,
+			post_created_at DATETIME,
+			post_updated_at DATETIME,
+			created_by TEXT,
+			updated_by TEXT
+		);`,
+		`INSERT INTO nav_table_v2 (
+			id, name, url, logo, catelog, "desc", sort, hide, content,
+			post_title, post_content, post_created_at, post_updated_at, created_by, updated_by
+		)
+		SELECT t.id, t.name, t.url, t.logo, c.name, t."desc", t.sort, t.hide, t.content,
+			t.post_title, t.post_content, t.post_created_at, t.post_updated_at, t.created_by, t.updated_by
+		FROM nav_table t LEFT JOIN nav_catelog c ON c.id = t.catelog_id;`,
+		`DROP TABLE nav_table;`,
+		`ALTER TABLE nav_table_v2 RENAME TO nav_table;`,
+	)
+}
+
+// 有回收站的表，deleted_at 是放进回收站的时间，unix 秒，为空表示没有删除
+var softDeleteTables = []string{"nav_table", "nav_catelog", "posts"}
+
+func migrationSoftDeleteUp(tx *sql.Tx) error {
+	for _, table := range softDeleteTables {
+		if err := addColumn(tx, table, "deleted_at", "INTEGER"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 回滚后回收站里的内容会重新出现，不会丢数据
+func migrationSoftDeleteDown(tx *sql.Tx) error {
+	for _, table := range softDeleteTables {
+		exists, err := columnExists(tx, table, "deleted_at")
+		if err != nil {
+			return err
+		}
+		if !exists {
+			continue
+		}
+		if _, err = tx.Exec(`ALTER TABLE ` + table + ` DROP COLUMN deleted_at;`); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 工具和帖子的修改历史，每次修改前把旧版本整个存成 json。
+// target_type 是 tool 或 post，created_at 是这次修改的时间，unix 秒
+func migrationRevisionsUp(tx *sql.Tx) error {
+	return execAll(tx,
+		`CREATE TABLE IF NOT EXISTS nav_revision (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			target_type TEXT NOT NULL,
+			target_id INTEGER NOT NULL,
+			data TEXT NOT NULL,
+			created_by TEXT NOT NULL DEFAULT '',
+			created_at INTEGER NOT NULL
+		);`,
+		`CREATE INDEX IF NOT EXISTS idx_nav_revision_target ON nav_revision (target_type, target_id);`,
+	)
+}
+
+func migrationRevisionsDown(tx *sql.Tx) error {
+	return execAll(tx, `DROP TABLE IF EXISTS nav_revision;`)
+}
 
-	_, err = DB.Exec(sql_rename_table)
+// 限定分类的 api token 改成用分类 id 关联分类，按原来的分类名称回填。
+// 对应的分类已经不在的 token 直接停用，免得变成不限分类的 token
+func migrationTokenCatelogIdUp(tx *sql.Tx) error {
+	if err := addColumn(tx, "nav_api_token", "catelog_id", "INTEGER REFERENCES nav_catelog (id)"); err != nil {
+		return err
+	}
+	if err := backfillTokenCatelogIds(tx); err != nil {
+		return err
+	}
+	exists, err := columnExists(tx, "nav_api_token", "catelog")
 	if err != nil {
-		panic(err)
-	}
-}
-//
-// func migration_2025_01_19() {
-//     // 添加帖子相关字段到 nav_table 表
-//     if !columnExists("nav_table", "post_title") {
-//         DB.Exec(`ALTER TABLE nav_table ADD COLUMN post_title TEXT;`)
-//     }
-//     if !columnExists("nav_table", "post_content") {
-//         DB.Exec(`ALTER TABLE nav_table ADD COLUMN post_content TEXT;`)
-//     }
-//     if !columnExists("nav_table", "post_created_at") {
-//         DB.Exec(`ALTER TABLE nav_table ADD COLUMN post_created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP;`)
-//     }
-//     if !columnExists("nav_table", "post_updated_at") {
-//         DB.Exec(`ALTER TABLE nav_table ADD COLUMN post_updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP;`)
-//     }
-// }
-//
-// func migration_2025_01_19_fix() {
-//     // 1. 添加 content 字段（如果不存在）
-//     if !columnExists("nav_table", "content") {
-//         DB.Exec(`ALTER TABLE nav_table ADD COLUMN content TEXT;`)
-//     }
-//
-//     // 2. 确保所有必需字段都存在
-//     if !columnExists("nav_table", "sort") {
-//         DB.Exec(`ALTER TABLE nav_table ADD COLUMN sort INTEGER DEFAULT 0;`)
-//     }
-//     if !columnExists("nav_table", "hide") {
-//         DB.Exec(`ALTER TABLE nav_table ADD COLUMN hide BOOLEAN DEFAULT 0;`)
-//     }
-// }
-
-
-
-
-//
-// func migration_2025_01_20() {
-//     logger.LogInfo("开始执行 2025-01-20 数据库迁移...")
-//
-//     // 获取当前时间
-//     currentTime := time.Date(2025, 1, 20, 3, 15, 30, 0, time.UTC)
-//
-//     // 1. 更新现有帖子的更新时间和创建时间
-//     updateSQL := `
-//         UPDATE nav_table
-//         SET post_updated_at = ?,
-//             post_created_at = ?
-//         WHERE post_title IS NOT NULL
-//           AND post_content IS NOT NULL
-//           AND (post_updated_at IS NULL OR post_created_at IS NULL)
-//     `
-//
-//     if _, err := DB.Exec(updateSQL, currentTime, currentTime); err != nil {
-//         logger.LogError("更新帖子时间戳失败: %v", err)
-//     }
-//
-//     // 2. 创建新表以确保字段的正确性和默认值
-//     createTempTable := `
-//         CREATE TABLE nav_table_new (
-//             id INTEGER PRIMARY KEY AUTOINCREMENT,
-//             name TEXT,
-//             url TEXT,
-//             logo TEXT,
-//             catelog TEXT,
-//             desc TEXT,
-//             content TEXT,
-//             sort INTEGER DEFAULT 0,
-//             hide BOOLEAN DEFAULT 0,
-//             post_title TEXT,
-//             post_content TEXT,
-//             post_created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-//             post_updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
-//         )
-//     `
-//
-//     // 3. 复制数据到新表
-//     migrateData := `
-//         INSERT INTO nav_table_new
-//         SELECT id, name, url, logo, catelog, desc, content,
-//                COALESCE(sort, 0) as sort,
-//                COALESCE(hide, 0) as hide,
-//                post_title, post_content,
-//                COALESCE(post_created_at, ?) as post_created_at,
-//                COALESCE(post_updated_at, ?) as post_updated_at
-//         FROM nav_table
-//     `
-//
-//     // 执行迁移
-//     tx, err := DB.Begin()
-//     if err != nil {
-//         logger.LogError("开始事务失败: %v", err)
-//         return
-//     }
-//
-//     // 执行创建新表
-//     if _, err := tx.Exec(createTempTable); err != nil {
-//         logger.LogError("创建新表失败: %v", err)
-//         tx.Rollback()
-//         return
-//     }
-//
-//     // 复制数据
-//     if _, err := tx.Exec(migrateData, currentTime, currentTime); err != nil {
-//         logger.LogError("迁移数据失败: %v", err)
-//         tx.Rollback()
-//         return
-//     }
-//
-//     // 替换旧表
-//     if _, err := tx.Exec("DROP TABLE nav_table"); err != nil {
-//         logger.LogError("删除旧表失败: %v", err)
-//         tx.Rollback()
-//         return
-//     }
-//
-//     if _, err := tx.Exec("ALTER TABLE nav_table_new RENAME TO nav_table"); err != nil {
-//         logger.LogError("重命名表失败: %v", err)
-//         tx.Rollback()
-//         return
-//     }
-//
-//     // 提交事务
-//     if err := tx.Commit(); err != nil {
-//         logger.LogError("提交事务失败: %v", err)
-//         return
-//     }
-//
-//     logger.LogInfo("2025-01-20 03:15:30 数据库迁移完成")
-// }
\ No newline at end of file
+		return err
+	}
+	if exists {
+		_, err = tx.Exec(`ALTER TABLE nav_api_token DROP COLUMN catelog;`)
+	}
+	return err
+}
+
+func backfillTokenCatelogIds(tx *sql.Tx) error {
+	res, err := tx.Exec(`
+		UPDATE nav_api_token SET disabled = 1
+		WHERE catelog IS NOT NULL AND catelog != ''
+			AND catelog NOT IN (SELECT name FROM nav_catelog WHERE name IS NOT NULL);
+		`)
+	if err != nil {
+		return err
+	}
+	if disabled, _ := res.RowsAffected(); disabled > 0 {
+		logger.LogInfo("已停用 %d 个 api token，它们限定的分类已经不存在", disabled)
+	}
+	_, err = tx.Exec(`
+		UPDATE nav_api_token
+		SET catelog_id = (SELECT MIN(id) FROM nav_catelog WHERE nav_catelog.name = nav_api_token.catelog)
+		WHERE catelog_id IS NULL AND catelog IS NOT NULL AND catelog != '';
+		`)
+	return err
+}
+
+// SQLite 不能删除外键用到的列，只能按版本 5 的结构重建 token 表，分类名称从分类表里取回来
+func migrationTokenCatelogIdDown(tx *sql.Tx) error {
+	return execAll(tx,
+		`CREATE TABLE nav_api_token_v5 (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT,
+			value TEXT,
+			disabled INTEGER,
+			token_hash TEXT,
+			scopes TEXT NOT NULL DEFAULT '',
+			catelog TEXT NOT NULL DEFAULT '',
+			expires_at INTEGER,
+			last_used_at INTEGER,
+			last_used_ip TEXT NOT NULL DEFAULT '',
+			created_at INTEGER
+		);`,
+		`INSERT INTO nav_api_token_v5 (
+			id, name, value, disabled, token_hash, scopes, catelog, expires_at, last_used_at, last_used_ip, created_at
+		)
+		SELECT t.id, t.name, t.value, t.disabled, t.token_hash, t.scopes, COALESCE(c.name, ''),
+			t.expires_at, t.last_used_at, t.last_used_ip, t.created_at
+		FROM nav_api_token t LEFT JOIN nav_catelog c ON c.id = t.catelog_id;`,
+		`DROP TABLE nav_api_token;`,
+		`ALTER TABLE nav_api_token_v5 RENAME TO nav_api_token;`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS idx_nav_api_token_hash ON nav_api_token (token_hash);`,
+	)
+}
@@ -0,0 +1,239 @@
+package database
+
+import (
+	"database/sql"
+)
+
+// PostgreSQL 的数据库迁移，版本号和 SQLite 的一一对应。
+// PostgreSQL 没有需要兼容的老库，基线直接建好当时的结构
+var postgresMigrations = []Migration{
+	{Version: 1, Name: "baseline", Up: postgresBaseline},
+	{Version: 2, Name: "tool_post_columns", Up: postgresToolPostColumnsUp, Down: postgresToolPostColumnsDown},
+	{Version: 3, Name: "tool_catelog_id", Up: postgresToolCatelogIdUp, Down: postgresToolCatelogIdDown},
+	{Version: 4, Name: "soft_delete", Up: postgresSoftDeleteUp, Down: postgresSoftDeleteDown},
+	{Version: 5, Name: "revisions", Up: postgresRevisionsUp, Down: postgresRevisionsDown},
+	{Version: 6, Name: "token_catelog_id", Up: postgresTokenCatelogIdUp, Down: postgresTokenCatelogIdDown},
+}
+
+// 时间都是 unix 秒，用 BIGINT；desc 是关键字，要加引号
+func postgresBaseline(tx *sql.Tx) error {
+	err := execAll(tx,
+		`CREATE TABLE IF NOT EXISTS nav_user (
+			id SERIAL PRIMARY KEY,
+			name TEXT,
+			password TEXT,
+			role TEXT NOT NULL DEFAULT 'owner',
+			totp_secret TEXT NOT NULL DEFAULT '',
+			totp_enabled INTEGER NOT NULL DEFAULT 0,
+			totp_last_step BIGINT NOT NULL DEFAULT 0,
+			oidc_subject TEXT NOT NULL DEFAULT ''
+		);`,
+		`CREATE TABLE IF NOT EXISTS nav_recovery_code (
+			id SERIAL PRIMARY KEY,
+			user_id INTEGER,
+			code_hash TEXT,
+			used_at BIGINT
+		);`,
+		`CREATE TABLE IF NOT EXISTS nav_setting (
+			id SERIAL PRIMARY KEY,
+			favicon TEXT,
+			title TEXT,
+			govRecord TEXT,
+			logo192 TEXT,
+			logo512 TEXT,
+			hideAdmin BOOLEAN,
+			hideGithub BOOLEAN,
+			jumpTargetBlank BOOLEAN,
+			privateMode BOOLEAN,
+			privateAllowlist TEXT
+		);`,
+		`CREATE TABLE IF NOT EXISTS nav_table (
+			id SERIAL PRIMARY KEY,
+			name TEXT,
+			url TEXT,
+			logo TEXT,
+			catelog TEXT,
+			"desc" TEXT,
+			sort INTEGER,
+			hide BOOLEAN
+		);`,
+		`CREATE TABLE IF NOT EXISTS nav_catelog (
+			id SERIAL PRIMARY KEY,
+			name TEXT,
+			sort INTEGER NOT NULL DEFAULT 0,
+			hide BOOLEAN
+		);`,
+		`CREATE TABLE IF NOT EXISTS nav_api_token (
+			id SERIAL PRIMARY KEY,
+			name TEXT,
+			value TEXT,
+			disabled INTEGER,
+			token_hash TEXT,
+			scopes TEXT NOT NULL DEFAULT '',
+			catelog TEXT NOT NULL DEFAULT '',
+			expires_at BIGINT,
+			last_used_at BIGINT,
+			last_used_ip TEXT NOT NULL DEFAULT '',
+			created_at BIGINT
+		);`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS idx_nav_api_token_hash ON nav_api_token (token_hash);`,
+		`CREATE TABLE IF NOT EXISTS nav_session (
+			id TEXT PRIMARY KEY,
+			user_id INTEGER,
+			issued_at BIGINT,
+			expires_at BIGINT,
+			ip TEXT,
+			user_agent TEXT,
+			revoked_at BIGINT
+		);`,
+		`CREATE TABLE IF NOT EXISTS nav_share (
+			id TEXT PRIMARY KEY,
+			name TEXT,
+			type TEXT,
+			target_id INTEGER,
+			created_by TEXT,
+			created_at BIGINT,
+			expires_at BIGINT,
+			revoked_at BIGINT
+		);`,
+		`CREATE TABLE IF NOT EXISTS nav_audit (
+			id SERIAL PRIMARY KEY,
+			created_at BIGINT,
+			actor TEXT,
+			actor_type TEXT,
+			actor_id INTEGER,
+			action TEXT,
+			target_type TEXT,
+			target_id TEXT,
+			changes TEXT,
+			ip TEXT
+		);`,
+		`CREATE INDEX IF NOT EXISTS idx_nav_audit_created_at ON nav_audit (created_at);`,
+		`CREATE TABLE IF NOT EXISTS nav_img (
+			id SERIAL PRIMARY KEY,
+			url TEXT,
+			value TEXT
+		);`,
+		`CREATE TABLE IF NOT EXISTS posts (
+			id SERIAL PRIMARY KEY,
+			title TEXT NOT NULL,
+			content TEXT NOT NULL,
+			create_time TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			update_time TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		);`,
+	)
+	if err != nil {
+		return err
+	}
+
+	var count int
+	if err = tx.QueryRow(`SELECT COUNT(*) FROM nav_setting;`).Scan(&count); err != nil {
+		return err
+	}
+	if count == 0 {
+		_, err = tx.Exec(`
+			INSERT INTO nav_setting (favicon, title, govRecord, logo192, logo512, hideAdmin, hideGithub, jumpTargetBlank)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?);
+			`, "favicon.ico", "Van Nav", "", "logo192.png", "logo512.png", false, false, true)
+	}
+	return err
+}
+
+func postgresToolPostColumnsUp(tx *sql.Tx) error {
+	for _, item := range toolPostColumns {
+		definition := item.definition
+		if definition == "DATETIME" {
+			definition = "TIMESTAMP"
+		}
+		if _, err := tx.Exec(`ALTER TABLE nav_table ADD COLUMN IF NOT EXISTS ` + item.column + ` ` + definition + `;`); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func postgresToolPostColumnsDown(tx *sql.Tx) error {
+	for _, item := range toolPostColumns {
+		if _, err := tx.Exec(`ALTER TABLE nav_table DROP COLUMN IF EXISTS ` + item.column + `;`); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func postgresToolCatelogIdUp(tx *sql.Tx) error {
+	_, err := tx.Exec(`ALTER TABLE nav_table ADD COLUMN IF NOT EXISTS catelog_id INTEGER REFERENCES nav_catelog (id);`)
+	if err != nil {
+		return err
+	}
+	if err = backfillToolCatelogIds(tx); err != nil {
+		return err
+	}
+	return execAll(tx,
+		`ALTER TABLE nav_table DROP COLUMN IF EXISTS catelog;`,
+		`CREATE INDEX IF NOT EXISTS idx_nav_table_catelog_id ON nav_table (catelog_id);`,
+	)
+}
+
+func postgresToolCatelogIdDown(tx *sql.Tx) error {
+	return execAll(tx,
+		`ALTER TABLE nav_table ADD COLUMN IF NOT EXISTS catelog TEXT;`,
+		`UPDATE nav_table SET catelog = (SELECT name FROM nav_catelog WHERE nav_catelog.id = nav_table.catelog_id);`,
+		`ALTER TABLE nav_table DROP COLUMN IF EXISTS catelog_id;`,
+	)
+}
+
+func postgresSoftDeleteUp(tx *sql.Tx) error {
+	for _, table := range softDeleteTables {
+		if _, err := tx.Exec(`ALTER TABLE ` + table + ` ADD COLUMN IF NOT EXISTS deleted_at BIGINT;`); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func postgresSoftDeleteDown(tx *sql.Tx) error {
+	for _, table := range softDeleteTables {
+		if _, err := tx.Exec(`ALTER TABLE ` + table + ` DROP COLUMN IF EXISTS deleted_at;`); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func postgresRevisionsUp(tx *sql.Tx) error {
+	return execAll(tx,
+		`CREATE TABLE IF NOT EXISTS nav_revision (
+			id SERIAL PRIMARY KEY,
+			target_type TEXT NOT NULL,
+			target_id BIGINT NOT NULL,
+			data TEXT NOT NULL,
+			created_by TEXT NOT NULL DEFAULT '',
+			created_at BIGINT NOT NULL
+		);`,
+		`CREATE INDEX IF NOT EXISTS idx_nav_revision_target ON nav_revision (target_type, target_id);`,
+	)
+}
+
+func postgresRevisionsDown(tx *sql.Tx) error {
+	return execAll(tx, `DROP TABLE IF EXISTS nav_revision;`)
+}
+
+func postgresTokenCatelogIdUp(tx *sql.Tx) error {
+	_, err := tx.Exec(`ALTER TABLE nav_api_token ADD COLUMN IF NOT EXISTS catelog_id INTEGER REFERENCES nav_catelog (id);`)
+	if err != nil {
+		return err
+	}
+	if err = backfillTokenCatelogIds(tx); err != nil {
+		return err
+	}
+	return execAll(tx, `ALTER TABLE nav_api_token DROP COLUMN IF EXISTS catelog;`)
+}
+
+func postgresTokenCatelogIdDown(tx *sql.Tx) error {
+	return execAll(tx,
+		`ALTER TABLE nav_api_token ADD COLUMN IF NOT EXISTS catelog TEXT NOT NULL DEFAULT '';`,
+		`UPDATE nav_api_token SET catelog = COALESCE((SELECT name FROM nav_catelog WHERE nav_catelog.id = nav_api_token.catelog_id), '');`,
+		`ALTER TABLE nav_api_token DROP COLUMN IF EXISTS catelog_id;`,
+	)
+}
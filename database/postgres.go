@@ -0,0 +1,104 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+type postgresDialect struct{}
+
+func (postgresDialect) name() Dialect {
+	return DialectPostgres
+}
+
+func (postgresDialect) open(source string) (*sql.DB, error) {
+	connector, err := pq.NewConnector(source)
+	if err != nil {
+		return nil, err
+	}
+	return sql.OpenDB(rebindConnector{connector}), nil
+}
+
+func (postgresDialect) migrations() []Migration {
+	return postgresMigrations
+}
+
+func (postgresDialect) insert(q execQueryer, query string, args ...interface{}) (int64, error) {
+	query = strings.TrimRight(strings.TrimSpace(query), ";") + " RETURNING id;"
+	var id int64
+	err := q.QueryRow(query, args...).Scan(&id)
+	return id, err
+}
+
+func (postgresDialect) syncSequence(q execQueryer, table string) error {
+	_, err := q.Exec(`SELECT setval(pg_get_serial_sequence('` + table + `', 'id'), GREATEST(MAX(id), 1)) FROM ` + table + `;`)
+	return err
+}
+
+// 包一层 pq 的连接，执行前把 ? 换成 $n，这样其他地方的 SQL 不用区分数据库
+type rebindConnector struct {
+	driver.Connector
+}
+
+func (c rebindConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return rebindConn{conn}, nil
+}
+
+type rebindConn struct {
+	driver.Conn
+}
+
+func (c rebindConn) Prepare(query string) (driver.Stmt, error) {
+	return c.Conn.Prepare(rebind(query))
+}
+
+func (c rebindConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	if conn, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		return conn.PrepareContext(ctx, rebind(query))
+	}
+	return c.Prepare(query)
+}
+
+func (c rebindConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	if conn, ok := c.Conn.(driver.QueryerContext); ok {
+		return conn.QueryContext(ctx, rebind(query), args)
+	}
+	return nil, driver.ErrSkip
+}
+
+func (c rebindConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if conn, ok := c.Conn.(driver.ExecerContext); ok {
+		return conn.ExecContext(ctx, rebind(query), args)
+	}
+	return nil, driver.ErrSkip
+}
+
+func (c rebindConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if conn, ok := c.Conn.(driver.ConnBeginTx); ok {
+		return conn.BeginTx(ctx, opts)
+	}
+	return c.Conn.Begin()
+}
+
+func (c rebindConn) Ping(ctx context.Context) error {
+	if conn, ok := c.Conn.(driver.Pinger); ok {
+		return conn.Ping(ctx)
+	}
+	return nil
+}
+
+// 连接断开后让连接池丢掉这个连接
+func (c rebindConn) IsValid() bool {
+	if conn, ok := c.Conn.(driver.Validator); ok {
+		return conn.IsValid()
+	}
+	return true
+}
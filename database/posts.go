@@ -0,0 +1,72 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/ziren926/van-nav/types"
+)
+
+type postRepository struct {
+	db      *sql.DB
+	dialect dialect
+}
+
+func (r postRepository) GetAll() ([]types.Post, error) {
+	rows, err := r.db.Query(`SELECT id, title, content, create_time, update_time FROM posts WHERE deleted_at IS NULL ORDER BY create_time DESC;`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	results := make([]types.Post, 0)
+	for rows.Next() {
+		var post types.Post
+		if err = rows.Scan(&post.ID, &post.Title, &post.Content, &post.CreateTime, &post.UpdateTime); err != nil {
+			return nil, err
+		}
+		results = append(results, post)
+	}
+	return results, rows.Err()
+}
+
+func (r postRepository) GetById(id int64) (types.Post, error) {
+	var post types.Post
+	err := r.db.QueryRow(`SELECT id, title, content, create_time, update_time FROM posts WHERE id = ? AND deleted_at IS NULL;`, id).
+		Scan(&post.ID, &post.Title, &post.Content, &post.CreateTime, &post.UpdateTime)
+	return post, notFound(err)
+}
+
+func (r postRepository) Add(post types.Post) (int64, error) {
+	return r.dialect.insert(r.db, `INSERT INTO posts (title, content, create_time, update_time) VALUES (?, ?, ?, ?);`,
+		post.Title, post.Content, post.CreateTime, post.UpdateTime)
+}
+
+func (r postRepository) Update(post types.Post, by string) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var current types.Post
+	err = tx.QueryRow(`SELECT id, title, content, create_time, update_time FROM posts WHERE id = ? AND deleted_at IS NULL;`, post.ID).
+		Scan(&current.ID, &current.Title, &current.Content, &current.CreateTime, &current.UpdateTime)
+	if err != nil {
+		return notFound(err)
+	}
+	// 只改了时间不算改动
+	if current.Title != post.Title || current.Content != post.Content {
+		if err = addRevision(tx, types.RevisionPost, post.ID, current, by, post.UpdateTime); err != nil {
+			return err
+		}
+	}
+	err = checkAffected(tx.Exec(`UPDATE posts SET title = ?, content = ?, update_time = ? WHERE id = ? AND deleted_at IS NULL;`,
+		post.Title, post.Content, post.UpdateTime, post.ID))
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r postRepository) Delete(id int64, now time.Time) error {
+	return checkAffected(r.db.Exec(`UPDATE posts SET deleted_at = ? WHERE id = ? AND deleted_at IS NULL;`, now.Unix(), id))
+}
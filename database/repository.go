@@ -0,0 +1,260 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/ziren926/van-nav/types"
+)
+
+// 要找的记录不存在
+var ErrNotFound = errors.New("记录不存在")
+
+// 已经有用户了，不能再初始化
+var ErrUsersExist = errors.New("已经有用户了")
+
+// 分类下还有工具，不能直接删除
+var ErrCatelogNotEmpty = errors.New("分类下还有工具")
+
+// 从回收站恢复分类时已经有同名的分类了
+var ErrCatelogNameTaken = errors.New("已经有同名的分类")
+
+// 外部身份已经关联到别的用户，或者这个用户已经关联了别的外部身份
+var ErrSubjectLinked = errors.New("已经关联过单点登录账号")
+
+// ToolRepository 工具，帖子是挂在工具上的，也在这里。查询和修改都不包括回收站里的
+type ToolRepository interface {
+	GetAll() ([]types.Tool, error)
+	GetById(id int64) (types.Tool, error)
+	// 返回新工具的 id
+	Add(tool types.Tool) (int64, error)
+	// 按 options 和已有的工具去重后导入，在一个事务里完成，DryRun 时回滚，只返回导入计划。
+	// 覆盖时把旧版本存进修改历史，by 是操作人
+	Import(tools []types.Tool, options types.ImportOptions, by string, now time.Time) (types.ImportReport, error)
+	// 更新工具本身的字段和帖子内容，帖子的更新时间用 tool.PostUpdatedAt。
+	// 有改动时先把旧版本存进修改历史，by 是操作人
+	Update(tool types.Tool, by string) error
+	UpdateLogo(id int64, logo string) error
+	UpdateSort(updates []types.UpdateToolsSortDto) error
+	// 放进回收站，图标缓存留着，恢复时还要用
+	Delete(id int64, now time.Time) error
+	// 包括回收站里的工具，它们的图标缓存也不能删
+	LogoInUse(logo string) (bool, error)
+	GetPost(id int64) (types.Post, error)
+	// 第一次写帖子，同时记下创建人和创建时间。和 UpdatePost 一样会存修改历史
+	AddPost(id int64, post types.Post, by string) error
+	UpdatePost(id int64, post types.Post, by string) error
+}
+
+// CatelogRepository 分类，查询和修改都不包括回收站里的
+type CatelogRepository interface {
+	GetAll() ([]types.Catelog, error)
+	// 有重名的分类时返回 id 最小的
+	GetByName(name string) (types.Catelog, error)
+	Add(catelog types.Catelog) (int, error)
+	// 工具和限定分类的 token 都按 id 关联分类，改名不用动它们
+	Update(catelog types.Catelog) error
+	// 放进回收站。mode 见 types.CatelogDelete*，move 时把工具移到 target，cascade 时工具也放进回收站。
+	// 返回移走或删掉的工具数，reject 时分类下还有工具返回 ErrCatelogNotEmpty
+	Delete(id int, mode string, target int, now time.Time) (int64, error)
+}
+
+// SettingRepository 网站设置，只有一行
+type SettingRepository interface {
+	Get() (types.Setting, error)
+	// 私有模式和白名单，每个公开的读请求都要查，所以单独查这两列
+	GetPrivateMode() (bool, string, error)
+	Update(setting types.Setting) error
+}
+
+// UserRepository 用户、两步验证和恢复码。User.Password 是哈希
+type UserRepository interface {
+	GetAll() ([]types.User, error)
+	GetById(id int) (types.User, error)
+	GetByName(name string) (types.User, error)
+	GetBySubject(subject string) (types.User, error)
+	// 把外部身份关联到指定的本地用户，两边都只能关联一次，否则返回 ErrSubjectLinked
+	LinkSubject(id int, subject string) error
+	// 取消关联，之后这个外部身份登录时会按新用户处理
+	UnlinkSubject(id int) error
+	NameTaken(name string, exceptId int) (bool, error)
+	Count() (int, error)
+	CountByRole(role string) (int, error)
+	Add(user types.User) (int, error)
+	// 没有任何用户时创建第一个用户并设置网站标题，已经有用户时返回 ErrUsersExist
+	AddFirst(user types.User, title string) (int, error)
+	SetRole(id int, role string) error
+	SetPassword(id int, hash string) error
+	// 用户名、角色和密码在一个事务里一起改，role 或 hash 为空时不改
+	Update(id int, name string, role string, hash string) error
+	// 同时删除恢复码
+	Delete(id int) error
+
+	// 两步验证。没开启时 enabled 是 false，secret 可能是还没确认的密钥
+	GetTOTP(id int) (secret string, enabled bool, lastStep int64, err error)
+	SetTOTPSecret(id int, secret string) error
+	EnableTOTP(id int, step int64) error
+	// 只有 step 比上次用过的大才更新，返回是否更新了
+	AdvanceTOTPStep(id int, step int64) (bool, error)
+	// 同时删除恢复码
+	DisableTOTP(id int) error
+	ReplaceRecoveryCodes(id int, hashes []string) error
+	// 用掉一个恢复码，返回这个恢复码是否有效
+	UseRecoveryCode(id int, hash string, now time.Time) (bool, error)
+	CountRecoveryCodes(id int) (int, error)
+}
+
+// TokenRepository api token，只存哈希
+type TokenRepository interface {
+	// 没删除的 token，包括过期的
+	GetAll() ([]types.Token, error)
+	// 可用的 token：没删除、没过期
+	GetByHash(hash string, now time.Time) (types.Token, error)
+	Add(token types.Token, hash string) (int, error)
+	Disable(id int) error
+	// 已经删除的 token 的哈希，恢复备份时用来把它们在恢复后的库里也删掉
+	DisabledHashes() ([]string, error)
+	DisableByHash(hash string) error
+	// 记录最近一次使用的时间和 ip
+	Touch(id int, ip string, now time.Time) error
+}
+
+// ImgRepository 图标缓存，url 是转义过的图标地址
+type ImgRepository interface {
+	GetByUrl(url string) (types.Img, error)
+	Add(url string, value string) error
+	DeleteByUrl(url string) error
+}
+
+// PostRepository 独立的帖子，查询和修改都不包括回收站里的
+type PostRepository interface {
+	GetAll() ([]types.Post, error)
+	GetById(id int64) (types.Post, error)
+	Add(post types.Post) (int64, error)
+	// 标题或正文有改动时先把旧版本存进修改历史，by 是操作人
+	Update(post types.Post, by string) error
+	// 放进回收站
+	Delete(id int64, now time.Time) error
+}
+
+// TrashRepository 回收站里的工具、分类和帖子，kind 见 types.Trash*
+type TrashRepository interface {
+	// 按删除时间倒序
+	List() ([]types.TrashItem, error)
+	// 恢复工具时它的分类也在回收站里的话一起恢复；恢复分类时一起恢复跟它同时删除的工具。
+	// 已经有同名的分类时返回 ErrCatelogNameTaken
+	Restore(kind string, id int64) error
+	// 永久删除，分类下在回收站里的工具一起删除，修改历史也一起删除。返回删掉的工具的图标地址，用来清理图标缓存
+	Purge(kind string, id int64) ([]string, error)
+	// 永久删除 before 之前放进回收站的所有内容，返回删除的条数和工具的图标地址
+	PurgeBefore(before time.Time) (int64, []string, error)
+}
+
+// RevisionRepository 工具和帖子的修改历史，kind 见 types.Revision*。
+// 历史是在 Tools 和 Posts 修改时写进去的，这里只读
+type RevisionRepository interface {
+	// 按时间倒序，不带 Data
+	List(kind string, targetId int64) ([]types.Revision, error)
+	GetById(kind string, targetId int64, id int64) (types.Revision, error)
+}
+
+// SessionRepository 登录会话，id 就是 jwt 里的 jti
+type SessionRepository interface {
+	Add(session types.Session) error
+	// 会话是否还有效：存在、没被吊销、没过期
+	IsActive(id string, now time.Time) (bool, error)
+	// 用户没吊销、没过期的会话，新的在前
+	GetActive(userId int, now time.Time) ([]types.Session, error)
+	// 只能吊销自己的会话，没有这个会话或者已经吊销过返回 ErrNotFound
+	Revoke(userId int, id string, now time.Time) error
+	// 吊销用户的全部会话，返回吊销的个数
+	RevokeUser(userId int, now time.Time) (int64, error)
+	// 吊销 before 和之前签发的全部会话，库里只精确到秒，返回吊销的个数
+	RevokeIssuedBefore(before time.Time, now time.Time) (int64, error)
+	// 删除 before 之前就过期的会话
+	DeleteExpired(before time.Time) error
+}
+
+// ShareRepository 分享链接，id 就是分享 token 里的 jti。返回的 Target 是空的，由调用方补上
+type ShareRepository interface {
+	// 包括已过期和已吊销的，新的在前
+	GetAll() ([]types.Share, error)
+	GetById(id string) (types.Share, error)
+	Add(share types.Share) error
+	// 已经吊销过的返回 ErrNotFound
+	Revoke(id string, now time.Time) error
+}
+
+// AuditRepository 审计日志，只增不改
+type AuditRepository interface {
+	Add(entry types.AuditLog) error
+	// 按条件分页查询，新的在前，返回这一页和符合条件的总数。Page 和 PageSize 由调用方先规范好
+	Query(query types.AuditQueryDto) ([]types.AuditLog, int, error)
+}
+
+// DoctorRepository van-nav doctor 检查数据之间的对应关系时用的查询和修复
+type DoctorRepository interface {
+	// 没有分类、分类 id 不存在或者分类在回收站里的工具，只有 Id、Name 和 CatelogId，没有分类时 CatelogId 是 0
+	OrphanTools() ([]types.Tool, error)
+	MoveTool(id int64, catelogId int) error
+	// 没有工具在用的图标缓存，回收站里的工具用的也算在用。只有 Id 和 Url
+	DanglingImgs() ([]types.Img, error)
+	DeleteImg(id int) error
+}
+
+// 各个仓库，OpenDB 时按数据库类型初始化
+var (
+	Tools     ToolRepository
+	Catelogs  CatelogRepository
+	Settings  SettingRepository
+	Users     UserRepository
+	Tokens    TokenRepository
+	Imgs      ImgRepository
+	Posts     PostRepository
+	Trash     TrashRepository
+	Revisions RevisionRepository
+	Sessions  SessionRepository
+	Shares    ShareRepository
+	Audits    AuditRepository
+	Doctor    DoctorRepository
+)
+
+func initRepositories(db *sql.DB, d dialect) {
+	Tools = toolRepository{db, d}
+	Catelogs = catelogRepository{db, d}
+	Settings = settingRepository{db}
+	Users = userRepository{db, d}
+	Tokens = tokenRepository{db, d}
+	Imgs = imgRepository{db}
+	Posts = postRepository{db, d}
+	Trash = trashRepository{db}
+	Revisions = revisionRepository{db}
+	Sessions = sessionRepository{db}
+	Shares = shareRepository{db}
+	Audits = auditRepository{db}
+	Doctor = doctorRepository{db}
+}
+
+// 更新或删除没有影响任何一行时当作不存在
+func checkAffected(res sql.Result, err error) error {
+	if err != nil {
+		return err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// 查询单行时把 sql.ErrNoRows 换成 ErrNotFound
+func notFound(err error) error {
+	if err == sql.ErrNoRows {
+		return ErrNotFound
+	}
+	return err
+}
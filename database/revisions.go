@@ -0,0 +1,66 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/ziren926/van-nav/types"
+)
+
+type revisionRepository struct {
+	db *sql.DB
+}
+
+func (r revisionRepository) List(kind string, targetId int64) ([]types.Revision, error) {
+	rows, err := r.db.Query(`
+		SELECT id, target_type, target_id, created_by, created_at
+		FROM nav_revision
+		WHERE target_type = ? AND target_id = ?
+		ORDER BY id DESC;
+		`, kind, targetId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	results := make([]types.Revision, 0)
+	for rows.Next() {
+		var revision types.Revision
+		var createdAt int64
+		if err = rows.Scan(&revision.Id, &revision.TargetType, &revision.TargetId, &revision.CreatedBy, &createdAt); err != nil {
+			return nil, err
+		}
+		revision.CreatedAt = time.Unix(createdAt, 0)
+		results = append(results, revision)
+	}
+	return results, rows.Err()
+}
+
+func (r revisionRepository) GetById(kind string, targetId int64, id int64) (types.Revision, error) {
+	var revision types.Revision
+	var data string
+	var createdAt int64
+	err := r.db.QueryRow(`
+		SELECT id, target_type, target_id, data, created_by, created_at
+		FROM nav_revision
+		WHERE id = ? AND target_type = ? AND target_id = ?;
+		`, id, kind, targetId).
+		Scan(&revision.Id, &revision.TargetType, &revision.TargetId, &data, &revision.CreatedBy, &createdAt)
+	if err != nil {
+		return revision, notFound(err)
+	}
+	revision.Data = json.RawMessage(data)
+	revision.CreatedAt = time.Unix(createdAt, 0)
+	return revision, nil
+}
+
+// 在修改的事务里把旧版本存进修改历史，at 是这次修改的时间
+func addRevision(tx *sql.Tx, kind string, targetId int64, previous interface{}, by string, at time.Time) error {
+	data, err := json.Marshal(previous)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`INSERT INTO nav_revision (target_type, target_id, data, created_by, created_at) VALUES (?, ?, ?, ?, ?);`,
+		kind, targetId, string(data), by, at.Unix())
+	return err
+}
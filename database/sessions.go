@@ -0,0 +1,83 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/ziren926/van-nav/types"
+)
+
+type sessionRepository struct {
+	db *sql.DB
+}
+
+func (r sessionRepository) Add(session types.Session) error {
+	_, err := r.db.Exec(`
+		INSERT INTO nav_session (id, user_id, issued_at, expires_at, ip, user_agent)
+		VALUES (?, ?, ?, ?, ?, ?);
+		`, session.Id, session.UserId, session.IssuedAt.Unix(), session.ExpiresAt.Unix(), session.Ip, session.UserAgent)
+	return err
+}
+
+func (r sessionRepository) IsActive(id string, now time.Time) (bool, error) {
+	var found string
+	err := r.db.QueryRow(`SELECT id FROM nav_session WHERE id = ? AND revoked_at IS NULL AND expires_at > ?;`, id, now.Unix()).
+		Scan(&found)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (r sessionRepository) GetActive(userId int, now time.Time) ([]types.Session, error) {
+	rows, err := r.db.Query(`
+		SELECT id, user_id, issued_at, expires_at, COALESCE(ip, ''), COALESCE(user_agent, '')
+		FROM nav_session
+		WHERE user_id = ? AND revoked_at IS NULL AND expires_at > ?
+		ORDER BY issued_at DESC;
+		`, userId, now.Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	results := make([]types.Session, 0)
+	for rows.Next() {
+		var session types.Session
+		var issuedAt, expiresAt int64
+		if err = rows.Scan(&session.Id, &session.UserId, &issuedAt, &expiresAt, &session.Ip, &session.UserAgent); err != nil {
+			return nil, err
+		}
+		session.IssuedAt = time.Unix(issuedAt, 0)
+		session.ExpiresAt = time.Unix(expiresAt, 0)
+		results = append(results, session)
+	}
+	return results, rows.Err()
+}
+
+func (r sessionRepository) Revoke(userId int, id string, now time.Time) error {
+	return checkAffected(r.db.Exec(`
+		UPDATE nav_session SET revoked_at = ?
+		WHERE id = ? AND user_id = ? AND revoked_at IS NULL;
+		`, now.Unix(), id, userId))
+}
+
+func (r sessionRepository) RevokeUser(userId int, now time.Time) (int64, error) {
+	res, err := r.db.Exec(`UPDATE nav_session SET revoked_at = ? WHERE user_id = ? AND revoked_at IS NULL;`, now.Unix(), userId)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func (r sessionRepository) RevokeIssuedBefore(before time.Time, now time.Time) (int64, error) {
+	res, err := r.db.Exec(`UPDATE nav_session SET revoked_at = ? WHERE issued_at <= ? AND revoked_at IS NULL;`, now.Unix(), before.Unix())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func (r sessionRepository) DeleteExpired(before time.Time) error {
+	_, err := r.db.Exec(`DELETE FROM nav_session WHERE expires_at < ?;`, before.Unix())
+	return err
+}
@@ -0,0 +1,57 @@
+package database
+
+import (
+	"database/sql"
+
+	"github.com/ziren926/van-nav/types"
+)
+
+type settingRepository struct {
+	db *sql.DB
+}
+
+func (r settingRepository) Get() (types.Setting, error) {
+	var setting types.Setting
+	var favicon, title, govRecord, logo192, logo512, privateAllowlist sql.NullString
+	var hideAdmin, hideGithub, jumpTargetBlank, privateMode sql.NullBool
+	err := r.db.QueryRow(`
+		SELECT id, favicon, title, govRecord, logo192, logo512, hideAdmin, hideGithub, jumpTargetBlank, privateMode, privateAllowlist
+		FROM nav_setting
+		ORDER BY id ASC
+		LIMIT 1;
+		`).Scan(&setting.Id, &favicon, &title, &govRecord, &logo192, &logo512,
+		&hideAdmin, &hideGithub, &jumpTargetBlank, &privateMode, &privateAllowlist)
+	if err != nil {
+		return setting, notFound(err)
+	}
+	setting.Favicon = favicon.String
+	setting.Title = title.String
+	setting.GovRecord = govRecord.String
+	setting.Logo192 = logo192.String
+	setting.Logo512 = logo512.String
+	setting.HideAdmin = hideAdmin.Bool
+	setting.HideGithub = hideGithub.Bool
+	// 没设置过的默认在新窗口打开
+	setting.JumpTargetBlank = !jumpTargetBlank.Valid || jumpTargetBlank.Bool
+	setting.PrivateMode = privateMode.Bool
+	setting.PrivateAllowlist = privateAllowlist.String
+	return setting, nil
+}
+
+func (r settingRepository) GetPrivateMode() (bool, string, error) {
+	var privateMode sql.NullBool
+	var privateAllowlist sql.NullString
+	err := r.db.QueryRow(`SELECT privateMode, privateAllowlist FROM nav_setting ORDER BY id ASC LIMIT 1;`).
+		Scan(&privateMode, &privateAllowlist)
+	return privateMode.Bool, privateAllowlist.String, notFound(err)
+}
+
+func (r settingRepository) Update(setting types.Setting) error {
+	_, err := r.db.Exec(`
+		UPDATE nav_setting
+		SET favicon = ?, title = ?, govRecord = ?, logo192 = ?, logo512 = ?, hideAdmin = ?, hideGithub = ?, jumpTargetBlank = ?, privateMode = ?, privateAllowlist = ?
+		WHERE id = (SELECT id FROM nav_setting ORDER BY id ASC LIMIT 1);
+		`, setting.Favicon, setting.Title, setting.GovRecord, setting.Logo192, setting.Logo512,
+		setting.HideAdmin, setting.HideGithub, setting.JumpTargetBlank, setting.PrivateMode, setting.PrivateAllowlist)
+	return err
+}
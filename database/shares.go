@@ -0,0 +1,63 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/ziren926/van-nav/types"
+	"github.com/ziren926/van-nav/utils"
+)
+
+type shareRepository struct {
+	db *sql.DB
+}
+
+const shareColumns = `id, COALESCE(name, ''), type, target_id, COALESCE(created_by, ''), created_at, expires_at, revoked_at`
+
+func scanShare(scanner interface{ Scan(...interface{}) error }) (types.Share, error) {
+	var share types.Share
+	var createdAt, expiresAt int64
+	var revokedAt sql.NullInt64
+	err := scanner.Scan(&share.Id, &share.Name, &share.Type, &share.TargetId, &share.CreatedBy, &createdAt, &expiresAt, &revokedAt)
+	if err != nil {
+		return share, err
+	}
+	share.CreatedAt = time.Unix(createdAt, 0)
+	share.ExpiresAt = time.Unix(expiresAt, 0)
+	share.RevokedAt = utils.UnixToTime(revokedAt)
+	return share, nil
+}
+
+func (r shareRepository) GetAll() ([]types.Share, error) {
+	rows, err := r.db.Query(`SELECT ` + shareColumns + ` FROM nav_share ORDER BY created_at DESC;`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	results := make([]types.Share, 0)
+	for rows.Next() {
+		share, err := scanShare(rows)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, share)
+	}
+	return results, rows.Err()
+}
+
+func (r shareRepository) GetById(id string) (types.Share, error) {
+	share, err := scanShare(r.db.QueryRow(`SELECT `+shareColumns+` FROM nav_share WHERE id = ?;`, id))
+	return share, notFound(err)
+}
+
+func (r shareRepository) Add(share types.Share) error {
+	_, err := r.db.Exec(`
+		INSERT INTO nav_share (id, name, type, target_id, created_by, created_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?);
+		`, share.Id, share.Name, share.Type, share.TargetId, share.CreatedBy, share.CreatedAt.Unix(), share.ExpiresAt.Unix())
+	return err
+}
+
+func (r shareRepository) Revoke(id string, now time.Time) error {
+	return checkAffected(r.db.Exec(`UPDATE nav_share SET revoked_at = ? WHERE id = ? AND revoked_at IS NULL;`, now.Unix(), id))
+}
@@ -0,0 +1,50 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+type sqliteDialect struct{}
+
+// SQLite 的文件名和连接参数，DSN 里没有带连接参数时使用
+var (
+	SQLiteFile        = "nav.db"
+	SQLiteJournalMode = "WAL"
+	SQLiteBusyTimeout = 5 * time.Second
+)
+
+func (sqliteDialect) name() Dialect {
+	return DialectSQLite
+}
+
+// 没有带连接参数时，按 SQLiteJournalMode 和 SQLiteBusyTimeout 设置，写事务一开始就拿写锁。
+// SQLite 的外键检查默认是关的，要在每个连接上打开。驱动只认 _pragma、_txlock 这些参数
+func (sqliteDialect) open(source string) (*sql.DB, error) {
+	if !strings.Contains(source, "?") {
+		source += fmt.Sprintf("?_pragma=journal_mode(%s)&_pragma=busy_timeout(%d)&_txlock=immediate&_pragma=foreign_keys(1)",
+			SQLiteJournalMode, SQLiteBusyTimeout.Milliseconds())
+	}
+	return sql.Open("sqlite", source)
+}
+
+func (sqliteDialect) migrations() []Migration {
+	return sqliteMigrations
+}
+
+func (sqliteDialect) insert(q execQueryer, query string, args ...interface{}) (int64, error) {
+	res, err := q.Exec(query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+// SQLite 的自增 id 本来就是在现有最大值上加一
+func (sqliteDialect) syncSequence(q execQueryer, table string) error {
+	return nil
+}
@@ -0,0 +1,113 @@
+package database
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/ziren926/van-nav/types"
+	"github.com/ziren926/van-nav/utils"
+)
+
+type tokenRepository struct {
+	db      *sql.DB
+	dialect dialect
+}
+
+func (r tokenRepository) GetAll() ([]types.Token, error) {
+	rows, err := r.db.Query(`
+		SELECT t.id, COALESCE(t.name, ''), t.disabled, t.scopes, COALESCE(t.catelog_id, 0), COALESCE(c.name, ''),
+			t.expires_at, t.last_used_at, t.last_used_ip, t.created_at
+		FROM nav_api_token t LEFT JOIN nav_catelog c ON c.id = t.catelog_id
+		WHERE t.disabled = 0;
+		`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	results := make([]types.Token, 0)
+	for rows.Next() {
+		var token types.Token
+		var scopes string
+		var expiresAt, lastUsedAt, createdAt sql.NullInt64
+		err = rows.Scan(&token.Id, &token.Name, &token.Disabled, &scopes, &token.CatelogId, &token.Catelog, &expiresAt, &lastUsedAt, &token.LastUsedIp, &createdAt)
+		if err != nil {
+			return nil, err
+		}
+		token.Scopes = SplitScopes(scopes)
+		token.ExpiresAt = utils.UnixToTime(expiresAt)
+		token.LastUsedAt = utils.UnixToTime(lastUsedAt)
+		token.CreatedAt = utils.UnixToTime(createdAt)
+		results = append(results, token)
+	}
+	return results, rows.Err()
+}
+
+func (r tokenRepository) GetByHash(hash string, now time.Time) (types.Token, error) {
+	var token types.Token
+	var scopes string
+	err := r.db.QueryRow(`
+		SELECT t.id, COALESCE(t.name, ''), t.scopes, COALESCE(t.catelog_id, 0), COALESCE(c.name, '')
+		FROM nav_api_token t LEFT JOIN nav_catelog c ON c.id = t.catelog_id
+		WHERE t.token_hash = ? AND t.disabled = 0 AND (t.expires_at IS NULL OR t.expires_at > ?);
+		`, hash, now.Unix()).Scan(&token.Id, &token.Name, &scopes, &token.CatelogId, &token.Catelog)
+	token.Scopes = SplitScopes(scopes)
+	return token, notFound(err)
+}
+
+func (r tokenRepository) Add(token types.Token, hash string) (int, error) {
+	var catelogId, expiresAt, createdAt interface{}
+	if token.CatelogId != 0 {
+		catelogId = token.CatelogId
+	}
+	if token.ExpiresAt != nil {
+		expiresAt = token.ExpiresAt.Unix()
+	}
+	if token.CreatedAt != nil {
+		createdAt = token.CreatedAt.Unix()
+	}
+	id, err := r.dialect.insert(r.db, `
+		INSERT INTO nav_api_token (name, value, disabled, token_hash, scopes, catelog_id, expires_at, created_at)
+		VALUES (?, '', 0, ?, ?, ?, ?, ?);
+		`, token.Name, hash, strings.Join(token.Scopes, ","), catelogId, expiresAt, createdAt)
+	return int(id), err
+}
+
+func (r tokenRepository) Disable(id int) error {
+	return checkAffected(r.db.Exec(`UPDATE nav_api_token SET disabled = 1 WHERE id = ?;`, id))
+}
+
+func (r tokenRepository) DisabledHashes() ([]string, error) {
+	rows, err := r.db.Query(`SELECT token_hash FROM nav_api_token WHERE disabled = 1 AND token_hash IS NOT NULL;`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	results := make([]string, 0)
+	for rows.Next() {
+		var hash string
+		if err = rows.Scan(&hash); err != nil {
+			return nil, err
+		}
+		results = append(results, hash)
+	}
+	return results, rows.Err()
+}
+
+func (r tokenRepository) DisableByHash(hash string) error {
+	return checkAffected(r.db.Exec(`UPDATE nav_api_token SET disabled = 1 WHERE token_hash = ? AND disabled = 0;`, hash))
+}
+
+// 一分钟内同一个 ip 重复使用不再写库
+func (r tokenRepository) Touch(id int, ip string, now time.Time) error {
+	_, err := r.db.Exec(`
+		UPDATE nav_api_token SET last_used_at = ?, last_used_ip = ?
+		WHERE id = ? AND (last_used_at IS NULL OR last_used_at < ? OR last_used_ip != ?);
+		`, now.Unix(), ip, id, now.Unix()-60, ip)
+	return err
+}
+
+// 库里 scopes 用逗号分隔
+func SplitScopes(scopes string) []string {
+	return utils.SplitAndTrim(scopes)
+}
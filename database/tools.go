@@ -0,0 +1,223 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/ziren926/van-nav/types"
+)
+
+type toolRepository struct {
+	db      *sql.DB
+	dialect dialect
+}
+
+// 查询工具的列，和 scanTool 对应。时间列不能包在 COALESCE 里，不然驱动不知道是时间类型
+const toolColumns = `t.id, COALESCE(t.name, ''), COALESCE(t.url, ''), COALESCE(t.logo, ''), t.catelog_id, COALESCE(c.name, ''), COALESCE(t."desc", ''),
+	t.sort, t.hide, COALESCE(t.content, ''), COALESCE(t.post_title, ''), COALESCE(t.post_content, ''),
+	t.post_created_at, t.post_updated_at`
+
+// 工具只存分类 id，分类名称从分类表里连出来，接口里还是返回名称。
+// 除了回收站，所有查询和修改都只看 deleted_at 为空的工具
+const toolFrom = ` FROM nav_table t LEFT JOIN nav_catelog c ON c.id = t.catelog_id`
+
+func scanTool(scanner interface{ Scan(...interface{}) error }) (types.Tool, error) {
+	var tool types.Tool
+	var catelogId, sort sql.NullInt64
+	var hide sql.NullBool
+	// 没有帖子的是 NULL
+	var postCreatedAt, postUpdatedAt sql.NullTime
+	err := scanner.Scan(
+		&tool.Id, &tool.Name, &tool.Url, &tool.Logo, &catelogId, &tool.Catelog, &tool.Desc,
+		&sort, &hide, &tool.Content, &tool.PostTitle, &tool.PostContent,
+		&postCreatedAt, &postUpdatedAt,
+	)
+	tool.CatelogId = int(catelogId.Int64)
+	tool.Sort = int(sort.Int64)
+	tool.Hide = hide.Bool
+	tool.PostCreatedAt = postCreatedAt.Time
+	tool.PostUpdatedAt = postUpdatedAt.Time
+	return tool, err
+}
+
+// 没有分类时存 NULL，外键不检查 NULL
+func catelogIdValue(id int) interface{} {
+	if id == 0 {
+		return nil
+	}
+	return id
+}
+
+func (r toolRepository) GetAll() ([]types.Tool, error) {
+	rows, err := r.db.Query(`SELECT ` + toolColumns + toolFrom + ` WHERE t.deleted_at IS NULL ORDER BY t.sort;`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	results := make([]types.Tool, 0)
+	for rows.Next() {
+		tool, err := scanTool(rows)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, tool)
+	}
+	return results, rows.Err()
+}
+
+func (r toolRepository) GetById(id int64) (types.Tool, error) {
+	tool, err := scanTool(r.db.QueryRow(`SELECT `+toolColumns+toolFrom+` WHERE t.id = ? AND t.deleted_at IS NULL;`, id))
+	return tool, notFound(err)
+}
+
+func (r toolRepository) Add(tool types.Tool) (int64, error) {
+	return r.dialect.insert(r.db, `
+		INSERT INTO nav_table (
+			name, url, logo, "desc", catelog_id,
+			sort, hide, content,
+			post_title, post_content,
+			post_created_at, post_updated_at
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);
+		`,
+		tool.Name, tool.Url, tool.Logo, tool.Desc, catelogIdValue(tool.CatelogId),
+		tool.Sort, tool.Hide, tool.Content,
+		tool.PostTitle, tool.PostContent,
+		tool.PostCreatedAt, tool.PostUpdatedAt,
+	)
+}
+
+func (r toolRepository) Update(tool types.Tool, by string) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	err = saveToolRevision(tx, tool.Id, by, tool.PostUpdatedAt, func(next *types.Tool) {
+		*next = tool
+	})
+	if err != nil {
+		return err
+	}
+	err = checkAffected(tx.Exec(`
+		UPDATE nav_table
+		SET name = ?, url = ?, logo = ?, catelog_id = ?, "desc" = ?,
+			sort = ?, hide = ?, content = ?,
+			post_title = ?, post_content = ?,
+			post_updated_at = ?
+		WHERE id = ? AND deleted_at IS NULL;
+		`,
+		tool.Name, tool.Url, tool.Logo, catelogIdValue(tool.CatelogId), tool.Desc,
+		tool.Sort, tool.Hide, tool.Content,
+		tool.PostTitle, tool.PostContent,
+		tool.PostUpdatedAt,
+		tool.Id,
+	))
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// 修改工具前把当前版本存进修改历史，change 把当前版本改成修改后的样子，没有改动时不存
+func saveToolRevision(tx *sql.Tx, id int64, by string, at time.Time, change func(next *types.Tool)) error {
+	current, err := scanTool(tx.QueryRow(`SELECT `+toolColumns+toolFrom+` WHERE t.id = ? AND t.deleted_at IS NULL;`, id))
+	if err != nil {
+		return notFound(err)
+	}
+	next := current
+	change(&next)
+	if sameToolContent(current, next) {
+		return nil
+	}
+	return addRevision(tx, types.RevisionTool, id, current, by, at)
+}
+
+// 分类名称跟着分类 id 走，时间每次保存都会变，都不算改动
+func sameToolContent(a types.Tool, b types.Tool) bool {
+	for _, tool := range []*types.Tool{&a, &b} {
+		tool.Catelog = ""
+		tool.PostCreatedAt = time.Time{}
+		tool.PostUpdatedAt = time.Time{}
+	}
+	return a == b
+}
+
+func (r toolRepository) UpdateLogo(id int64, logo string) error {
+	return checkAffected(r.db.Exec(`UPDATE nav_table SET logo = ? WHERE id = ? AND deleted_at IS NULL;`, logo, id))
+}
+
+func (r toolRepository) UpdateSort(updates []types.UpdateToolsSortDto) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, update := range updates {
+		if _, err = tx.Exec(`UPDATE nav_table SET sort = ? WHERE id = ? AND deleted_at IS NULL;`, update.Sort, update.Id); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (r toolRepository) Delete(id int64, now time.Time) error {
+	return checkAffected(r.db.Exec(`UPDATE nav_table SET deleted_at = ? WHERE id = ? AND deleted_at IS NULL;`, now.Unix(), id))
+}
+
+func (r toolRepository) LogoInUse(logo string) (bool, error) {
+	var count int
+	err := r.db.QueryRow(`SELECT COUNT(*) FROM nav_table WHERE logo = ?;`, logo).Scan(&count)
+	return count > 0, err
+}
+
+func (r toolRepository) GetPost(id int64) (types.Post, error) {
+	var post types.Post
+	var createdAt, updatedAt sql.NullTime
+	err := r.db.QueryRow(`
+		SELECT COALESCE(post_title, ''), COALESCE(post_content, ''), post_created_at, post_updated_at
+		FROM nav_table
+		WHERE id = ? AND deleted_at IS NULL;
+		`, id).Scan(&post.Title, &post.Content, &createdAt, &updatedAt)
+	post.ID = id
+	post.CreateTime = createdAt.Time
+	post.UpdateTime = updatedAt.Time
+	return post, notFound(err)
+}
+
+func (r toolRepository) AddPost(id int64, post types.Post, by string) error {
+	return r.writePost(id, post, by, `
+		UPDATE nav_table
+		SET post_title = ?, post_content = ?,
+			post_created_at = ?, post_updated_at = ?,
+			created_by = ?, updated_by = ?
+		WHERE id = ? AND deleted_at IS NULL;
+		`, post.Title, post.Content, post.CreateTime, post.UpdateTime, by, by, id)
+}
+
+func (r toolRepository) UpdatePost(id int64, post types.Post, by string) error {
+	return r.writePost(id, post, by, `
+		UPDATE nav_table
+		SET post_title = ?, post_content = ?, post_updated_at = ?, updated_by = ?
+		WHERE id = ? AND deleted_at IS NULL;
+		`, post.Title, post.Content, post.UpdateTime, by, id)
+}
+
+// 帖子是工具的一部分，修改历史存的是整个工具
+func (r toolRepository) writePost(id int64, post types.Post, by string, query string, args ...interface{}) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	err = saveToolRevision(tx, id, by, post.UpdateTime, func(next *types.Tool) {
+		next.PostTitle = post.Title
+		next.PostContent = post.Content
+	})
+	if err != nil {
+		return err
+	}
+	if err = checkAffected(tx.Exec(query, args...)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,239 @@
+package database
+
+import (
+	"database/sql"
+	"sort"
+	"time"
+
+	"github.com/ziren926/van-nav/types"
+)
+
+type trashRepository struct {
+	db *sql.DB
+}
+
+func (r trashRepository) List() ([]types.TrashItem, error) {
+	results := make([]types.TrashItem, 0)
+	queries := []struct {
+		kind  string
+		query string
+	}{
+		{types.TrashTool, `
+			SELECT t.id, COALESCE(t.name, ''), COALESCE(c.name, ''), COALESCE(t.catelog_id, 0), t.deleted_at
+			FROM nav_table t LEFT JOIN nav_catelog c ON c.id = t.catelog_id
+			WHERE t.deleted_at IS NOT NULL;`},
+		{types.TrashCatelog, `SELECT id, COALESCE(name, ''), '', 0, deleted_at FROM nav_catelog WHERE deleted_at IS NOT NULL;`},
+		{types.TrashPost, `SELECT id, title, '', 0, deleted_at FROM posts WHERE deleted_at IS NOT NULL;`},
+	}
+	for _, item := range queries {
+		rows, err := r.db.Query(item.query)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			trash := types.TrashItem{Type: item.kind}
+			var deletedAt int64
+			if err = rows.Scan(&trash.Id, &trash.Name, &trash.Catelog, &trash.CatelogId, &deletedAt); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			trash.DeletedAt = time.Unix(deletedAt, 0)
+			results = append(results, trash)
+		}
+		rows.Close()
+		if err = rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].DeletedAt.After(results[j].DeletedAt)
+	})
+	return results, nil
+}
+
+func (r trashRepository) Restore(kind string, id int64) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	switch kind {
+	case types.TrashTool:
+		var catelogId sql.NullInt64
+		err = tx.QueryRow(`SELECT catelog_id FROM nav_table WHERE id = ? AND deleted_at IS NOT NULL;`, id).Scan(&catelogId)
+		if err != nil {
+			return notFound(err)
+		}
+		if catelogId.Valid {
+			if err = restoreCatelog(tx, catelogId.Int64); err != nil && err != ErrNotFound {
+				return err
+			}
+		}
+		err = checkAffected(tx.Exec(`UPDATE nav_table SET deleted_at = NULL WHERE id = ?;`, id))
+	case types.TrashCatelog:
+		var deletedAt int64
+		err = tx.QueryRow(`SELECT deleted_at FROM nav_catelog WHERE id = ? AND deleted_at IS NOT NULL;`, id).Scan(&deletedAt)
+		if err != nil {
+			return notFound(err)
+		}
+		if err = restoreCatelog(tx, id); err != nil {
+			return err
+		}
+		_, err = tx.Exec(`UPDATE nav_table SET deleted_at = NULL WHERE catelog_id = ? AND deleted_at = ?;`, id, deletedAt)
+	case types.TrashPost:
+		err = checkAffected(tx.Exec(`UPDATE posts SET deleted_at = NULL WHERE id = ? AND deleted_at IS NOT NULL;`, id))
+	default:
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// 恢复回收站里的分类，分类不在回收站里时返回 ErrNotFound
+func restoreCatelog(tx *sql.Tx, id int64) error {
+	var name string
+	err := tx.QueryRow(`SELECT COALESCE(name, '') FROM nav_catelog WHERE id = ? AND deleted_at IS NOT NULL;`, id).Scan(&name)
+	if err != nil {
+		return notFound(err)
+	}
+	var count int
+	err = tx.QueryRow(`SELECT COUNT(*) FROM nav_catelog WHERE name = ? AND deleted_at IS NULL;`, name).Scan(&count)
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return ErrCatelogNameTaken
+	}
+	return checkAffected(tx.Exec(`UPDATE nav_catelog SET deleted_at = NULL WHERE id = ?;`, id))
+}
+
+func (r trashRepository) Purge(kind string, id int64) ([]string, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	var logos []string
+	switch kind {
+	case types.TrashTool:
+		if logos, err = toolLogos(tx, `id = ? AND deleted_at IS NOT NULL`, id); err != nil {
+			return nil, err
+		}
+		if err = deleteRevisions(tx, types.RevisionTool, `id = ? AND deleted_at IS NOT NULL`, id); err != nil {
+			return nil, err
+		}
+		err = checkAffected(tx.Exec(`DELETE FROM nav_table WHERE id = ? AND deleted_at IS NOT NULL;`, id))
+	case types.TrashCatelog:
+		var count int
+		err = tx.QueryRow(`SELECT COUNT(*) FROM nav_table WHERE catelog_id = ? AND deleted_at IS NULL;`, id).Scan(&count)
+		if err != nil {
+			return nil, err
+		}
+		if count > 0 {
+			return nil, ErrCatelogNotEmpty
+		}
+		if logos, err = toolLogos(tx, `catelog_id = ?`, id); err != nil {
+			return nil, err
+		}
+		if err = deleteRevisions(tx, types.RevisionTool, `catelog_id = ?`, id); err != nil {
+			return nil, err
+		}
+		if _, err = tx.Exec(`DELETE FROM nav_table WHERE catelog_id = ?;`, id); err != nil {
+			return nil, err
+		}
+		if _, err = tx.Exec(`UPDATE nav_api_token SET disabled = 1, catelog_id = NULL WHERE catelog_id = ?;`, id); err != nil {
+			return nil, err
+		}
+		err = checkAffected(tx.Exec(`DELETE FROM nav_catelog WHERE id = ? AND deleted_at IS NOT NULL;`, id))
+	case types.TrashPost:
+		if err = deleteRevisions(tx, types.RevisionPost, `id = ? AND deleted_at IS NOT NULL`, id); err != nil {
+			return nil, err
+		}
+		err = checkAffected(tx.Exec(`DELETE FROM posts WHERE id = ? AND deleted_at IS NOT NULL;`, id))
+	default:
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return logos, tx.Commit()
+}
+
+// 分类要等它下面的工具都删掉以后才能删，外键不允许工具引用不存在的分类。
+// 限定了被删分类的 token 一起停用，免得变成不限分类的 token
+func (r trashRepository) PurgeBefore(before time.Time) (int64, []string, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return 0, nil, err
+	}
+	defer tx.Rollback()
+	logos, err := toolLogos(tx, `deleted_at < ?`, before.Unix())
+	if err != nil {
+		return 0, nil, err
+	}
+	if err = deleteRevisions(tx, types.RevisionTool, `deleted_at < ?`, before.Unix()); err != nil {
+		return 0, nil, err
+	}
+	if err = deleteRevisions(tx, types.RevisionPost, `deleted_at < ?`, before.Unix()); err != nil {
+		return 0, nil, err
+	}
+	_, err = tx.Exec(`
+		UPDATE nav_api_token SET disabled = 1, catelog_id = NULL
+		WHERE catelog_id IN (
+			SELECT id FROM nav_catelog
+			WHERE deleted_at < ? AND id NOT IN (SELECT catelog_id FROM nav_table WHERE deleted_at >= ? OR deleted_at IS NULL)
+		);
+		`, before.Unix(), before.Unix())
+	if err != nil {
+		return 0, nil, err
+	}
+	var total int64
+	statements := []string{
+		`DELETE FROM nav_table WHERE deleted_at < ?;`,
+		`DELETE FROM posts WHERE deleted_at < ?;`,
+		`DELETE FROM nav_catelog WHERE deleted_at < ? AND id NOT IN (SELECT catelog_id FROM nav_table WHERE catelog_id IS NOT NULL);`,
+	}
+	for _, statement := range statements {
+		res, err := tx.Exec(statement, before.Unix())
+		if err != nil {
+			return 0, nil, err
+		}
+		affected, err := res.RowsAffected()
+		if err != nil {
+			return 0, nil, err
+		}
+		total += affected
+	}
+	return total, logos, tx.Commit()
+}
+
+// 删除按条件选中的工具或帖子的修改历史，要在删除工具或帖子之前调用
+func deleteRevisions(tx *sql.Tx, kind string, where string, args ...interface{}) error {
+	table := "nav_table"
+	if kind == types.RevisionPost {
+		table = "posts"
+	}
+	_, err := tx.Exec(`DELETE FROM nav_revision WHERE target_type = ? AND target_id IN (SELECT id FROM `+table+` WHERE `+where+`);`,
+		append([]interface{}{kind}, args...)...)
+	return err
+}
+
+// 按条件查出工具的图标地址，去掉空的和重复的
+func toolLogos(tx *sql.Tx, where string, args ...interface{}) ([]string, error) {
+	rows, err := tx.Query(`SELECT DISTINCT logo FROM nav_table WHERE logo IS NOT NULL AND logo != '' AND `+where+`;`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	logos := make([]string, 0)
+	for rows.Next() {
+		var logo string
+		if err = rows.Scan(&logo); err != nil {
+			return nil, err
+		}
+		logos = append(logos, logo)
+	}
+	return logos, rows.Err()
+}
@@ -0,0 +1,256 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/ziren926/van-nav/types"
+)
+
+type userRepository struct {
+	db      *sql.DB
+	dialect dialect
+}
+
+func scanUser(row *sql.Row) (types.User, error) {
+	var user types.User
+	var name, password sql.NullString
+	err := row.Scan(&user.Id, &name, &password, &user.Role)
+	user.Name = name.String
+	user.Password = password.String
+	return user, notFound(err)
+}
+
+func (r userRepository) GetAll() ([]types.User, error) {
+	rows, err := r.db.Query(`SELECT id, COALESCE(name, ''), role FROM nav_user ORDER BY id;`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	results := make([]types.User, 0)
+	for rows.Next() {
+		var user types.User
+		if err = rows.Scan(&user.Id, &user.Name, &user.Role); err != nil {
+			return nil, err
+		}
+		results = append(results, user)
+	}
+	return results, rows.Err()
+}
+
+func (r userRepository) GetById(id int) (types.User, error) {
+	return scanUser(r.db.QueryRow(`SELECT id, name, password, role FROM nav_user WHERE id = ?;`, id))
+}
+
+func (r userRepository) GetByName(name string) (types.User, error) {
+	return scanUser(r.db.QueryRow(`SELECT id, name, password, role FROM nav_user WHERE name = ?;`, name))
+}
+
+func (r userRepository) GetBySubject(subject string) (types.User, error) {
+	return scanUser(r.db.QueryRow(`SELECT id, name, password, role FROM nav_user WHERE oidc_subject = ?;`, subject))
+}
+
+func (r userRepository) LinkSubject(id int, subject string) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var current string
+	if err = tx.QueryRow(`SELECT oidc_subject FROM nav_user WHERE id = ?;`, id).Scan(&current); err != nil {
+		return notFound(err)
+	}
+	if current == subject {
+		return nil
+	}
+	if current != "" {
+		return ErrSubjectLinked
+	}
+	var count int
+	if err = tx.QueryRow(`SELECT COUNT(*) FROM nav_user WHERE oidc_subject = ?;`, subject).Scan(&count); err != nil {
+		return err
+	}
+	if count > 0 {
+		return ErrSubjectLinked
+	}
+	if _, err = tx.Exec(`UPDATE nav_user SET oidc_subject = ? WHERE id = ?;`, subject, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r userRepository) UnlinkSubject(id int) error {
+	return checkAffected(r.db.Exec(`UPDATE nav_user SET oidc_subject = '' WHERE id = ?;`, id))
+}
+
+func (r userRepository) NameTaken(name string, exceptId int) (bool, error) {
+	var count int
+	err := r.db.QueryRow(`SELECT COUNT(*) FROM nav_user WHERE name = ? AND id != ?;`, name, exceptId).Scan(&count)
+	return count > 0, err
+}
+
+func (r userRepository) Count() (int, error) {
+	var count int
+	err := r.db.QueryRow(`SELECT COUNT(*) FROM nav_user;`).Scan(&count)
+	return count, err
+}
+
+func (r userRepository) CountByRole(role string) (int, error) {
+	var count int
+	err := r.db.QueryRow(`SELECT COUNT(*) FROM nav_user WHERE role = ?;`, role).Scan(&count)
+	return count, err
+}
+
+func (r userRepository) Add(user types.User) (int, error) {
+	id, err := r.dialect.insert(r.db, `INSERT INTO nav_user (name, password, role, oidc_subject) VALUES (?, ?, ?, ?);`,
+		user.Name, user.Password, user.Role, user.OidcSubject)
+	return int(id), err
+}
+
+// 在事务里确认还没有用户，防止两个请求同时初始化
+func (r userRepository) AddFirst(user types.User, title string) (int, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	var count int
+	if err = tx.QueryRow(`SELECT COUNT(*) FROM nav_user;`).Scan(&count); err != nil {
+		return 0, err
+	}
+	if count > 0 {
+		return 0, ErrUsersExist
+	}
+	id, err := r.dialect.insert(tx, `INSERT INTO nav_user (name, password, role) VALUES (?, ?, ?);`,
+		user.Name, user.Password, user.Role)
+	if err != nil {
+		return 0, err
+	}
+	if title != "" {
+		if _, err = tx.Exec(`UPDATE nav_setting SET title = ?;`, title); err != nil {
+			return 0, err
+		}
+	}
+	return int(id), tx.Commit()
+}
+
+func (r userRepository) SetRole(id int, role string) error {
+	return checkAffected(r.db.Exec(`UPDATE nav_user SET role = ? WHERE id = ?;`, role, id))
+}
+
+func (r userRepository) SetPassword(id int, hash string) error {
+	return checkAffected(r.db.Exec(`UPDATE nav_user SET password = ? WHERE id = ?;`, hash, id))
+}
+
+func (r userRepository) Update(id int, name string, role string, hash string) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err = checkAffected(tx.Exec(`UPDATE nav_user SET name = ? WHERE id = ?;`, name, id)); err != nil {
+		return err
+	}
+	if role != "" {
+		if _, err = tx.Exec(`UPDATE nav_user SET role = ? WHERE id = ?;`, role, id); err != nil {
+			return err
+		}
+	}
+	if hash != "" {
+		if _, err = tx.Exec(`UPDATE nav_user SET password = ? WHERE id = ?;`, hash, id); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (r userRepository) Delete(id int) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err = checkAffected(tx.Exec(`DELETE FROM nav_user WHERE id = ?;`, id)); err != nil {
+		return err
+	}
+	if _, err = tx.Exec(`DELETE FROM nav_recovery_code WHERE user_id = ?;`, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r userRepository) GetTOTP(id int) (string, bool, int64, error) {
+	var secret string
+	var enabled int
+	var lastStep int64
+	err := r.db.QueryRow(`SELECT totp_secret, totp_enabled, totp_last_step FROM nav_user WHERE id = ?;`, id).
+		Scan(&secret, &enabled, &lastStep)
+	return secret, enabled == 1, lastStep, notFound(err)
+}
+
+// 重新生成密钥时也要清掉上次用过的时间步
+func (r userRepository) SetTOTPSecret(id int, secret string) error {
+	return checkAffected(r.db.Exec(`UPDATE nav_user SET totp_secret = ?, totp_last_step = 0 WHERE id = ?;`, secret, id))
+}
+
+func (r userRepository) EnableTOTP(id int, step int64) error {
+	return checkAffected(r.db.Exec(`UPDATE nav_user SET totp_enabled = 1, totp_last_step = ? WHERE id = ?;`, step, id))
+}
+
+// 带上条件更新，两个请求同时用同一个验证码时只有一个能成功
+func (r userRepository) AdvanceTOTPStep(id int, step int64) (bool, error) {
+	err := checkAffected(r.db.Exec(`UPDATE nav_user SET totp_last_step = ? WHERE id = ? AND totp_enabled = 1 AND totp_last_step < ?;`,
+		step, id, step))
+	if err == ErrNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (r userRepository) DisableTOTP(id int) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	_, err = tx.Exec(`UPDATE nav_user SET totp_secret = '', totp_enabled = 0, totp_last_step = 0 WHERE id = ?;`, id)
+	if err != nil {
+		return err
+	}
+	if _, err = tx.Exec(`DELETE FROM nav_recovery_code WHERE user_id = ?;`, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r userRepository) ReplaceRecoveryCodes(id int, hashes []string) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err = tx.Exec(`DELETE FROM nav_recovery_code WHERE user_id = ?;`, id); err != nil {
+		return err
+	}
+	for _, hash := range hashes {
+		if _, err = tx.Exec(`INSERT INTO nav_recovery_code (user_id, code_hash) VALUES (?, ?);`, id, hash); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (r userRepository) UseRecoveryCode(id int, hash string, now time.Time) (bool, error) {
+	err := checkAffected(r.db.Exec(`UPDATE nav_recovery_code SET used_at = ? WHERE user_id = ? AND code_hash = ? AND used_at IS NULL;`,
+		now.Unix(), id, hash))
+	if err == ErrNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (r userRepository) CountRecoveryCodes(id int) (int, error) {
+	var count int
+	err := r.db.QueryRow(`SELECT COUNT(*) FROM nav_recovery_code WHERE user_id = ? AND used_at IS NULL;`, id).Scan(&count)
+	return count, err
+}
@@ -9,6 +9,7 @@ import (
 	"net/url"
 	"regexp"
 	"strings"
+	"time"
 
 	"golang.org/x/net/html"
 	"golang.org/x/net/html/charset"
@@ -17,6 +18,8 @@ import (
 var (
 	EscapedFragment string = "_escaped_fragment_="
 	fragmentRegexp         = regexp.MustCompile("#!(.*)")
+	// 每次请求的超时时间，包括读取响应
+	Timeout = 10 * time.Second
 )
 
 type Scraper struct {
@@ -129,6 +132,7 @@ func (scraper *Scraper) getDocument() (*Document, error) {
 		Transport: &http.Transport{
 			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
 		},
+		Timeout: Timeout,
 	}
 
 	resp, err := client.Do(req)
@@ -330,8 +334,6 @@ func (scraper *Scraper) parseDocument(doc *Document) error {
 		}
 
 	}
-
-	return nil
 }
 
 func avoidByte(b byte) bool {
@@ -0,0 +1,35 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ziren926/van-nav/service"
+	"github.com/ziren926/van-nav/types"
+	"github.com/ziren926/van-nav/utils"
+)
+
+// 分页查看审计日志，可以按操作人、动作、对象和时间范围筛选
+func GetAuditsHandler(c *gin.Context) {
+	var query types.AuditQueryDto
+	if err := c.ShouldBindQuery(&query); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success":      false,
+			"errorMessage": err.Error(),
+		})
+		return
+	}
+	result, err := service.GetAudits(query)
+	if err != nil {
+		utils.CheckErr(err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success":      false,
+			"errorMessage": err.Error(),
+		})
+		return
+	}
+	c.JSON(200, gin.H{
+		"success": true,
+		"data":    result,
+	})
+}
@@ -0,0 +1,122 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ziren926/van-nav/middleware"
+	"github.com/ziren926/van-nav/service"
+	"github.com/ziren926/van-nav/types"
+	"github.com/ziren926/van-nav/utils"
+)
+
+// 查看当前和宽限期内的 JWT 签名密钥（只返回 kid 和时间）
+func GetJWTKeysHandler(c *gin.Context) {
+	c.JSON(200, gin.H{
+		"success": true,
+		"data":    utils.GetJWTKeys(),
+	})
+}
+
+// 轮换 JWT 签名密钥，旧密钥签发的 token 在宽限期内仍然有效
+func RotateJWTKeyHandler(c *gin.Context) {
+	key, err := utils.RotateJWTKey()
+	if err != nil {
+		utils.CheckErr(err)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success":      false,
+			"errorMessage": err.Error(),
+		})
+		return
+	}
+	middleware.SetAudit(c, "jwtKey.rotate", "jwtKey", key.Kid, nil, nil)
+	c.JSON(200, gin.H{
+		"success": true,
+		"message": "轮换密钥成功",
+		"data":    key,
+	})
+}
+
+// 列出当前用户所有有效的会话
+func GetSessionsHandler(c *gin.Context) {
+	sessions := service.GetActiveSessions(c.GetInt("uid"))
+	jti := c.GetString("jti")
+	for i := range sessions {
+		sessions[i].Current = sessions[i].Id == jti
+	}
+	c.JSON(200, gin.H{
+		"success": true,
+		"data":    sessions,
+	})
+}
+
+// 吊销某个会话
+func RevokeSessionHandler(c *gin.Context) {
+	revoked, err := service.RevokeSession(c.GetInt("uid"), c.Param("id"))
+	if err != nil {
+		utils.CheckErr(err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success":      false,
+			"errorMessage": err.Error(),
+		})
+		return
+	}
+	if !revoked {
+		c.JSON(http.StatusNotFound, gin.H{
+			"success":      false,
+			"errorMessage": "会话不存在",
+		})
+		return
+	}
+	middleware.SetAudit(c, "session.revoke", "session", c.Param("id"), nil, nil)
+	c.JSON(200, gin.H{
+		"success": true,
+		"message": "吊销会话成功",
+	})
+}
+
+// 吊销当前用户的全部会话，包括当前这个
+func RevokeAllSessionsHandler(c *gin.Context) {
+	count, err := service.RevokeUserSessions(c.GetInt("uid"))
+	if err != nil {
+		utils.CheckErr(err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success":      false,
+			"errorMessage": err.Error(),
+		})
+		return
+	}
+	middleware.SetAudit(c, "session.revokeAll", "user", c.GetInt("uid"), nil, gin.H{"count": count})
+	c.JSON(200, gin.H{
+		"success": true,
+		"message": "吊销全部会话成功",
+		"data":    gin.H{"count": count},
+	})
+}
+
+// 查看登录失败记录和被锁定的 IP、用户名
+func GetLockoutsHandler(c *gin.Context) {
+	c.JSON(200, gin.H{
+		"success": true,
+		"data":    service.GetLoginLockouts(),
+	})
+}
+
+// 解除锁定。可以用 type=ip|user 和 key 指定，不传就全部清除
+func ClearLockoutsHandler(c *gin.Context) {
+	kind := c.Query("type")
+	if kind != "" && kind != types.LockoutIp && kind != types.LockoutUser {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success":      false,
+			"errorMessage": "无效的类型: " + kind,
+		})
+		return
+	}
+	count := service.ClearLoginLockouts(kind, c.Query("key"))
+	middleware.SetAudit(c, "lockout.clear", "lockout", c.Query("key"), nil, gin.H{"type": kind, "count": count})
+	c.JSON(200, gin.H{
+		"success": true,
+		"message": "解除锁定成功",
+		"data":    gin.H{"count": count},
+	})
+}
@@ -0,0 +1,133 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ziren926/van-nav/database"
+	"github.com/ziren926/van-nav/middleware"
+	"github.com/ziren926/van-nav/service"
+	"github.com/ziren926/van-nav/types"
+	"github.com/ziren926/van-nav/utils"
+)
+
+// 备份不存在返回 404，PostgreSQL 返回 400，其它错误返回 500
+func backupError(c *gin.Context, err error) {
+	status := http.StatusInternalServerError
+	switch err {
+	case service.ErrBackupNotFound:
+		status = http.StatusNotFound
+	case database.ErrBackupUnsupported, utils.ErrDecrypt:
+		status = http.StatusBadRequest
+	default:
+		utils.CheckErr(err)
+	}
+	c.JSON(status, gin.H{
+		"success":      false,
+		"errorMessage": err.Error(),
+	})
+}
+
+func GetBackupsHandler(c *gin.Context) {
+	backups, err := service.GetBackups()
+	if err != nil {
+		backupError(c, err)
+		return
+	}
+	c.JSON(200, gin.H{
+		"success": true,
+		"data":    backups,
+	})
+}
+
+// 马上备份一次
+func AddBackupHandler(c *gin.Context) {
+	backup, err := service.CreateBackup(types.BackupManual)
+	if err != nil {
+		backupError(c, err)
+		return
+	}
+	middleware.SetAudit(c, "backup.add", "backup", backup.Name, nil, backup)
+	c.JSON(200, gin.H{
+		"success": true,
+		"message": "备份成功",
+		"data":    backup,
+	})
+}
+
+// 原样下载，加密的备份下载后要用 van-nav backup decrypt 解密
+func DownloadBackupHandler(c *gin.Context) {
+	name := c.Param("name")
+	path, err := service.BackupPath(name)
+	if err != nil {
+		backupError(c, err)
+		return
+	}
+	middleware.SetAudit(c, "backup.download", "backup", name, nil, nil)
+	c.FileAttachment(path, name)
+}
+
+func RestoreBackupHandler(c *gin.Context) {
+	name := c.Param("name")
+	snapshot, err := service.RestoreBackup(name)
+	if err != nil {
+		if err == service.ErrBackupNotFound || err == utils.ErrDecrypt || err == database.ErrBackupUnsupported {
+			backupError(c, err)
+			return
+		}
+		utils.CheckErr(err)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success":      false,
+			"errorMessage": err.Error(),
+		})
+		return
+	}
+	middleware.SetAudit(c, "backup.restore", "backup", name, nil, gin.H{"snapshot": snapshot.Name})
+	c.JSON(200, gin.H{
+		"success": true,
+		"message": "恢复成功，所有登录会话都已失效，请重新登录",
+		"data":    snapshot,
+	})
+}
+
+// 远程备份目标最近一次上传的情况
+func GetRemoteBackupStatusHandler(c *gin.Context) {
+	c.JSON(200, gin.H{
+		"success": true,
+		"data":    service.GetRemoteBackupStatus(),
+	})
+}
+
+// 手动把一个备份重新上传到远程，比如上次上传失败了
+func PushBackupHandler(c *gin.Context) {
+	name := c.Param("name")
+	if err := service.PushBackup(name); err != nil {
+		if err == service.ErrBackupNotFound {
+			backupError(c, err)
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success":      false,
+			"errorMessage": err.Error(),
+		})
+		return
+	}
+	middleware.SetAudit(c, "backup.push", "backup", name, nil, nil)
+	c.JSON(200, gin.H{
+		"success": true,
+		"message": "已开始上传",
+	})
+}
+
+func DeleteBackupHandler(c *gin.Context) {
+	name := c.Param("name")
+	if err := service.DeleteBackup(name); err != nil {
+		backupError(c, err)
+		return
+	}
+	middleware.SetAudit(c, "backup.delete", "backup", name, nil, nil)
+	c.JSON(200, gin.H{
+		"success": true,
+		"message": "删除成功",
+	})
+}
@@ -0,0 +1,82 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ziren926/van-nav/middleware"
+	"github.com/ziren926/van-nav/service"
+	"github.com/ziren926/van-nav/types"
+	"github.com/ziren926/van-nav/utils"
+)
+
+// 上传浏览器导出的书签文件，表单字段是 file。folders 为 flatten（默认）、prefix 或 none，
+// catelog 是不在文件夹里的书签放进的分类，strategy 和 dryRun 同 importTools，按网址去重
+func ImportBookmarksHandler(c *gin.Context) {
+	header, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success":      false,
+			"errorMessage": "请上传书签文件",
+		})
+		return
+	}
+	file, err := header.Open()
+	if err != nil {
+		utils.CheckErr(err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success":      false,
+			"errorMessage": err.Error(),
+		})
+		return
+	}
+	defer file.Close()
+	bookmarkOptions := types.BookmarkOptions{
+		Folders: c.Query("folders"),
+		Catelog: c.Query("catelog"),
+	}
+	// 限定了分类的 token 只能导入到这个分类
+	tokenCatelogId := c.GetInt("tokenCatelogId")
+	if tokenCatelogId != 0 {
+		bookmarkOptions = types.BookmarkOptions{Folders: types.BookmarkFoldersNone, Catelog: c.GetString("tokenCatelog")}
+	}
+	dryRun, _ := strconv.ParseBool(c.Query("dryRun"))
+	report, ignored, err := service.ImportBookmarks(file, bookmarkOptions, types.ImportOptions{
+		Strategy:  c.Query("strategy"),
+		MatchBy:   types.ImportMatchUrl,
+		DryRun:    dryRun,
+		CatelogId: tokenCatelogId,
+	}, c.GetString("username"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success":      false,
+			"errorMessage": err.Error(),
+		})
+		return
+	}
+	message := "导入书签成功"
+	if dryRun {
+		message = "导入预览"
+	}
+	if ignored > 0 {
+		message += fmt.Sprintf("，忽略了 %d 个不是网址的书签", ignored)
+	}
+	if !dryRun {
+		middleware.SetAudit(c, "tool.importBookmarks", "tool", nil, nil, gin.H{
+			"file":      header.Filename,
+			"strategy":  report.Strategy,
+			"created":   report.Created,
+			"updated":   report.Updated,
+			"skipped":   report.Skipped,
+			"conflicts": report.Conflicts,
+			"ignored":   ignored,
+		})
+	}
+	c.JSON(200, gin.H{
+		"success": true,
+		"message": message,
+		"data":    report,
+	})
+}
@@ -0,0 +1,14 @@
+package handler
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/ziren926/van-nav/config"
+)
+
+// 当前生效的配置，密码和密钥用 ****** 代替
+func GetConfigHandler(c *gin.Context) {
+	c.JSON(200, gin.H{
+		"success": true,
+		"data":    config.Current.Redacted(),
+	})
+}
@@ -0,0 +1,32 @@
+package handler
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/ziren926/van-nav/middleware"
+	"github.com/ziren926/van-nav/service"
+	"github.com/ziren926/van-nav/types"
+)
+
+// 检查数据库，只报告不修改
+func GetDoctorHandler(c *gin.Context) {
+	c.JSON(200, gin.H{
+		"success": true,
+		"data":    service.RunDoctor(false),
+	})
+}
+
+// 检查数据库并修复能自动修复的问题
+func FixDoctorHandler(c *gin.Context) {
+	report := service.RunDoctor(true)
+	fixed := make([]types.DoctorIssue, 0)
+	for _, issue := range report.Issues {
+		if issue.Fixed {
+			fixed = append(fixed, issue)
+		}
+	}
+	middleware.SetAudit(c, "doctor.fix", "database", nil, nil, fixed)
+	c.JSON(200, gin.H{
+		"success": true,
+		"data":    report,
+	})
+}
@@ -2,14 +2,16 @@ package handler
 
 import (
 	"encoding/base64"
+	"math"
 	"net/http"
-	"net/url"
 	"strconv"
 	"strings"
+	"time"
     "fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/ziren926/van-nav/database"
 	"github.com/ziren926/van-nav/logger"
+	"github.com/ziren926/van-nav/middleware"
 	"github.com/ziren926/van-nav/service"
 	"github.com/ziren926/van-nav/types"
 	"github.com/ziren926/van-nav/utils"
@@ -25,6 +27,7 @@ func ExportToolsHandler(c *gin.Context) {
 }
 
 func ImportToolsHandler(c *gin.Context) {
+	// strategy 为 skip（默认）、overwrite 或 keepBoth，matchBy 为 url（默认）或 id，dryRun=true 时只返回导入计划
 	var tools []types.Tool
 	err := c.ShouldBindJSON(&tools)
 	if err != nil {
@@ -35,37 +38,75 @@ func ImportToolsHandler(c *gin.Context) {
 		})
 		return
 	}
-	// 导入所有工具
-	service.ImportTools(tools)
+	catelogs := make([]string, 0, len(tools))
+	for _, tool := range tools {
+		catelogs = append(catelogs, tool.Catelog)
+	}
+	if !checkTokenCatelog(c, catelogs...) {
+		return
+	}
+	dryRun, _ := strconv.ParseBool(c.Query("dryRun"))
+	report, err := service.ImportTools(tools, types.ImportOptions{
+		Strategy: c.Query("strategy"),
+		MatchBy:  c.Query("matchBy"),
+		DryRun:   dryRun,
+		// 限定了分类的 token 只能匹配和覆盖这个分类里的工具
+		CatelogId: c.GetInt("tokenCatelogId"),
+	}, c.GetString("username"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success":      false,
+			"errorMessage": err.Error(),
+		})
+		return
+	}
+	if dryRun {
+		c.JSON(200, gin.H{
+			"success": true,
+			"message": "导入预览",
+			"data":    report,
+		})
+		return
+	}
+	middleware.SetAudit(c, "tool.import", "tool", nil, nil, gin.H{
+		"count":     len(tools),
+		"strategy":  report.Strategy,
+		"matchBy":   report.MatchBy,
+		"created":   report.Created,
+		"updated":   report.Updated,
+		"skipped":   report.Skipped,
+		"conflicts": report.Conflicts,
+	})
 	c.JSON(200, gin.H{
 		"success": true,
 		"message": "导入工具成功",
+		"data":    report,
 	})
 }
 
 func DeleteApiTokenHandler(c *gin.Context) {
 	// 删除 Token
 	id := c.Param("id")
-	sql_delete_api_token := `
-		UPDATE nav_api_token
-		SET disabled = 1
-		WHERE id = ?;
-		`
-	stmt, err := database.DB.Prepare(sql_delete_api_token)
-	utils.CheckErr(err)
-	res, err := stmt.Exec(id)
-	utils.CheckErr(err)
-	_, err = res.RowsAffected()
-	utils.CheckErr(err)
+	numberId, _ := strconv.Atoi(id)
+	before, _ := service.GetApiTokenById(numberId)
+	service.DeleteApiToken(numberId)
+	middleware.SetAudit(c, "token.delete", "token", id, before, nil)
 	c.JSON(200, gin.H{
 		"success": true,
 		"message": "删除 API Token 成功",
 	})
 }
 
+func GetApiTokensHandler(c *gin.Context) {
+	c.JSON(200, gin.H{
+		"success": true,
+		"data":    service.GetApiTokens(),
+	})
+}
+
 func AddApiTokenHandler(c *gin.Context) {
-	var token types.AddTokenDto
-	err := c.ShouldBindJSON(&token)
+	var data types.AddTokenDto
+	err := c.ShouldBindJSON(&data)
 	if err != nil {
 		utils.CheckErr(err)
 		c.JSON(http.StatusBadRequest, gin.H{
@@ -74,35 +115,86 @@ func AddApiTokenHandler(c *gin.Context) {
 		})
 		return
 	}
-	newId := utils.GenerateId()
-	var signedJwt string
-	signedJwt, err = utils.SignJWTForAPI(token.Name, newId)
+	// 用 token 创建 token 时，不能给出比自己更大的权限
+	if c.GetString("authType") == "token" {
+		ownScopes := c.GetStringSlice("scopes")
+		for _, scope := range data.Scopes {
+			if !service.HasScope(ownScopes, scope) {
+				c.JSON(http.StatusForbidden, gin.H{
+					"success":      false,
+					"errorMessage": "不能授予自己没有的权限: " + scope,
+				})
+				return
+			}
+		}
+		if ownCatelogId := c.GetInt("tokenCatelogId"); ownCatelogId != 0 {
+			data.Catelog = ""
+			data.CatelogId = ownCatelogId
+		}
+	}
+	token, err := service.AddApiToken(data)
 	if err != nil {
-		utils.CheckErr(err)
 		c.JSON(http.StatusBadRequest, gin.H{
 			"success":      false,
 			"errorMessage": err.Error(),
 		})
 		return
 	}
-	service.AddApiTokenInDB(types.Token{
-		Name:     token.Name,
-		Value:    signedJwt,
-		Id:       newId,
-		Disabled: 0,
-	})
-	// 签名 jwt
+	// 审计日志里不能有 token 明文
+	audited := token
+	audited.Value = ""
+	middleware.SetAudit(c, "token.add", "token", token.Id, nil, audited)
 	c.JSON(200, gin.H{
 		"success": true,
-		"data": gin.H{
-			"id":    newId,
-			"Value": signedJwt,
-			"Name":  token.Name,
-		},
-		"message": "添加 Token 成功",
+		"data":    token,
+		"message": "添加 Token 成功，请立即保存，之后将无法再次查看",
 	})
 }
 
+// 限定了分类的 api token 只能操作这个分类下的工具，按分类 id 比较。不通过时已经写好响应，调用方直接返回
+func checkTokenCatelogId(c *gin.Context, ids ...int) bool {
+	allowed := c.GetInt("tokenCatelogId")
+	if allowed == 0 {
+		return true
+	}
+	for _, id := range ids {
+		if id != allowed {
+			c.JSON(http.StatusForbidden, gin.H{
+				"success":      false,
+				"errorMessage": "权限不足，这个 token 只能操作分类: " + c.GetString("tokenCatelog"),
+			})
+			return false
+		}
+	}
+	return true
+}
+
+// 同上，请求里的分类是名称，先换成 id。不存在的分类也不放行
+func checkTokenCatelog(c *gin.Context, catelogs ...string) bool {
+	if c.GetInt("tokenCatelogId") == 0 {
+		return true
+	}
+	ids := make([]int, 0, len(catelogs))
+	for _, catelog := range catelogs {
+		id, _ := service.GetCatelogIdByName(catelog)
+		ids = append(ids, id)
+	}
+	return checkTokenCatelogId(c, ids...)
+}
+
+// 同上，按工具 id 检查。找不到工具时也不放行
+func checkTokenToolCatelog(c *gin.Context, ids ...int) bool {
+	if c.GetInt("tokenCatelogId") == 0 {
+		return true
+	}
+	catelogIds := make([]int, 0, len(ids))
+	for _, id := range ids {
+		catelogId, _ := service.GetToolCatelogById(id)
+		catelogIds = append(catelogIds, catelogId)
+	}
+	return checkTokenCatelogId(c, catelogIds...)
+}
+
 func UpdateSettingHandler(c *gin.Context) {
 	var data types.Setting
 	if err := c.ShouldBindJSON(&data); err != nil {
@@ -114,6 +206,7 @@ func UpdateSettingHandler(c *gin.Context) {
 		return
 	}
 	logger.LogInfo("更新配置: %+v", data)
+	before := service.GetSetting()
 	err := service.UpdateSetting(data)
 	if err != nil {
 		utils.CheckErr(err)
@@ -123,6 +216,7 @@ func UpdateSettingHandler(c *gin.Context) {
 		})
 		return
 	}
+	middleware.SetAudit(c, "setting.update", "setting", before.Id, before, data)
 	c.JSON(200, gin.H{
 		"success": true,
 		"message": "更新配置成功",
@@ -139,7 +233,28 @@ func UpdateUserHandler(c *gin.Context) {
 		})
 		return
 	}
-	service.UpdateUser(data)
+	// 只能改自己，改别人走 /admin/users/:id
+	data.Id = int64(c.GetInt("uid"))
+	before, _ := service.GetUserById(int(data.Id))
+	if err := service.UpdateUser(data); err != nil {
+		utils.CheckErr(err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success":      false,
+			"errorMessage": err.Error(),
+		})
+		return
+	}
+	middleware.SetAudit(c, "user.update", "user", data.Id, before, data)
+	if data.Password != "" {
+		// 改了密码，之前登录的地方全部下线
+		_, err := service.RevokeUserSessions(int(data.Id))
+		utils.CheckErr(err)
+		c.JSON(200, gin.H{
+			"success": true,
+			"message": "更新用户成功，请重新登录",
+		})
+		return
+	}
 	c.JSON(200, gin.H{
 		"success": true,
 		"message": "更新用户成功",
@@ -150,11 +265,12 @@ func GetAllHandler(c *gin.Context) {
     tools := service.GetAllTool()
     // 获取全部数据，包括帖子内容
     catelogs := service.GetAllCatelog()
-    if !utils.IsLogin(c) {
+	if share, ok := service.ResolveShare(c.Query("share")); ok && !utils.IsLogin(c) {
+		// 带了分享链接，隐藏的内容里只放出分享的那部分
+		tools, catelogs = service.FilterHideWithShare(tools, catelogs, share)
+	} else if !utils.IsLogin(c) {
         // 过滤掉隐藏工具
         tools = utils.FilterHideTools(tools, catelogs)
-    }
-    if !utils.IsLogin(c) {
         // 过滤掉隐藏分类
         catelogs = utils.FilterHideCates(catelogs)
     }
@@ -191,142 +307,53 @@ func GetLogoImgHandler(c *gin.Context) {
 }
 
 func GetAdminAllDataHandler(c *gin.Context) {
-    // 1. 获取所有工具数据
-    tools := []types.Tool{}
-    toolRows, err := database.DB.Query(`
-        SELECT id, name, desc, logo, url, catelog, sort, hide
-        FROM tools
-        ORDER BY sort ASC
-    `)
-    if err != nil {
-        c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-        return
-    }
-    defer toolRows.Close()
-
-    for toolRows.Next() {
-        var tool types.Tool
-        err := toolRows.Scan(&tool.ID, &tool.Name, &tool.Desc, &tool.Logo, &tool.Url, &tool.Catelog, &tool.Sort, &tool.Hide)
-        if err != nil {
-            continue
-        }
-        tools = append(tools, tool)
-    }
-
-    // 2. 获取所有分类数据
-    catelogs := []types.Catelog{}
-    catelogRows, err := database.DB.Query(`
-        SELECT id, name, sort, hide
-        FROM catelogs
-        ORDER BY sort ASC
-    `)
+	posts, err := getAllPosts()
     if err != nil {
-        c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-        return
-    }
-    defer catelogRows.Close()
-
-    for catelogRows.Next() {
-        var catelog types.Catelog
-        err := catelogRows.Scan(&catelog.ID, &catelog.Name, &catelog.Sort, &catelog.Hide)
-        if err != nil {
-            continue
-        }
-        catelogs = append(catelogs, catelog)
-    }
-
-    // 3. 获取所有 API Token 数据
-    tokens := []types.ApiToken{}
-    tokenRows, err := database.DB.Query(`
-        SELECT id, name, value
-        FROM api_tokens
-    `)
-    if err != nil {
-        c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-        return
-    }
-    defer tokenRows.Close()
-
-    for tokenRows.Next() {
-        var token types.ApiToken
-        err := tokenRows.Scan(&token.ID, &token.Name, &token.Value)
-        if err != nil {
-            continue
-        }
-        tokens = append(tokens, token)
-    }
-
-    // 4. 获取系统设置数据
-    var setting types.Setting
-    err = database.DB.QueryRow(`
-        SELECT
-            favicon,
-            title,
-            gov_record,
-            jump_target_blank,
-            logo192,
-            logo512,
-            hide_admin,
-            hide_github
-        FROM settings
-        LIMIT 1
-    `).Scan(
-        &setting.Favicon,
-        &setting.Title,
-        &setting.GovRecord,
-        &setting.JumpTargetBlank,
-        &setting.Logo192,
-        &setting.Logo512,
-        &setting.HideAdmin,
-        &setting.HideGithub,
-    )
-    if err != nil {
-        // 如果没有设置数据，使用默认值
-        setting = types.Setting{
-            Favicon:         "/favicon.ico",
-            Title:          "VanNav",
-            GovRecord:      "",
-            JumpTargetBlank: true,
-            Logo192:        "/logo192.png",
-            Logo512:        "/logo512.png",
-            HideAdmin:      false,
-            HideGithub:     false,
-        }
-    }
-
-    // 5. 获取所有帖子数据
-    posts := []types.Post{}
-    postRows, err := database.DB.Query(`
-        SELECT id, title, content, create_time, update_time
-        FROM posts
-        ORDER BY create_time DESC
-    `)
-    if err != nil {
-        c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-        return
-    }
-    defer postRows.Close()
-
-    for postRows.Next() {
-        var post types.Post
-        err := postRows.Scan(&post.ID, &post.Title, &post.Content, &post.CreateTime, &post.UpdateTime)
-        if err != nil {
-            continue
-        }
-        posts = append(posts, post)
-    }
-
-    // 返回所有数据
+		utils.CheckErr(err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success":      false,
+			"errorMessage": err.Error(),
+		})
+		return
+	}
+	// token 只给 owner 看
+	tokens := []types.Token{}
+	if c.GetString("role") == types.RoleOwner {
+		tokens = service.GetApiTokens()
+	}
     c.JSON(http.StatusOK, gin.H{
-        "tools":    tools,
-        "catelogs": catelogs,
-        "tokens":   tokens,
-        "setting":  setting,
-        "posts":    posts,
-    })
+		"success": true,
+		"data": gin.H{
+			"tools":    service.GetAllTool(),
+			"catelogs": service.GetAllCatelog(),
+			"tokens":   tokens,
+			"setting":  service.GetSetting(),
+			"posts":    posts,
+			"user": gin.H{
+				"id":   c.GetInt("uid"),
+				"name": c.GetString("username"),
+				"role": c.GetString("role"),
+			},
+		},
+	})
+}
+
+// 开启单点登录并关闭密码登录后，密码登录和两步验证都不能用
+func passwordLoginDisabled(c *gin.Context) bool {
+	if !service.PasswordLoginDisabled() {
+		return false
+	}
+	c.JSON(http.StatusForbidden, gin.H{
+		"success":      false,
+		"errorMessage": "已关闭密码登录，请使用单点登录",
+	})
+	return true
 }
 
 func LoginHandler(c *gin.Context) {
+	if passwordLoginDisabled(c) {
+		return
+	}
 	var data types.LoginDto
 	if err := c.ShouldBindJSON(&data); err != nil {
 		utils.CheckErr(err)
@@ -336,38 +363,106 @@ func LoginHandler(c *gin.Context) {
 		})
 		return
 	}
+	ip := c.ClientIP()
+	if wait, ok := service.CheckLoginAllowed(ip, data.Name); !ok {
+		tooManyLoginAttempts(c, wait)
+		return
+	}
 	user := service.GetUser(data.Name)
 	if user.Name == "" {
+		// 不区分用户不存在和密码错误
+		utils.FakeVerifyPassword(data.Password)
+		service.RecordLoginFailure(ip, data.Name)
 		c.JSON(200, gin.H{
 			"success":      false,
-			"errorMessage": "用户名不存在",
+			"errorMessage": "用户名或密码错误",
 		})
 		return
 	}
-	if user.Password != data.Password {
+	ok, needUpgrade := utils.VerifyPassword(user.Password, data.Password)
+	if !ok {
+		service.RecordLoginFailure(ip, data.Name)
 		c.JSON(200, gin.H{
 			"success":      false,
-			"errorMessage": "密码错误",
+			"errorMessage": "用户名或密码错误",
 		})
 		return
 	}
-	// 生成 token
-	token, err := utils.SignJWT(user)
-	utils.CheckErr(err)
+	if needUpgrade {
+		// 老的明文密码，登录成功后顺手升级成哈希
+		service.UpgradeUserPassword(user.Id, data.Password)
+	}
+	// 开启了两步验证的，先发预登录 token，验证码通过后才创建会话
+	if service.IsTOTPEnabled(user.Id) {
+		preAuthToken, err := utils.SignPreAuthJWT(user)
+		if err != nil {
+			utils.CheckErr(err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"success":      false,
+				"errorMessage": "登录失败",
+			})
+			return
+		}
+		c.JSON(200, gin.H{
+			"success": true,
+			"message": "请输入两步验证码",
+			"data": gin.H{
+				"totpRequired": true,
+				"preAuthToken": preAuthToken,
+			},
+		})
+		return
+	}
+	loginSuccess(c, user)
+}
+
+// 登录失败太多次，告诉客户端多久之后再试
+func tooManyLoginAttempts(c *gin.Context, wait time.Duration) {
+	c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	c.JSON(http.StatusTooManyRequests, gin.H{
+		"success":      false,
+		"errorMessage": fmt.Sprintf("登录失败次数过多，请 %d 秒后再试", int(math.Ceil(wait.Seconds()))),
+	})
+}
+
+// 创建会话并返回 token，密码登录和两步验证都走这里
+func loginSuccess(c *gin.Context, user types.User) {
+	service.RecordLoginSuccess(user.Name)
+	token, err := service.CreateSession(user, c.ClientIP(), c.Request.UserAgent())
+	if err != nil {
+		utils.CheckErr(err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success":      false,
+			"errorMessage": "创建会话失败",
+		})
+		return
+	}
+	setSessionCookie(c, token)
 
 	c.JSON(200, gin.H{
 		"success": true,
 		"message": "登录成功",
 		"data": gin.H{
-			"user":  user,
+			"user":  types.ResUserDto{Name: user.Name, Role: user.Role},
 			"token": token,
 		},
 	})
+}
 
+// 私有模式下加载图片和 manifest 用的会话 cookie，前端读不到，过期时间和 jwt 一样
+func setSessionCookie(c *gin.Context, token string) {
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(middleware.SessionCookie, token, int(utils.SessionLifetime.Seconds()), "/", "", isHttps(c), true)
 }
 
-// 退出登录
+// 退出登录，吊销当前会话
 func LogoutHandler(c *gin.Context) {
+	jti := c.GetString("jti")
+	if jti != "" {
+		_, err := service.RevokeSession(c.GetInt("uid"), jti)
+		utils.CheckErr(err)
+	}
+	c.SetCookie(middleware.SessionCookie, "", -1, "/", "", isHttps(c), true)
 	c.JSON(200, gin.H{
 		"success": true,
 		"message": "登出成功",
@@ -386,13 +481,24 @@ func AddToolHandler(c *gin.Context) {
         return
     }
 
+	if !checkTokenCatelog(c, data.Catelog) {
+		return
+	}
+
     logger.LogInfo("新增工具: %s, 帖子標題: %s", data.Name, data.PostTitle)
-    // 修改這裡，直接調用 AddTool
-    service.AddTool(data)
+	id, err := service.AddTool(data)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success":      false,
+			"errorMessage": err.Error(),
+		})
+		return
+	}
+	data.ID = id
+	middleware.SetAudit(c, "tool.add", "tool", id, nil, data)
 
     if data.Logo == "" {
-        // 由於我們不再使用 id，需要修改這裡的邏輯
-        go service.LazyFetchLogo(data.Url, 0) // 或者從 data 中獲取 ID
+		go service.LazyFetchLogo(data.Url, id)
     }
 
     c.JSON(200, gin.H{
@@ -404,29 +510,15 @@ func AddToolHandler(c *gin.Context) {
 func DeleteToolHandler(c *gin.Context) {
 	// 删除工具
 	id := c.Param("id")
-	sql_delete_tool := `
-		DELETE FROM nav_table WHERE id = ?;
-		`
-	stmt, err := database.DB.Prepare(sql_delete_tool)
-	utils.CheckErr(err)
-	res, err := stmt.Exec(id)
-	utils.CheckErr(err)
-	_, err = res.RowsAffected()
-	utils.CheckErr(err)
-	// 删除工具的 logo，如果有
 	numberId, err := strconv.Atoi(id)
 	utils.CheckErr(err)
-	url1 := service.GetToolLogoUrlById(numberId)
-	urlEncoded := url.QueryEscape(url1)
-	sql_delete_tool_img := `
-		DELETE FROM nav_img WHERE url = ?;
-		`
-	stmt, err = database.DB.Prepare(sql_delete_tool_img)
-	utils.CheckErr(err)
-	res, err = stmt.Exec(urlEncoded)
-	utils.CheckErr(err)
-	_, err = res.RowsAffected()
-	utils.CheckErr(err)
+	if !checkTokenToolCatelog(c, numberId) {
+		return
+	}
+	before, _ := service.GetToolById(int64(numberId))
+	// 只是放进回收站，logo 缓存等永久删除时再删
+	service.DeleteTool(int64(numberId))
+	middleware.SetAudit(c, "tool.delete", "tool", id, before, nil)
 	c.JSON(200, gin.H{
 		"success": true,
 		"message": "删除成功",
@@ -444,7 +536,12 @@ func AddCatelogHandler(c *gin.Context) {
 		})
 		return
 	}
+	// 限定了分类的 token 不能管理分类
+	if !checkTokenCatelog(c, "") {
+		return
+	}
 	service.AddCatelog(data)
+	middleware.SetAudit(c, "catelog.add", "catelog", nil, nil, data)
 
 	c.JSON(200, gin.H{
 		"success": true,
@@ -453,17 +550,36 @@ func AddCatelogHandler(c *gin.Context) {
 }
 
 func DeleteCatelogHandler(c *gin.Context) {
-	// 删除分类
+	// 删除分类，限定了分类的 token 不能管理分类
+	if !checkTokenCatelog(c, "") {
+		return
+	}
+	// mode 为 reject（默认）、move 或 cascade，move 时用 target 指定目标分类的 id
 	id := c.Param("id")
-	sql_delete_catelog := `
-		DELETE FROM nav_catelog WHERE id = ?;
-		`
-	stmt, err := database.DB.Prepare(sql_delete_catelog)
-	utils.CheckErr(err)
-	res, err := stmt.Exec(id)
-	utils.CheckErr(err)
-	_, err = res.RowsAffected()
-	utils.CheckErr(err)
+	numberId, _ := strconv.Atoi(id)
+	mode := c.Query("mode")
+	target, _ := strconv.Atoi(c.Query("target"))
+	before, _ := service.GetCatelogById(numberId)
+	count, err := service.DeleteCatelog(numberId, mode, target)
+	if err == database.ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{
+			"success":      false,
+			"errorMessage": "分类不存在",
+		})
+		return
+	}
+	if err != nil {
+		status := http.StatusBadRequest
+		if err == database.ErrCatelogNotEmpty {
+			status = http.StatusConflict
+		}
+		c.JSON(status, gin.H{
+			"success":      false,
+			"errorMessage": err.Error(),
+		})
+		return
+	}
+	middleware.SetAudit(c, "catelog.delete", "catelog", id, before, gin.H{"mode": mode, "target": target, "tools": count})
 	c.JSON(200, gin.H{
 		"success": true,
 		"message": "删除分类成功",
@@ -481,7 +597,13 @@ func UpdateCatelogHandler(c *gin.Context) {
 		})
 		return
 	}
+	// 限定了分类的 token 不能管理分类
+	if !checkTokenCatelog(c, "") {
+		return
+	}
+	before, _ := service.GetCatelogById(data.Id)
 	service.UpdateCatelog(data)
+	middleware.SetAudit(c, "catelog.update", "catelog", data.Id, before, data)
 
 	c.JSON(200, gin.H{
 		"success": true,
@@ -543,6 +665,14 @@ func UpdateToolsSortHandler(c *gin.Context) {
 		return
 	}
 
+	ids := make([]int, 0, len(updates))
+	for _, update := range updates {
+		ids = append(ids, update.Id)
+	}
+	if !checkTokenToolCatelog(c, ids...) {
+		return
+	}
+
 	err := service.UpdateToolsSort(updates)
 	if err != nil {
 		utils.CheckErr(err)
@@ -552,6 +682,7 @@ func UpdateToolsSortHandler(c *gin.Context) {
 		})
 		return
 	}
+	middleware.SetAudit(c, "tool.sort", "tool", nil, nil, updates)
 
 	c.JSON(200, gin.H{
 		"success": true,
@@ -570,8 +701,14 @@ func UpdateToolHandler(c *gin.Context) {
         return
     }
 
+	// 原来的分类和新的分类都要在 token 允许的范围内
+	if !checkTokenToolCatelog(c, data.Id) || !checkTokenCatelog(c, data.Catelog) {
+		return
+	}
+
     logger.LogInfo("更新工具: %s, 帖子标题: %s", data.Name, data.PostTitle)
-    err := service.UpdateTool(data)
+	before, _ := service.GetToolById(int64(data.Id))
+	err := service.UpdateTool(data, c.GetString("username"))
     if err != nil {
         utils.CheckErr(err)
         c.JSON(http.StatusInternalServerError, gin.H{
@@ -581,6 +718,8 @@ func UpdateToolHandler(c *gin.Context) {
         return
     }
 
+	middleware.SetAudit(c, "tool.update", "tool", data.Id, before, data)
+
     if data.Logo == "" {
         logger.LogInfo("%s 获取 logo: %s", data.Name, data.Logo)
         go service.LazyFetchLogo(data.Url, int64(data.Id))
@@ -624,8 +763,8 @@ func GetToolDetailHandler(c *gin.Context) {
         return
     }
 
-    // 根据是否登录返回不同级别的信息
-    if !utils.IsLogin(c) && tool.Hide {
+	// 根据是否登录返回不同级别的信息，隐藏的工具可以通过分享链接查看
+	if !utils.IsLogin(c) && tool.Hide && !sharedTool(c, tool) {
         c.JSON(http.StatusForbidden, gin.H{
             "success":      false,
             "errorMessage": "无权访问该工具",
@@ -638,4 +777,28 @@ func GetToolDetailHandler(c *gin.Context) {
         "success": true,
         "data":    tool,
     })
-}
\ No newline at end of file
+}
+
+// GetPostHandler 获取工具上的帖子
+func GetPostHandler(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success":      false,
+			"errorMessage": "无效的工具 id",
+		})
+		return
+	}
+	post, err := service.GetPost(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"success":      false,
+			"errorMessage": err.Error(),
+		})
+		return
+	}
+	c.JSON(200, gin.H{
+		"success": true,
+		"data":    post,
+	})
+}
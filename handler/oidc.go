@@ -0,0 +1,158 @@
+package handler
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ziren926/van-nav/logger"
+	"github.com/ziren926/van-nav/middleware"
+	"github.com/ziren926/van-nav/service"
+)
+
+// 绑定浏览器和 state 的 cookie，防止别人把自己的登录结果塞给受害者
+const oidcStateCookie = "vn_oidc_state"
+
+// 登录页用来决定显示哪些登录方式
+func LoginOptionsHandler(c *gin.Context) {
+	c.JSON(200, gin.H{
+		"success": true,
+		"data": gin.H{
+			"passwordLogin": !service.PasswordLoginDisabled(),
+			"oidc":          service.OIDCEnabled(),
+		},
+	})
+}
+
+func isHttps(c *gin.Context) bool {
+	return c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https"
+}
+
+// 跳转到身份提供方登录
+func OIDCLoginHandler(c *gin.Context) {
+	if !service.OIDCEnabled() {
+		c.JSON(http.StatusNotFound, gin.H{
+			"success":      false,
+			"errorMessage": "没有开启单点登录",
+		})
+		return
+	}
+	authUrl, state, err := service.BeginOIDCLogin(c.Query("redirect"))
+	if err != nil {
+		logger.LogError("开始单点登录失败: %s", err)
+		oidcLoginFailed(c, "连接身份提供方失败")
+		return
+	}
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcStateCookie, state, 600, "/api/oidc", "", isHttps(c), true)
+	c.Redirect(http.StatusFound, authUrl)
+}
+
+// 身份提供方回调，完成后带着 token 跳回登录页，由前端保存
+func OIDCCallbackHandler(c *gin.Context) {
+	if !service.OIDCEnabled() {
+		c.JSON(http.StatusNotFound, gin.H{
+			"success":      false,
+			"errorMessage": "没有开启单点登录",
+		})
+		return
+	}
+	state := c.Query("state")
+	cookieState, _ := c.Cookie(oidcStateCookie)
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcStateCookie, "", -1, "/api/oidc", "", isHttps(c), true)
+	if errorCode := c.Query("error"); errorCode != "" {
+		logger.LogError("单点登录失败: %s %s", errorCode, c.Query("error_description"))
+		oidcLoginFailed(c, "身份提供方拒绝了登录")
+		return
+	}
+	if state == "" || state != cookieState {
+		oidcLoginFailed(c, "登录状态校验失败，请重试")
+		return
+	}
+	user, redirect, err := service.FinishOIDCLogin(state, c.Query("code"))
+	if err != nil {
+		logger.LogError("单点登录失败: %s", err)
+		oidcLoginFailed(c, err.Error())
+		return
+	}
+	token, err := service.CreateSession(user, c.ClientIP(), c.Request.UserAgent())
+	if err != nil {
+		logger.LogError("创建会话失败: %s", err)
+		oidcLoginFailed(c, "创建会话失败")
+		return
+	}
+	setSessionCookie(c, token)
+	// token 放在 # 后面，不会出现在服务器和代理的访问日志里
+	fragment := url.Values{}
+	fragment.Set("token", token)
+	fragment.Set("redirect", redirect)
+	c.Redirect(http.StatusFound, "/login#"+fragment.Encode())
+}
+
+// 登录着的用户关联自己的单点登录账号。返回授权地址，由前端跳转，回调和登录是同一个地址
+func OIDCLinkHandler(c *gin.Context) {
+	if !service.OIDCEnabled() {
+		c.JSON(http.StatusNotFound, gin.H{
+			"success":      false,
+			"errorMessage": "没有开启单点登录",
+		})
+		return
+	}
+	authUrl, state, err := service.BeginOIDCLink(c.GetInt("uid"))
+	if err != nil {
+		logger.LogError("开始关联单点登录账号失败: %s", err)
+		c.JSON(http.StatusBadGateway, gin.H{
+			"success":      false,
+			"errorMessage": "连接身份提供方失败",
+		})
+		return
+	}
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcStateCookie, state, 600, "/api/oidc", "", isHttps(c), true)
+	middleware.SetAudit(c, "oidc.link", "user", c.GetInt("uid"), nil, nil)
+	c.JSON(200, gin.H{
+		"success": true,
+		"data":    gin.H{"url": authUrl},
+	})
+}
+
+// 取消关联自己的单点登录账号
+func OIDCUnlinkHandler(c *gin.Context) {
+	unlinkOIDC(c, c.GetInt("uid"))
+}
+
+// owner 取消关联某个用户的单点登录账号，比如关联错了或者身份提供方里的账号换了人
+func UnlinkUserOIDCHandler(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success":      false,
+			"errorMessage": "无效的ID",
+		})
+		return
+	}
+	unlinkOIDC(c, id)
+}
+
+func unlinkOIDC(c *gin.Context, id int) {
+	if err := service.UnlinkOIDC(id); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"success":      false,
+			"errorMessage": err.Error(),
+		})
+		return
+	}
+	middleware.SetAudit(c, "oidc.unlink", "user", id, nil, nil)
+	c.JSON(200, gin.H{
+		"success": true,
+		"message": "已取消关联单点登录账号",
+	})
+}
+
+func oidcLoginFailed(c *gin.Context, message string) {
+	fragment := url.Values{}
+	fragment.Set("error", message)
+	c.Redirect(http.StatusFound, "/login#"+fragment.Encode())
+}
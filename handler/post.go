@@ -4,6 +4,7 @@ package handler
 import (
     "github.com/gin-gonic/gin"
     "github.com/ziren926/van-nav/database"
+	"github.com/ziren926/van-nav/middleware"
     "github.com/ziren926/van-nav/types"
     "net/http"
     "strconv"
@@ -18,45 +19,16 @@ func AddPostHandler(c *gin.Context) {
         return
     }
 
-    // 使用事务确保数据一致性
-    tx, err := database.DB.Begin()
+	now := time.Now()
+	post.CreateTime = now
+	post.UpdateTime = now
+	id, err := database.Posts.Add(post)
     if err != nil {
         c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
         return
     }
-
-    // 准备SQL语句
-    stmt, err := tx.Prepare(`
-        INSERT INTO posts (title, content, create_time, update_time)
-        VALUES (?, ?, datetime('now'), datetime('now'))
-    `)
-    if err != nil {
-        tx.Rollback()
-        c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-        return
-    }
-    defer stmt.Close()
-
-    // 执行SQL语句
-    result, err := stmt.Exec(post.Title, post.Content)
-    if err != nil {
-        tx.Rollback()
-        c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-        return
-    }
-
-    // 提交事务
-    if err := tx.Commit(); err != nil {
-        tx.Rollback()
-        c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-        return
-    }
-
-    // 获取新插入的ID
-    id, _ := result.LastInsertId()
     post.ID = id
-    post.CreateTime = time.Now()
-    post.UpdateTime = time.Now()
+	middleware.SetAudit(c, "post.add", "post", id, nil, post)
 
     c.JSON(http.StatusOK, post)
 }
@@ -70,17 +42,17 @@ func DeletePostHandler(c *gin.Context) {
         return
     }
 
-    result, err := database.DB.Exec("DELETE FROM posts WHERE id = ?", postID)
+	before, _ := getPost(postID)
+	err = database.Posts.Delete(postID, time.Now())
+	if err == database.ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "帖子不存在"})
+		return
+	}
     if err != nil {
         c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
         return
     }
-
-    rowsAffected, _ := result.RowsAffected()
-    if rowsAffected == 0 {
-        c.JSON(http.StatusNotFound, gin.H{"error": "帖子不存在"})
-        return
-    }
+	middleware.SetAudit(c, "post.delete", "post", postID, before, nil)
 
     c.JSON(http.StatusOK, gin.H{"message": "删除成功"})
 }
@@ -100,47 +72,43 @@ func UpdatePostHandler(c *gin.Context) {
         return
     }
 
-    result, err := database.DB.Exec(`
-        UPDATE posts
-        SET title = ?, content = ?, update_time = datetime('now')
-        WHERE id = ?
-    `, post.Title, post.Content, postID)
+	before, _ := getPost(postID)
+	post.ID = postID
+	post.UpdateTime = time.Now()
+	err = database.Posts.Update(post, c.GetString("username"))
+	if err == database.ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "帖子不存在"})
+		return
+	}
     if err != nil {
         c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
         return
     }
-
-    rowsAffected, _ := result.RowsAffected()
-    if rowsAffected == 0 {
-        c.JSON(http.StatusNotFound, gin.H{"error": "帖子不存在"})
-        return
-    }
+	middleware.SetAudit(c, "post.update", "post", postID, before, gin.H{"title": post.Title, "content": post.Content})
 
     c.JSON(http.StatusOK, gin.H{"message": "更新成功"})
 }
 
 // GetPostsHandler 获取所有帖子
 func GetPostsHandler(c *gin.Context) {
-    posts := []types.Post{}
-    rows, err := database.DB.Query(`
-        SELECT id, title, content, create_time, update_time
-        FROM posts
-        ORDER BY create_time DESC
-    `)
+	posts, err := getAllPosts()
     if err != nil {
         c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
         return
     }
-    defer rows.Close()
+	c.JSON(http.StatusOK, posts)
+}
 
-    for rows.Next() {
-        var post types.Post
-        err := rows.Scan(&post.ID, &post.Title, &post.Content, &post.CreateTime, &post.UpdateTime)
-        if err != nil {
-            continue
-        }
-        posts = append(posts, post)
-    }
+// getAllPosts 所有帖子，新的在前
+func getAllPosts() ([]types.Post, error) {
+	return database.Posts.GetAll()
+}
 
-    c.JSON(http.StatusOK, posts)
-}
\ No newline at end of file
+// getPost 按 ID 获取帖子，审计日志记录修改前的内容用
+func getPost(id int64) (*types.Post, bool) {
+	post, err := database.Posts.GetById(id)
+	if err != nil {
+		return nil, false
+	}
+	return &post, true
+}
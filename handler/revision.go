@@ -0,0 +1,126 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ziren926/van-nav/database"
+	"github.com/ziren926/van-nav/middleware"
+	"github.com/ziren926/van-nav/service"
+	"github.com/ziren926/van-nav/types"
+	"github.com/ziren926/van-nav/utils"
+)
+
+// 工具和帖子的修改历史用同一套处理，查看在 read 组，恢复挂在各自的路由组下面
+func GetToolRevisionsHandler(c *gin.Context)    { getRevisions(c, types.RevisionTool) }
+func GetPostRevisionsHandler(c *gin.Context)    { getRevisions(c, types.RevisionPost) }
+func GetToolRevisionHandler(c *gin.Context)     { getRevision(c, types.RevisionTool) }
+func GetPostRevisionHandler(c *gin.Context)     { getRevision(c, types.RevisionPost) }
+func DiffToolRevisionHandler(c *gin.Context)    { diffRevision(c, types.RevisionTool) }
+func DiffPostRevisionHandler(c *gin.Context)    { diffRevision(c, types.RevisionPost) }
+func RestoreToolRevisionHandler(c *gin.Context) { restoreRevision(c, types.RevisionTool) }
+func RestorePostRevisionHandler(c *gin.Context) { restoreRevision(c, types.RevisionPost) }
+
+// 找不到的返回 404，其它错误返回 500
+func revisionError(c *gin.Context, err error) {
+	if err == database.ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{
+			"success":      false,
+			"errorMessage": "没有找到这个版本",
+		})
+		return
+	}
+	utils.CheckErr(err)
+	c.JSON(http.StatusInternalServerError, gin.H{
+		"success":      false,
+		"errorMessage": err.Error(),
+	})
+}
+
+func revisionIds(c *gin.Context) (int64, int64) {
+	targetId, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+	id, _ := strconv.ParseInt(c.Param("rid"), 10, 64)
+	return targetId, id
+}
+
+func getRevisions(c *gin.Context, kind string) {
+	targetId, _ := revisionIds(c)
+	revisions, err := service.GetRevisions(kind, targetId)
+	if err != nil {
+		revisionError(c, err)
+		return
+	}
+	c.JSON(200, gin.H{
+		"success": true,
+		"data":    revisions,
+	})
+}
+
+func getRevision(c *gin.Context, kind string) {
+	targetId, id := revisionIds(c)
+	revision, err := service.GetRevision(kind, targetId, id)
+	if err != nil {
+		revisionError(c, err)
+		return
+	}
+	c.JSON(200, gin.H{
+		"success": true,
+		"data":    revision,
+	})
+}
+
+// 默认和当前版本比，?to= 指定另一个版本
+func diffRevision(c *gin.Context, kind string) {
+	targetId, id := revisionIds(c)
+	to, _ := strconv.ParseInt(c.Query("to"), 10, 64)
+	diff, err := service.DiffRevisions(kind, targetId, id, to)
+	if err != nil {
+		revisionError(c, err)
+		return
+	}
+	c.JSON(200, gin.H{
+		"success": true,
+		"data":    diff,
+	})
+}
+
+func restoreRevision(c *gin.Context, kind string) {
+	targetId, id := revisionIds(c)
+	if kind == types.RevisionTool && !checkTokenToolCatelog(c, int(targetId)) {
+		return
+	}
+	revision, err := service.GetRevision(kind, targetId, id)
+	if err != nil {
+		revisionError(c, err)
+		return
+	}
+	var before interface{}
+	if kind == types.RevisionTool {
+		// 恢复后的分类也要在 token 允许的范围内
+		if !checkTokenCatelogId(c, service.RevisionCatelogId(revision)) {
+			return
+		}
+		before, _ = service.GetToolById(targetId)
+	} else {
+		before, _ = getPost(targetId)
+	}
+
+	revision, err = service.RestoreRevision(kind, targetId, id, c.GetString("username"))
+	if err == database.ErrNotFound {
+		revisionError(c, err)
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success":      false,
+			"errorMessage": err.Error(),
+		})
+		return
+	}
+	middleware.SetAudit(c, kind+".revision.restore", kind, targetId, before, revision.Data)
+	c.JSON(200, gin.H{
+		"success": true,
+		"message": "恢复成功",
+	})
+}
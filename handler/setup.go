@@ -0,0 +1,68 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ziren926/van-nav/service"
+	"github.com/ziren926/van-nav/types"
+	"github.com/ziren926/van-nav/utils"
+)
+
+// 前端用来判断是否要显示初始化向导
+func GetSetupHandler(c *gin.Context) {
+	c.JSON(200, gin.H{
+		"success": true,
+		"data":    gin.H{"required": service.SetupRequired()},
+	})
+}
+
+// 创建第一个 owner，成功后直接登录
+func SetupHandler(c *gin.Context) {
+	var data types.SetupDto
+	if err := c.ShouldBindJSON(&data); err != nil {
+		utils.CheckErr(err)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success":      false,
+			"errorMessage": err.Error(),
+		})
+		return
+	}
+	if !service.SetupRequired() {
+		c.JSON(http.StatusConflict, gin.H{
+			"success":      false,
+			"errorMessage": "已经完成初始化",
+		})
+		return
+	}
+	user, err := service.CompleteSetup(data)
+	if err != nil {
+		status := http.StatusBadRequest
+		if !service.SetupRequired() {
+			status = http.StatusConflict
+		}
+		c.JSON(status, gin.H{
+			"success":      false,
+			"errorMessage": err.Error(),
+		})
+		return
+	}
+	token, err := service.CreateSession(user, c.ClientIP(), c.Request.UserAgent())
+	if err != nil {
+		utils.CheckErr(err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success":      false,
+			"errorMessage": "初始化成功，但创建会话失败，请重新登录",
+		})
+		return
+	}
+	setSessionCookie(c, token)
+	c.JSON(200, gin.H{
+		"success": true,
+		"message": "初始化成功",
+		"data": gin.H{
+			"user":  types.ResUserDto{Name: user.Name, Role: user.Role},
+			"token": token,
+		},
+	})
+}
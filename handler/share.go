@@ -0,0 +1,99 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ziren926/van-nav/middleware"
+	"github.com/ziren926/van-nav/service"
+	"github.com/ziren926/van-nav/types"
+	"github.com/ziren926/van-nav/utils"
+)
+
+// 请求里的分享链接是否包含这个工具
+func sharedTool(c *gin.Context, tool types.Tool) bool {
+	share, ok := service.ResolveShare(c.Query("share"))
+	return ok && service.ShareCoversTool(share, tool)
+}
+
+func GetSharesHandler(c *gin.Context) {
+	c.JSON(200, gin.H{
+		"success": true,
+		"data":    service.GetShares(),
+	})
+}
+
+// 创建分享链接，分享 token 只在这里返回一次
+func AddShareHandler(c *gin.Context) {
+	var data types.AddShareDto
+	if err := c.ShouldBindJSON(&data); err != nil {
+		utils.CheckErr(err)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success":      false,
+			"errorMessage": err.Error(),
+		})
+		return
+	}
+	// 限定了分类的 token 只能分享自己分类里的东西
+	if data.Type == types.ShareTool && !checkTokenToolCatelog(c, data.TargetId) {
+		return
+	}
+	if data.Type == types.ShareCatelog && !checkTokenCatelogId(c, data.TargetId) {
+		return
+	}
+	share, err := service.AddShare(data, c.GetString("username"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success":      false,
+			"errorMessage": err.Error(),
+		})
+		return
+	}
+	audited := share
+	audited.Token = ""
+	middleware.SetAudit(c, "share.add", "share", share.Id, nil, audited)
+	c.JSON(200, gin.H{
+		"success": true,
+		"message": "创建分享链接成功，请立即保存，之后将无法再次查看",
+		"data":    share,
+	})
+}
+
+func RevokeShareHandler(c *gin.Context) {
+	id := c.Param("id")
+	before, ok := service.GetShareById(id)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{
+			"success":      false,
+			"errorMessage": "分享链接不存在",
+		})
+		return
+	}
+	if before.Type == types.ShareTool && !checkTokenToolCatelog(c, before.TargetId) {
+		return
+	}
+	if before.Type == types.ShareCatelog && !checkTokenCatelogId(c, before.TargetId) {
+		return
+	}
+	revoked, err := service.RevokeShare(id)
+	if err != nil {
+		utils.CheckErr(err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success":      false,
+			"errorMessage": err.Error(),
+		})
+		return
+	}
+	if !revoked {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success":      false,
+			"errorMessage": "分享链接已经吊销过了",
+		})
+		return
+	}
+	middleware.SetAudit(c, "share.revoke", "share", id, before, nil)
+	c.JSON(200, gin.H{
+		"success": true,
+		"message": "吊销分享链接成功",
+	})
+}
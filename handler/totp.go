@@ -0,0 +1,223 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ziren926/van-nav/middleware"
+	"github.com/ziren926/van-nav/service"
+	"github.com/ziren926/van-nav/types"
+	"github.com/ziren926/van-nav/utils"
+)
+
+// 登录第二步：用预登录 token 和验证码（或恢复码）换正式的登录 token
+func LoginTOTPHandler(c *gin.Context) {
+	if passwordLoginDisabled(c) {
+		return
+	}
+	var data types.LoginTOTPDto
+	if err := c.ShouldBindJSON(&data); err != nil {
+		utils.CheckErr(err)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success":      false,
+			"errorMessage": err.Error(),
+		})
+		return
+	}
+	userId, err := utils.ParsePreAuthJWT(data.PreAuthToken)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"success":      false,
+			"errorMessage": err.Error(),
+		})
+		return
+	}
+	user, err := service.GetUserById(userId)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"success":      false,
+			"errorMessage": err.Error(),
+		})
+		return
+	}
+	// 验证码只有一百万种，和密码共用失败计数
+	ip := c.ClientIP()
+	if wait, ok := service.CheckLoginAllowed(ip, user.Name); !ok {
+		tooManyLoginAttempts(c, wait)
+		return
+	}
+	if !service.VerifySecondFactor(userId, data.Code) {
+		service.RecordLoginFailure(ip, user.Name)
+		c.JSON(200, gin.H{
+			"success":      false,
+			"errorMessage": "验证码错误",
+		})
+		return
+	}
+	loginSuccess(c, user)
+}
+
+func GetTOTPHandler(c *gin.Context) {
+	c.JSON(200, gin.H{
+		"success": true,
+		"data":    service.GetTOTPStatus(c.GetInt("uid")),
+	})
+}
+
+// 生成两步验证密钥，返回 otpauth 链接给验证器 App 扫码
+func SetupTOTPHandler(c *gin.Context) {
+	user, err := service.GetUserById(c.GetInt("uid"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success":      false,
+			"errorMessage": err.Error(),
+		})
+		return
+	}
+	setup, err := service.SetupTOTP(user)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success":      false,
+			"errorMessage": err.Error(),
+		})
+		return
+	}
+	middleware.SetAudit(c, "totp.setup", "user", c.GetInt("uid"), nil, nil)
+	c.JSON(200, gin.H{
+		"success": true,
+		"data":    setup,
+	})
+}
+
+// 输入验证码确认后才真正开启，同时返回恢复码
+func ConfirmTOTPHandler(c *gin.Context) {
+	var data types.TOTPCodeDto
+	if err := c.ShouldBindJSON(&data); err != nil {
+		utils.CheckErr(err)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success":      false,
+			"errorMessage": err.Error(),
+		})
+		return
+	}
+	codes, err := service.ConfirmTOTP(c.GetInt("uid"), data.Code)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success":      false,
+			"errorMessage": err.Error(),
+		})
+		return
+	}
+	middleware.SetAudit(c, "totp.enable", "user", c.GetInt("uid"), nil, nil)
+	c.JSON(200, gin.H{
+		"success": true,
+		"message": "已开启两步验证，请妥善保存恢复码",
+		"data":    gin.H{"recoveryCodes": codes},
+	})
+}
+
+// 重新生成恢复码，需要当前的验证码
+func RegenerateRecoveryCodesHandler(c *gin.Context) {
+	var data types.TOTPCodeDto
+	if err := c.ShouldBindJSON(&data); err != nil {
+		utils.CheckErr(err)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success":      false,
+			"errorMessage": err.Error(),
+		})
+		return
+	}
+	uid := c.GetInt("uid")
+	if !service.VerifySecondFactor(uid, data.Code) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success":      false,
+			"errorMessage": "验证码错误",
+		})
+		return
+	}
+	codes, err := service.RegenerateRecoveryCodes(uid)
+	if err != nil {
+		utils.CheckErr(err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success":      false,
+			"errorMessage": err.Error(),
+		})
+		return
+	}
+	middleware.SetAudit(c, "totp.regenerateRecoveryCodes", "user", uid, nil, nil)
+	c.JSON(200, gin.H{
+		"success": true,
+		"message": "已重新生成恢复码，旧的恢复码已作废",
+		"data":    gin.H{"recoveryCodes": codes},
+	})
+}
+
+// 关闭两步验证，需要密码和验证码（或恢复码）
+func DisableTOTPHandler(c *gin.Context) {
+	var data types.DisableTOTPDto
+	if err := c.ShouldBindJSON(&data); err != nil {
+		utils.CheckErr(err)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success":      false,
+			"errorMessage": err.Error(),
+		})
+		return
+	}
+	uid := c.GetInt("uid")
+	user, err := service.GetUserById(uid)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success":      false,
+			"errorMessage": err.Error(),
+		})
+		return
+	}
+	if ok, _ := utils.VerifyPassword(user.Password, data.Password); !ok {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success":      false,
+			"errorMessage": "密码错误",
+		})
+		return
+	}
+	if !service.VerifySecondFactor(uid, data.Code) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success":      false,
+			"errorMessage": "验证码错误",
+		})
+		return
+	}
+	err = service.DisableTOTP(uid)
+	utils.CheckErr(err)
+	middleware.SetAudit(c, "totp.disable", "user", uid, nil, nil)
+	c.JSON(200, gin.H{
+		"success": true,
+		"message": "已关闭两步验证",
+	})
+}
+
+// owner 帮丢了手机的用户关闭两步验证
+func ResetUserTOTPHandler(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success":      false,
+			"errorMessage": "无效的ID",
+		})
+		return
+	}
+	if _, err = service.GetUserById(id); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"success":      false,
+			"errorMessage": err.Error(),
+		})
+		return
+	}
+	err = service.DisableTOTP(id)
+	utils.CheckErr(err)
+	middleware.SetAudit(c, "totp.reset", "user", id, nil, nil)
+	c.JSON(200, gin.H{
+		"success": true,
+		"message": "已关闭该用户的两步验证",
+	})
+}
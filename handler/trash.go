@@ -0,0 +1,101 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ziren926/van-nav/database"
+	"github.com/ziren926/van-nav/middleware"
+	"github.com/ziren926/van-nav/service"
+	"github.com/ziren926/van-nav/types"
+	"github.com/ziren926/van-nav/utils"
+)
+
+func GetTrashHandler(c *gin.Context) {
+	items, err := service.GetTrash()
+	if err != nil {
+		utils.CheckErr(err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success":      false,
+			"errorMessage": err.Error(),
+		})
+		return
+	}
+	c.JSON(200, gin.H{
+		"success": true,
+		"data":    items,
+	})
+}
+
+// 每种内容的恢复和永久删除挂在各自的路由组下面，权限和删除时一样
+func RestoreToolHandler(c *gin.Context)    { restoreTrash(c, types.TrashTool) }
+func RestoreCatelogHandler(c *gin.Context) { restoreTrash(c, types.TrashCatelog) }
+func RestorePostHandler(c *gin.Context)    { restoreTrash(c, types.TrashPost) }
+func PurgeToolHandler(c *gin.Context)      { purgeTrash(c, types.TrashTool) }
+func PurgeCatelogHandler(c *gin.Context)   { purgeTrash(c, types.TrashCatelog) }
+func PurgePostHandler(c *gin.Context)      { purgeTrash(c, types.TrashPost) }
+
+// 找到回收站里的这一项，并检查限定了分类的 token 能不能操作它
+func findTrashItem(c *gin.Context, kind string) (types.TrashItem, bool) {
+	id, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+	item, ok := service.GetTrashItem(kind, id)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{
+			"success":      false,
+			"errorMessage": "回收站里没有这一项",
+		})
+		return item, false
+	}
+	switch kind {
+	case types.TrashTool:
+		return item, checkTokenCatelogId(c, item.CatelogId)
+	case types.TrashCatelog:
+		return item, checkTokenCatelog(c, "")
+	}
+	return item, true
+}
+
+func restoreTrash(c *gin.Context, kind string) {
+	item, ok := findTrashItem(c, kind)
+	if !ok {
+		return
+	}
+	err := service.RestoreTrash(kind, item.Id)
+	if err != nil {
+		status := http.StatusBadRequest
+		if err == database.ErrCatelogNameTaken {
+			status = http.StatusConflict
+		}
+		c.JSON(status, gin.H{
+			"success":      false,
+			"errorMessage": err.Error(),
+		})
+		return
+	}
+	middleware.SetAudit(c, kind+".restore", kind, item.Id, nil, item)
+	c.JSON(200, gin.H{
+		"success": true,
+		"message": "恢复成功",
+	})
+}
+
+func purgeTrash(c *gin.Context, kind string) {
+	item, ok := findTrashItem(c, kind)
+	if !ok {
+		return
+	}
+	err := service.PurgeTrash(kind, item.Id)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success":      false,
+			"errorMessage": err.Error(),
+		})
+		return
+	}
+	middleware.SetAudit(c, kind+".purge", kind, item.Id, item, nil)
+	c.JSON(200, gin.H{
+		"success": true,
+		"message": "永久删除成功",
+	})
+}
@@ -0,0 +1,114 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ziren926/van-nav/middleware"
+	"github.com/ziren926/van-nav/service"
+	"github.com/ziren926/van-nav/types"
+	"github.com/ziren926/van-nav/utils"
+)
+
+func GetUsersHandler(c *gin.Context) {
+	c.JSON(200, gin.H{
+		"success": true,
+		"data":    service.GetAllUsers(),
+	})
+}
+
+func AddUserHandler(c *gin.Context) {
+	var data types.AddUserDto
+	if err := c.ShouldBindJSON(&data); err != nil {
+		utils.CheckErr(err)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success":      false,
+			"errorMessage": err.Error(),
+		})
+		return
+	}
+	user, err := service.AddUser(data)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success":      false,
+			"errorMessage": err.Error(),
+		})
+		return
+	}
+	middleware.SetAudit(c, "user.add", "user", user.Id, nil, user)
+	c.JSON(200, gin.H{
+		"success": true,
+		"message": "添加用户成功",
+		"data":    user,
+	})
+}
+
+func UpdateUserByIdHandler(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success":      false,
+			"errorMessage": "无效的ID",
+		})
+		return
+	}
+	var data types.UpdateUserRoleDto
+	if err := c.ShouldBindJSON(&data); err != nil {
+		utils.CheckErr(err)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success":      false,
+			"errorMessage": err.Error(),
+		})
+		return
+	}
+	before, _ := service.GetUserById(id)
+	passwordChanged, err := service.UpdateUserByOwner(id, data)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success":      false,
+			"errorMessage": err.Error(),
+		})
+		return
+	}
+	if passwordChanged {
+		_, err = service.RevokeUserSessions(id)
+		utils.CheckErr(err)
+	}
+	middleware.SetAudit(c, "user.update", "user", id, before, data)
+	c.JSON(200, gin.H{
+		"success": true,
+		"message": "更新用户成功",
+	})
+}
+
+func DeleteUserHandler(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success":      false,
+			"errorMessage": "无效的ID",
+		})
+		return
+	}
+	if id == c.GetInt("uid") {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success":      false,
+			"errorMessage": "不能删除自己",
+		})
+		return
+	}
+	before, _ := service.GetUserById(id)
+	if err := service.DeleteUser(id); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success":      false,
+			"errorMessage": err.Error(),
+		})
+		return
+	}
+	middleware.SetAudit(c, "user.delete", "user", id, before, nil)
+	c.JSON(200, gin.H{
+		"success": true,
+		"message": "删除用户成功",
+	})
+}
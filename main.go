@@ -3,15 +3,22 @@ package main
 import (
 	"embed"
 	"flag"
-	"fmt"
+	"net"
 	"net/http"
+	"os"
 	"path"
 	"strings"
+	"time"
 
+	"github.com/ziren926/van-nav/config"
 	"github.com/ziren926/van-nav/database"
+	"github.com/ziren926/van-nav/goscraper"
 	"github.com/ziren926/van-nav/handler"
 	"github.com/ziren926/van-nav/logger"
 	"github.com/ziren926/van-nav/middleware"
+	"github.com/ziren926/van-nav/service"
+	"github.com/ziren926/van-nav/types"
+	"github.com/ziren926/van-nav/utils"
 
 	"github.com/gin-contrib/gzip"
 	"github.com/gin-gonic/gin"
@@ -56,63 +63,258 @@ func BinaryFileSystem(data embed.FS, root string) *binaryFileSystem {
 	}
 }
 
-var port = flag.String("port", "6412", "指定监听端口")
+// 数据库相关的配置，子命令也要用
+func applyDatabaseConfig(cfg *config.Config) {
+	database.DataDir = cfg.Database.DataDir
+	database.DSN = cfg.Database.DSN
+	database.SQLiteFile = cfg.Database.SQLite.File
+	database.SQLiteJournalMode = cfg.Database.SQLite.JournalMode
+	database.SQLiteBusyTimeout = time.Duration(cfg.Database.SQLite.BusyTimeout)
+}
 
 func main() {
-	flag.Parse()
-	database.InitDB()
-	gin.SetMode(gin.ReleaseMode)
+	if runCommand(os.Args[1:]) {
+		return
+	}
+	cfg, err := config.Load(os.Args[0], os.Args[1:])
+	if err == flag.ErrHelp {
+		return
+	}
+	if err != nil {
+		logger.LogError("配置错误: %s", err)
+		os.Exit(1)
+	}
+	config.Current = cfg
+	if cfg.File != "" {
+		logger.LogInfo("已读取配置文件: %s", cfg.File)
+	}
+	applyDatabaseConfig(cfg)
+	utils.SessionLifetime = time.Duration(cfg.Auth.SessionLifetime)
+	utils.PreAuthLifetime = time.Duration(cfg.Auth.PreAuthLifetime)
+	utils.JWTKeyGracePeriod = time.Duration(cfg.Auth.JWTKeyGracePeriod)
+	utils.ImgFetchTimeout = time.Duration(cfg.Scraper.ImageTimeout)
+	goscraper.Timeout = time.Duration(cfg.Scraper.Timeout)
+	service.ScraperMaxRedirect = cfg.Scraper.MaxRedirect
+	if err := database.InitDB(); err != nil {
+		logger.LogError("初始化数据库失败: %s", err)
+		os.Exit(1)
+	}
+	service.TrashRetention = time.Duration(cfg.Trash.Days) * 24 * time.Hour
+	service.StartTrashPurge()
+	service.BackupDir = cfg.Backup.Dir
+	service.BackupKeepDaily = cfg.Backup.KeepDaily
+	service.BackupKeepWeekly = cfg.Backup.KeepWeekly
+	service.BackupPassphrase = cfg.Backup.Passphrase
+	if cfg.Backup.Schedule != "" {
+		// 格式在加载配置时已经检查过了
+		service.BackupSchedule, _ = utils.ParseCron(cfg.Backup.Schedule)
+	}
+	if err := service.InitRemoteBackup(cfg.Backup); err != nil {
+		logger.LogError("远程备份配置错误: %s", err)
+		os.Exit(1)
+	}
+	service.StartBackupSchedule()
+	if err := service.SetupFromConfig(cfg.Setup); err != nil {
+		logger.LogError("初始化管理员失败: %s", err)
+		os.Exit(1)
+	}
+	if service.SetupRequired() {
+		logger.LogInfo("还没有管理员账号，请打开 /login 完成初始化，或者用 van-nav setup 命令、VAN_NAV_ADMIN_USER 和 VAN_NAV_ADMIN_PASSWORD 环境变量创建")
+	}
+	if err := utils.InitJWTKey(database.DataDir, cfg.Auth.JWTSecret, cfg.Auth.JWTPreviousSecret); err != nil {
+		logger.LogError("初始化 JWT 密钥失败: %s", err)
+		os.Exit(1)
+	}
+	if err := service.InitOIDC(cfg.OIDC); err != nil {
+		logger.LogError("单点登录配置错误: %s", err)
+		os.Exit(1)
+	}
+	if err := service.InitProxyAuth(cfg.ProxyAuth); err != nil {
+		logger.LogError("反向代理认证配置错误: %s", err)
+		os.Exit(1)
+	}
+	gin.SetMode(cfg.Server.Mode)
 	router := gin.Default()
+	// 只采信可信代理传过来的客户端 IP，否则谁都能伪造 X-Forwarded-For 绕过登录限制
+	if err := router.SetTrustedProxies(cfg.Server.TrustedProxies); err != nil {
+		logger.LogError("可信代理配置错误: %s", err)
+		os.Exit(1)
+	}
 
-	router.Use(middleware.CORS())
+	// 跨域策略，默认不允许任何跨域请求。管理接口单独配置，不在白名单里的直接拒绝
+	publicCORS, err := middleware.NewCORSPolicy(cfg.CORS.Public, "cors.public", false)
+	if err != nil {
+		logger.LogError("跨域配置错误: %s", err)
+		os.Exit(1)
+	}
+	adminCORS, err := middleware.NewCORSPolicy(cfg.CORS.Admin, "cors.admin", true)
+	if err != nil {
+		logger.LogError("跨域配置错误: %s", err)
+		os.Exit(1)
+	}
+	router.Use(middleware.CORS(publicCORS, adminCORS))
 	router.Use(gzip.Gzip(gzip.DefaultCompression))
 	// 嵌入文件夹
-	router.GET("/manifest.json", handler.ManifastHanlder)
+	router.GET("/manifest.json", middleware.Authenticate(), middleware.PrivateMode(), handler.ManifastHanlder)
 	router.Use(Serve("/", BinaryFileSystem(fs, "public")))
 	api := router.Group("/api")
+	api.Use(middleware.Authenticate())
 	{
-		// 获取数据的路由
-		api.GET("/", handler.GetAllHandler)
+		// 获取数据的路由，开启私有模式后要登录
+		private := middleware.PrivateMode()
+		api.GET("/", private, handler.GetAllHandler)
 		// 获取用户信息
-        api.GET("/tool/:id", handler.GetToolDetailHandler)  // 新增这一行
-		api.POST("/login", handler.LoginHandler)
+		api.GET("/tool/:id", private, handler.GetToolDetailHandler) // 新增这一行
+		// 登录接口按 IP 限流，失败次数另外在 handler 里按 IP 和用户名计数
+		loginLimit := middleware.RateLimit(1, 5)
+		api.POST("/login", loginLimit, handler.LoginHandler)
+		api.POST("/login/totp", loginLimit, handler.LoginTOTPHandler)
+		api.GET("/login/options", handler.LoginOptionsHandler)
+		api.GET("/oidc/login", loginLimit, handler.OIDCLoginHandler)
+		api.GET("/oidc/callback", loginLimit, handler.OIDCCallbackHandler)
 		api.GET("/logout", handler.LogoutHandler)
-		api.GET("/img", handler.GetLogoImgHandler)
-		// 管理员用的
+		api.GET("/img", private, handler.GetLogoImgHandler)
+		// 首次启动的初始化向导
+		api.GET("/setup", handler.GetSetupHandler)
+		api.POST("/setup", loginLimit, handler.SetupHandler)
+		// 管理员用的。登录用户按角色、api token 按权限范围放行
 		admin := api.Group("/admin")
-		admin.Use(middleware.JWTMiddleware())
+		admin.Use(middleware.RequireSetup(), middleware.JWTMiddleware(), middleware.AuditMiddleware())
+		read := admin.Group("")
+		read.Use(middleware.Require(types.RoleViewer, types.ScopeRead))
 		{
-			admin.POST("/apiToken", handler.AddApiTokenHandler)
-			admin.DELETE("/apiToken/:id", handler.DeleteApiTokenHandler)
-			admin.GET("/all", handler.GetAdminAllDataHandler)
-			admin.GET("/tool/:id/post", handler.GetPostHandler)
-            admin.PUT("/tool/:id/post", handler.UpdatePostHandler)
-
-			admin.GET("/exportTools", handler.ExportToolsHandler)
-
-			admin.POST("/importTools", handler.ImportToolsHandler)
-
-			admin.PUT("/user", handler.UpdateUserHandler)
-
-			admin.PUT("/setting", handler.UpdateSettingHandler)
-
+			read.GET("/all", handler.GetAdminAllDataHandler)
+			read.GET("/tool/:id/post", handler.GetPostHandler)
+			read.GET("/exportTools", handler.ExportToolsHandler)
+			read.GET("/posts", handler.GetPostsHandler)
+			read.GET("/trash", handler.GetTrashHandler)
+			// 修改历史
+			read.GET("/tool/:id/revisions", handler.GetToolRevisionsHandler)
+			read.GET("/tool/:id/revisions/:rid", handler.GetToolRevisionHandler)
+			read.GET("/tool/:id/revisions/:rid/diff", handler.DiffToolRevisionHandler)
+			read.GET("/post/:id/revisions", handler.GetPostRevisionsHandler)
+			read.GET("/post/:id/revisions/:rid", handler.GetPostRevisionHandler)
+			read.GET("/post/:id/revisions/:rid/diff", handler.DiffPostRevisionHandler)
+		}
+		// 只有登录用户能访问，token 不行
+		self := admin.Group("")
+		self.Use(middleware.Require(types.RoleViewer, ""))
+		{
+			// 修改自己的用户名和密码
+			self.PUT("/user", handler.UpdateUserHandler)
 
+			self.GET("/sessions", handler.GetSessionsHandler)
+			self.DELETE("/sessions", handler.RevokeAllSessionsHandler)
+			self.DELETE("/sessions/:id", handler.RevokeSessionHandler)
 
+			// 两步验证
+			self.GET("/totp", handler.GetTOTPHandler)
+			self.POST("/totp/setup", handler.SetupTOTPHandler)
+			self.POST("/totp/confirm", handler.ConfirmTOTPHandler)
+			self.POST("/totp/recoveryCodes", handler.RegenerateRecoveryCodesHandler)
+			self.POST("/totp/disable", handler.DisableTOTPHandler)
 
+			// 关联单点登录账号
+			self.POST("/oidc/link", handler.OIDCLinkHandler)
+			self.DELETE("/oidc/link", handler.OIDCUnlinkHandler)
+		}
+		// editor 及以上：管理工具、分类和帖子
+		tools := admin.Group("")
+		tools.Use(middleware.Require(types.RoleEditor, types.ScopeToolsWrite))
+		{
+			tools.POST("/tool", handler.AddToolHandler)
+			tools.PUT("/tool/:id", handler.UpdateToolHandler)
+			tools.DELETE("/tool/:id", handler.DeleteToolHandler)
+			tools.PUT("/tools/sort", handler.UpdateToolsSortHandler)
+			tools.POST("/importTools", handler.ImportToolsHandler)
+			tools.POST("/importBookmarks", handler.ImportBookmarksHandler)
+			tools.POST("/trash/tool/:id/restore", handler.RestoreToolHandler)
+			tools.DELETE("/trash/tool/:id", handler.PurgeToolHandler)
+			tools.POST("/tool/:id/revisions/:rid/restore", handler.RestoreToolRevisionHandler)
+		}
+		catelogs := admin.Group("")
+		catelogs.Use(middleware.Require(types.RoleEditor, types.ScopeCatelogsWrite))
+		{
+			catelogs.POST("/catelog", handler.AddCatelogHandler)
+			catelogs.DELETE("/catelog/:id", handler.DeleteCatelogHandler)
+			catelogs.PUT("/catelog/:id", handler.UpdateCatelogHandler)
+			catelogs.POST("/trash/catelog/:id/restore", handler.RestoreCatelogHandler)
+			catelogs.DELETE("/trash/catelog/:id", handler.PurgeCatelogHandler)
+		}
+		posts := admin.Group("")
+		posts.Use(middleware.Require(types.RoleEditor, types.ScopePostsWrite))
+		{
+			posts.PUT("/tool/:id/post", handler.UpdatePostHandler)
+			posts.POST("/post", handler.AddPostHandler)
+			posts.DELETE("/post/:id", handler.DeletePostHandler)
+			posts.PUT("/post/:id", handler.UpdatePostHandler)
+			posts.POST("/trash/post/:id/restore", handler.RestorePostHandler)
+			posts.DELETE("/trash/post/:id", handler.PurgePostHandler)
+			posts.POST("/post/:id/revisions/:rid/restore", handler.RestorePostRevisionHandler)
+		}
+		// editor 及以上：管理分享链接
+		shares := admin.Group("")
+		shares.Use(middleware.Require(types.RoleEditor, types.ScopeSharesWrite))
+		{
+			shares.GET("/shares", handler.GetSharesHandler)
+			shares.POST("/share", handler.AddShareHandler)
+			shares.DELETE("/share/:id", handler.RevokeShareHandler)
+		}
+		// owner：管理用户、token 和网站设置
+		settings := admin.Group("")
+		settings.Use(middleware.Require(types.RoleOwner, types.ScopeSettingsWrite))
+		{
+			settings.PUT("/setting", handler.UpdateSettingHandler)
+			// 当前生效的配置，密码和密钥不显示
+			settings.GET("/config", handler.GetConfigHandler)
+			// 检查和修复数据库
+			settings.GET("/doctor", handler.GetDoctorHandler)
+			settings.POST("/doctor", handler.FixDoctorHandler)
+		}
+		// 备份里有所有的数据，包括密码哈希和 token，只给 owner
+		backups := admin.Group("")
+		backups.Use(middleware.Require(types.RoleOwner, types.ScopeBackupsWrite))
+		{
+			backups.GET("/backups", handler.GetBackupsHandler)
+			backups.GET("/backups/remote", handler.GetRemoteBackupStatusHandler)
+			backups.POST("/backup", handler.AddBackupHandler)
+			backups.GET("/backup/:name", handler.DownloadBackupHandler)
+			backups.POST("/backup/:name/restore", handler.RestoreBackupHandler)
+			backups.POST("/backup/:name/push", handler.PushBackupHandler)
+			backups.DELETE("/backup/:name", handler.DeleteBackupHandler)
+		}
+		apiTokens := admin.Group("")
+		apiTokens.Use(middleware.Require(types.RoleOwner, types.ScopeTokensWrite))
+		{
+			apiTokens.GET("/apiTokens", handler.GetApiTokensHandler)
+			apiTokens.POST("/apiToken", handler.AddApiTokenHandler)
+			apiTokens.DELETE("/apiToken/:id", handler.DeleteApiTokenHandler)
+		}
+		users := admin.Group("")
+		users.Use(middleware.Require(types.RoleOwner, types.ScopeUsersWrite))
+		{
+			users.GET("/users", handler.GetUsersHandler)
+			users.POST("/users", handler.AddUserHandler)
+			users.PUT("/users/:id", handler.UpdateUserByIdHandler)
+			users.DELETE("/users/:id", handler.DeleteUserHandler)
+			users.DELETE("/users/:id/totp", handler.ResetUserTOTPHandler)
+			users.DELETE("/users/:id/oidc", handler.UnlinkUserOIDCHandler)
 
-			admin.POST("/catelog", handler.AddCatelogHandler)
-			admin.DELETE("/catelog/:id", handler.DeleteCatelogHandler)
-			admin.PUT("/catelog/:id", handler.UpdateCatelogHandler)
+			users.GET("/lockouts", handler.GetLockoutsHandler)
+			users.DELETE("/lockouts", handler.ClearLockoutsHandler)
 
-			admin.GET("/posts", handler.GetPostsHandler)
-            admin.POST("/post", handler.AddPostHandler)
-            admin.DELETE("/post/:id", handler.DeletePostHandler)
-            admin.PUT("/post/:id", handler.UpdatePostHandler)
+			users.GET("/jwtKeys", handler.GetJWTKeysHandler)
+			users.POST("/jwtKeys/rotate", handler.RotateJWTKeyHandler)
+		}
+		audit := admin.Group("")
+		audit.Use(middleware.Require(types.RoleOwner, types.ScopeAuditRead))
+		{
+			audit.GET("/audit", handler.GetAuditsHandler)
 		}
 	}
-	logger.LogInfo("应用启动成功，网址: http://localhost:%s", *port)
-	listen := fmt.Sprintf(":%s", *port)
-	err := router.Run(listen)
+	logger.LogInfo("应用启动成功，网址: http://localhost:%s", cfg.Server.Port)
+	listen := net.JoinHostPort(cfg.Server.Host, cfg.Server.Port)
+	err = router.Run(listen)
 	if err != nil {
 		logger.LogError("应用启动失败，错误: %s", err)
 	}
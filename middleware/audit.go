@@ -2,43 +2,78 @@
 package middleware
 
 import (
-    "time"
+	"fmt"
+	"net/http"
+	"time"
+
     "github.com/gin-gonic/gin"
     "github.com/ziren926/van-nav/logger"
+	"github.com/ziren926/van-nav/service"
+	"github.com/ziren926/van-nav/types"
 )
 
-var (
-    CurrentUser = "ziren926"
-    CurrentTime = time.Date(2025, 1, 20, 2, 45, 19, 0, time.UTC)
-)
-
+// 一次请求的审计信息，handler 通过 SetAudit 补充操作对象和修改前后的数据
 type AuditInfo struct {
-    UserLogin  string
-    Timestamp  time.Time
-    RequestID  string
+	Action     string
+	TargetType string
+	TargetId   string
+	Before     interface{}
+	After      interface{}
 }
 
+// AuditMiddleware 把管理接口的每个修改请求写进审计日志，放在认证中间件之后。
+// 只记录成功的请求，handler 没有调用 SetAudit 时按请求方法和路由记录
 func AuditMiddleware() gin.HandlerFunc {
     return func(c *gin.Context) {
-        audit := &AuditInfo{
-            UserLogin: CurrentUser,
-            Timestamp: CurrentTime,
-            RequestID: c.GetString("X-Request-ID"),
-        }
-
+		switch c.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			c.Next()
+			return
+		}
+		audit := &AuditInfo{}
         c.Set("audit", audit)
 
-        // 记录请求开始
-        logger.LogInfo("Request started - User: %s, Time: %s, Path: %s",
-            audit.UserLogin,
-            audit.Timestamp.Format("2006-01-02 15:04:05"),
-            c.Request.URL.Path)
-
         c.Next()
 
-        // 记录请求结束
-        logger.LogInfo("Request completed - User: %s, Path: %s",
-            audit.UserLogin,
-            c.Request.URL.Path)
-    }
-}
\ No newline at end of file
+		if c.IsAborted() || c.Writer.Status() >= http.StatusBadRequest {
+			return
+		}
+		if audit.Action == "" {
+			audit.Action = c.Request.Method + " " + c.FullPath()
+		}
+		entry := types.AuditLog{
+			CreatedAt:  time.Now(),
+			Actor:      c.GetString("username"),
+			ActorType:  c.GetString("authType"),
+			Action:     audit.Action,
+			TargetType: audit.TargetType,
+			TargetId:   audit.TargetId,
+			Changes:    service.AuditDiff(audit.Before, audit.After),
+			Ip:         c.ClientIP(),
+		}
+		if entry.ActorType == "token" {
+			entry.ActorId = c.GetInt("tokenId")
+		} else {
+			entry.ActorId = c.GetInt("uid")
+		}
+		if err := service.AddAudit(entry); err != nil {
+			logger.LogError("写入审计日志失败: %s", err)
+		}
+	}
+}
+
+// SetAudit 记录这次修改的动作、对象和前后的数据，新增时 before 传 nil，删除时 after 传 nil
+func SetAudit(c *gin.Context, action string, targetType string, targetId interface{}, before interface{}, after interface{}) {
+	value, ok := c.Get("audit")
+	if !ok {
+		return
+	}
+	audit := value.(*AuditInfo)
+	audit.Action = action
+	audit.TargetType = targetType
+	if targetId != nil {
+		audit.TargetId = fmt.Sprint(targetId)
+	}
+	audit.Before = before
+	audit.After = after
+}
@@ -2,36 +2,99 @@ package middleware
 
 import (
 	"net/http"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt"
 	"github.com/ziren926/van-nav/database"
+	"github.com/ziren926/van-nav/service"
 	"github.com/ziren926/van-nav/utils"
 )
 
-// 定义一个 JWT 的中间件, 除了校验 jtw，还要校验之前签发的 api token 只要一样就放行。
-func JWTMiddleware() gin.HandlerFunc {
+// 解析请求里的登录信息并放到上下文，不拦截请求。公开接口靠它判断是否登录
+func Authenticate() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		rawToken := c.Request.Header.Get("Authorization")
-		if rawToken == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{
-				"success":      false,
-				"errorMessage": "未登录",
-			})
-			c.Abort()
-			return
-		}
+		authenticate(c)
+		c.Next()
+	}
+}
 
-		if database.HasApiToken(rawToken) {
-			c.Set("username", "apiToken")
-			c.Set("uid", 1)
-			c.Next()
-			return
-		}
+// 依次尝试 Authorization 头和反向代理认证
+func authenticate(c *gin.Context) bool {
+	if _, ok := c.Get("authType"); ok {
+		return true
+	}
+	if rawToken := c.Request.Header.Get("Authorization"); rawToken != "" && authenticateToken(c, rawToken) {
+		return true
+	}
+	return authenticateProxy(c)
+}
+
+// 反向代理已经认证过的用户，只信任直接来自配置网段的请求。
+// 这里要用 RemoteIP 而不是 ClientIP，后者会采信 X-Forwarded-For
+func authenticateProxy(c *gin.Context) bool {
+	remoteIp, _ := c.RemoteIP()
+	user, ok := service.ProxyAuthenticate(remoteIp, c.GetHeader)
+	if !ok {
+		return false
+	}
+	c.Set("authType", "user")
+	c.Set("username", user.Name)
+	c.Set("uid", user.Id)
+	c.Set("role", user.Role)
+	return true
+}
 
-		// 解析 token
-		token, err := utils.ParseJWT(rawToken)
-		if err != nil {
+// 校验 jwt 和之前签发的 api token，jwt 还要对应一条没被吊销的会话
+func authenticateToken(c *gin.Context, rawToken string) bool {
+	// api token 只存了哈希，按哈希查找。它没有用户身份，权限只看 scopes
+	if apiToken, err := database.Tokens.GetByHash(utils.HashApiToken(rawToken), time.Now()); err == nil {
+		database.Tokens.Touch(apiToken.Id, c.ClientIP(), time.Now())
+		c.Set("authType", "token")
+		c.Set("username", "token:"+apiToken.Name)
+		c.Set("tokenId", apiToken.Id)
+		c.Set("scopes", apiToken.Scopes)
+		c.Set("tokenCatelogId", apiToken.CatelogId)
+		c.Set("tokenCatelog", apiToken.Catelog)
+		return true
+	}
+
+	// 解析 token
+	token, err := utils.ParseJWT(rawToken)
+	if err != nil || !token.Valid {
+		return false
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return false
+	}
+	// 预登录之类有特定用途的 token 不能当登录会话用
+	if _, ok := claims["purpose"]; ok {
+		return false
+	}
+	jti, _ := claims["jti"].(string)
+	if !service.IsSessionActive(jti) {
+		return false
+	}
+	id, _ := claims["id"].(float64)
+	// 每次都查一下角色，改了角色或者删了用户马上生效
+	user, err := database.Users.GetById(int(id))
+	if err != nil {
+		return false
+	}
+	// 把名称加到上下文
+	c.Set("authType", "user")
+	c.Set("username", claims["name"])
+	c.Set("uid", int(id))
+	c.Set("role", user.Role)
+	c.Set("jti", jti)
+	return true
+}
+
+// 定义一个 JWT 的中间件, 除了校验 jtw，还要校验之前签发的 api token，都不通过就返回 401。
+func JWTMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !authenticate(c) {
 			c.JSON(http.StatusUnauthorized, gin.H{
 				"success":      false,
 				"errorMessage": "未登录",
@@ -39,18 +102,28 @@ func JWTMiddleware() gin.HandlerFunc {
 			c.Abort()
 			return
 		}
-		// 把名称加到上下文
-		if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-			c.Set("username", claims["name"])
-			c.Set("uid", claims["id"])
-			c.Next()
+		c.Next()
+	}
+}
+
+// 用户要求角色不低于 role，api token 要求有 scope 权限。scope 为空表示不允许 token 访问。
+// 需要放在 JWTMiddleware 后面
+func Require(role string, scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var allowed bool
+		if c.GetString("authType") == "token" {
+			allowed = scope != "" && service.HasScope(c.GetStringSlice("scopes"), scope)
 		} else {
-			c.JSON(http.StatusUnauthorized, gin.H{
+			allowed = service.RoleAtLeast(c.GetString("role"), role)
+		}
+		if !allowed {
+			c.JSON(http.StatusForbidden, gin.H{
 				"success":      false,
-				"errorMessage": "未登录",
+				"errorMessage": "权限不足",
 			})
 			c.Abort()
 			return
 		}
+		c.Next()
 	}
 }
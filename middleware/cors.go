@@ -2,46 +2,161 @@
 package middleware
 
 import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
     "github.com/gin-gonic/gin"
-    "github.com/ziren926/van-nav/logger" // 添加 logger 导入
+	"github.com/ziren926/van-nav/config"
 )
 
-// CORS 处理跨域请求的中间件
-func CORS() gin.HandlerFunc {
+// 管理接口的路径前缀，用单独的跨域策略
+const adminPathPrefix = "/api/admin"
+
+// CORSPolicy 一组跨域规则。AllowOrigins 支持完全匹配（https://portal.example.com）、
+// 子域名通配（https://*.example.com，不包括 example.com 本身）和 *（任意来源）
+type CORSPolicy struct {
+	AllowOrigins     []string
+	AllowMethods     []string
+	AllowHeaders     []string
+	AllowCredentials bool
+	MaxAge           int // 预检结果缓存的秒数
+	// 不在白名单里的跨域请求直接返回 403，而不只是不返回 CORS 头。
+	// 浏览器虽然读不到响应，但简单请求已经执行了，修改数据的接口要开启
+	Strict bool
+}
+
+// NewCORSPolicy 按配置生成策略并检查，name 是配置项的前缀，出错时提示用。
+// 没有配置 origins 表示不允许任何跨域请求
+func NewCORSPolicy(cfg config.CORSPolicyConfig, name string, strict bool) (CORSPolicy, error) {
+	methods := make([]string, 0, len(cfg.Methods))
+	for _, method := range cfg.Methods {
+		methods = append(methods, strings.ToUpper(method))
+	}
+	policy := CORSPolicy{
+		AllowOrigins:     cfg.Origins,
+		AllowMethods:     methods,
+		AllowHeaders:     cfg.Headers,
+		AllowCredentials: cfg.Credentials,
+		MaxAge:           cfg.MaxAge,
+		Strict:           strict,
+	}
+	if policy.MaxAge < 0 {
+		return policy, errors.New(name + ".maxAge 不能小于 0")
+	}
+	for _, origin := range policy.AllowOrigins {
+		if origin == "*" {
+			if policy.AllowCredentials {
+				return policy, errors.New(name + ".origins 为 * 时不能开启 " + name + ".credentials")
+			}
+			continue
+		}
+		parsed, err := url.Parse(strings.Replace(origin, "://*.", "://x.", 1))
+		if err != nil || parsed.Scheme == "" || parsed.Host == "" || (parsed.Path != "" && parsed.Path != "/") {
+			return policy, errors.New("无效的 " + name + ".origins: " + origin)
+		}
+	}
+	return policy, nil
+}
+
+// 来源是否在白名单里
+func (p CORSPolicy) allowOrigin(origin string) bool {
+	parsed, err := url.Parse(origin)
+	if err != nil || parsed.Scheme == "" || parsed.Host == "" {
+		return false
+	}
+	for _, allowed := range p.AllowOrigins {
+		allowed = strings.TrimSuffix(allowed, "/")
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+		// 子域名通配：协议和端口要一样，主机名以 .example.com 结尾
+		scheme, host, ok := strings.Cut(allowed, "://*.")
+		if !ok || !strings.EqualFold(scheme, parsed.Scheme) {
+			continue
+		}
+		suffix, port, _ := strings.Cut(host, ":")
+		if port == parsed.Port() && strings.HasSuffix(strings.ToLower(parsed.Hostname()), "."+strings.ToLower(suffix)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p CORSPolicy) allowMethod(method string) bool {
+	for _, allowed := range p.AllowMethods {
+		if allowed == method {
+			return true
+		}
+	}
+	return false
+}
+
+// 同源请求浏览器也可能带 Origin（比如 POST），不算跨域
+func sameOrigin(c *gin.Context, origin string) bool {
+	parsed, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(parsed.Host, c.Request.Host)
+}
+
+// CORS 处理跨域请求的中间件，/api/admin 下用 admin 策略，其他用 public 策略。
+// 只回显白名单里的来源，不再返回 Access-Control-Allow-Origin: *
+func CORS(public CORSPolicy, admin CORSPolicy) gin.HandlerFunc {
     return func(c *gin.Context) {
-        // 设置允许的来源
-        c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
-
-        // 允许携带凭证
-        c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
-
-        // 允许的请求方法
-        c.Writer.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS, PATCH")
-
-        // 允许的请求头
-        c.Writer.Header().Set("Access-Control-Allow-Headers", ""+
-            "Authorization, "+
-            "Content-Type, "+
-            "Content-Length, "+
-            "Accept-Encoding, "+
-            "X-CSRF-Token, "+
-            "X-Requested-With, "+
-            "Accept, "+
-            "Origin, "+
-            "Cache-Control")
-
-        // 处理预检请求
-        if c.Request.Method == "OPTIONS" {
-            c.AbortWithStatus(204)
-            return
-        }
-
-        // 记录跨域请求
-        origin := c.Request.Header.Get("Origin")
-        if origin != "" {
-            logger.LogInfo("CORS request from: %s, Path: %s", origin, c.Request.URL.Path)
-        }
+		policy := public
+		if strings.HasPrefix(c.Request.URL.Path, adminPathPrefix) {
+			policy = admin
+		}
+		origin := c.Request.Header.Get("Origin")
+		// 响应内容和 Origin 有关，告诉缓存要按 Origin 区分
+		c.Writer.Header().Add("Vary", "Origin")
+		if origin == "" || sameOrigin(c, origin) {
+			c.Next()
+			return
+		}
+		preflight := c.Request.Method == http.MethodOptions && c.Request.Header.Get("Access-Control-Request-Method") != ""
+
+		if !policy.allowOrigin(origin) {
+			if preflight || policy.Strict {
+				c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+					"success":      false,
+					"errorMessage": "不允许的跨域来源",
+				})
+				return
+			}
+			c.Next()
+			return
+		}
 
+		header := c.Writer.Header()
+		header.Set("Access-Control-Allow-Origin", origin)
+		if policy.AllowCredentials {
+			header.Set("Access-Control-Allow-Credentials", "true")
+		}
+		if preflight {
+			if !policy.allowMethod(strings.ToUpper(c.Request.Header.Get("Access-Control-Request-Method"))) {
+				c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+					"success":      false,
+					"errorMessage": "不允许的跨域请求方法",
+				})
+				return
+			}
+			header.Add("Vary", "Access-Control-Request-Method")
+			header.Add("Vary", "Access-Control-Request-Headers")
+			header.Set("Access-Control-Allow-Methods", strings.Join(policy.AllowMethods, ", "))
+			header.Set("Access-Control-Allow-Headers", strings.Join(policy.AllowHeaders, ", "))
+			header.Set("Access-Control-Max-Age", strconv.Itoa(policy.MaxAge))
+			c.AbortWithStatus(http.StatusNoContent)
+			return
+		}
+		if policy.Strict && !policy.allowMethod(c.Request.Method) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+				"success":      false,
+				"errorMessage": "不允许的跨域请求方法",
+			})
+			return
+		}
         c.Next()
     }
-}
\ No newline at end of file
+}
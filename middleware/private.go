@@ -0,0 +1,48 @@
+package middleware
+
+import (
+	"net"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ziren926/van-nav/service"
+	"github.com/ziren926/van-nav/types"
+	"github.com/ziren926/van-nav/utils"
+)
+
+// 登录时顺便写进 cookie 的会话 token。浏览器加载图片和 manifest 时带不了 Authorization 头，
+// 私有模式下靠它判断是否登录。只在这里认，管理接口不认 cookie，免得被跨站请求利用
+const SessionCookie = "vn_session"
+
+// PrivateMode 开启私有模式后，公开的读接口也要登录才能访问，白名单里的 IP 不用登录。
+// 需要放在 Authenticate 后面
+func PrivateMode() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		enabled, allowlist := service.GetPrivateMode()
+		if !enabled || privateAccess(c, allowlist) {
+			c.Next()
+			return
+		}
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+			"success":      false,
+			"errorMessage": "需要登录后才能访问",
+			"privateMode":  true,
+		})
+	}
+}
+
+func privateAccess(c *gin.Context, allowlist []*net.IPNet) bool {
+	if authenticate(c) || authenticateCookie(c) {
+		// 登录用户什么角色都可以，api token 要有读权限
+		if c.GetString("authType") != "token" || service.HasScope(c.GetStringSlice("scopes"), types.ScopeRead) {
+			return true
+		}
+	}
+	// 经过 SetTrustedProxies 之后 ClientIP 只采信可信代理转发的地址
+	return utils.ContainsIP(allowlist, net.ParseIP(c.ClientIP()))
+}
+
+func authenticateCookie(c *gin.Context) bool {
+	raw, err := c.Cookie(SessionCookie)
+	return err == nil && raw != "" && authenticateToken(c, raw)
+}
@@ -2,19 +2,57 @@
 package middleware
 
 import (
+	"fmt"
+	"math"
+	"sync"
     "time"
 
     "github.com/gin-gonic/gin"
     "golang.org/x/time/rate"
 )
 
-// RateLimit 创建速率限制中间件
-func RateLimit() gin.HandlerFunc {
-    // 创建一个限制器：每秒允许10个请求
-    limiter := rate.NewLimiter(rate.Every(time.Second), 10)
+type ipLimiter struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// RateLimit 创建速率限制中间件，按客户端 IP 分别限流，每秒 r 个请求，最多攒 burst 个。
+// IP 用 c.ClientIP()，放在反向代理后面时需要配置可信代理
+func RateLimit(r rate.Limit, burst int) gin.HandlerFunc {
+	var mu sync.Mutex
+	limiters := make(map[string]*ipLimiter)
+	lastSweep := time.Now()
 
     return func(c *gin.Context) {
-        if !limiter.Allow() {
+		ip := c.ClientIP()
+		now := time.Now()
+
+		mu.Lock()
+		// 定期清理一段时间没访问的 IP，避免 map 无限增长
+		if now.Sub(lastSweep) > time.Minute {
+			for key, l := range limiters {
+				if now.Sub(l.lastSeen) > time.Minute*10 {
+					delete(limiters, key)
+				}
+			}
+			lastSweep = now
+		}
+		l, ok := limiters[ip]
+		if !ok {
+			l = &ipLimiter{limiter: rate.NewLimiter(r, burst)}
+			limiters[ip] = l
+		}
+		l.lastSeen = now
+		reservation := l.limiter.ReserveN(now, 1)
+		delay := reservation.DelayFrom(now)
+		if delay > 0 {
+			// 不真的占用额度，只是用来算要等多久
+			reservation.CancelAt(now)
+		}
+		mu.Unlock()
+
+		if delay > 0 {
+			c.Header("Retry-After", fmt.Sprint(int(math.Ceil(delay.Seconds()))))
             c.JSON(429, gin.H{
                 "success": false,
                 "errorMessage": "请求过于频繁，请稍后再试",
@@ -24,4 +62,4 @@ func RateLimit() gin.HandlerFunc {
         }
         c.Next()
     }
-}
\ No newline at end of file
+}
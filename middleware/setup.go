@@ -0,0 +1,24 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ziren926/van-nav/service"
+)
+
+// 还没有完成初始化时，管理接口一律不可用
+func RequireSetup() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if service.SetupRequired() {
+			c.JSON(http.StatusServiceUnavailable, gin.H{
+				"success":       false,
+				"errorMessage":  "请先完成初始化",
+				"setupRequired": true,
+			})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
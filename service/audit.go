@@ -0,0 +1,128 @@
+package service
+
+import (
+	"encoding/json"
+	"reflect"
+
+	"github.com/ziren926/van-nav/database"
+	"github.com/ziren926/van-nav/types"
+)
+
+const (
+	auditDefaultPageSize = 20
+	auditMaxPageSize     = 200
+)
+
+// 这些字段只记录改没改，不记录值
+var auditSecretFields = map[string]bool{
+	"password":   true,
+	"value":      true,
+	"secret":     true,
+	"totpSecret": true,
+}
+
+// 转成按 json 字段名索引的 map，不是对象的整个放在 data 里
+func auditFields(v interface{}) map[string]interface{} {
+	if v == nil || (reflect.ValueOf(v).Kind() == reflect.Ptr && reflect.ValueOf(v).IsNil()) {
+		return nil
+	}
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	fields := make(map[string]interface{})
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		var value interface{}
+		json.Unmarshal(raw, &value)
+		return map[string]interface{}{"data": value}
+	}
+	return fields
+}
+
+// 计算修改前后的差异。新增时 before 为 nil，删除时 after 为 nil。
+// 都不为 nil 时只比较 after 里有的字段，因为 after 一般是请求里的 dto，字段比实体少
+func AuditDiff(before interface{}, after interface{}) map[string]types.AuditChange {
+	beforeFields := auditFields(before)
+	afterFields := auditFields(after)
+	keys := afterFields
+	if keys == nil {
+		keys = beforeFields
+	}
+	changes := make(map[string]types.AuditChange)
+	for key := range keys {
+		var change types.AuditChange
+		beforeValue, hasBefore := beforeFields[key]
+		afterValue, hasAfter := afterFields[key]
+		if hasBefore && hasAfter && reflect.DeepEqual(beforeValue, afterValue) {
+			continue
+		}
+		if beforeFields != nil && afterFields != nil {
+			// 修改时 id 不算改动；实体里省略了的空字段和 dto 里的空值也当作没改
+			if key == "id" || (!hasBefore && isEmptyAuditValue(afterValue)) {
+				continue
+			}
+		} else if isEmptyAuditValue(beforeValue) && isEmptyAuditValue(afterValue) {
+			// 新增和删除时空字段没必要记
+			continue
+		}
+		if hasBefore {
+			change.Before = beforeValue
+		}
+		if hasAfter {
+			change.After = afterValue
+		}
+		if auditSecretFields[key] {
+			if change.Before != nil {
+				change.Before = "******"
+			}
+			if change.After != nil && change.After != "" {
+				change.After = "******"
+			}
+		}
+		changes[key] = change
+	}
+	return changes
+}
+
+func isEmptyAuditValue(v interface{}) bool {
+	switch value := v.(type) {
+	case nil:
+		return true
+	case string:
+		return value == ""
+	case bool:
+		return !value
+	case float64:
+		return value == 0
+	}
+	return false
+}
+
+func AddAudit(entry types.AuditLog) error {
+	return database.Audits.Add(entry)
+}
+
+// 分页查询审计日志，新的在前
+func GetAudits(query types.AuditQueryDto) (types.ResAuditListDto, error) {
+	if query.Page < 1 {
+		query.Page = 1
+	}
+	if query.PageSize < 1 {
+		query.PageSize = auditDefaultPageSize
+	}
+	if query.PageSize > auditMaxPageSize {
+		query.PageSize = auditMaxPageSize
+	}
+	result := types.ResAuditListDto{
+		List:     make([]types.AuditLog, 0),
+		Page:     query.Page,
+		PageSize: query.PageSize,
+	}
+	list, total, err := database.Audits.Query(query)
+	if err != nil {
+		return result, err
+	}
+	result.List = list
+	result.Total = total
+	return result, nil
+}
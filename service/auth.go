@@ -1,63 +1,46 @@
 package service
 
 import (
+	"errors"
+
 	"github.com/ziren926/van-nav/database"
 	"github.com/ziren926/van-nav/types"
 	"github.com/ziren926/van-nav/utils"
 )
 
-func GetApiTokens() []types.Token {
-	sql_get_api_tokens := `
-		SELECT id,name,value,disabled FROM nav_api_token WHERE disabled = 0;
-		`
-	results := make([]types.Token, 0)
-	rows, err := database.DB.Query(sql_get_api_tokens)
-	utils.CheckErr(err)
-	for rows.Next() {
-		var token types.Token
-		err = rows.Scan(&token.Id, &token.Name, &token.Value, &token.Disabled)
+func GetUser(name string) types.User {
+	user, err := database.Users.GetByName(name)
+	if err != nil && err != database.ErrNotFound {
 		utils.CheckErr(err)
-		results = append(results, token)
 	}
-	defer rows.Close()
-	return results
-}
-
-func GetUser(name string) types.User {
-	sql_get_user := `
-		SELECT id,name,password FROM nav_user WHERE name = ?;
-		`
-	var user types.User
-	row := database.DB.QueryRow(sql_get_user, name)
-	err := row.Scan(&user.Id, &user.Name, &user.Password)
-	utils.CheckErr(err)
 	return user
 }
 
-func AddApiTokenInDB(data types.Token) {
-	sql_add_api_token := `
-		INSERT INTO nav_api_token (id,name,value,disabled)
-		VALUES (?,?,?,?);
-		`
-	stmt, err := database.DB.Prepare(sql_add_api_token)
-	utils.CheckErr(err)
-
-	res, err := stmt.Exec(data.Id, data.Name, data.Value, data.Disabled)
-	utils.CheckErr(err)
-	_, err = res.LastInsertId()
-	utils.CheckErr(err)
+func UpdateUser(data types.UpdateUserDto) error {
+	if data.Name == "" {
+		return errors.New("用户名不能为空")
+	}
+	if userNameTaken(data.Name, int(data.Id)) {
+		return errors.New("用户名已存在")
+	}
+	// 密码留空表示只改用户名
+	hash := ""
+	if data.Password != "" {
+		var err error
+		if hash, err = utils.HashPassword(data.Password); err != nil {
+			return err
+		}
+	}
+	return database.Users.Update(int(data.Id), data.Name, "", hash)
 }
 
-func UpdateUser(data types.UpdateUserDto) {
-	sql_update_user := `
-		UPDATE nav_user
-		SET name = ?, password = ?
-		WHERE id = ?;
-		`
-	stmt, err := database.DB.Prepare(sql_update_user)
-	utils.CheckErr(err)
-	res, err := stmt.Exec(data.Name, data.Password, data.Id)
-	utils.CheckErr(err)
-	_, err = res.RowsAffected()
+// 把明文密码升级成哈希，登录成功时调用
+func UpgradeUserPassword(id int, password string) {
+	hash, err := utils.HashPassword(password)
+	if err != nil {
+		utils.CheckErr(err)
+		return
+	}
+	err = database.Users.SetPassword(id, hash)
 	utils.CheckErr(err)
 }
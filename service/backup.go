@@ -0,0 +1,362 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ziren926/van-nav/database"
+	"github.com/ziren926/van-nav/logger"
+	"github.com/ziren926/van-nav/types"
+	"github.com/ziren926/van-nav/utils"
+)
+
+// 备份配置，启动时按命令行参数设置
+var (
+	// 备份文件放在哪，为空时用数据目录下的 backups
+	BackupDir = ""
+	// 定时备份的规则，为 nil 时不定时备份
+	BackupSchedule *utils.Cron
+	// 定时备份按天和按周各保留几份，手动备份和恢复前的备份不会自动删除
+	BackupKeepDaily  = 7
+	BackupKeepWeekly = 4
+	// 不为空时备份文件用这个口令加密
+	BackupPassphrase = ""
+)
+
+// 备份文件名里有时间和来源，加密的多一个 .enc 后缀
+const backupTimeLayout = "20060102-150405"
+
+var backupNamePattern = regexp.MustCompile(`^nav-(\d{8}-\d{6})-(auto|manual|prerestore)\.db(\.enc)?$`)
+
+var ErrBackupNotFound = errors.New("备份不存在")
+
+// 同一时间只做一件事，定时备份不能和恢复撞在一起
+var backupMu sync.Mutex
+
+func backupDir() string {
+	if BackupDir != "" {
+		return BackupDir
+	}
+	return filepath.Join(database.DataDir, "backups")
+}
+
+func parseBackupName(name string) (types.Backup, bool) {
+	match := backupNamePattern.FindStringSubmatch(name)
+	if match == nil {
+		return types.Backup{}, false
+	}
+	createdAt, err := time.ParseInLocation(backupTimeLayout, match[1], time.Local)
+	if err != nil {
+		return types.Backup{}, false
+	}
+	return types.Backup{Name: name, Kind: match[2], Encrypted: match[3] != "", CreatedAt: createdAt}, true
+}
+
+// GetBackups 备份目录里所有的备份，新的在前
+func GetBackups() ([]types.Backup, error) {
+	entries, err := os.ReadDir(backupDir())
+	if os.IsNotExist(err) {
+		return []types.Backup{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	backups := make([]types.Backup, 0)
+	for _, entry := range entries {
+		backup, ok := parseBackupName(entry.Name())
+		if !ok || entry.IsDir() {
+			continue
+		}
+		if info, err := entry.Info(); err == nil {
+			backup.Size = info.Size()
+		}
+		backups = append(backups, backup)
+	}
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].CreatedAt.After(backups[j].CreatedAt)
+	})
+	return backups, nil
+}
+
+// BackupPath 备份文件的完整路径。只认备份的文件名，防止用 ../ 读到别的文件
+func BackupPath(name string) (string, error) {
+	if _, ok := parseBackupName(name); !ok {
+		return "", ErrBackupNotFound
+	}
+	path := filepath.Join(backupDir(), name)
+	if _, err := os.Stat(path); err != nil {
+		return "", ErrBackupNotFound
+	}
+	return path, nil
+}
+
+// CreateBackup 备份当前数据库，kind 见 types.Backup*
+func CreateBackup(kind string) (types.Backup, error) {
+	backupMu.Lock()
+	defer backupMu.Unlock()
+	return createBackup(kind)
+}
+
+func createBackup(kind string) (types.Backup, error) {
+	dir := backupDir()
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return types.Backup{}, err
+	}
+	now := time.Now()
+	name := fmt.Sprintf("nav-%s-%s.db", now.Format(backupTimeLayout), kind)
+	if BackupPassphrase != "" {
+		name += ".enc"
+	}
+	path := filepath.Join(dir, name)
+	if _, err := os.Stat(path); err == nil {
+		return types.Backup{}, fmt.Errorf("备份 %s 已经存在，请稍后再试", name)
+	}
+
+	// 先备份到临时文件，加密或者改名之后才算完成，列表里不会出现不完整的备份
+	temp := filepath.Join(dir, "."+name+".tmp")
+	os.Remove(temp)
+	defer os.Remove(temp)
+	if err := database.BackupTo(temp); err != nil {
+		return types.Backup{}, err
+	}
+	if BackupPassphrase != "" {
+		if err := encryptFile(temp, path); err != nil {
+			os.Remove(path)
+			return types.Backup{}, err
+		}
+	} else if err := os.Rename(temp, path); err != nil {
+		return types.Backup{}, err
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		return types.Backup{}, err
+	}
+
+	backup, _ := parseBackupName(name)
+	if info, err := os.Stat(path); err == nil {
+		backup.Size = info.Size()
+	}
+	logger.LogInfo("已备份数据库: %s", name)
+	go pushBackup(backup)
+	return backup, nil
+}
+
+func encryptFile(src string, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if err = utils.EncryptStream(out, in, BackupPassphrase); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+func decryptFile(src string, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if err = utils.DecryptStream(out, in, BackupPassphrase); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// RestoreBackup 用备份替换当前数据库。恢复前先把当前数据库备份一份，返回这份备份。
+// 恢复后所有登录会话都会吊销，要重新登录
+func RestoreBackup(name string) (types.Backup, error) {
+	backupMu.Lock()
+	defer backupMu.Unlock()
+	path, err := BackupPath(name)
+	if err != nil {
+		return types.Backup{}, err
+	}
+	backup, _ := parseBackupName(name)
+
+	source := path
+	if backup.Encrypted {
+		if BackupPassphrase == "" {
+			return types.Backup{}, errors.New("这个备份是加密的，请配置 backup.passphrase（VAN_NAV_BACKUP_PASSPHRASE）后重启")
+		}
+		source = filepath.Join(backupDir(), "."+name+".restore")
+		defer os.Remove(source)
+		if err = decryptFile(path, source); err != nil {
+			return types.Backup{}, err
+		}
+	}
+	// 先检查一遍，备份不能用的话就不用做恢复前的备份了
+	if _, err = database.CheckBackup(source); err != nil {
+		return types.Backup{}, err
+	}
+	revoked, err := collectRevoked()
+	if err != nil {
+		return types.Backup{}, err
+	}
+	snapshot, err := createBackup(types.BackupPreRestore)
+	if err != nil {
+		return types.Backup{}, fmt.Errorf("恢复前备份当前数据库失败: %s", err)
+	}
+	if err = database.RestoreFrom(source); err != nil {
+		return snapshot, fmt.Errorf("恢复失败，恢复前的数据库已经备份为 %s: %s", snapshot.Name, err)
+	}
+	// 恢复的库里可能没有用户，要重新判断是否需要初始化
+	atomic.StoreInt32(&setupDone, 0)
+	logger.LogInfo("已从 %s 恢复数据库，恢复前的数据库备份为 %s", name, snapshot.Name)
+	if err = revoked.apply(time.Now()); err != nil {
+		return snapshot, fmt.Errorf("已经恢复，但是吊销恢复前的会话、token 和分享链接失败: %s", err)
+	}
+	return snapshot, nil
+}
+
+// 备份之后才删除的 token 和吊销的分享链接，恢复后会重新生效，恢复前先记下来
+type revokedBeforeRestore struct {
+	tokenHashes []string
+	shareIds    []string
+}
+
+func collectRevoked() (revokedBeforeRestore, error) {
+	var revoked revokedBeforeRestore
+	var err error
+	if revoked.tokenHashes, err = database.Tokens.DisabledHashes(); err != nil {
+		return revoked, err
+	}
+	shares, err := database.Shares.GetAll()
+	if err != nil {
+		return revoked, err
+	}
+	for _, share := range shares {
+		if share.RevokedAt != nil {
+			revoked.shareIds = append(revoked.shareIds, share.Id)
+		}
+	}
+	return revoked, nil
+}
+
+// 在恢复后的库里吊销恢复前签发的全部登录会话，大家都要重新登录；
+// 恢复前已经删除的 token 和吊销的分享链接也再删除、吊销一次。恢复后的库里没有的不用管
+func (revoked revokedBeforeRestore) apply(now time.Time) error {
+	sessions, err := database.Sessions.RevokeIssuedBefore(now, now)
+	if err != nil {
+		return err
+	}
+	for _, hash := range revoked.tokenHashes {
+		if err = database.Tokens.DisableByHash(hash); err != nil && err != database.ErrNotFound {
+			return err
+		}
+	}
+	for _, id := range revoked.shareIds {
+		if err = database.Shares.Revoke(id, now); err != nil && err != database.ErrNotFound {
+			return err
+		}
+	}
+	logger.LogInfo("恢复后已吊销 %d 个登录会话", sessions)
+	return nil
+}
+
+func DeleteBackup(name string) error {
+	backupMu.Lock()
+	defer backupMu.Unlock()
+	path, err := BackupPath(name)
+	if err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// PruneBackups 按保留规则删除本地多余的定时备份，返回删掉的文件名
+func PruneBackups() ([]string, error) {
+	backups, err := GetBackups()
+	if err != nil {
+		return nil, err
+	}
+	deleted := make([]string, 0)
+	for _, backup := range expiredBackups(backups) {
+		if err = os.Remove(filepath.Join(backupDir(), backup.Name)); err != nil {
+			return deleted, err
+		}
+		deleted = append(deleted, backup.Name)
+	}
+	return deleted, nil
+}
+
+// 按保留规则挑出要删除的定时备份：最近 BackupKeepDaily 天每天留最新的一份，
+// 最近 BackupKeepWeekly 周每周留最新的一份，最新的一份总是保留。backups 要按时间倒序
+func expiredBackups(backups []types.Backup) []types.Backup {
+	days := make(map[string]bool)
+	weeks := make(map[string]bool)
+	expired := make([]types.Backup, 0)
+	first := true
+	for _, backup := range backups {
+		if backup.Kind != types.BackupAuto {
+			continue
+		}
+		keep := first
+		first = false
+		day := backup.CreatedAt.Format("2006-01-02")
+		if !days[day] && len(days) < BackupKeepDaily {
+			days[day] = true
+			keep = true
+		}
+		year, week := backup.CreatedAt.ISOWeek()
+		weekKey := fmt.Sprintf("%d-%d", year, week)
+		if !weeks[weekKey] && len(weeks) < BackupKeepWeekly {
+			weeks[weekKey] = true
+			keep = true
+		}
+		if !keep {
+			expired = append(expired, backup)
+		}
+	}
+	return expired
+}
+
+// StartBackupSchedule 按 BackupSchedule 定时备份，备份完按保留规则清理
+func StartBackupSchedule() {
+	if BackupSchedule == nil {
+		return
+	}
+	if database.CurrentDialect != database.DialectSQLite {
+		logger.LogInfo("%s", database.ErrBackupUnsupported)
+		return
+	}
+	go func() {
+		for {
+			next := BackupSchedule.Next(time.Now())
+			if next.IsZero() {
+				logger.LogError("定时备份的规则永远不会触发，不再定时备份")
+				return
+			}
+			time.Sleep(time.Until(next))
+			if _, err := CreateBackup(types.BackupAuto); err != nil {
+				logger.LogError("定时备份失败: %s", err)
+				continue
+			}
+			deleted, err := PruneBackups()
+			if err != nil {
+				logger.LogError("清理旧备份失败: %s", err)
+			}
+			if len(deleted) > 0 {
+				logger.LogInfo("已删除 %d 份旧的定时备份", len(deleted))
+			}
+		}
+	}()
+}
@@ -0,0 +1,258 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ziren926/van-nav/config"
+	"github.com/ziren926/van-nav/logger"
+	"github.com/ziren926/van-nav/types"
+	"github.com/ziren926/van-nav/utils"
+)
+
+// 远程备份目标，备份文件按原来的文件名存
+type remoteBackupTarget interface {
+	name() string
+	location() string
+	upload(name string, path string) error
+	// 远程的所有文件名，不是备份的也可能在里面
+	list() ([]string, error)
+	remove(name string) error
+}
+
+type s3BackupTarget struct {
+	client *utils.S3Client
+	prefix string // 放在 bucket 里的哪个目录下，为空或者以 / 结尾
+}
+
+func (t s3BackupTarget) name() string {
+	return "s3"
+}
+
+func (t s3BackupTarget) location() string {
+	return t.client.Endpoint + " " + t.client.Bucket + "/" + t.prefix
+}
+
+func (t s3BackupTarget) upload(name string, path string) error {
+	return t.client.PutFile(t.prefix+name, path)
+}
+
+func (t s3BackupTarget) list() ([]string, error) {
+	keys, err := t.client.List(t.prefix)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(keys))
+	for _, key := range keys {
+		names = append(names, strings.TrimPrefix(key, t.prefix))
+	}
+	return names, nil
+}
+
+func (t s3BackupTarget) remove(name string) error {
+	return t.client.Delete(t.prefix + name)
+}
+
+type webdavBackupTarget struct {
+	client *utils.WebDAVClient
+}
+
+func (t webdavBackupTarget) name() string {
+	return "webdav"
+}
+
+// 地址里可能带着密码，不能显示出来
+func (t webdavBackupTarget) location() string {
+	if u, err := url.Parse(t.client.Url); err == nil {
+		return u.Redacted()
+	}
+	return t.client.Url
+}
+
+func (t webdavBackupTarget) upload(name string, path string) error {
+	return t.client.PutFile(name, path)
+}
+
+func (t webdavBackupTarget) list() ([]string, error) {
+	return t.client.List()
+}
+
+func (t webdavBackupTarget) remove(name string) error {
+	return t.client.Delete(name)
+}
+
+// 上传失败后重试的次数和第一次重试前等多久，之后每次加倍
+var (
+	remoteBackupRetries    = 3
+	remoteBackupRetryDelay = 2 * time.Second
+)
+
+var (
+	remoteBackupTargets []remoteBackupTarget
+	remoteBackupStatus  = make(map[string]*types.RemoteBackupStatus)
+	// 上传一个接一个来，状态也要加锁
+	remoteBackupMu       sync.Mutex
+	remoteBackupStatusMu sync.Mutex
+)
+
+// 按配置的 backup.s3 和 backup.webdav 设置远程目标，都没配置就只在本地备份
+func InitRemoteBackup(cfg config.BackupConfig) error {
+	targets := make([]remoteBackupTarget, 0)
+	if s3 := cfg.S3; s3.Endpoint != "" {
+		client := &utils.S3Client{
+			Endpoint:  s3.Endpoint,
+			Region:    s3.Region,
+			Bucket:    s3.Bucket,
+			AccessKey: s3.AccessKey,
+			SecretKey: s3.SecretKey,
+			PathStyle: !s3.VirtualHost,
+			Client:    &http.Client{Timeout: 10 * time.Minute},
+		}
+		if client.Bucket == "" || client.AccessKey == "" || client.SecretKey == "" {
+			return errors.New("备份到 S3 需要配置 backup.s3.bucket、backup.s3.accessKey 和 backup.s3.secretKey")
+		}
+		prefix := strings.Trim(s3.Prefix, "/")
+		if prefix != "" {
+			prefix += "/"
+		}
+		targets = append(targets, s3BackupTarget{client, prefix})
+	}
+	if dav := cfg.WebDAV; dav.Url != "" {
+		targets = append(targets, webdavBackupTarget{&utils.WebDAVClient{
+			Url:      dav.Url,
+			Username: dav.User,
+			Password: dav.Password,
+			Client:   &http.Client{Timeout: 10 * time.Minute},
+		}})
+	}
+	remoteBackupTargets = targets
+	for _, target := range targets {
+		remoteBackupStatus[target.name()] = &types.RemoteBackupStatus{Target: target.name(), Location: target.location()}
+		logger.LogInfo("备份会上传到 %s: %s", target.name(), target.location())
+	}
+	return nil
+}
+
+// GetRemoteBackupStatus 每个远程目标最近一次上传的情况
+func GetRemoteBackupStatus() []types.RemoteBackupStatus {
+	remoteBackupStatusMu.Lock()
+	defer remoteBackupStatusMu.Unlock()
+	statuses := make([]types.RemoteBackupStatus, 0, len(remoteBackupStatus))
+	for _, status := range remoteBackupStatus {
+		statuses = append(statuses, *status)
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Target < statuses[j].Target
+	})
+	return statuses
+}
+
+func updateRemoteBackupStatus(target string, update func(status *types.RemoteBackupStatus)) {
+	remoteBackupStatusMu.Lock()
+	defer remoteBackupStatusMu.Unlock()
+	if status, ok := remoteBackupStatus[target]; ok {
+		update(status)
+	}
+}
+
+// PushBackup 把一个本地备份上传到所有远程目标，在后台进行，结果看 GetRemoteBackupStatus
+func PushBackup(name string) error {
+	if len(remoteBackupTargets) == 0 {
+		return errors.New("没有配置远程备份")
+	}
+	if _, err := BackupPath(name); err != nil {
+		return err
+	}
+	backup, _ := parseBackupName(name)
+	go pushBackup(backup)
+	return nil
+}
+
+// 上传到每个目标，失败了按间隔重试，成功后按保留规则清理远程的定时备份
+func pushBackup(backup types.Backup) {
+	if len(remoteBackupTargets) == 0 {
+		return
+	}
+	remoteBackupMu.Lock()
+	defer remoteBackupMu.Unlock()
+	path := filepath.Join(backupDir(), backup.Name)
+	for _, target := range remoteBackupTargets {
+		err := uploadWithRetry(target, backup.Name, path)
+		now := time.Now()
+		if err != nil {
+			logger.LogError("上传备份 %s 到 %s 失败: %s", backup.Name, target.name(), err)
+			updateRemoteBackupStatus(target.name(), func(status *types.RemoteBackupStatus) {
+				status.LastErrorAt = &now
+				status.LastError = err.Error()
+			})
+			continue
+		}
+		logger.LogInfo("已上传备份 %s 到 %s", backup.Name, target.name())
+		pruned, err := pruneRemoteBackups(target)
+		updateRemoteBackupStatus(target.name(), func(status *types.RemoteBackupStatus) {
+			status.LastSuccessAt = &now
+			status.LastBackup = backup.Name
+			status.Pruned = pruned
+			if err != nil {
+				status.LastErrorAt = &now
+				status.LastError = "清理远程备份失败: " + err.Error()
+			}
+		})
+		if err != nil {
+			logger.LogError("清理 %s 上的旧备份失败: %s", target.name(), err)
+		}
+	}
+}
+
+func uploadWithRetry(target remoteBackupTarget, name string, path string) error {
+	delay := remoteBackupRetryDelay
+	var err error
+	for attempt := 0; attempt <= remoteBackupRetries; attempt++ {
+		if attempt > 0 {
+			logger.LogInfo("上传备份 %s 到 %s 失败，%s 后重试: %s", name, target.name(), delay, err)
+			time.Sleep(delay)
+			delay *= 2
+		}
+		// 上传前本地备份可能被删了，这时候不用重试
+		if _, statErr := os.Stat(path); statErr != nil {
+			return fmt.Errorf("本地备份已经不存在: %s", name)
+		}
+		if err = target.upload(name, path); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// 远程的定时备份按和本地一样的规则清理，不是 van-nav 备份的文件不动。返回删除的个数
+func pruneRemoteBackups(target remoteBackupTarget) (int, error) {
+	names, err := target.list()
+	if err != nil {
+		return 0, err
+	}
+	backups := make([]types.Backup, 0, len(names))
+	for _, name := range names {
+		if backup, ok := parseBackupName(name); ok {
+			backups = append(backups, backup)
+		}
+	}
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].CreatedAt.After(backups[j].CreatedAt)
+	})
+	pruned := 0
+	for _, backup := range expiredBackups(backups) {
+		if err = target.remove(backup.Name); err != nil {
+			return pruned, err
+		}
+		pruned++
+	}
+	return pruned, nil
+}
@@ -0,0 +1,194 @@
+package service
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/ziren926/van-nav/config"
+	"github.com/ziren926/van-nav/types"
+	"github.com/ziren926/van-nav/utils"
+	"golang.org/x/net/webdav"
+)
+
+// 内存里的远程目标，前 failUploads 次上传失败
+type fakeBackupTarget struct {
+	files       map[string]bool
+	failUploads int
+	uploads     int
+	removed     []string
+}
+
+func (t *fakeBackupTarget) name() string {
+	return "fake"
+}
+
+func (t *fakeBackupTarget) location() string {
+	return "memory"
+}
+
+func (t *fakeBackupTarget) upload(name string, path string) error {
+	t.uploads++
+	if t.uploads <= t.failUploads {
+		return errors.New("连接失败")
+	}
+	t.files[name] = true
+	return nil
+}
+
+func (t *fakeBackupTarget) list() ([]string, error) {
+	names := make([]string, 0, len(t.files))
+	for name := range t.files {
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+func (t *fakeBackupTarget) remove(name string) error {
+	delete(t.files, name)
+	t.removed = append(t.removed, name)
+	return nil
+}
+
+// 临时改保留规则，测试完恢复
+func setBackupRetention(t *testing.T, daily int, weekly int) {
+	oldDaily, oldWeekly := BackupKeepDaily, BackupKeepWeekly
+	BackupKeepDaily, BackupKeepWeekly = daily, weekly
+	t.Cleanup(func() { BackupKeepDaily, BackupKeepWeekly = oldDaily, oldWeekly })
+}
+
+// 2024-01-17 是星期三，15 号到 17 号在同一周，7 号在上上周
+var remoteBackupNames = []string{
+	"nav-20240117-100000-auto.db",
+	"nav-20240117-080000-auto.db",
+	"nav-20240116-100000-auto.db.enc",
+	"nav-20240115-100000-auto.db",
+	"nav-20240107-100000-auto.db",
+	"nav-20231201-100000-manual.db",
+	"nav-20231201-100000-prerestore.db",
+	"notes.txt",
+}
+
+var remoteBackupsExpired = []string{
+	"nav-20240107-100000-auto.db",
+	"nav-20240115-100000-auto.db",
+	"nav-20240117-080000-auto.db",
+}
+
+func TestPruneRemoteBackups(t *testing.T) {
+	setBackupRetention(t, 2, 1)
+	target := &fakeBackupTarget{files: make(map[string]bool)}
+	for _, name := range remoteBackupNames {
+		target.files[name] = true
+	}
+	pruned, err := pruneRemoteBackups(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(target.removed)
+	if pruned != len(remoteBackupsExpired) || !reflect.DeepEqual(target.removed, remoteBackupsExpired) {
+		t.Fatalf("删除了 %d 个: %v，应该是 %v", pruned, target.removed, remoteBackupsExpired)
+	}
+}
+
+// 本地备份目录里放一个备份，远程目标换成 targets
+func setupRemoteBackupTest(t *testing.T, name string, targets ...remoteBackupTarget) types.Backup {
+	BackupDir = t.TempDir()
+	t.Cleanup(func() { BackupDir = "" })
+	if err := os.WriteFile(filepath.Join(BackupDir, name), []byte("backup"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	oldDelay := remoteBackupRetryDelay
+	remoteBackupRetryDelay = time.Millisecond
+	t.Cleanup(func() {
+		remoteBackupRetryDelay = oldDelay
+		remoteBackupTargets = nil
+	})
+	remoteBackupTargets = targets
+	for _, target := range targets {
+		remoteBackupStatus[target.name()] = &types.RemoteBackupStatus{Target: target.name(), Location: target.location()}
+	}
+	backup, ok := parseBackupName(name)
+	if !ok {
+		t.Fatalf("不是备份的文件名: %s", name)
+	}
+	return backup
+}
+
+func remoteStatus(t *testing.T, target string) types.RemoteBackupStatus {
+	for _, status := range GetRemoteBackupStatus() {
+		if status.Target == target {
+			return status
+		}
+	}
+	t.Fatalf("没有 %s 的状态", target)
+	return types.RemoteBackupStatus{}
+}
+
+func TestPushBackupRetries(t *testing.T) {
+	target := &fakeBackupTarget{files: make(map[string]bool), failUploads: remoteBackupRetries}
+	backup := setupRemoteBackupTest(t, "nav-20240117-100000-manual.db", target)
+	pushBackup(backup)
+	status := remoteStatus(t, "fake")
+	if !target.files[backup.Name] || status.LastBackup != backup.Name || status.LastSuccessAt == nil || status.LastError != "" {
+		t.Fatalf("重试后应该上传成功，状态是 %+v", status)
+	}
+
+	// 重试都失败了才记录错误
+	target = &fakeBackupTarget{files: make(map[string]bool), failUploads: remoteBackupRetries + 1}
+	backup = setupRemoteBackupTest(t, "nav-20240117-100000-manual.db", target)
+	pushBackup(backup)
+	status = remoteStatus(t, "fake")
+	if target.uploads != remoteBackupRetries+1 || status.LastSuccessAt != nil || status.LastError == "" {
+		t.Fatalf("上传了 %d 次，状态是 %+v", target.uploads, status)
+	}
+}
+
+// 通过 WebDAV 上传一个新的定时备份，之后按保留规则清理远程的旧备份
+func TestPushBackupWebDAV(t *testing.T) {
+	setBackupRetention(t, 2, 1)
+	handler := &webdav.Handler{FileSystem: webdav.NewMemFS(), LockSystem: webdav.NewMemLS()}
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	err := InitRemoteBackup(config.BackupConfig{WebDAV: config.WebDAVConfig{Url: server.URL + "/backups/"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &utils.WebDAVClient{Url: server.URL + "/backups/", Client: http.DefaultClient}
+	backup := setupRemoteBackupTest(t, remoteBackupNames[0], remoteBackupTargets...)
+	// 远程已经有的旧备份
+	path := filepath.Join(BackupDir, backup.Name)
+	for _, name := range remoteBackupNames[1:] {
+		if err = client.PutFile(name, path); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	pushBackup(backup)
+	status := remoteStatus(t, "webdav")
+	if status.LastBackup != backup.Name || status.LastError != "" || status.Pruned != len(remoteBackupsExpired) {
+		t.Fatalf("上传后的状态是 %+v", status)
+	}
+	names, err := client.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := make([]string, 0)
+	for _, name := range remoteBackupNames {
+		if !utils.In(name, remoteBackupsExpired) {
+			want = append(want, name)
+		}
+	}
+	sort.Strings(names)
+	sort.Strings(want)
+	if !reflect.DeepEqual(names, want) {
+		t.Fatalf("清理后远程有 %v，应该是 %v", names, want)
+	}
+}
@@ -0,0 +1,65 @@
+package service
+
+import (
+	"sync/atomic"
+	"testing"
+
+	"github.com/ziren926/van-nav/database"
+	"github.com/ziren926/van-nav/types"
+)
+
+// 备份之后才删除的 token、吊销的分享链接和会话，恢复后都不能重新生效
+func TestRestoreBackupKeepsRevocations(t *testing.T) {
+	database.DataDir = t.TempDir()
+	database.DSN = ""
+	if err := database.InitDB(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { database.DB.Close() })
+	BackupDir = t.TempDir()
+	t.Cleanup(func() { BackupDir = "" })
+	atomic.StoreInt32(&setupDone, 0)
+
+	admin, err := database.Users.Add(types.User{Name: "admin", Password: "x", Role: types.RoleOwner})
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwt, err := CreateSession(types.User{Id: admin, Name: "admin", Role: types.RoleOwner}, "127.0.0.1", "test")
+	if err != nil || jwt == "" {
+		t.Fatalf("创建会话失败: %v", err)
+	}
+	token, err := AddApiToken(types.AddTokenDto{Name: "ci", Scopes: []string{types.ScopeRead}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	catelogId, err := database.Catelogs.Add(types.Catelog{Name: "隐藏", Hide: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	share, err := AddShare(types.AddShareDto{Type: types.ShareCatelog, TargetId: catelogId}, "admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	backup, err := CreateBackup(types.BackupManual)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	DeleteApiToken(token.Id)
+	if _, err = RevokeShare(share.Id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = RestoreBackup(backup.Name); err != nil {
+		t.Fatal(err)
+	}
+
+	if sessions := GetActiveSessions(admin); len(sessions) != 0 {
+		t.Fatalf("恢复后还有会话 %+v", sessions)
+	}
+	if tokens := GetApiTokens(); len(tokens) != 0 {
+		t.Fatalf("恢复后删除过的 token 又出现了 %+v", tokens)
+	}
+	if restored, ok := GetShareById(share.Id); !ok || restored.RevokedAt == nil {
+		t.Fatalf("恢复后分享链接是 %+v", restored)
+	}
+}
@@ -0,0 +1,82 @@
+package service
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+
+	"github.com/ziren926/van-nav/types"
+	"github.com/ziren926/van-nav/utils"
+)
+
+// 不在文件夹里的书签默认放进的分类
+const defaultBookmarkCatelog = "书签"
+
+// ImportBookmarks 导入浏览器导出的书签文件，文件夹按 bookmarkOptions 对应到分类，
+// 之后和 ImportTools 一样去重和导入。书签里带的图标直接存进图片缓存。
+// 除了报告，还返回忽略了几个不是网址的书签，比如 javascript: 和 place:
+func ImportBookmarks(r io.Reader, bookmarkOptions types.BookmarkOptions, options types.ImportOptions, by string) (types.ImportReport, int, error) {
+	switch bookmarkOptions.Folders {
+	case "":
+		bookmarkOptions.Folders = types.BookmarkFoldersFlatten
+	case types.BookmarkFoldersFlatten, types.BookmarkFoldersPrefix, types.BookmarkFoldersNone:
+	default:
+		return types.ImportReport{}, 0, fmt.Errorf("不支持的文件夹处理方式: %s，可选 flatten、prefix、none", bookmarkOptions.Folders)
+	}
+	if bookmarkOptions.Catelog == "" {
+		bookmarkOptions.Catelog = defaultBookmarkCatelog
+	}
+	bookmarks, err := utils.ParseBookmarks(r)
+	if err != nil {
+		return types.ImportReport{}, 0, err
+	}
+	tools := make([]types.Tool, 0, len(bookmarks))
+	icons := make([]string, 0, len(bookmarks))
+	ignored := 0
+	for _, bookmark := range bookmarks {
+		u, err := url.Parse(bookmark.Url)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			ignored++
+			continue
+		}
+		tool := types.Tool{
+			Name:          bookmark.Name,
+			Url:           bookmark.Url,
+			Desc:          bookmark.Desc,
+			Catelog:       bookmarkCatelog(bookmark.Folders, bookmarkOptions),
+			PostCreatedAt: bookmark.AddDate,
+			PostUpdatedAt: bookmark.Modified,
+		}
+		if tool.Name == "" {
+			tool.Name = u.Host
+		}
+		tool.Logo = bookmarkLogo(u, bookmark)
+		tools = append(tools, tool)
+		icons = append(icons, bookmark.Icon)
+	}
+	report, err := importTools(tools, icons, options, by)
+	return report, ignored, err
+}
+
+func bookmarkCatelog(folders []string, options types.BookmarkOptions) string {
+	if len(folders) == 0 || options.Folders == types.BookmarkFoldersNone {
+		return options.Catelog
+	}
+	if options.Folders == types.BookmarkFoldersPrefix {
+		return strings.Join(folders, "/")
+	}
+	return folders[0]
+}
+
+// 图标缓存按图标地址存。Firefox 带了原来的地址就用它，
+// 只有图标内容时用网站的 /favicon.ico 当地址，同一个网站的书签共用一个。都没有时留空，可以在后台重新获取
+func bookmarkLogo(u *url.URL, bookmark utils.Bookmark) string {
+	if strings.HasPrefix(bookmark.IconUri, "http://") || strings.HasPrefix(bookmark.IconUri, "https://") {
+		return bookmark.IconUri
+	}
+	if bookmark.Icon != "" {
+		return u.Scheme + "://" + u.Host + "/favicon.ico"
+	}
+	return ""
+}
@@ -1,90 +1,83 @@
 package service
 
 import (
+	"fmt"
+	"time"
+
 	"github.com/ziren926/van-nav/database"
 	"github.com/ziren926/van-nav/types"
 	"github.com/ziren926/van-nav/utils"
 )
 
 func UpdateCatelog(data types.UpdateCatelogDto) {
-
-	// 查询分类原名称
-	sql_select_old_catelog_name := `select name from nav_catelog where id = ?;`
-	var oldName string
-	err := database.DB.QueryRow(sql_select_old_catelog_name, data.Id).Scan(&oldName)
-	utils.CheckErr(err)
-
-	// 开启事务
-	tx, err := database.DB.Begin()
-	utils.CheckErr(err)
-
-	// 更新分类新名称
-	sql_update_catelog := `
-		UPDATE nav_catelog
-		SET name = ?, sort = ?, hide = ?
-		WHERE id = ?;
-		`
-	stmt, err := tx.Prepare(sql_update_catelog)
-	utils.CheckTxErr(err, tx)
-	res, err := stmt.Exec(data.Name, data.Sort, data.Hide, data.Id)
-	utils.CheckTxErr(err, tx)
-	_, err = res.RowsAffected()
-	utils.CheckTxErr(err, tx)
-
-	if oldName != data.Name {
-		// 更新工具分类新名称
-		sql_update_tools := `
-		UPDATE nav_table
-		SET catelog = ?
-		WHERE catelog = ?;
-		`
-		stmt2, err := tx.Prepare(sql_update_tools)
-		utils.CheckTxErr(err, tx)
-		res2, err := stmt2.Exec(data.Name, oldName)
-		utils.CheckTxErr(err, tx)
-		_, err = res2.RowsAffected()
-		utils.CheckTxErr(err, tx)
-	}
-	// 提交事务
-	err = tx.Commit()
+	// 工具按 id 关联分类，改名不用动工具
+	err := database.Catelogs.Update(types.Catelog{Id: data.Id, Name: data.Name, Sort: data.Sort, Hide: data.Hide})
 	utils.CheckErr(err)
 }
 
 func AddCatelog(data types.AddCatelogDto) {
 	// 先检查重复不重复
-	existCatelogs := GetAllCatelog()
-	var existCatelogsArr []string
-	for _, catelogDto := range existCatelogs {
-		existCatelogsArr = append(existCatelogsArr, catelogDto.Name)
-	}
-	if utils.In(data.Name, existCatelogsArr) {
+	if catelogExists(data.Name) {
 		return
 	}
-	sql_add_catelog := `
-		INSERT INTO nav_catelog (name,sort,hide)
-		VALUES (?,?,?);
-		`
-	stmt, err := database.DB.Prepare(sql_add_catelog)
-	utils.CheckErr(err)
-	res, err := stmt.Exec(data.Name, data.Sort, data.Hide)
-	utils.CheckErr(err)
-	_, err = res.LastInsertId()
+	_, err := database.Catelogs.Add(types.Catelog{Name: data.Name, Sort: data.Sort, Hide: data.Hide})
 	utils.CheckErr(err)
 }
 
+// DeleteCatelog 把分类放进回收站，mode 为空时按 reject 处理。返回移走或删掉的工具数，分类不存在时返回 database.ErrNotFound
+func DeleteCatelog(id int, mode string, target int) (int64, error) {
+	switch mode {
+	case "", types.CatelogDeleteReject:
+		mode = types.CatelogDeleteReject
+	case types.CatelogDeleteMove:
+		if target == id {
+			return 0, fmt.Errorf("不能把工具移到要删除的分类")
+		}
+		if _, ok := GetCatelogById(target); !ok {
+			return 0, fmt.Errorf("目标分类不存在")
+		}
+	case types.CatelogDeleteCascade:
+	default:
+		return 0, fmt.Errorf("不支持的删除方式: %s", mode)
+	}
+	return database.Catelogs.Delete(id, mode, target, time.Now())
+}
+
 func GetAllCatelog() []types.Catelog {
-	sql_get_all := `
-		SELECT id,name,sort,hide FROM nav_catelog order by sort;
-	`
-	results := make([]types.Catelog, 0)
-	rows, err := database.DB.Query(sql_get_all)
-	utils.CheckErr(err)
-	for rows.Next() {
-		var catelog types.Catelog
-		err = rows.Scan(&catelog.Id, &catelog.Name, &catelog.Sort, &catelog.Hide)
+	results, err := database.Catelogs.GetAll()
+	if err != nil {
 		utils.CheckErr(err)
-		results = append(results, catelog)
+		return make([]types.Catelog, 0)
 	}
-	defer rows.Close()
 	return results
 }
+
+// GetCatelogIdByName 按名称找分类的 id，找不到时返回 false
+func GetCatelogIdByName(name string) (int, bool) {
+	catelog, err := database.Catelogs.GetByName(name)
+	if err != nil && err != database.ErrNotFound {
+		utils.CheckErr(err)
+	}
+	return catelog.Id, err == nil
+}
+
+// 工具里的分类名称换成 id，名称为空表示没有分类
+func catelogIdByName(name string) (int, error) {
+	if name == "" {
+		return 0, nil
+	}
+	catelog, err := database.Catelogs.GetByName(name)
+	if err == database.ErrNotFound {
+		return 0, fmt.Errorf("分类 %s 不存在", name)
+	}
+	return catelog.Id, err
+}
+
+func GetCatelogById(id int) (types.Catelog, bool) {
+	for _, catelog := range GetAllCatelog() {
+		if catelog.Id == id {
+			return catelog, true
+		}
+	}
+	return types.Catelog{}, false
+}
@@ -0,0 +1,163 @@
+package service
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+
+	"github.com/ziren926/van-nav/database"
+	"github.com/ziren926/van-nav/logger"
+	"github.com/ziren926/van-nav/types"
+)
+
+// RunDoctor 检查数据库：版本、表结构、工具和分类的对应关系、没用的图标缓存和数据库文件本身。
+// fix 时修复能自动修复的问题，只增不删，图标缓存除外
+func RunDoctor(fix bool) types.DoctorReport {
+	report := types.DoctorReport{
+		LatestVersion: database.LatestSchemaVersion(),
+		Issues:        make([]types.DoctorIssue, 0),
+	}
+	version, dirty, err := database.SchemaVersion()
+	if err != nil {
+		report.Issues = append(report.Issues, checkFailed(types.DoctorSchemaVersion, err))
+		return finishReport(report)
+	}
+	report.SchemaVersion = version
+	report.Dirty = dirty
+	if dirty {
+		report.Issues = append(report.Issues, types.DoctorIssue{
+			Kind:    types.DoctorSchemaVersion,
+			Target:  strconv.Itoa(version),
+			Message: "数据库迁移没有完成，检查后用 van-nav migrate force <版本号> 标记实际的版本",
+		})
+	} else if version < report.LatestVersion {
+		report.Issues = append(report.Issues, types.DoctorIssue{
+			Kind:    types.DoctorSchemaVersion,
+			Target:  strconv.Itoa(version),
+			Message: fmt.Sprintf("还有迁移没有执行，用 van-nav migrate up 升级到版本 %d", report.LatestVersion),
+		})
+	}
+
+	// 表结构不对的话后面的检查查不了，先修表结构
+	schemaIssues, err := database.CheckSchema(version, fix)
+	if err != nil {
+		report.Issues = append(report.Issues, checkFailed(types.DoctorMissingTable, err))
+	}
+	report.Issues = append(report.Issues, schemaIssues...)
+
+	orphanIssues, err := checkOrphanTools(fix)
+	if err != nil {
+		report.Issues = append(report.Issues, checkFailed(types.DoctorOrphanTool, err))
+	}
+	report.Issues = append(report.Issues, orphanIssues...)
+
+	imgIssues, err := checkDanglingImgs(fix)
+	if err != nil {
+		report.Issues = append(report.Issues, checkFailed(types.DoctorDanglingImg, err))
+	}
+	report.Issues = append(report.Issues, imgIssues...)
+
+	integrityIssues, err := database.CheckIntegrity()
+	if err != nil {
+		report.Issues = append(report.Issues, checkFailed(types.DoctorIntegrity, err))
+	}
+	report.Issues = append(report.Issues, integrityIssues...)
+	return finishReport(report)
+}
+
+func finishReport(report types.DoctorReport) types.DoctorReport {
+	report.Healthy = true
+	for _, issue := range report.Issues {
+		if !issue.Fixed {
+			report.Healthy = false
+		}
+	}
+	return report
+}
+
+// 检查本身失败了，也当成一个问题报告出来
+func checkFailed(kind string, err error) types.DoctorIssue {
+	return types.DoctorIssue{Kind: kind, Message: "检查失败: " + err.Error()}
+}
+
+// 修复时没有分类的工具都移到这个分类
+const doctorOrphanCatelog = "未分类"
+
+// 没有分类、分类 id 不存在或者分类在回收站里的工具，外键没有生效时（比如自己指定了 SQLite 的连接参数）会出现。
+// 修复时移到“未分类”，这个分类不存在时新建一个隐藏的，免得把原来看不到的工具露出来
+func checkOrphanTools(fix bool) ([]types.DoctorIssue, error) {
+	tools, err := database.Doctor.OrphanTools()
+	if err != nil {
+		return nil, err
+	}
+	issues := make([]types.DoctorIssue, 0, len(tools))
+	for _, tool := range tools {
+		issue := types.DoctorIssue{
+			Kind:    types.DoctorOrphanTool,
+			Target:  strconv.FormatInt(tool.Id, 10),
+			Message: fmt.Sprintf("工具 %d（%s）的分类 %d 不存在或在回收站里", tool.Id, tool.Name, tool.CatelogId),
+			Fixable: true,
+		}
+		if tool.CatelogId == 0 {
+			issue.Message = fmt.Sprintf("工具 %d（%s）没有分类", tool.Id, tool.Name)
+		}
+		issues = append(issues, issue)
+	}
+	if !fix || len(issues) == 0 {
+		return issues, nil
+	}
+
+	catelog, err := database.Catelogs.GetByName(doctorOrphanCatelog)
+	if err == database.ErrNotFound {
+		catelog = types.Catelog{Name: doctorOrphanCatelog, Hide: true}
+		catelog.Id, err = database.Catelogs.Add(catelog)
+		if err == nil {
+			logger.LogInfo("已新建分类 %s（隐藏）", doctorOrphanCatelog)
+		}
+	}
+	// 分类建不出来的话这些工具都修不了
+	for i, tool := range tools {
+		if err != nil {
+			issues[i].FixError = err.Error()
+			continue
+		}
+		if moveErr := database.Doctor.MoveTool(tool.Id, catelog.Id); moveErr != nil {
+			issues[i].FixError = moveErr.Error()
+			continue
+		}
+		issues[i].Fixed = true
+	}
+	return issues, nil
+}
+
+// 没有工具在用的图标缓存。nav_img 里的 url 是转义过的 logo 地址
+func checkDanglingImgs(fix bool) ([]types.DoctorIssue, error) {
+	imgs, err := database.Doctor.DanglingImgs()
+	if err != nil {
+		return nil, err
+	}
+	issues := make([]types.DoctorIssue, 0, len(imgs))
+	for _, img := range imgs {
+		// 存的是转义过的，展示的时候还原一下
+		display, err := url.QueryUnescape(img.Url)
+		if err != nil {
+			display = img.Url
+		}
+		issues = append(issues, types.DoctorIssue{
+			Kind:    types.DoctorDanglingImg,
+			Target:  strconv.Itoa(img.Id),
+			Message: "图标缓存没有工具在用: " + display,
+			Fixable: true,
+		})
+	}
+	if fix {
+		for i, img := range imgs {
+			if err := database.Doctor.DeleteImg(img.Id); err != nil {
+				issues[i].FixError = err.Error()
+				continue
+			}
+			issues[i].Fixed = true
+		}
+	}
+	return issues, nil
+}
@@ -0,0 +1,117 @@
+package service
+
+import (
+	"errors"
+
+	"github.com/ziren926/van-nav/config"
+	"github.com/ziren926/van-nav/database"
+	"github.com/ziren926/van-nav/logger"
+	"github.com/ziren926/van-nav/types"
+	"github.com/ziren926/van-nav/utils"
+)
+
+// 单点登录和反向代理认证这类外部身份，按组映射成本地角色
+type GroupRoleMapping struct {
+	OwnerGroups  []string
+	EditorGroups []string
+	ViewerGroups []string
+	DefaultRole  string // 不在任何组里的用户给什么角色，为空表示不允许登录
+}
+
+var errNoPermission = errors.New("没有访问权限，请联系管理员")
+
+// name 是配置项的前缀，出错时提示用
+func loadGroupRoleMapping(name string, cfg config.GroupRoleConfig) (GroupRoleMapping, error) {
+	mapping := GroupRoleMapping{
+		OwnerGroups:  cfg.OwnerGroups,
+		EditorGroups: cfg.EditorGroups,
+		ViewerGroups: cfg.ViewerGroups,
+		DefaultRole:  cfg.DefaultRole,
+	}
+	if mapping.DefaultRole != "" && !IsValidRole(mapping.DefaultRole) {
+		return mapping, errors.New("无效的 " + name + ".defaultRole: " + mapping.DefaultRole)
+	}
+	return mapping, nil
+}
+
+// 配置了组映射时，每次登录都按组同步角色；没配置时已有用户保持原来的角色
+func (m GroupRoleMapping) Configured() bool {
+	return len(m.OwnerGroups)+len(m.EditorGroups)+len(m.ViewerGroups) > 0
+}
+
+// 按组映射角色，取最高的那个
+func (m GroupRoleMapping) Role(groups []string) string {
+	for _, mapping := range []struct {
+		role   string
+		groups []string
+	}{
+		{types.RoleOwner, m.OwnerGroups},
+		{types.RoleEditor, m.EditorGroups},
+		{types.RoleViewer, m.ViewerGroups},
+	} {
+		for _, group := range groups {
+			if utils.In(group, mapping.groups) {
+				return mapping.role
+			}
+		}
+	}
+	return m.DefaultRole
+}
+
+// 找到或创建外部身份对应的本地用户。
+// subject 不为空时只按已经关联的 subject 找，找不到就创建新用户，不按用户名关联已有的本地用户，
+// 关联要由登录着的用户自己发起（BeginOIDCLink）；subject 为空时按用户名找
+func externalUser(source string, subject string, name string, groups []string, mapping GroupRoleMapping) (types.User, error) {
+	role := mapping.Role(groups)
+	var user types.User
+	var err error
+	if subject != "" {
+		user, err = database.Users.GetBySubject(subject)
+		if err != nil && err != database.ErrNotFound {
+			return user, err
+		}
+	} else {
+		user, err = database.Users.GetByName(name)
+	}
+	if err != nil {
+		if role == "" {
+			return user, errNoPermission
+		}
+		// 第一个 owner 必须走初始化，不能由外部身份自动创建
+		if SetupRequired() {
+			return user, errSetupRequired
+		}
+		if userNameTaken(name, 0) {
+			if subject != "" {
+				return user, errors.New("用户名 " + name + " 已被本地账号使用，如果是你的账号，请先用原来的方式登录，再关联单点登录账号")
+			}
+			return user, errors.New("用户名 " + name + " 已被其他账号使用")
+		}
+		// 外部身份的用户没有可用的本地密码
+		password, err := utils.HashPassword(randomUrlString(32))
+		if err != nil {
+			return user, err
+		}
+		id, err := database.Users.Add(types.User{Name: name, Password: password, Role: role, OidcSubject: subject})
+		if err != nil {
+			return user, err
+		}
+		logger.LogInfo("%s自动创建用户 %s，角色 %s", source, name, role)
+		return types.User{Id: id, Name: name, Role: role}, nil
+	}
+	if !mapping.Configured() {
+		return user, nil
+	}
+	if role == "" {
+		return user, errNoPermission
+	}
+	// 按组同步角色，但不把最后一个 owner 降级
+	if user.Role != role && !(user.Role == types.RoleOwner && countOwners() <= 1) {
+		if err := database.Users.SetRole(user.Id, role); err != nil {
+			return user, err
+		}
+		logger.LogInfo("%s用户 %s 的角色改为 %s", source, name, role)
+		user.Role = role
+	}
+	return user, nil
+}
@@ -11,9 +11,12 @@ import (
 	"github.com/ziren926/van-nav/utils"
 )
 
+// 抓取网页时最多跟随几次跳转
+var ScraperMaxRedirect = 5
+
 func getIcon(url string) string {
 	logger.LogInfo("getIcon: %s", url)
-	s, err := goscraper.Scrape(url, 5)
+	s, err := goscraper.Scrape(url, ScraperMaxRedirect)
 	if err != nil {
 		logger.LogError("getIcon: %s", err)
 		return ""
@@ -46,19 +49,11 @@ func LazyFetchLogo(url string, id int64) {
 
 func GetImgFromDB(url1 string) types.Img {
 	urlEncoded := url.QueryEscape(url1)
-	sql_get_img := `
-		SELECT id,url,value FROM nav_img
-		WHERE url=?;
-		`
-	rows, err := database.DB.Query(sql_get_img, urlEncoded)
-	utils.CheckErr(err)
-	var result types.Img
-	var has bool = false
-	for rows.Next() {
-		err = rows.Scan(&result.Id, &result.Url, &result.Value)
+	result, err := database.Imgs.GetByUrl(urlEncoded)
+	if err != nil && err != database.ErrNotFound {
 		utils.CheckErr(err)
-		has = true
 	}
+	has := err == nil
 	if !has {
 		var nullImg string
 		l := strings.Split(url1, ".")
@@ -71,35 +66,25 @@ func GetImgFromDB(url1 string) types.Img {
 
 		return types.Img{Id: 0, Url: url1, Value: nullImg}
 	}
-
-	defer rows.Close()
 	return result
 }
 
 func UpdateImg(url1 string) {
 	// 除了更新工具本身之外，也要更新 img 表
 	// 先看有没有，有的话就不管了，没有的话就创建
-	urlEncoded := url.QueryEscape(url1)
 	base64ImgValue := utils.GetImgBase64FromUrl(url1)
 	if base64ImgValue == "" {
 		return
 	}
-	sql_get_img := `
-		SELECT * FROM nav_img
-		WHERE url = ?;
-		`
+	SaveImg(url1, base64ImgValue)
+}
 
-	rows, err := database.DB.Query(sql_get_img, urlEncoded)
-	utils.CheckErr(err)
-	defer rows.Close()
-	if !rows.Next() {
-		sql_add_img := `
-		INSERT INTO nav_img (url, value)
-		VALUES (?, ?);
-		`
-		stmt, err := database.DB.Prepare(sql_add_img)
-		utils.CheckErr(err)
-		_, err = stmt.Exec(urlEncoded, base64ImgValue)
-		utils.CheckErr(err)
+// SaveImg 把已经拿到的图标存进 img 表，不用再下载，已经有了就不管
+func SaveImg(url1 string, base64ImgValue string) {
+	urlEncoded := url.QueryEscape(url1)
+	_, err := database.Imgs.GetByUrl(urlEncoded)
+	if err == database.ErrNotFound {
+		err = database.Imgs.Add(urlEncoded, base64ImgValue)
 	}
+	utils.CheckErr(err)
 }
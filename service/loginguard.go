@@ -0,0 +1,178 @@
+package service
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ziren926/van-nav/types"
+)
+
+// 登录失败计数，只放在内存里，重启后清空。
+// 同一个 IP 或同一个用户名连续失败超过免费次数后，每次失败都要等待翻倍的时间，
+// 失败次数达到上限就锁定一段时间。IP 和用户名分开计数，攻击者换 IP 也绕不过用户名的限制
+type loginPolicy struct {
+	freeAttempts int // 不需要等待的失败次数
+	lockAttempts int // 达到这个次数直接锁定
+}
+
+var (
+	loginIpPolicy   = loginPolicy{freeAttempts: 10, lockAttempts: 50}
+	loginUserPolicy = loginPolicy{freeAttempts: 5, lockAttempts: 20}
+)
+
+const (
+	loginBaseDelay    = time.Second
+	loginLockDuration = time.Minute * 15
+	// 这么久没有再失败就清零
+	loginFailureWindow = time.Hour
+)
+
+type loginFailure struct {
+	count       int
+	lastFailure time.Time
+	blockedTill time.Time
+}
+
+type loginGuard struct {
+	mu        sync.Mutex
+	byIp      map[string]*loginFailure
+	byUser    map[string]*loginFailure
+	lastSweep time.Time
+}
+
+var loginFailures = &loginGuard{
+	byIp:   make(map[string]*loginFailure),
+	byUser: make(map[string]*loginFailure),
+}
+
+// 计算失败后需要等待多久
+func (p loginPolicy) delay(count int) time.Duration {
+	if count >= p.lockAttempts {
+		return loginLockDuration
+	}
+	if count <= p.freeAttempts {
+		return 0
+	}
+	delay := loginBaseDelay
+	for i := p.freeAttempts + 1; i < count && delay < loginLockDuration; i++ {
+		delay *= 2
+	}
+	if delay > loginLockDuration {
+		delay = loginLockDuration
+	}
+	return delay
+}
+
+func (g *loginGuard) sweep(now time.Time) {
+	if now.Sub(g.lastSweep) < time.Minute {
+		return
+	}
+	g.lastSweep = now
+	for _, records := range []map[string]*loginFailure{g.byIp, g.byUser} {
+		for key, record := range records {
+			if now.Sub(record.lastFailure) > loginFailureWindow && now.After(record.blockedTill) {
+				delete(records, key)
+			}
+		}
+	}
+}
+
+func (g *loginGuard) fail(records map[string]*loginFailure, key string, policy loginPolicy, now time.Time) {
+	record, ok := records[key]
+	if !ok || now.Sub(record.lastFailure) > loginFailureWindow {
+		record = &loginFailure{}
+		records[key] = record
+	}
+	record.count++
+	record.lastFailure = now
+	record.blockedTill = now.Add(policy.delay(record.count))
+}
+
+// 检查是否允许尝试登录，不允许时返回还要等多久
+func CheckLoginAllowed(ip string, name string) (time.Duration, bool) {
+	loginFailures.mu.Lock()
+	defer loginFailures.mu.Unlock()
+	now := time.Now()
+	var wait time.Duration
+	if record, ok := loginFailures.byIp[ip]; ok && now.Before(record.blockedTill) {
+		wait = record.blockedTill.Sub(now)
+	}
+	if record, ok := loginFailures.byUser[name]; ok && now.Before(record.blockedTill) && record.blockedTill.Sub(now) > wait {
+		wait = record.blockedTill.Sub(now)
+	}
+	return wait, wait == 0
+}
+
+// 记录一次登录失败，用户名不存在也要记，避免被用来探测用户名
+func RecordLoginFailure(ip string, name string) {
+	loginFailures.mu.Lock()
+	defer loginFailures.mu.Unlock()
+	now := time.Now()
+	loginFailures.sweep(now)
+	loginFailures.fail(loginFailures.byIp, ip, loginIpPolicy, now)
+	if name != "" {
+		loginFailures.fail(loginFailures.byUser, name, loginUserPolicy, now)
+	}
+}
+
+// 登录成功后清掉这个用户名的失败记录。IP 的记录留着自然过期，
+// 不然攻击者用自己的账号登录一次就能重置
+func RecordLoginSuccess(name string) {
+	loginFailures.mu.Lock()
+	defer loginFailures.mu.Unlock()
+	delete(loginFailures.byUser, name)
+}
+
+// 列出所有有失败记录的 IP 和用户名
+func GetLoginLockouts() []types.Lockout {
+	loginFailures.mu.Lock()
+	defer loginFailures.mu.Unlock()
+	now := time.Now()
+	loginFailures.sweep(now)
+	results := make([]types.Lockout, 0)
+	for kind, records := range map[string]map[string]*loginFailure{
+		types.LockoutIp:   loginFailures.byIp,
+		types.LockoutUser: loginFailures.byUser,
+	} {
+		for key, record := range records {
+			lockout := types.Lockout{
+				Type:        kind,
+				Key:         key,
+				Failures:    record.count,
+				LastFailure: record.lastFailure,
+			}
+			if now.Before(record.blockedTill) {
+				blockedTill := record.blockedTill
+				lockout.BlockedUntil = &blockedTill
+			}
+			results = append(results, lockout)
+		}
+	}
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].LastFailure.After(results[j].LastFailure)
+	})
+	return results
+}
+
+// 清除失败记录。kind 为空表示全部清除，key 为空表示清除这一类的全部记录。返回清除的条数
+func ClearLoginLockouts(kind string, key string) int {
+	loginFailures.mu.Lock()
+	defer loginFailures.mu.Unlock()
+	count := 0
+	for k, records := range map[string]map[string]*loginFailure{
+		types.LockoutIp:   loginFailures.byIp,
+		types.LockoutUser: loginFailures.byUser,
+	} {
+		if kind != "" && kind != k {
+			continue
+		}
+		for recordKey := range records {
+			if key == "" || key == recordKey {
+				delete(records, recordKey)
+				count++
+			}
+		}
+	}
+	return count
+}
@@ -0,0 +1,452 @@
+package service
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/ziren926/van-nav/config"
+	"github.com/ziren926/van-nav/database"
+	"github.com/ziren926/van-nav/logger"
+	"github.com/ziren926/van-nav/types"
+	"github.com/ziren926/van-nav/utils"
+)
+
+// OIDC 单点登录运行时的配置，InitOIDC 按 config.OIDCConfig 生成，来源是配置文件、环境变量和命令行参数
+type OIDCConfig struct {
+	Issuer        string
+	ClientId      string
+	ClientSecret  string
+	RedirectUrl   string // 回调地址，形如 https://nav.example.com/api/oidc/callback
+	Scopes        []string
+	UsernameClaim string
+	GroupsClaim   string
+	GroupRoleMapping
+	// 开启后只能用单点登录，api token 不受影响
+	DisablePasswordLogin bool
+}
+
+var oidcConfig *OIDCConfig
+
+// 按配置开启单点登录，没配置 oidc.issuer 就不开启
+func InitOIDC(cfg config.OIDCConfig) error {
+	if cfg.Issuer == "" {
+		if cfg.DisablePasswordLogin {
+			return errors.New("没有配置单点登录时不能关闭密码登录")
+		}
+		return nil
+	}
+	config := &OIDCConfig{
+		Issuer:               strings.TrimSuffix(cfg.Issuer, "/"),
+		ClientId:             cfg.ClientId,
+		ClientSecret:         cfg.ClientSecret,
+		RedirectUrl:          cfg.RedirectUrl,
+		Scopes:               append([]string{}, cfg.Scopes...),
+		UsernameClaim:        cfg.UsernameClaim,
+		GroupsClaim:          cfg.GroupsClaim,
+		DisablePasswordLogin: cfg.DisablePasswordLogin,
+	}
+	if config.ClientId == "" || config.RedirectUrl == "" {
+		return errors.New("开启单点登录需要配置 oidc.clientId 和 oidc.redirectUrl")
+	}
+	mapping, err := loadGroupRoleMapping("oidc", cfg.GroupRoleConfig)
+	if err != nil {
+		return err
+	}
+	config.GroupRoleMapping = mapping
+	if !utils.In("openid", config.Scopes) {
+		config.Scopes = append([]string{"openid"}, config.Scopes...)
+	}
+	oidcConfig = config
+	logger.LogInfo("已开启单点登录，issuer: %s", config.Issuer)
+	return nil
+}
+
+func OIDCEnabled() bool {
+	return oidcConfig != nil
+}
+
+func PasswordLoginDisabled() bool {
+	return oidcConfig != nil && oidcConfig.DisablePasswordLogin
+}
+
+// ---- 发现文档和签名公钥 ----
+
+type oidcDiscovery struct {
+	Issuer                string   `json:"issuer"`
+	AuthorizationEndpoint string   `json:"authorization_endpoint"`
+	TokenEndpoint         string   `json:"token_endpoint"`
+	JwksUri               string   `json:"jwks_uri"`
+	TokenAuthMethods      []string `json:"token_endpoint_auth_methods_supported"`
+}
+
+type oidcJWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+var (
+	oidcHttpClient = &http.Client{Timeout: time.Second * 10}
+
+	oidcMu           sync.Mutex
+	oidcProvider     *oidcDiscovery
+	oidcProviderTime time.Time
+	oidcKeys         map[string]*rsa.PublicKey
+	oidcKeysTime     time.Time
+)
+
+const oidcCacheTime = time.Hour
+
+func oidcGetJSON(url string, v interface{}) error {
+	res, err := oidcHttpClient.Get(url)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("请求 %s 失败: %s", url, res.Status)
+	}
+	return json.NewDecoder(res.Body).Decode(v)
+}
+
+// 发现文档缓存一小时，身份提供方暂时不可用时不影响启动
+func oidcDiscover() (*oidcDiscovery, error) {
+	oidcMu.Lock()
+	defer oidcMu.Unlock()
+	if oidcProvider != nil && time.Since(oidcProviderTime) < oidcCacheTime {
+		return oidcProvider, nil
+	}
+	var provider oidcDiscovery
+	if err := oidcGetJSON(oidcConfig.Issuer+"/.well-known/openid-configuration", &provider); err != nil {
+		return nil, err
+	}
+	if strings.TrimSuffix(provider.Issuer, "/") != oidcConfig.Issuer {
+		return nil, fmt.Errorf("发现文档里的 issuer %s 和配置不一致", provider.Issuer)
+	}
+	if provider.AuthorizationEndpoint == "" || provider.TokenEndpoint == "" || provider.JwksUri == "" {
+		return nil, errors.New("发现文档缺少必要的地址")
+	}
+	oidcProvider = &provider
+	oidcProviderTime = time.Now()
+	return oidcProvider, nil
+}
+
+func parseRSAJWK(key oidcJWK) (*rsa.PublicKey, error) {
+	n, err := base64.RawURLEncoding.DecodeString(key.N)
+	if err != nil {
+		return nil, err
+	}
+	e, err := base64.RawURLEncoding.DecodeString(key.E)
+	if err != nil {
+		return nil, err
+	}
+	if len(e) == 0 || len(e) > 4 {
+		return nil, errors.New("无效的公钥指数")
+	}
+	exponent := 0
+	for _, b := range e {
+		exponent = exponent<<8 | int(b)
+	}
+	return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: exponent}, nil
+}
+
+// 按 kid 找签名公钥。找不到时重新拉取一次，身份提供方轮换密钥后不用重启，但一分钟内最多拉一次
+func oidcKey(jwksUri string, kid string) (*rsa.PublicKey, error) {
+	oidcMu.Lock()
+	defer oidcMu.Unlock()
+	if key, ok := oidcKeys[kid]; ok && time.Since(oidcKeysTime) < oidcCacheTime {
+		return key, nil
+	}
+	if time.Since(oidcKeysTime) < time.Minute {
+		if key, ok := oidcKeys[kid]; ok {
+			return key, nil
+		}
+		return nil, fmt.Errorf("找不到签名公钥: %s", kid)
+	}
+	var jwks struct {
+		Keys []oidcJWK `json:"keys"`
+	}
+	if err := oidcGetJSON(jwksUri, &jwks); err != nil {
+		return nil, err
+	}
+	keys := make(map[string]*rsa.PublicKey)
+	for _, k := range jwks.Keys {
+		if k.Kty != "RSA" || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+		key, err := parseRSAJWK(k)
+		if err != nil {
+			logger.LogError("解析签名公钥 %s 失败: %s", k.Kid, err)
+			continue
+		}
+		keys[k.Kid] = key
+	}
+	oidcKeys = keys
+	oidcKeysTime = time.Now()
+	if key, ok := oidcKeys[kid]; ok {
+		return key, nil
+	}
+	// 只有一个公钥时 id token 里可能不带 kid
+	if kid == "" && len(oidcKeys) == 1 {
+		for _, key := range oidcKeys {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("找不到签名公钥: %s", kid)
+}
+
+// ---- 授权码 + PKCE ----
+
+type oidcPending struct {
+	nonce    string
+	verifier string
+	redirect string
+	// 不为 0 时是登录着的用户在关联自己的账号
+	linkUserId int
+	expiresAt  time.Time
+}
+
+var (
+	oidcPendingMu sync.Mutex
+	oidcPendings  = make(map[string]oidcPending)
+)
+
+const oidcPendingLifetime = time.Minute * 10
+
+func randomUrlString(size int) string {
+	bytes := make([]byte, size)
+	if _, err := rand.Read(bytes); err != nil {
+		panic(fmt.Sprintf("生成随机数失败: %v", err))
+	}
+	return base64.RawURLEncoding.EncodeToString(bytes)
+}
+
+// 只允许跳回本站的相对路径，防止被当成开放跳转
+func safeRedirect(redirect string) string {
+	if !strings.HasPrefix(redirect, "/") || strings.HasPrefix(redirect, "//") || strings.Contains(redirect, "\\") {
+		return "/admin"
+	}
+	return redirect
+}
+
+// 开始单点登录，返回身份提供方的授权地址和 state
+func BeginOIDCLogin(redirect string) (string, string, error) {
+	return beginOIDC(oidcPending{redirect: safeRedirect(redirect)})
+}
+
+// 登录着的用户把自己的账号关联到身份提供方的账号，之后可以用单点登录。流程和登录一样，回调时关联
+func BeginOIDCLink(userId int) (string, string, error) {
+	return beginOIDC(oidcPending{redirect: "/admin", linkUserId: userId})
+}
+
+func beginOIDC(pending oidcPending) (string, string, error) {
+	provider, err := oidcDiscover()
+	if err != nil {
+		return "", "", err
+	}
+	state := randomUrlString(24)
+	pending.nonce = randomUrlString(24)
+	pending.verifier = randomUrlString(32)
+	pending.expiresAt = time.Now().Add(oidcPendingLifetime)
+	oidcPendingMu.Lock()
+	now := time.Now()
+	for key, p := range oidcPendings {
+		if now.After(p.expiresAt) {
+			delete(oidcPendings, key)
+		}
+	}
+	oidcPendings[state] = pending
+	oidcPendingMu.Unlock()
+
+	challenge := sha256.Sum256([]byte(pending.verifier))
+	params := url.Values{}
+	params.Set("response_type", "code")
+	params.Set("client_id", oidcConfig.ClientId)
+	params.Set("redirect_uri", oidcConfig.RedirectUrl)
+	params.Set("scope", strings.Join(oidcConfig.Scopes, " "))
+	params.Set("state", state)
+	params.Set("nonce", pending.nonce)
+	params.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	params.Set("code_challenge_method", "S256")
+	sep := "?"
+	if strings.Contains(provider.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return provider.AuthorizationEndpoint + sep + params.Encode(), state, nil
+}
+
+// 用授权码换 id token
+func oidcExchange(provider *oidcDiscovery, code string, verifier string) (string, error) {
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", oidcConfig.RedirectUrl)
+	form.Set("client_id", oidcConfig.ClientId)
+	form.Set("code_verifier", verifier)
+	// 默认用 client_secret_basic，身份提供方只支持 post 时放到表单里
+	useBasic := oidcConfig.ClientSecret != "" &&
+		(len(provider.TokenAuthMethods) == 0 || utils.In("client_secret_basic", provider.TokenAuthMethods))
+	if oidcConfig.ClientSecret != "" && !useBasic {
+		form.Set("client_secret", oidcConfig.ClientSecret)
+	}
+	req, err := http.NewRequest("POST", provider.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if useBasic {
+		req.SetBasicAuth(url.QueryEscape(oidcConfig.ClientId), url.QueryEscape(oidcConfig.ClientSecret))
+	}
+	res, err := oidcHttpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return "", err
+	}
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("换取 token 失败: %s %s", res.Status, string(body))
+	}
+	var token struct {
+		IdToken string `json:"id_token"`
+	}
+	if err := json.Unmarshal(body, &token); err != nil {
+		return "", err
+	}
+	if token.IdToken == "" {
+		return "", errors.New("身份提供方没有返回 id_token")
+	}
+	return token.IdToken, nil
+}
+
+// 校验 id token 的签名、issuer、audience、有效期和 nonce
+func oidcVerifyIdToken(provider *oidcDiscovery, idToken string, nonce string) (jwt.MapClaims, error) {
+	parser := &jwt.Parser{ValidMethods: []string{"RS256"}}
+	token, err := parser.Parse(idToken, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return oidcKey(provider.JwksUri, kid)
+	})
+	if err != nil || !token.Valid {
+		return nil, fmt.Errorf("id token 无效: %v", err)
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, errors.New("id token 无效")
+	}
+	if iss, _ := claims["iss"].(string); strings.TrimSuffix(iss, "/") != oidcConfig.Issuer {
+		return nil, errors.New("id token 的 issuer 不匹配")
+	}
+	audiences := claimStrings(claims["aud"])
+	if !utils.In(oidcConfig.ClientId, audiences) {
+		return nil, errors.New("id token 的 audience 不匹配")
+	}
+	// 有 azp 时必须是自己，多个 audience 时必须有
+	azp, hasAzp := claims["azp"].(string)
+	if (hasAzp || len(audiences) > 1) && azp != oidcConfig.ClientId {
+		return nil, errors.New("id token 的 azp 不匹配")
+	}
+	if _, ok := claims["exp"]; !ok {
+		return nil, errors.New("id token 缺少过期时间")
+	}
+	if claims["nonce"] != nonce {
+		return nil, errors.New("id token 的 nonce 不匹配")
+	}
+	if sub, _ := claims["sub"].(string); sub == "" {
+		return nil, errors.New("id token 缺少 sub")
+	}
+	return claims, nil
+}
+
+// claim 可能是字符串，也可能是字符串数组
+func claimStrings(value interface{}) []string {
+	switch v := value.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		results := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				results = append(results, s)
+			}
+		}
+		return results
+	}
+	return []string{}
+}
+
+// 完成单点登录：校验 state，换 token，找到或创建本地用户。返回用户和登录后要跳转的地址
+func FinishOIDCLogin(state string, code string) (types.User, string, error) {
+	oidcPendingMu.Lock()
+	pending, ok := oidcPendings[state]
+	delete(oidcPendings, state)
+	oidcPendingMu.Unlock()
+	if !ok || time.Now().After(pending.expiresAt) {
+		return types.User{}, "", errors.New("登录已过期，请重试")
+	}
+	provider, err := oidcDiscover()
+	if err != nil {
+		return types.User{}, "", err
+	}
+	idToken, err := oidcExchange(provider, code, pending.verifier)
+	if err != nil {
+		return types.User{}, "", err
+	}
+	claims, err := oidcVerifyIdToken(provider, idToken, pending.nonce)
+	if err != nil {
+		return types.User{}, "", err
+	}
+	subject := oidcConfig.Issuer + "|" + claims["sub"].(string)
+	name, _ := claims[oidcConfig.UsernameClaim].(string)
+	if name == "" {
+		name = claims["sub"].(string)
+	}
+	if pending.linkUserId != 0 {
+		user, err := linkOIDC(pending.linkUserId, subject)
+		return user, pending.redirect, err
+	}
+	user, err := externalUser("单点登录", subject, name, claimStrings(claims[oidcConfig.GroupsClaim]), oidcConfig.GroupRoleMapping)
+	return user, pending.redirect, err
+}
+
+// 把外部身份关联到指定的本地用户。两边都只能关联一次，要换先取消关联
+func linkOIDC(userId int, subject string) (types.User, error) {
+	user, err := GetUserById(userId)
+	if err != nil {
+		return user, err
+	}
+	if err = database.Users.LinkSubject(userId, subject); err != nil {
+		if err == database.ErrSubjectLinked {
+			return user, errors.New("这个单点登录账号已经关联了其他用户，或者你已经关联过别的单点登录账号")
+		}
+		return user, err
+	}
+	logger.LogInfo("用户 %s 关联了单点登录账号 %s", user.Name, subject)
+	return user, nil
+}
+
+// 取消关联单点登录账号，自己取消或者 owner 帮忙取消
+func UnlinkOIDC(userId int) error {
+	err := database.Users.UnlinkSubject(userId)
+	if err == database.ErrNotFound {
+		return errors.New("用户不存在")
+	}
+	return err
+}
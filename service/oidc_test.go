@@ -0,0 +1,384 @@
+package service
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/ziren926/van-nav/config"
+	"github.com/ziren926/van-nav/database"
+	"github.com/ziren926/van-nav/types"
+)
+
+const (
+	testClientId     = "van-nav"
+	testClientSecret = "secret"
+	testRedirectUrl  = "https://nav.example.com/api/oidc/callback"
+)
+
+// 测试用的身份提供方：发现文档、签名公钥和 token 接口，授权页由 authorize 代替浏览器完成
+type testIssuer struct {
+	t      *testing.T
+	server *httptest.Server
+	key    *rsa.PrivateKey
+
+	mu    sync.Mutex
+	codes map[string]testCode
+}
+
+type testCode struct {
+	challenge string
+	claims    jwt.MapClaims
+}
+
+func newTestIssuer(t *testing.T) *testIssuer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuer := &testIssuer{t: t, key: key, codes: make(map[string]testCode)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(oidcDiscovery{
+			Issuer:                issuer.server.URL,
+			AuthorizationEndpoint: issuer.server.URL + "/authorize",
+			TokenEndpoint:         issuer.server.URL + "/token",
+			JwksUri:               issuer.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []oidcJWK{{
+			Kty: "RSA",
+			Kid: "k1",
+			Use: "sig",
+			N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", issuer.token)
+	issuer.server = httptest.NewServer(mux)
+	t.Cleanup(issuer.server.Close)
+	return issuer
+}
+
+// 校验客户端认证和 PKCE，返回签好名的 id token
+func (issuer *testIssuer) token(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if id, secret, ok := r.BasicAuth(); !ok || id != testClientId || secret != testClientSecret {
+		http.Error(w, `{"error":"invalid_client"}`, http.StatusUnauthorized)
+		return
+	}
+	if r.FormValue("grant_type") != "authorization_code" || r.FormValue("redirect_uri") != testRedirectUrl {
+		http.Error(w, `{"error":"invalid_request"}`, http.StatusBadRequest)
+		return
+	}
+	issuer.mu.Lock()
+	code, ok := issuer.codes[r.FormValue("code")]
+	delete(issuer.codes, r.FormValue("code"))
+	issuer.mu.Unlock()
+	verifier := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+	if !ok || base64.RawURLEncoding.EncodeToString(verifier[:]) != code.challenge {
+		http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"id_token": issuer.sign(code.claims)})
+}
+
+func (issuer *testIssuer) sign(claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = "k1"
+	signed, err := token.SignedString(issuer.key)
+	if err != nil {
+		issuer.t.Fatal(err)
+	}
+	return signed
+}
+
+func (issuer *testIssuer) claims(sub string, name string, nonce string) jwt.MapClaims {
+	return jwt.MapClaims{
+		"iss":                issuer.server.URL,
+		"aud":                testClientId,
+		"sub":                sub,
+		"exp":                time.Now().Add(time.Minute).Unix(),
+		"iat":                time.Now().Unix(),
+		"nonce":              nonce,
+		"preferred_username": name,
+	}
+}
+
+// 代替浏览器完成授权页：检查授权地址的参数，发一个授权码。
+// edit 可以在签名前改 claims，返回 state 和授权码
+func (issuer *testIssuer) authorize(authUrl string, sub string, name string, edit func(jwt.MapClaims)) (string, string) {
+	u, err := url.Parse(authUrl)
+	if err != nil {
+		issuer.t.Fatal(err)
+	}
+	if !strings.HasPrefix(authUrl, issuer.server.URL+"/authorize?") {
+		issuer.t.Fatalf("授权地址不对: %s", authUrl)
+	}
+	query := u.Query()
+	for key, want := range map[string]string{
+		"response_type":         "code",
+		"client_id":             testClientId,
+		"redirect_uri":          testRedirectUrl,
+		"code_challenge_method": "S256",
+	} {
+		if query.Get(key) != want {
+			issuer.t.Fatalf("授权地址的 %s 是 %q，应该是 %q", key, query.Get(key), want)
+		}
+	}
+	if !strings.Contains(query.Get("scope"), "openid") {
+		issuer.t.Fatalf("scope 里没有 openid: %s", query.Get("scope"))
+	}
+	claims := issuer.claims(sub, name, query.Get("nonce"))
+	if edit != nil {
+		edit(claims)
+	}
+	code := randomUrlString(16)
+	issuer.mu.Lock()
+	issuer.codes[code] = testCode{challenge: query.Get("code_challenge"), claims: claims}
+	issuer.mu.Unlock()
+	return query.Get("state"), code
+}
+
+func (issuer *testIssuer) config() config.OIDCConfig {
+	return config.OIDCConfig{
+		Issuer:          issuer.server.URL + "/",
+		ClientId:        testClientId,
+		ClientSecret:    testClientSecret,
+		RedirectUrl:     testRedirectUrl,
+		UsernameClaim:   "preferred_username",
+		GroupsClaim:     "groups",
+		GroupRoleConfig: config.GroupRoleConfig{DefaultRole: types.RoleViewer},
+	}
+}
+
+// 每个测试用新的数据库和身份提供方，清掉上一个测试缓存的发现文档和公钥
+// 数据库里有一个 owner：admin
+func setupOIDCTest(t *testing.T) *testIssuer {
+	database.DataDir = t.TempDir()
+	database.DSN = ""
+	if err := database.InitDB(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { database.DB.Close() })
+	atomic.StoreInt32(&setupDone, 0)
+	if _, err := database.Users.Add(types.User{Name: "admin", Password: "x", Role: types.RoleOwner}); err != nil {
+		t.Fatal(err)
+	}
+
+	issuer := newTestIssuer(t)
+	oidcMu.Lock()
+	oidcProvider, oidcKeys = nil, nil
+	oidcProviderTime, oidcKeysTime = time.Time{}, time.Time{}
+	oidcMu.Unlock()
+	if err := InitOIDC(issuer.config()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { oidcConfig = nil })
+	return issuer
+}
+
+func oidcLogin(t *testing.T, issuer *testIssuer, sub string, name string, edit func(jwt.MapClaims)) (types.User, error) {
+	authUrl, state, err := BeginOIDCLogin("/admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	gotState, code := issuer.authorize(authUrl, sub, name, edit)
+	if gotState != state {
+		t.Fatalf("授权地址里的 state 是 %q，应该是 %q", gotState, state)
+	}
+	user, redirect, err := FinishOIDCLogin(state, code)
+	if err == nil && redirect != "/admin" {
+		t.Errorf("登录后跳转到 %q，应该是 /admin", redirect)
+	}
+	return user, err
+}
+
+func TestOIDCLoginCreatesUser(t *testing.T) {
+	issuer := setupOIDCTest(t)
+	user, err := oidcLogin(t, issuer, "u1", "alice", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Name != "alice" || user.Role != types.RoleViewer {
+		t.Fatalf("创建的用户是 %+v", user)
+	}
+	// 身份提供方里改了用户名也还是同一个用户
+	again, err := oidcLogin(t, issuer, "u1", "alice2", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.Id != user.Id {
+		t.Fatalf("第二次登录是用户 %d，应该是 %d", again.Id, user.Id)
+	}
+}
+
+func TestOIDCDoesNotLinkByName(t *testing.T) {
+	issuer := setupOIDCTest(t)
+	if _, err := oidcLogin(t, issuer, "attacker", "admin", nil); err == nil {
+		t.Fatal("用户名和本地用户相同时不能登录成本地用户")
+	}
+	if _, err := database.Users.GetBySubject(issuer.server.URL + "|attacker"); err != database.ErrNotFound {
+		t.Fatalf("外部身份不应该被关联: %v", err)
+	}
+}
+
+func TestOIDCLink(t *testing.T) {
+	issuer := setupOIDCTest(t)
+	admin, err := database.Users.GetByName("admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	authUrl, state, err := BeginOIDCLink(admin.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, code := issuer.authorize(authUrl, "u1", "someone", nil)
+	user, _, err := FinishOIDCLogin(state, code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Id != admin.Id {
+		t.Fatalf("关联到了用户 %d，应该是 %d", user.Id, admin.Id)
+	}
+	user, err = oidcLogin(t, issuer, "u1", "someone", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Id != admin.Id || user.Role != types.RoleOwner {
+		t.Fatalf("关联后登录的是 %+v", user)
+	}
+
+	// 同一个外部身份不能再关联别的用户
+	other, err := oidcLogin(t, issuer, "u2", "bob", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authUrl, state, err = BeginOIDCLink(other.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, code = issuer.authorize(authUrl, "u1", "someone", nil)
+	if _, _, err = FinishOIDCLogin(state, code); err == nil {
+		t.Fatal("已经关联了别的用户的外部身份不能再关联")
+	}
+
+	// 取消关联后按新用户处理，用户名被占用就不能登录
+	if err = UnlinkOIDC(admin.Id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = oidcLogin(t, issuer, "u1", "admin", nil); err == nil {
+		t.Fatal("取消关联后不能再登录成原来的用户")
+	}
+}
+
+func TestOIDCRejectsInvalidIdToken(t *testing.T) {
+	issuer := setupOIDCTest(t)
+	for _, tc := range []struct {
+		name string
+		edit func(jwt.MapClaims)
+	}{
+		{"nonce 不匹配", func(c jwt.MapClaims) { c["nonce"] = "other" }},
+		{"没有 nonce", func(c jwt.MapClaims) { delete(c, "nonce") }},
+		{"aud 不是自己", func(c jwt.MapClaims) { c["aud"] = "other-client" }},
+		{"azp 不是自己", func(c jwt.MapClaims) { c["azp"] = "other-client" }},
+		{"多个 aud 没有 azp", func(c jwt.MapClaims) { c["aud"] = []string{testClientId, "other-client"} }},
+		{"多个 aud 的 azp 不是自己", func(c jwt.MapClaims) {
+			c["aud"] = []string{testClientId, "other-client"}
+			c["azp"] = "other-client"
+		}},
+		{"issuer 不匹配", func(c jwt.MapClaims) { c["iss"] = "https://evil.example.com" }},
+		{"已经过期", func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Minute).Unix() }},
+		{"没有过期时间", func(c jwt.MapClaims) { delete(c, "exp") }},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := oidcLogin(t, issuer, "u1", "alice", tc.edit); err == nil {
+				t.Fatal("应该拒绝这个 id token")
+			}
+		})
+	}
+
+	// azp 是自己时可以登录
+	if _, err := oidcLogin(t, issuer, "u1", "alice", func(c jwt.MapClaims) {
+		c["aud"] = []string{testClientId, "other-client"}
+		c["azp"] = testClientId
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOIDCRejectsWrongVerifier(t *testing.T) {
+	issuer := setupOIDCTest(t)
+	authUrl, state, err := BeginOIDCLogin("/admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, code := issuer.authorize(authUrl, "u1", "alice", nil)
+	// 换成别的 code_challenge，token 接口校验 code_verifier 时就对不上
+	issuer.mu.Lock()
+	entry := issuer.codes[code]
+	entry.challenge = "other"
+	issuer.codes[code] = entry
+	issuer.mu.Unlock()
+	if _, _, err = FinishOIDCLogin(state, code); err == nil {
+		t.Fatal("code_verifier 不对时不能登录")
+	}
+	// state 只能用一次
+	if _, _, err = FinishOIDCLogin(state, code); err == nil {
+		t.Fatal("同一个 state 不能用两次")
+	}
+}
+
+func TestOIDCRejectsUnknownKey(t *testing.T) {
+	issuer := setupOIDCTest(t)
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuer.key, other = other, issuer.key
+	defer func() { issuer.key = other }()
+	if _, err = oidcLogin(t, issuer, "u1", "alice", nil); err == nil {
+		t.Fatal("签名公钥不对时不能登录")
+	}
+}
+
+func TestDisablePasswordLogin(t *testing.T) {
+	issuer := setupOIDCTest(t)
+	if PasswordLoginDisabled() {
+		t.Fatal("默认不关闭密码登录")
+	}
+
+	cfg := issuer.config()
+	cfg.DisablePasswordLogin = true
+	if err := InitOIDC(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if !PasswordLoginDisabled() {
+		t.Fatal("应该关闭了密码登录")
+	}
+
+	// 没有单点登录时关掉密码登录就没法登录了
+	oidcConfig = nil
+	if err := InitOIDC(config.OIDCConfig{DisablePasswordLogin: true}); err == nil {
+		t.Fatal("没有配置单点登录时不能关闭密码登录")
+	}
+	if PasswordLoginDisabled() || OIDCEnabled() {
+		t.Fatal("没有配置单点登录时应该可以用密码登录")
+	}
+}
@@ -0,0 +1,66 @@
+package service
+
+import (
+	"errors"
+	"net"
+	"strings"
+
+	"github.com/ziren926/van-nav/config"
+	"github.com/ziren926/van-nav/logger"
+	"github.com/ziren926/van-nav/types"
+	"github.com/ziren926/van-nav/utils"
+)
+
+// 反向代理认证：Authelia、Authentik、oauth2-proxy 等认证后把用户名和组放在请求头里转发过来
+type ProxyAuthConfig struct {
+	Networks     []*net.IPNet // 只信任直接来自这些地址的请求头
+	UserHeader   string
+	GroupsHeader string
+	GroupRoleMapping
+}
+
+var proxyAuthConfig *ProxyAuthConfig
+
+// 按配置开启反向代理认证，没配置 proxyAuth.cidrs 就不开启
+func InitProxyAuth(cfg config.ProxyAuthConfig) error {
+	cidrs := []string(cfg.CIDRs)
+	if len(cidrs) == 0 {
+		return nil
+	}
+	config := &ProxyAuthConfig{
+		UserHeader:   cfg.UserHeader,
+		GroupsHeader: cfg.GroupsHeader,
+	}
+	networks, err := utils.ParseNetworks(cidrs)
+	if err != nil {
+		return errors.New("proxyAuth.cidrs 配置错误: " + err.Error())
+	}
+	config.Networks = networks
+	mapping, err := loadGroupRoleMapping("proxyAuth", cfg.GroupRoleConfig)
+	if err != nil {
+		return err
+	}
+	config.GroupRoleMapping = mapping
+	proxyAuthConfig = config
+	logger.LogInfo("已开启反向代理认证，信任来自 %s 的 %s 请求头", strings.Join(cidrs, ","), config.UserHeader)
+	return nil
+}
+
+// 按反向代理传过来的请求头认证。remoteIp 必须是直接连过来的地址，不能用 X-Forwarded-For 里的
+func ProxyAuthenticate(remoteIp net.IP, header func(string) string) (types.User, bool) {
+	if proxyAuthConfig == nil || !utils.ContainsIP(proxyAuthConfig.Networks, remoteIp) {
+		return types.User{}, false
+	}
+	name := strings.TrimSpace(header(proxyAuthConfig.UserHeader))
+	if name == "" {
+		return types.User{}, false
+	}
+	// 组一般用逗号分隔，有的代理用竖线
+	groups := utils.SplitAndTrim(strings.ReplaceAll(header(proxyAuthConfig.GroupsHeader), "|", ","))
+	user, err := externalUser("反向代理认证", "", name, groups, proxyAuthConfig.GroupRoleMapping)
+	if err != nil {
+		logger.LogError("反向代理认证用户 %s 失败: %s", name, err)
+		return types.User{}, false
+	}
+	return user, true
+}
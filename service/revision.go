@@ -0,0 +1,172 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/ziren926/van-nav/database"
+	"github.com/ziren926/van-nav/types"
+	"github.com/ziren926/van-nav/utils"
+)
+
+// 正文这类长文本字段不放进按字段的差异里，单独给出按行的 unified diff
+var revisionTextFields = map[string]map[string]bool{
+	types.RevisionTool: {"content": true, "post_content": true},
+	types.RevisionPost: {"content": true},
+}
+
+// 每次保存都会变的字段，比较两个版本时不算
+var revisionIgnoredFields = map[string]bool{
+	"id":              true,
+	"post_created_at": true,
+	"post_updated_at": true,
+	"createTime":      true,
+	"updateTime":      true,
+}
+
+// unified diff 每处改动前后保留的行数
+const revisionDiffContext = 3
+
+func checkRevisionKind(kind string) error {
+	if kind != types.RevisionTool && kind != types.RevisionPost {
+		return fmt.Errorf("无效的类型: %s", kind)
+	}
+	return nil
+}
+
+// 当前版本，工具或帖子不存在时返回 database.ErrNotFound
+func currentRevisionTarget(kind string, targetId int64) (interface{}, error) {
+	if kind == types.RevisionTool {
+		return database.Tools.GetById(targetId)
+	}
+	return database.Posts.GetById(targetId)
+}
+
+// GetRevisions 工具或帖子的修改历史，新的在前。工具或帖子不存在时返回 database.ErrNotFound
+func GetRevisions(kind string, targetId int64) ([]types.Revision, error) {
+	if err := checkRevisionKind(kind); err != nil {
+		return nil, err
+	}
+	if _, err := currentRevisionTarget(kind, targetId); err != nil {
+		return nil, err
+	}
+	return database.Revisions.List(kind, targetId)
+}
+
+func GetRevision(kind string, targetId int64, id int64) (types.Revision, error) {
+	if err := checkRevisionKind(kind); err != nil {
+		return types.Revision{}, err
+	}
+	return database.Revisions.GetById(kind, targetId, id)
+}
+
+// DiffRevisions 比较两个版本，to 是 0 时和当前版本比
+func DiffRevisions(kind string, targetId int64, from int64, to int64) (types.RevisionDiff, error) {
+	diff := types.RevisionDiff{From: from, To: to}
+	revision, err := GetRevision(kind, targetId, from)
+	if err != nil {
+		return diff, err
+	}
+	before := revisionFields(revision.Data)
+	var after map[string]interface{}
+	if to == 0 {
+		current, err := currentRevisionTarget(kind, targetId)
+		if err != nil {
+			return diff, err
+		}
+		after = auditFields(current)
+	} else {
+		revision, err = GetRevision(kind, targetId, to)
+		if err != nil {
+			return diff, err
+		}
+		after = revisionFields(revision.Data)
+	}
+
+	diff.Changes = make(map[string]types.AuditChange)
+	diff.TextDiffs = make(map[string]string)
+	keys := make(map[string]bool)
+	for key := range before {
+		keys[key] = true
+	}
+	for key := range after {
+		keys[key] = true
+	}
+	for key := range keys {
+		if revisionIgnoredFields[key] {
+			continue
+		}
+		// 空字段在 json 里可能被省略了，缺了的和空值当作一样
+		beforeValue, afterValue := before[key], after[key]
+		if reflect.DeepEqual(beforeValue, afterValue) || (isEmptyAuditValue(beforeValue) && isEmptyAuditValue(afterValue)) {
+			continue
+		}
+		if revisionTextFields[kind][key] {
+			beforeText, _ := beforeValue.(string)
+			afterText, _ := afterValue.(string)
+			diff.TextDiffs[key] = utils.UnifiedDiff(beforeText, afterText, revisionDiffContext)
+			continue
+		}
+		diff.Changes[key] = types.AuditChange{Before: beforeValue, After: afterValue}
+	}
+	return diff, nil
+}
+
+func revisionFields(data json.RawMessage) map[string]interface{} {
+	fields := make(map[string]interface{})
+	json.Unmarshal(data, &fields)
+	return fields
+}
+
+// RestoreRevision 把一个旧版本恢复成当前版本，恢复前的版本也会存进修改历史。返回恢复的版本
+func RestoreRevision(kind string, targetId int64, id int64, by string) (types.Revision, error) {
+	revision, err := GetRevision(kind, targetId, id)
+	if err != nil {
+		return revision, err
+	}
+	now := time.Now()
+	if kind == types.RevisionPost {
+		var post types.Post
+		if err = json.Unmarshal(revision.Data, &post); err != nil {
+			return revision, err
+		}
+		post.ID = targetId
+		post.UpdateTime = now
+		return revision, database.Posts.Update(post, by)
+	}
+
+	var tool types.Tool
+	if err = json.Unmarshal(revision.Data, &tool); err != nil {
+		return revision, err
+	}
+	// 分类可能已经删了，不能让工具指向不存在的分类
+	if _, ok := catelogById(tool.CatelogId); tool.CatelogId != 0 && !ok {
+		return revision, fmt.Errorf("这个版本的分类 %s 已经不存在了", tool.Catelog)
+	}
+	tool.Id = targetId
+	tool.PostUpdatedAt = now
+	return revision, database.Tools.Update(tool, by)
+}
+
+// RevisionCatelogId 工具的旧版本所在分类的 id
+func RevisionCatelogId(revision types.Revision) int {
+	var tool types.Tool
+	json.Unmarshal(revision.Data, &tool)
+	return tool.CatelogId
+}
+
+func catelogById(id int) (types.Catelog, bool) {
+	catelogs, err := database.Catelogs.GetAll()
+	if err != nil {
+		utils.CheckErr(err)
+		return types.Catelog{}, false
+	}
+	for _, catelog := range catelogs {
+		if catelog.Id == id {
+			return catelog, true
+		}
+	}
+	return types.Catelog{}, false
+}
@@ -0,0 +1,67 @@
+package service
+
+import (
+	"time"
+
+	"github.com/ziren926/van-nav/database"
+	"github.com/ziren926/van-nav/types"
+	"github.com/ziren926/van-nav/utils"
+)
+
+// 创建一条登录会话并签发对应的 jwt
+func CreateSession(user types.User, ip string, userAgent string) (string, error) {
+	now := time.Now()
+	expiresAt := now.Add(utils.SessionLifetime)
+	jti := utils.GenerateJti()
+
+	// 顺手清理过期很久的会话
+	err := database.Sessions.DeleteExpired(now.Add(-time.Hour * 24 * 30))
+	utils.CheckErr(err)
+
+	err = database.Sessions.Add(types.Session{
+		Id:        jti,
+		UserId:    user.Id,
+		IssuedAt:  now,
+		ExpiresAt: expiresAt,
+		Ip:        ip,
+		UserAgent: userAgent,
+	})
+	if err != nil {
+		return "", err
+	}
+	return utils.SignJWT(user, jti, expiresAt)
+}
+
+// 获取用户所有有效的会话
+func GetActiveSessions(userId int) []types.Session {
+	results, err := database.Sessions.GetActive(userId, time.Now())
+	if err != nil {
+		utils.CheckErr(err)
+		return make([]types.Session, 0)
+	}
+	return results
+}
+
+// 会话是否还有效：存在、没被吊销、没过期
+func IsSessionActive(jti string) bool {
+	if jti == "" {
+		return false
+	}
+	active, err := database.Sessions.IsActive(jti, time.Now())
+	utils.CheckErr(err)
+	return active
+}
+
+// 吊销用户的某个会话，返回是否真的吊销了
+func RevokeSession(userId int, id string) (bool, error) {
+	err := database.Sessions.Revoke(userId, id, time.Now())
+	if err == database.ErrNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// 吊销用户的全部会话，改密码后也会调用
+func RevokeUserSessions(userId int) (int64, error) {
+	return database.Sessions.RevokeUser(userId, time.Now())
+}
@@ -1,25 +1,18 @@
 package service
 
 import (
+	"errors"
+	"net"
+	"strings"
+
 	"github.com/ziren926/van-nav/database"
 	"github.com/ziren926/van-nav/logger"
 	"github.com/ziren926/van-nav/types"
+	"github.com/ziren926/van-nav/utils"
 )
 
 func GetSetting() types.Setting {
-	sql_get_user := `
-		SELECT id,favicon,title,govRecord,logo192,logo512,hideAdmin,hideGithub,jumpTargetBlank 
-		FROM nav_setting 
-		ORDER BY id ASC 
-		LIMIT 1;
-		`
-	var setting types.Setting
-	row := database.DB.QueryRow(sql_get_user, 0)
-	// 建立一个空变量
-	var hideGithub interface{}
-	var hideAdmin interface{}
-	var jumpTargetBlank interface{}
-	err := row.Scan(&setting.Id, &setting.Favicon, &setting.Title, &setting.GovRecord, &setting.Logo192, &setting.Logo512, &hideAdmin, &hideGithub, &jumpTargetBlank)
+	setting, err := database.Settings.Get()
 	if err != nil {
 		logger.LogError("获取配置失败: %s", err)
 		return types.Setting{
@@ -34,56 +27,30 @@ func GetSetting() types.Setting {
 			JumpTargetBlank: true,
 		}
 	}
-	if hideGithub == nil {
-		setting.HideGithub = false
-	} else {
-		if hideGithub.(int64) == 0 {
-			setting.HideGithub = false
-		} else {
-			setting.HideGithub = true
-		}
-	}
-	if hideAdmin == nil {
-		setting.HideAdmin = false
-	} else {
-		if hideAdmin.(int64) == 0 {
-			setting.HideAdmin = false
-		} else {
-			setting.HideAdmin = true
-		}
-	}
-
-	if jumpTargetBlank == nil {
-		setting.JumpTargetBlank = true
-	} else {
-		if jumpTargetBlank.(int64) == 0 {
-			setting.JumpTargetBlank = false
-		} else {
-			setting.JumpTargetBlank = true
-		}
-	}
-
 	return setting
 }
 
-func UpdateSetting(data types.Setting) error {
-	sql_update_setting := `
-		UPDATE nav_setting
-		SET favicon = ?, title = ?, govRecord = ?, logo192 = ?, logo512 = ?, hideAdmin = ?, hideGithub = ?, jumpTargetBlank = ?
-		WHERE id = (SELECT id FROM nav_setting ORDER BY id ASC LIMIT 1);
-		`
-
-	stmt, err := database.DB.Prepare(sql_update_setting)
+// 私有模式的配置，每个公开的读请求都要查一次，所以只查这两列。
+// 查询出错时当作开启、没有白名单，宁可拒绝也不要把内容露出去
+func GetPrivateMode() (bool, []*net.IPNet) {
+	privateMode, privateAllowlist, err := database.Settings.GetPrivateMode()
 	if err != nil {
-		return err
+		logger.LogError("获取私有模式配置失败: %s", err)
+		return true, nil
 	}
-	res, err := stmt.Exec(data.Favicon, data.Title, data.GovRecord, data.Logo192, data.Logo512, data.HideAdmin, data.HideGithub, data.JumpTargetBlank)
-	if err != nil {
-		return err
+	if !privateMode {
+		return false, nil
 	}
-	_, err = res.RowsAffected()
-	if err != nil {
-		return err
+	// 保存时已经校验过，这里出错就当没有白名单
+	networks, _ := utils.ParseNetworks(utils.SplitAndTrim(privateAllowlist))
+	return true, networks
+}
+
+func UpdateSetting(data types.Setting) error {
+	allowlist := utils.SplitAndTrim(data.PrivateAllowlist)
+	if _, err := utils.ParseNetworks(allowlist); err != nil {
+		return errors.New("私有模式白名单配置错误: " + err.Error())
 	}
-	return nil
+	data.PrivateAllowlist = strings.Join(allowlist, ",")
+	return database.Settings.Update(data)
 }
@@ -0,0 +1,89 @@
+package service
+
+import (
+	"errors"
+	"strings"
+	"sync/atomic"
+
+	"github.com/ziren926/van-nav/config"
+	"github.com/ziren926/van-nav/database"
+	"github.com/ziren926/van-nav/logger"
+	"github.com/ziren926/van-nav/types"
+	"github.com/ziren926/van-nav/utils"
+)
+
+// 初始化时设置的密码最短长度
+const setupMinPasswordLength = 8
+
+var (
+	errSetupRequired = errors.New("还没有完成初始化")
+	errSetupDone     = errors.New("已经完成初始化")
+)
+
+// 完成初始化后就不会再回到未初始化的状态（不能删除最后一个 owner），缓存起来不用每次都查库
+var setupDone int32
+
+// 还没有任何用户时需要先初始化
+func SetupRequired() bool {
+	if atomic.LoadInt32(&setupDone) == 1 {
+		return false
+	}
+	count, err := database.Users.Count()
+	if err != nil {
+		utils.CheckErr(err)
+		return true
+	}
+	if count > 0 {
+		atomic.StoreInt32(&setupDone, 1)
+	}
+	return count == 0
+}
+
+// 创建第一个 owner 并设置网站标题。只有一个请求能成功，其他的返回已经初始化
+func CompleteSetup(data types.SetupDto) (types.User, error) {
+	data.Name = strings.TrimSpace(data.Name)
+	data.Title = strings.TrimSpace(data.Title)
+	if data.Name == "" {
+		return types.User{}, errors.New("用户名不能为空")
+	}
+	if len(data.Password) < setupMinPasswordLength {
+		return types.User{}, errors.New("密码至少要 8 位")
+	}
+	if !SetupRequired() {
+		return types.User{}, errSetupDone
+	}
+	hash, err := utils.HashPassword(data.Password)
+	if err != nil {
+		return types.User{}, err
+	}
+
+	// 在事务里再确认一次没有用户，防止两个请求同时初始化
+	id, err := database.Users.AddFirst(types.User{Name: data.Name, Password: hash, Role: types.RoleOwner}, data.Title)
+	if err == database.ErrUsersExist {
+		return types.User{}, errSetupDone
+	}
+	if err != nil {
+		return types.User{}, err
+	}
+	atomic.StoreInt32(&setupDone, 1)
+	logger.LogInfo("初始化完成，管理员: %s", data.Name)
+	return types.User{Id: id, Name: data.Name, Role: types.RoleOwner}, nil
+}
+
+// 无界面部署时用 setup.adminUser、setup.adminPassword（可选 setup.siteTitle）初始化，
+// 也就是 VAN_NAV_ADMIN_USER、VAN_NAV_ADMIN_PASSWORD 和 VAN_NAV_SITE_TITLE 环境变量。已经初始化过就忽略
+func SetupFromConfig(cfg config.SetupConfig) error {
+	if cfg.AdminUser == "" && cfg.AdminPassword == "" {
+		return nil
+	}
+	if !SetupRequired() {
+		logger.LogInfo("已经完成初始化，忽略配置里的管理员账号")
+		return nil
+	}
+	_, err := CompleteSetup(types.SetupDto{
+		Name:     cfg.AdminUser,
+		Password: cfg.AdminPassword,
+		Title:    cfg.SiteTitle,
+	})
+	return err
+}
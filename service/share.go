@@ -0,0 +1,154 @@
+package service
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/ziren926/van-nav/database"
+	"github.com/ziren926/van-nav/types"
+	"github.com/ziren926/van-nav/utils"
+)
+
+const (
+	shareDefaultLifetime = time.Hour * 24 * 7
+	shareMaxLifetime     = time.Hour * 24 * 365
+)
+
+// 分享对象的名称，工具还要返回所在的分类。找不到返回 false
+func shareTarget(shareType string, targetId int) (name string, catelog string, ok bool) {
+	switch shareType {
+	case types.ShareCatelog:
+		item, found := GetCatelogById(targetId)
+		return item.Name, item.Name, found
+	case types.ShareTool:
+		tool, err := database.Tools.GetById(int64(targetId))
+		return tool.Name, tool.Catelog, err == nil
+	}
+	return "", "", false
+}
+
+// 创建分享链接，返回的 Token 只有这一次能看到
+func AddShare(data types.AddShareDto, createdBy string) (types.Share, error) {
+	if data.Type != types.ShareCatelog && data.Type != types.ShareTool {
+		return types.Share{}, errors.New("无效的分享类型: " + data.Type)
+	}
+	target, _, ok := shareTarget(data.Type, data.TargetId)
+	if !ok {
+		return types.Share{}, errors.New("要分享的分类或工具不存在")
+	}
+	now := time.Now()
+	expiresAt := now.Add(shareDefaultLifetime)
+	if data.ExpiresAt != nil {
+		expiresAt = *data.ExpiresAt
+	}
+	if !expiresAt.After(now) {
+		return types.Share{}, errors.New("过期时间必须晚于现在")
+	}
+	if expiresAt.Sub(now) > shareMaxLifetime {
+		return types.Share{}, errors.New("分享链接最长有效一年")
+	}
+	share := types.Share{
+		Id:        utils.GenerateJti(),
+		Name:      strings.TrimSpace(data.Name),
+		Type:      data.Type,
+		TargetId:  data.TargetId,
+		Target:    target,
+		CreatedBy: createdBy,
+		CreatedAt: time.Unix(now.Unix(), 0),
+		ExpiresAt: time.Unix(expiresAt.Unix(), 0),
+	}
+	if share.Name == "" {
+		share.Name = target
+	}
+	err := database.Shares.Add(share)
+	if err != nil {
+		return types.Share{}, err
+	}
+	share.Token, err = utils.SignShareJWT(share.Id, share.ExpiresAt)
+	return share, err
+}
+
+// 列出所有分享链接，包括已过期和已吊销的，不返回 token
+func GetShares() []types.Share {
+	results, err := database.Shares.GetAll()
+	if err != nil {
+		utils.CheckErr(err)
+		return make([]types.Share, 0)
+	}
+	for i := range results {
+		results[i].Target, _, _ = shareTarget(results[i].Type, results[i].TargetId)
+	}
+	return results
+}
+
+func GetShareById(id string) (types.Share, bool) {
+	share, err := database.Shares.GetById(id)
+	if err != nil {
+		if err != database.ErrNotFound {
+			utils.CheckErr(err)
+		}
+		return share, false
+	}
+	share.Target, _, _ = shareTarget(share.Type, share.TargetId)
+	return share, true
+}
+
+// 吊销分享链接，返回是否真的吊销了
+func RevokeShare(id string) (bool, error) {
+	err := database.Shares.Revoke(id, time.Now())
+	if err == database.ErrNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// 校验请求里带的分享 token，签名、有效期、是否吊销都要检查
+func ResolveShare(token string) (types.Share, bool) {
+	if token == "" {
+		return types.Share{}, false
+	}
+	id, err := utils.ParseShareJWT(token)
+	if err != nil {
+		return types.Share{}, false
+	}
+	share, ok := GetShareById(id)
+	if !ok || share.RevokedAt != nil || !share.ExpiresAt.After(time.Now()) {
+		return types.Share{}, false
+	}
+	return share, true
+}
+
+// 分享是否包含这个工具：分享了工具本身，或者分享了工具所在的分类
+func ShareCoversTool(share types.Share, tool types.Tool) bool {
+	switch share.Type {
+	case types.ShareTool:
+		return int(tool.Id) == share.TargetId
+	case types.ShareCatelog:
+		return tool.CatelogId == share.TargetId
+	}
+	return false
+}
+
+// 没登录时过滤掉隐藏的内容，但保留分享范围内的。
+// 分享单个工具时，工具所在的分类也要返回，不然前端没地方显示它
+func FilterHideWithShare(tools []types.Tool, catelogs []types.Catelog, share types.Share) ([]types.Tool, []types.Catelog) {
+	_, shareCatelog, _ := shareTarget(share.Type, share.TargetId)
+	hideCates := make(map[string]bool)
+	resultCates := make([]types.Catelog, 0)
+	for _, catelog := range catelogs {
+		if catelog.Hide {
+			hideCates[catelog.Name] = true
+		}
+		if !catelog.Hide || catelog.Name == shareCatelog {
+			resultCates = append(resultCates, catelog)
+		}
+	}
+	resultTools := make([]types.Tool, 0)
+	for _, tool := range tools {
+		if (!tool.Hide && !hideCates[tool.Catelog]) || ShareCoversTool(share, tool) {
+			resultTools = append(resultTools, tool)
+		}
+	}
+	return resultTools, resultCates
+}
@@ -0,0 +1,116 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ziren926/van-nav/database"
+	"github.com/ziren926/van-nav/types"
+	"github.com/ziren926/van-nav/utils"
+)
+
+func IsValidScope(scope string) bool {
+	for _, s := range types.AllScopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// scopes 里是否包含 scope，* 包含所有权限
+func HasScope(scopes []string, scope string) bool {
+	for _, s := range scopes {
+		if s == types.ScopeAll || s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// 列出所有没删除的 token，不返回 token 本身
+func GetApiTokens() []types.Token {
+	results, err := database.Tokens.GetAll()
+	if err != nil {
+		utils.CheckErr(err)
+		return make([]types.Token, 0)
+	}
+	return results
+}
+
+// 删除 token，只是标记为已删除
+func DeleteApiToken(id int) {
+	err := database.Tokens.Disable(id)
+	if err != nil && err != database.ErrNotFound {
+		utils.CheckErr(err)
+	}
+}
+
+func GetApiTokenById(id int) (types.Token, bool) {
+	for _, token := range GetApiTokens() {
+		if token.Id == id {
+			return token, true
+		}
+	}
+	return types.Token{}, false
+}
+
+func catelogExists(name string) bool {
+	for _, catelog := range GetAllCatelog() {
+		if catelog.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// 创建 token，返回值里的 Value 是 token 明文，之后再也拿不到
+func AddApiToken(data types.AddTokenDto) (types.Token, error) {
+	if data.Name == "" {
+		return types.Token{}, errors.New("名称不能为空")
+	}
+	if len(data.Scopes) == 0 {
+		return types.Token{}, errors.New("至少要选择一个权限")
+	}
+	for _, scope := range data.Scopes {
+		if !IsValidScope(scope) {
+			return types.Token{}, errors.New("无效的权限: " + scope)
+		}
+	}
+	catelogId := data.CatelogId
+	if catelogId == 0 {
+		id, err := catelogIdByName(data.Catelog)
+		if err != nil {
+			return types.Token{}, err
+		}
+		catelogId = id
+	} else if catelog, ok := GetCatelogById(catelogId); ok {
+		data.Catelog = catelog.Name
+	} else {
+		return types.Token{}, fmt.Errorf("分类 %d 不存在", catelogId)
+	}
+	now := time.Now()
+	if data.ExpiresAt != nil && !data.ExpiresAt.After(now) {
+		return types.Token{}, errors.New("过期时间必须晚于当前时间")
+	}
+
+	value := utils.GenerateApiToken()
+	// 库里只存到秒
+	createdAt := time.Unix(now.Unix(), 0)
+	token := types.Token{
+		Name:      data.Name,
+		Scopes:    data.Scopes,
+		Catelog:   data.Catelog,
+		CatelogId: catelogId,
+		ExpiresAt: data.ExpiresAt,
+		CreatedAt: &createdAt,
+	}
+	id, err := database.Tokens.Add(token, utils.HashApiToken(value))
+	if err != nil {
+		return types.Token{}, err
+	}
+	token.Id = id
+	token.Value = value
+	return token, nil
+}
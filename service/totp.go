@@ -0,0 +1,109 @@
+package service
+
+import (
+	"errors"
+	"time"
+
+	"github.com/ziren926/van-nav/database"
+	"github.com/ziren926/van-nav/types"
+	"github.com/ziren926/van-nav/utils"
+)
+
+// 每次生成的恢复码数量
+const recoveryCodeCount = 10
+
+func IsTOTPEnabled(userId int) bool {
+	_, enabled, _, err := database.Users.GetTOTP(userId)
+	return err == nil && enabled
+}
+
+func GetTOTPStatus(userId int) types.ResTOTPStatusDto {
+	status := types.ResTOTPStatusDto{Enabled: IsTOTPEnabled(userId)}
+	if status.Enabled {
+		count, err := database.Users.CountRecoveryCodes(userId)
+		utils.CheckErr(err)
+		status.RecoveryCodesLeft = count
+	}
+	return status
+}
+
+// 生成新的密钥，确认之前不会生效。重复调用会覆盖还没确认的密钥
+func SetupTOTP(user types.User) (types.ResTOTPSetupDto, error) {
+	if IsTOTPEnabled(user.Id) {
+		return types.ResTOTPSetupDto{}, errors.New("已经开启了两步验证，请先关闭")
+	}
+	secret := utils.GenerateTOTPSecret()
+	if err := database.Users.SetTOTPSecret(user.Id, secret); err != nil {
+		return types.ResTOTPSetupDto{}, err
+	}
+	issuer := GetSetting().Title
+	if issuer == "" {
+		issuer = "Van Nav"
+	}
+	return types.ResTOTPSetupDto{
+		Secret: secret,
+		Uri:    utils.TOTPURI(issuer, user.Name, secret),
+	}, nil
+}
+
+// 用验证码确认密钥，开启两步验证并返回恢复码
+func ConfirmTOTP(userId int, code string) ([]string, error) {
+	if IsTOTPEnabled(userId) {
+		return nil, errors.New("已经开启了两步验证")
+	}
+	secret, _, _, err := database.Users.GetTOTP(userId)
+	if err != nil {
+		return nil, err
+	}
+	if secret == "" {
+		return nil, errors.New("请先生成两步验证密钥")
+	}
+	step, ok := utils.ValidateTOTP(secret, code, time.Now())
+	if !ok {
+		return nil, errors.New("验证码错误")
+	}
+	if err := database.Users.EnableTOTP(userId, step); err != nil {
+		return nil, err
+	}
+	return RegenerateRecoveryCodes(userId)
+}
+
+// 作废旧的恢复码并生成一组新的，明文只返回这一次
+func RegenerateRecoveryCodes(userId int) ([]string, error) {
+	codes := make([]string, 0, recoveryCodeCount)
+	hashes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		code := utils.GenerateRecoveryCode()
+		codes = append(codes, code)
+		hashes = append(hashes, utils.HashRecoveryCode(code))
+	}
+	if err := database.Users.ReplaceRecoveryCodes(userId, hashes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// 校验第二步的验证码或恢复码。验证码每个时间步只能用一次，恢复码用过就作废
+func VerifySecondFactor(userId int, code string) bool {
+	secret, enabled, lastStep, err := database.Users.GetTOTP(userId)
+	if err != nil || !enabled {
+		return false
+	}
+	if step, ok := utils.ValidateTOTP(secret, code, time.Now()); ok {
+		if step <= lastStep {
+			return false
+		}
+		// 两个请求同时用同一个验证码时只有一个能成功
+		advanced, err := database.Users.AdvanceTOTPStep(userId, step)
+		utils.CheckErr(err)
+		return advanced
+	}
+	used, err := database.Users.UseRecoveryCode(userId, utils.HashRecoveryCode(code), time.Now())
+	utils.CheckErr(err)
+	return used
+}
+
+// 关闭两步验证，同时删除密钥和恢复码
+func DisableTOTP(userId int) error {
+	return database.Users.DisableTOTP(userId)
+}
@@ -0,0 +1,119 @@
+package service
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/ziren926/van-nav/database"
+	"github.com/ziren926/van-nav/logger"
+	"github.com/ziren926/van-nav/types"
+	"github.com/ziren926/van-nav/utils"
+)
+
+// 回收站里的内容保留多久，过期后自动永久删除。0 表示不自动删除
+var TrashRetention = 30 * 24 * time.Hour
+
+// 多久检查一次过期的内容
+const trashPurgeInterval = time.Hour
+
+func checkTrashKind(kind string) error {
+	if kind != types.TrashTool && kind != types.TrashCatelog && kind != types.TrashPost {
+		return fmt.Errorf("无效的类型: %s", kind)
+	}
+	return nil
+}
+
+func GetTrash() ([]types.TrashItem, error) {
+	items, err := database.Trash.List()
+	if err != nil {
+		return nil, err
+	}
+	if TrashRetention > 0 {
+		for i := range items {
+			purgeAt := items[i].DeletedAt.Add(TrashRetention)
+			items[i].PurgeAt = &purgeAt
+		}
+	}
+	return items, nil
+}
+
+func GetTrashItem(kind string, id int64) (types.TrashItem, bool) {
+	items, err := GetTrash()
+	if err != nil {
+		utils.CheckErr(err)
+		return types.TrashItem{}, false
+	}
+	for _, item := range items {
+		if item.Type == kind && item.Id == id {
+			return item, true
+		}
+	}
+	return types.TrashItem{}, false
+}
+
+func RestoreTrash(kind string, id int64) error {
+	if err := checkTrashKind(kind); err != nil {
+		return err
+	}
+	return database.Trash.Restore(kind, id)
+}
+
+// PurgeTrash 永久删除回收站里的一项，工具的图标缓存没有别的工具在用的话一起删
+func PurgeTrash(kind string, id int64) error {
+	if err := checkTrashKind(kind); err != nil {
+		return err
+	}
+	logos, err := database.Trash.Purge(kind, id)
+	if err != nil {
+		return err
+	}
+	deleteUnusedLogos(logos)
+	return nil
+}
+
+// PurgeExpiredTrash 永久删除超过保留时间的内容，返回删除的条数
+func PurgeExpiredTrash() (int64, error) {
+	if TrashRetention <= 0 {
+		return 0, nil
+	}
+	count, logos, err := database.Trash.PurgeBefore(time.Now().Add(-TrashRetention))
+	if err != nil {
+		return 0, err
+	}
+	deleteUnusedLogos(logos)
+	return count, nil
+}
+
+// StartTrashPurge 启动时清理一次，之后每小时清理一次
+func StartTrashPurge() {
+	if TrashRetention <= 0 {
+		return
+	}
+	go func() {
+		for {
+			count, err := PurgeExpiredTrash()
+			if err != nil {
+				logger.LogError("清理回收站失败: %s", err)
+			} else if count > 0 {
+				logger.LogInfo("已永久删除回收站里过期的 %d 项", count)
+			}
+			time.Sleep(trashPurgeInterval)
+		}
+	}()
+}
+
+// 同一个图标可能有好几个工具在用，都删了才能删缓存
+func deleteUnusedLogos(logos []string) {
+	for _, logo := range logos {
+		inUse, err := database.Tools.LogoInUse(logo)
+		if err != nil {
+			utils.CheckErr(err)
+			continue
+		}
+		if !inUse {
+			err = database.Imgs.DeleteByUrl(url.QueryEscape(logo))
+			utils.CheckErr(err)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package service
+
+import (
+	"errors"
+
+	"github.com/ziren926/van-nav/database"
+	"github.com/ziren926/van-nav/types"
+	"github.com/ziren926/van-nav/utils"
+)
+
+var roleRank = map[string]int{
+	types.RoleViewer: 1,
+	types.RoleEditor: 2,
+	types.RoleOwner:  3,
+}
+
+func IsValidRole(role string) bool {
+	_, ok := roleRank[role]
+	return ok
+}
+
+// role 的权限是否不低于 required
+func RoleAtLeast(role string, required string) bool {
+	return roleRank[role] >= roleRank[required] && roleRank[role] > 0
+}
+
+func GetAllUsers() []types.User {
+	results, err := database.Users.GetAll()
+	if err != nil {
+		utils.CheckErr(err)
+		return make([]types.User, 0)
+	}
+	return results
+}
+
+func GetUserById(id int) (types.User, error) {
+	user, err := database.Users.GetById(id)
+	if err == database.ErrNotFound {
+		return user, errors.New("用户不存在")
+	}
+	return user, err
+}
+
+func userNameTaken(name string, exceptId int) bool {
+	taken, err := database.Users.NameTaken(name, exceptId)
+	utils.CheckErr(err)
+	return taken
+}
+
+func countOwners() int {
+	count, err := database.Users.CountByRole(types.RoleOwner)
+	utils.CheckErr(err)
+	return count
+}
+
+func AddUser(data types.AddUserDto) (types.User, error) {
+	if data.Name == "" || data.Password == "" {
+		return types.User{}, errors.New("用户名和密码不能为空")
+	}
+	if !IsValidRole(data.Role) {
+		return types.User{}, errors.New("无效的角色: " + data.Role)
+	}
+	if userNameTaken(data.Name, 0) {
+		return types.User{}, errors.New("用户名已存在")
+	}
+	hash, err := utils.HashPassword(data.Password)
+	if err != nil {
+		return types.User{}, err
+	}
+	id, err := database.Users.Add(types.User{Name: data.Name, Password: hash, Role: data.Role})
+	if err != nil {
+		return types.User{}, err
+	}
+	return types.User{Id: id, Name: data.Name, Role: data.Role}, nil
+}
+
+// owner 修改其他用户。返回值表示密码是否被修改
+func UpdateUserByOwner(id int, data types.UpdateUserRoleDto) (bool, error) {
+	user, err := GetUserById(id)
+	if err != nil {
+		return false, err
+	}
+	if data.Name == "" {
+		data.Name = user.Name
+	}
+	if data.Role == "" {
+		data.Role = user.Role
+	}
+	if !IsValidRole(data.Role) {
+		return false, errors.New("无效的角色: " + data.Role)
+	}
+	if userNameTaken(data.Name, id) {
+		return false, errors.New("用户名已存在")
+	}
+	if user.Role == types.RoleOwner && data.Role != types.RoleOwner && countOwners() <= 1 {
+		return false, errors.New("至少要保留一个 owner")
+	}
+	// 密码留空表示不改密码
+	hash := ""
+	if data.Password != "" {
+		if hash, err = utils.HashPassword(data.Password); err != nil {
+			return false, err
+		}
+	}
+	if err = database.Users.Update(id, data.Name, data.Role, hash); err != nil {
+		return false, err
+	}
+	return hash != "", nil
+}
+
+func DeleteUser(id int) error {
+	user, err := GetUserById(id)
+	if err != nil {
+		return err
+	}
+	if user.Role == types.RoleOwner && countOwners() <= 1 {
+		return errors.New("至少要保留一个 owner")
+	}
+	// 恢复码一起删掉
+	if err = database.Users.Delete(id); err != nil {
+		return err
+	}
+	_, err = RevokeUserSessions(id)
+	return err
+}
@@ -1,7 +1,10 @@
 package types
 
+import "time"
+
 type ResUserDto struct {
 	Name string `json:"name"`
+	Role string `json:"role"`
 }
 
 type UpdateUserDto struct {
@@ -10,12 +13,88 @@ type UpdateUserDto struct {
 	Password string `json:"password"`
 }
 
+type AddUserDto struct {
+	Name     string `json:"name"`
+	Password string `json:"password"`
+	Role     string `json:"role"`
+}
+
+// 管理员修改其他用户，密码留空表示不改
+type UpdateUserRoleDto struct {
+	Name     string `json:"name"`
+	Password string `json:"password"`
+	Role     string `json:"role"`
+}
+
+// 首次启动时创建第一个 owner
+type SetupDto struct {
+	Name     string `json:"name"`
+	Password string `json:"password"`
+	Title    string `json:"title"`
+}
+
 type LoginDto struct {
 	Name     string `json:"name"`
 	Password string `json:"password"`
 }
+
+// 两步验证第二步，code 可以是验证码也可以是恢复码
+type LoginTOTPDto struct {
+	PreAuthToken string `json:"preAuthToken"`
+	Code         string `json:"code"`
+}
+
+type TOTPCodeDto struct {
+	Code string `json:"code"`
+}
+
+type DisableTOTPDto struct {
+	Password string `json:"password"`
+	Code     string `json:"code"`
+}
+
+type ResTOTPSetupDto struct {
+	Secret string `json:"secret"`
+	Uri    string `json:"uri"` // otpauth:// 链接，前端用它生成二维码
+}
+
+type ResTOTPStatusDto struct {
+	Enabled           bool `json:"enabled"`
+	RecoveryCodesLeft int  `json:"recoveryCodesLeft"`
+}
 type AddTokenDto struct {
-	Name string `json:"name"`
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	Catelog   string     `json:"catelog"`
+	CatelogId int        `json:"catelogId"` // 按 id 限定分类，不为 0 时不看 catelog
+	ExpiresAt *time.Time `json:"expiresAt"`
+}
+
+// 查询审计日志，条件都可以不填，时间是 unix 秒
+type AuditQueryDto struct {
+	Page       int    `form:"page"`
+	PageSize   int    `form:"pageSize"`
+	Actor      string `form:"actor"`
+	Action     string `form:"action"`
+	TargetType string `form:"targetType"`
+	TargetId   string `form:"targetId"`
+	From       int64  `form:"from"`
+	To         int64  `form:"to"`
+}
+
+type ResAuditListDto struct {
+	List     []AuditLog `json:"list"`
+	Total    int        `json:"total"`
+	Page     int        `json:"page"`
+	PageSize int        `json:"pageSize"`
+}
+
+// 创建分享链接，不传过期时间默认 7 天
+type AddShareDto struct {
+	Name      string     `json:"name"`
+	Type      string     `json:"type"`
+	TargetId  int        `json:"targetId"`
+	ExpiresAt *time.Time `json:"expiresAt"`
 }
 
 type UpdateCatelogDto struct {
@@ -1,18 +1,23 @@
 package types
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+)
 
 // 默认是 0
 type Setting struct {
-    Id              int    `json:"id"`
-    Favicon         string `json:"favicon"`
-    Title           string `json:"title"`
-    GovRecord       string `json:"govRecord"`
-    Logo192         string `json:"logo192"`
-    Logo512         string `json:"logo512"`
-    HideAdmin       bool   `json:"hideAdmin"`
-    HideGithub      bool   `json:"hideGithub"`
-    JumpTargetBlank bool   `json:"jumpTargetBlank"`
+	Id               int    `json:"id"`
+	Favicon          string `json:"favicon"`
+	Title            string `json:"title"`
+	GovRecord        string `json:"govRecord"`
+	Logo192          string `json:"logo192"`
+	Logo512          string `json:"logo512"`
+	HideAdmin        bool   `json:"hideAdmin"`
+	HideGithub       bool   `json:"hideGithub"`
+	JumpTargetBlank  bool   `json:"jumpTargetBlank"`
+	PrivateMode      bool   `json:"privateMode"`      // 私有模式：首页数据、工具详情、图片和 manifest 都要登录才能访问
+	PrivateAllowlist string `json:"privateAllowlist"` // 私有模式下不用登录的 IP 或网段，逗号分隔
 }
 
 type Tool struct {
@@ -22,6 +27,7 @@ type Tool struct {
     Logo          string    `json:"logo"`
     Desc          string    `json:"desc"`
     Catelog       string    `json:"catelog"`
+	CatelogId     int       `json:"catelogId"` // 分类 id，没有分类时是 0
     Content       string    `json:"content,omitempty"`
     Sort          int       `json:"sort"`
     Hide          bool      `json:"hide"`
@@ -31,17 +37,124 @@ type Tool struct {
     PostUpdatedAt time.Time `json:"post_updated_at"`  // 帖子更新时间
 }
 
+// 导入时遇到已经有的工具怎么办
+const (
+	ImportSkip      = "skip"      // 跳过，保留原来的，默认
+	ImportOverwrite = "overwrite" // 用导入的覆盖原来的，旧版本存进修改历史
+	ImportKeepBoth  = "keepBoth"  // 两个都留着，导入的作为新工具添加
+)
+
+// 导入时按什么判断是同一个工具
+const (
+	ImportMatchUrl = "url" // 规范化之后的网址相同，默认
+	ImportMatchId  = "id"  // id 相同
+)
+
+type ImportOptions struct {
+	Strategy  string // skip、overwrite 或 keepBoth
+	MatchBy   string // url 或 id
+	DryRun    bool   // 只返回导入计划，什么都不写
+	CatelogId int    // 不为 0 时只和这个分类里的工具比较，限定了分类的 token 用
+}
+
+// 导入计划里每一行的处理结果
+const (
+	ImportCreated  = "created"
+	ImportUpdated  = "updated"
+	ImportSkipped  = "skipped"
+	ImportConflict = "conflict" // 没法按选的方式处理，不会写入
+)
+
+type ImportItem struct {
+	Row       int    `json:"row"`       // 导入数据里的第几个，从 1 开始
+	Action    string `json:"action"`    // created、updated、skipped 或 conflict
+	Id        int64  `json:"id"`        // 新建或覆盖的工具，预览时新建的是 0
+	MatchedId int64  `json:"matchedId"` // 匹配到的已有工具，没匹配到或者匹配到的是前面导入的行时是 0
+	Name      string `json:"name"`
+	Url       string `json:"url"`
+	Catelog   string `json:"catelog"`
+	Reason    string `json:"reason,omitempty"` // 跳过和冲突的原因
+}
+
+// 导入结果，DryRun 时是导入计划
+type ImportReport struct {
+	DryRun      bool         `json:"dryRun"`
+	Strategy    string       `json:"strategy"`
+	MatchBy     string       `json:"matchBy"`
+	Created     int          `json:"created"`
+	Updated     int          `json:"updated"`
+	Skipped     int          `json:"skipped"`
+	Conflicts   int          `json:"conflicts"`
+	NewCatelogs []string     `json:"newCatelogs"` // 导入时新建的分类
+	Items       []ImportItem `json:"items"`
+}
+
+// 导入书签时文件夹怎么对应到分类
+const (
+	BookmarkFoldersFlatten = "flatten" // 放进最外层文件夹的分类，里面的文件夹都摊平，默认
+	BookmarkFoldersPrefix  = "prefix"  // 分类名是文件夹的完整路径，比如 开发/前端
+	BookmarkFoldersNone    = "none"    // 不管文件夹，都放进 Catelog
+)
+
+type BookmarkOptions struct {
+	Folders string // flatten、prefix 或 none
+	Catelog string // 不在文件夹里的书签放进的分类，为空时是“书签”
+}
+
+// api token 的权限范围
+const (
+	ScopeAll           = "*"
+	ScopeRead          = "read"           // 查看隐藏内容、导出
+	ScopeToolsWrite    = "tools:write"    // 增删改工具、导入
+	ScopeCatelogsWrite = "catelogs:write" // 增删改分类
+	ScopePostsWrite    = "posts:write"    // 增删改帖子
+	ScopeSettingsWrite = "settings:write" // 修改网站设置
+	ScopeTokensWrite   = "tokens:write"   // 管理 api token
+	ScopeUsersWrite    = "users:write"    // 管理用户和签名密钥
+	ScopeAuditRead     = "audit:read"     // 查看审计日志
+	ScopeSharesWrite   = "shares:write"   // 管理分享链接
+	ScopeBackupsWrite  = "backups:write"  // 备份、下载和恢复数据库
+)
+
+var AllScopes = []string{ScopeAll, ScopeRead, ScopeToolsWrite, ScopeCatelogsWrite, ScopePostsWrite, ScopeSettingsWrite, ScopeTokensWrite, ScopeUsersWrite, ScopeAuditRead, ScopeSharesWrite, ScopeBackupsWrite}
+
 type Token struct {
-    Id       int    `json:"id"`
-    Name     string `json:"name"`
-    Value    string `json:"value"`
-    Disabled int    `json:"disabled"`
+	Id         int        `json:"id"`
+	Name       string     `json:"name"`
+	Value      string     `json:"value,omitempty"` // 明文只在创建时返回一次，库里只存哈希
+	Disabled   int        `json:"disabled"`
+	Scopes     []string   `json:"scopes"`
+	Catelog    string     `json:"catelog"`   // 限定的分类的名称，只用来展示
+	CatelogId  int        `json:"catelogId"` // 不为 0 时只能操作这个分类
+	ExpiresAt  *time.Time `json:"expiresAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt"`
+	LastUsedIp string     `json:"lastUsedIp"`
+	CreatedAt  *time.Time `json:"createdAt"`
 }
 
+// 用户角色，权限从高到低
+const (
+	RoleOwner  = "owner"  // 管理用户、token 和网站设置
+	RoleEditor = "editor" // 管理工具、分类和帖子
+	RoleViewer = "viewer" // 只能查看隐藏内容
+)
+
 type User struct {
-    Id       int    `json:"id"`
-    Name     string `json:"name"`
-    Password string `json:"password"`
+	Id          int    `json:"id"`
+	Name        string `json:"name"`
+	Password    string `json:"-"` // 带版本前缀的哈希，永远不要返回给前端
+	Role        string `json:"role"`
+	OidcSubject string `json:"-"` // 关联的外部身份 issuer|sub，没有关联是空的
+}
+
+type Session struct {
+	Id        string    `json:"id"`
+	UserId    int       `json:"userId"`
+	IssuedAt  time.Time `json:"issuedAt"`
+	ExpiresAt time.Time `json:"expiresAt"`
+	Ip        string    `json:"ip"`
+	UserAgent string    `json:"userAgent"`
+	Current   bool      `json:"current"` // 是否是发起请求的这个会话
 }
 
 type Img struct {
@@ -58,10 +171,168 @@ type Catelog struct {
 }
 
 
+// 删除分类时，分类下还有工具怎么办
+const (
+	CatelogDeleteReject  = "reject"  // 拒绝删除，默认
+	CatelogDeleteMove    = "move"    // 把工具移到另一个分类
+	CatelogDeleteCascade = "cascade" // 连工具一起删除
+)
+
+// 回收站里的内容
+const (
+	TrashTool    = "tool"
+	TrashCatelog = "catelog"
+	TrashPost    = "post"
+)
+
+// 回收站里的一项
+type TrashItem struct {
+	Type      string     `json:"type"` // tool、catelog 或 post
+	Id        int64      `json:"id"`
+	Name      string     `json:"name"`                // 工具和分类的名称，帖子的标题
+	Catelog   string     `json:"catelog,omitempty"`   // 工具所在的分类
+	CatelogId int        `json:"catelogId,omitempty"` // 工具所在分类的 id
+	DeletedAt time.Time  `json:"deletedAt"`
+	PurgeAt   *time.Time `json:"purgeAt"` // 自动永久删除的时间，不自动删除时为空
+}
+
+// 有修改历史的内容
+const (
+	RevisionTool = "tool"
+	RevisionPost = "post"
+)
+
+// 修改历史里的一个旧版本，Data 是修改前的工具或帖子
+type Revision struct {
+	Id         int64           `json:"id"`
+	TargetType string          `json:"targetType"` // tool 或 post
+	TargetId   int64           `json:"targetId"`
+	Data       json.RawMessage `json:"data,omitempty"` // 列表里不返回
+	CreatedBy  string          `json:"createdBy"`      // 做这次修改的人，api token 是 token:名称
+	CreatedAt  time.Time       `json:"createdAt"`      // 这次修改的时间，也就是这个版本被替换掉的时间
+}
+
+// 两个版本的差异，To 是 0 表示和当前版本比
+type RevisionDiff struct {
+	From      int64                  `json:"from"`
+	To        int64                  `json:"to"`
+	Changes   map[string]AuditChange `json:"changes"`   // 按字段记录的差异，只有改了的字段
+	TextDiffs map[string]string      `json:"textDiffs"` // 正文这类长文本字段的 unified diff
+}
+
 type Post struct {
     ID        int64     `json:"id"`
     Title     string    `json:"title"`
     Content   string    `json:"content"`
     CreateTime time.Time `json:"createTime"`
     UpdateTime time.Time `json:"updateTime"`
-}
\ No newline at end of file
+}
+
+const (
+	LockoutIp   = "ip"
+	LockoutUser = "user"
+)
+
+// 登录失败记录，BlockedUntil 不为空表示现在还不能登录
+type Lockout struct {
+	Type         string     `json:"type"`
+	Key          string     `json:"key"`
+	Failures     int        `json:"failures"`
+	LastFailure  time.Time  `json:"lastFailure"`
+	BlockedUntil *time.Time `json:"blockedUntil"`
+}
+
+// 审计日志，记录管理接口的每一次修改
+type AuditLog struct {
+	Id         int64                  `json:"id"`
+	CreatedAt  time.Time              `json:"createdAt"`
+	Actor      string                 `json:"actor"`     // 用户名，api token 是 token:名称
+	ActorType  string                 `json:"actorType"` // user 或 token
+	ActorId    int                    `json:"actorId"`   // 用户 id 或 token id
+	Action     string                 `json:"action"`
+	TargetType string                 `json:"targetType"`
+	TargetId   string                 `json:"targetId"`
+	Changes    map[string]AuditChange `json:"changes"` // 按字段记录修改前后的值，只有改了的字段
+	Ip         string                 `json:"ip"`
+}
+
+type AuditChange struct {
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
+}
+
+// 分享的范围
+const (
+	ShareCatelog = "catelog"
+	ShareTool    = "tool"
+)
+
+// 分享链接，让没登录的人在有效期内看到一个隐藏的分类或工具
+type Share struct {
+	Id        string     `json:"id"`
+	Name      string     `json:"name"`
+	Type      string     `json:"type"`     // catelog 或 tool
+	TargetId  int        `json:"targetId"` // 分类或工具的 id
+	Target    string     `json:"target"`   // 分类或工具的名称，展示用
+	CreatedBy string     `json:"createdBy"`
+	CreatedAt time.Time  `json:"createdAt"`
+	ExpiresAt time.Time  `json:"expiresAt"`
+	RevokedAt *time.Time `json:"revokedAt"`
+	Token     string     `json:"token,omitempty"` // 只在创建时返回
+}
+
+// 备份的来源
+const (
+	BackupAuto       = "auto"       // 定时备份，按保留规则自动清理
+	BackupManual     = "manual"     // 在后台手动备份
+	BackupPreRestore = "prerestore" // 恢复备份前自动备份的当前数据库
+)
+
+// 数据库备份文件
+type Backup struct {
+	Name      string    `json:"name"` // 文件名，下载、恢复和删除都用它
+	Kind      string    `json:"kind"` // auto、manual 或 prerestore
+	Size      int64     `json:"size"`
+	Encrypted bool      `json:"encrypted"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// 远程备份目标的上传情况，只在内存里，重启后清空
+type RemoteBackupStatus struct {
+	Target        string     `json:"target"`   // s3 或 webdav
+	Location      string     `json:"location"` // bucket 和前缀，或者 WebDAV 目录，不含密钥
+	LastSuccessAt *time.Time `json:"lastSuccessAt"`
+	LastBackup    string     `json:"lastBackup"` // 最近一次上传成功的备份
+	LastErrorAt   *time.Time `json:"lastErrorAt"`
+	LastError     string     `json:"lastError"` // 最近一次失败的原因，重试都失败了才记
+	Pruned        int        `json:"pruned"`    // 最近一次按保留规则删除的远程备份数
+}
+
+// 数据库检查发现的问题类型
+const (
+	DoctorSchemaVersion = "schemaVersion" // 迁移没执行完或者是 dirty，要用 migrate 命令处理
+	DoctorMissingTable  = "missingTable"
+	DoctorMissingColumn = "missingColumn"
+	DoctorMissingIndex  = "missingIndex"
+	DoctorOrphanTool    = "orphanTool"  // 工具的分类不存在
+	DoctorDanglingImg   = "danglingImg" // 缓存的图标已经没有工具在用
+	DoctorIntegrity     = "integrity"   // integrity_check 和 foreign_key_check 的结果
+)
+
+type DoctorIssue struct {
+	Kind     string `json:"kind"`
+	Target   string `json:"target"` // 表名、表名.列名、工具 id 等
+	Message  string `json:"message"`
+	Fixable  bool   `json:"fixable"`
+	Fixed    bool   `json:"fixed"`
+	FixError string `json:"fixError,omitempty"`
+}
+
+// 数据库检查结果，Healthy 表示没有问题，或者问题都已经修好了
+type DoctorReport struct {
+	SchemaVersion int           `json:"schemaVersion"`
+	LatestVersion int           `json:"latestVersion"`
+	Dirty         bool          `json:"dirty"`
+	Issues        []DoctorIssue `json:"issues"`
+	Healthy       bool          `json:"healthy"`
+}
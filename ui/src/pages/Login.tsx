@@ -1,28 +1,132 @@
-import React, { useState } from 'react';
+import React, { useEffect, useState } from 'react';
 import { useNavigate } from 'react-router-dom';
 import { message } from 'antd';
-import { login } from '../utils/api';
+import { fetchLoginOptions, fetchSetupStatus, login, loginTotp, setup } from '../utils/api';
 import './Login.css';
 
 const Login: React.FC = () => {
   const [username, setUsername] = useState('');
   const [password, setPassword] = useState('');
+  // 开启了两步验证时，密码正确后会拿到预登录 token，再提交验证码
+  const [preAuthToken, setPreAuthToken] = useState('');
+  const [code, setCode] = useState('');
+  const [options, setOptions] = useState({ passwordLogin: true, oidc: false });
+  // 新实例还没有管理员时，这个页面变成初始化向导
+  const [setupRequired, setSetupRequired] = useState(false);
+  const [confirmPassword, setConfirmPassword] = useState('');
+  const [title, setTitle] = useState('');
   const navigate = useNavigate();
 
+  useEffect(() => {
+    fetchLoginOptions().then(setOptions).catch(() => {});
+    fetchSetupStatus().then((status) => setSetupRequired(!!status.required)).catch(() => {});
+    // 单点登录回调后会带着 token 或错误信息跳回这里
+    const hash = new URLSearchParams(window.location.hash.slice(1));
+    window.history.replaceState(null, '', window.location.pathname);
+    if (hash.get('token')) {
+      localStorage.setItem('_token', hash.get('token') as string);
+      message.success('登录成功');
+      navigate(hash.get('redirect') || '/admin');
+    } else if (hash.get('error')) {
+      message.error(hash.get('error'));
+    }
+  }, [navigate]);
+
+  const handleSetup = async (e: React.FormEvent) => {
+    e.preventDefault();
+    if (password !== confirmPassword) {
+      message.error('两次输入的密码不一致');
+      return;
+    }
+    try {
+      const response = await setup({ name: username, password, title });
+      localStorage.setItem('_token', response.data.token);
+      message.success('初始化成功');
+      navigate('/admin');
+    } catch (error: any) {
+      message.error(error?.response?.data?.errorMessage || '初始化失败');
+      if (error?.response?.status === 409) {
+        setSetupRequired(false);
+      }
+    }
+  };
+
+  if (setupRequired) {
+    return (
+      <div className="login-container">
+        <div className="login-box">
+          <h2>初始化 VanNav</h2>
+          <form onSubmit={handleSetup}>
+            <div className="input-group">
+              <input
+                type="text"
+                value={username}
+                onChange={(e) => setUsername(e.target.value)}
+                placeholder="管理员用户名"
+                required
+              />
+            </div>
+            <div className="input-group">
+              <input
+                type="password"
+                value={password}
+                onChange={(e) => setPassword(e.target.value)}
+                placeholder="密码（至少 8 位）"
+                autoComplete="new-password"
+                minLength={8}
+                required
+              />
+            </div>
+            <div className="input-group">
+              <input
+                type="password"
+                value={confirmPassword}
+                onChange={(e) => setConfirmPassword(e.target.value)}
+                placeholder="确认密码"
+                autoComplete="new-password"
+                required
+              />
+            </div>
+            <div className="input-group">
+              <input
+                type="text"
+                value={title}
+                onChange={(e) => setTitle(e.target.value)}
+                placeholder="网站标题（可选）"
+              />
+            </div>
+            <button type="submit" className="login-button">
+              创建管理员
+            </button>
+          </form>
+        </div>
+      </div>
+    );
+  }
+
   const handleSubmit = async (e: React.FormEvent) => {
     e.preventDefault();
-    // TODO: 实现登录逻辑
     try {
-      const response = await login(username, password);
-      if (response.success) {
+      const response = preAuthToken
+        ? await loginTotp(preAuthToken, code)
+        : await login(username, password);
+      if (response.success && response.data?.totpRequired) {
+        setPreAuthToken(response.data.preAuthToken);
+        message.info(response.message);
+      } else if (response.success) {
         localStorage.setItem('_token', response.data.token);
         message.success('登录成功');
         navigate('/admin');
       } else {
-        message.error(response.message);
+        message.error(response.errorMessage || response.message);
       }
-    } catch (error) {
-      message.error('登录失败');
+    } catch (error: any) {
+      if (preAuthToken && error?.response?.status === 401) {
+        // 预登录过期了，回到输入密码
+        setPreAuthToken('');
+        setCode('');
+      }
+      message.error(error?.response?.data?.errorMessage || '登录失败');
       console.error('登录失败:', error);
     }
   };
@@ -31,29 +135,52 @@ const Login: React.FC = () => {
     <div className="login-container" >
       <div className="login-box">
         <h2>VanNav 登录</h2>
-        <form onSubmit={handleSubmit}>
-          <div className="input-group">
-            <input
-              type="text"
-              value={username}
-              onChange={(e) => setUsername(e.target.value)}
-              placeholder="用户名"
-              required
-            />
-          </div>
-          <div className="input-group">
-            <input
-              type="password"
-              value={password}
-              onChange={(e) => setPassword(e.target.value)}
-              placeholder="密码"
-              required
-            />
-          </div>
-          <button type="submit" className="login-button">
-            登录
-          </button>
-        </form>
+        {options.passwordLogin && (
+          <form onSubmit={handleSubmit}>
+            {preAuthToken ? (
+              <div className="input-group">
+                <input
+                  type="text"
+                  value={code}
+                  onChange={(e) => setCode(e.target.value)}
+                  placeholder="两步验证码或恢复码"
+                  autoComplete="one-time-code"
+                  autoFocus
+                  required
+                />
+              </div>
+            ) : (
+              <>
+                <div className="input-group">
+                  <input
+                    type="text"
+                    value={username}
+                    onChange={(e) => setUsername(e.target.value)}
+                    placeholder="用户名"
+                    required
+                  />
+                </div>
+                <div className="input-group">
+                  <input
+                    type="password"
+                    value={password}
+                    onChange={(e) => setPassword(e.target.value)}
+                    placeholder="密码"
+                    required
+                  />
+                </div>
+              </>
+            )}
+            <button type="submit" className="login-button">
+              {preAuthToken ? '验证' : '登录'}
+            </button>
+          </form>
+        )}
+        {options.oidc && !preAuthToken && (
+          <a className="login-button login-sso" href="/api/oidc/login?redirect=/admin">
+            单点登录
+          </a>
+        )}
       </div>
       <div className="github-link">
         <a href="https://github.com/mereith/van-nav" target="_blank" rel="noopener noreferrer">
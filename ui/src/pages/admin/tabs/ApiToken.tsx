@@ -1,8 +1,25 @@
 
-import { Button, Card, Form, Input, Modal, message, Popconfirm, Space, Spin, Table, Typography } from 'antd';
+import { Button, Card, DatePicker, Form, Input, Modal, message, Popconfirm, Select, Space, Spin, Table, Tag, Typography } from 'antd';
 import { useCallback, useState } from 'react';
+import { getOptions } from '../../../utils/admin';
 import { fetchAddApiToken, fetchDeleteApiToken } from '../../../utils/api';
 import { useData } from '../hooks/useData';
+
+const scopeOptions = [
+  { label: "全部权限", value: "*" },
+  { label: "查看隐藏内容和导出", value: "read" },
+  { label: "管理工具", value: "tools:write" },
+  { label: "管理分类", value: "catelogs:write" },
+  { label: "管理帖子", value: "posts:write" },
+  { label: "修改设置", value: "settings:write" },
+  { label: "管理 Token", value: "tokens:write" },
+  { label: "管理用户", value: "users:write" },
+  { label: "查看审计日志", value: "audit:read" },
+  { label: "管理分享链接", value: "shares:write" },
+  { label: "备份和恢复数据库", value: "backups:write" },
+];
+
+const formatTime = (val?: string) => (val ? new Date(val).toLocaleString() : "-");
 export interface ApiTokenProps {
 
 }
@@ -26,16 +43,26 @@ export const ApiToken: React.FC<ApiTokenProps> = (props) => {
   const handleCreate = useCallback(
     async (record: any) => {
       try {
-        await fetchAddApiToken(record);
-        message.success("添加成功!");
-      } catch (err) {
-        message.warning("添加失败!");
+        const token = await fetchAddApiToken(record);
+        addForm.resetFields();
+        // token 只在创建时返回一次
+        Modal.success({
+          title: "添加成功，请立即复制保存",
+          content: (
+            <Typography.Paragraph copyable style={{ wordBreak: "break-all" }}>
+              {token.value}
+            </Typography.Paragraph>
+          ),
+          width: 520,
+        });
+      } catch (err: any) {
+        message.warning(err?.response?.data?.errorMessage || "添加失败!");
       } finally {
         setShowAddModel(false);
         reload();
       }
     },
-    [reload, setShowAddModel]
+    [reload, setShowAddModel, addForm]
   );
   return (
     <Card
@@ -77,20 +104,34 @@ export const ApiToken: React.FC<ApiTokenProps> = (props) => {
             }}
           />
           <Table.Column
-            title="值"
-            dataIndex="value"
+            title="权限"
+            dataIndex="scopes"
             width={200}
-            render={(val, record: any) => {
+            render={(scopes: string[] = [], record: any) => {
               return (
-                <div style={{ maxWidth: "300px" }}>
-
-                  <Typography.Text copyable ellipsis={true}>
-                    {val}
-                  </Typography.Text>
+                <div>
+                  {scopes.map((scope) => (
+                    <Tag key={scope}>{scope}</Tag>
+                  ))}
+                  {record.catelog && <Tag color="blue">分类: {record.catelog}</Tag>}
                 </div>
               );
             }}
           />
+          <Table.Column
+            title="过期时间"
+            dataIndex="expiresAt"
+            width={120}
+            render={(val) => (val ? formatTime(val) : "永不过期")}
+          />
+          <Table.Column
+            title="最近使用"
+            dataIndex="lastUsedAt"
+            width={150}
+            render={(val, record: any) => {
+              return val ? `${formatTime(val)} (${record.lastUsedIp})` : "从未使用";
+            }}
+          />
           <Table.Column
             title="操作"
             width={40}
@@ -128,6 +169,15 @@ export const ApiToken: React.FC<ApiTokenProps> = (props) => {
           <Form.Item name="name" required label="名称" labelCol={{ span: 4 }}>
             <Input placeholder="请输入 API Token 名称" />
           </Form.Item>
+          <Form.Item name="scopes" required label="权限" labelCol={{ span: 4 }}>
+            <Select mode="multiple" options={scopeOptions} placeholder="请选择权限" />
+          </Form.Item>
+          <Form.Item name="catelog" label="分类" labelCol={{ span: 4 }} extra="限定分类后只能操作这个分类下的工具">
+            <Select options={getOptions(store?.catelogs || [])} placeholder="不限分类" allowClear />
+          </Form.Item>
+          <Form.Item name="expiresAt" label="过期时间" labelCol={{ span: 4 }}>
+            <DatePicker showTime placeholder="永不过期" />
+          </Form.Item>
         </Form>
       </Modal>
     </Card>
@@ -145,6 +145,16 @@ export const Setting: React.FC<SettingProps> = (props) => {
             <Form.Item label="隐藏 Github 按钮" name="hideGithub" tooltip="默认展示，开启后将在前台 Github 按钮" >
               <Switch defaultChecked={Boolean(store?.setting?.hideGithub)} />
             </Form.Item>
+            <Form.Item label="私有模式" name="privateMode" valuePropName="checked" tooltip="开启后首页、工具详情、图片和 manifest 都要登录才能访问" >
+              <Switch />
+            </Form.Item>
+            <Form.Item
+              label="免登录 IP 白名单"
+              name="privateAllowlist"
+              tooltip="私有模式下这些 IP 或网段不用登录，逗号分隔，例如 10.0.0.0/8, 192.168.1.10"
+            >
+              <Input placeholder="10.0.0.0/8, 192.168.1.10"></Input>
+            </Form.Item>
             <Form.Item wrapperCol={{ offset: 8, span: 16 }}>
               <Button type="primary" htmlType="submit">
                 提交
@@ -22,6 +22,7 @@ import {
   fetchAddTool,
   fetchDeleteTool,
   fetchExportTools,
+  fetchImportBookmarks,
   fetchImportTools,
   fetchUpdateTool,
   fetchUpdateToolsSort,
@@ -167,8 +168,21 @@ export const Tools: React.FC<ToolsProps> = (props) => {
   const handleImport = useCallback(
     async (data: any) => {
       try {
-        await fetchImportTools(data);
-        message.success("导入成功!");
+        const report = await fetchImportTools(data);
+        message.success(`导入成功! 新增 ${report.created || 0} 个，跳过 ${report.skipped || 0} 个`);
+      } catch (err) {
+        message.warning("导入失败!");
+      } finally {
+        reload();
+      }
+    },
+    [reload]
+  );
+  const handleImportBookmarks = useCallback(
+    async (file: File) => {
+      try {
+        const report = await fetchImportBookmarks(file);
+        message.success(`导入成功! 新增 ${report.created || 0} 个，跳过 ${report.skipped || 0} 个`);
       } catch (err) {
         message.warning("导入失败!");
       } finally {
@@ -375,6 +389,18 @@ export const Tools: React.FC<ToolsProps> = (props) => {
           >
             <Button type="primary">导入</Button>
           </Upload>
+          <Upload
+            name="bookmarks.html"
+            maxCount={1}
+            accept=".html,.htm"
+            fileList={[]}
+            beforeUpload={(file) => {
+              handleImportBookmarks(file);
+              return false;
+            }}
+          >
+            <Button type="primary">导入书签</Button>
+          </Upload>
           <Button
             type="primary"
             onClick={() => {
@@ -0,0 +1,166 @@
+package utils
+
+import (
+	"encoding/base64"
+	"errors"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+// Bookmark 书签文件里的一个书签
+type Bookmark struct {
+	Name     string
+	Url      string
+	Desc     string
+	Folders  []string // 所在的文件夹，从外到内，不包括书签栏这类浏览器自带的文件夹
+	AddDate  time.Time
+	Modified time.Time
+	Icon     string // 书签里带的图标，base64，没有是空的
+	IconUri  string // 图标原来的地址，只有 Firefox 导出的有
+}
+
+var ErrNotBookmarkFile = errors.New("不是浏览器导出的书签文件")
+
+type bookmarkFolder struct {
+	name string
+	// 书签栏、其他书签这类浏览器自带的文件夹
+	builtin bool
+}
+
+// ParseBookmarks 解析 Chrome、Firefox、Edge 导出的 Netscape 格式的书签文件（bookmarks.html）。
+// 文件夹是 <DT><H3> 后面跟一个 <DL>，书签是 <DT><A>，后面可以有 <DD> 描述，标签大多不闭合，所以按 token 读
+func ParseBookmarks(r io.Reader) ([]Bookmark, error) {
+	z := html.NewTokenizer(r)
+	results := make([]Bookmark, 0)
+	// 每个 <DL> 对应的文件夹，最外层的 <DL> 没有文件夹，是 nil
+	var stack []*bookmarkFolder
+	var pending *bookmarkFolder
+	seenList := false
+	// 最近的书签在 results 里的位置，<DD> 是它的描述
+	last, desc := -1, -1
+	for {
+		tt := z.Next()
+		switch tt {
+		case html.ErrorToken:
+			if z.Err() != io.EOF {
+				return nil, z.Err()
+			}
+			if !seenList {
+				return nil, ErrNotBookmarkFile
+			}
+			for i := range results {
+				results[i].Desc = strings.TrimSpace(results[i].Desc)
+			}
+			return results, nil
+		case html.TextToken:
+			if desc >= 0 {
+				results[desc].Desc += string(z.Text())
+			}
+		case html.EndTagToken:
+			desc = -1
+			if name, _ := z.TagName(); string(name) == "dl" && len(stack) > 0 {
+				stack = stack[:len(stack)-1]
+			}
+		case html.StartTagToken:
+			desc = -1
+			name, hasAttr := z.TagName()
+			tag := string(name)
+			attrs := make(map[string]string)
+			for hasAttr {
+				var key, value []byte
+				key, value, hasAttr = z.TagAttr()
+				attrs[string(key)] = string(value)
+			}
+			switch tag {
+			case "h3":
+				pending = &bookmarkFolder{
+					builtin: attrs["personal_toolbar_folder"] == "true" || attrs["unfiled_bookmarks_folder"] == "true",
+				}
+				pending.name = strings.TrimSpace(readTagText(z, tag))
+				last = -1
+			case "dl":
+				seenList = true
+				stack = append(stack, pending)
+				pending = nil
+				last = -1
+			case "a":
+				bookmark := Bookmark{
+					Url:      strings.TrimSpace(attrs["href"]),
+					Folders:  bookmarkFolders(stack),
+					AddDate:  unixAttr(attrs["add_date"]),
+					Modified: unixAttr(attrs["last_modified"]),
+					Icon:     dataUriBase64(attrs["icon"]),
+					IconUri:  strings.TrimSpace(attrs["icon_uri"]),
+				}
+				bookmark.Name = strings.TrimSpace(readTagText(z, tag))
+				results = append(results, bookmark)
+				last = len(results) - 1
+			case "dd":
+				desc = last
+			}
+		}
+	}
+}
+
+// 读到 tag 的结束标签为止，返回中间的文字
+func readTagText(z *html.Tokenizer, tag string) string {
+	var text strings.Builder
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return text.String()
+		case html.TextToken:
+			text.Write(z.Text())
+		case html.EndTagToken:
+			if name, _ := z.TagName(); string(name) == tag {
+				return text.String()
+			}
+		}
+	}
+}
+
+func bookmarkFolders(stack []*bookmarkFolder) []string {
+	folders := make([]string, 0, len(stack))
+	for _, folder := range stack {
+		if folder != nil && !folder.builtin && folder.name != "" {
+			folders = append(folders, folder.name)
+		}
+	}
+	return folders
+}
+
+// ADD_DATE 这类是 unix 秒，没有或者不对时是零值
+func unixAttr(value string) time.Time {
+	seconds, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+	if err != nil || seconds <= 0 {
+		return time.Time{}
+	}
+	return time.Unix(seconds, 0)
+}
+
+// 把 data:image/png;base64,xxx 里的内容取出来，统一成 base64。不是 data: URI 时返回空
+func dataUriBase64(uri string) string {
+	if !strings.HasPrefix(uri, "data:") {
+		return ""
+	}
+	meta, data, ok := strings.Cut(strings.TrimPrefix(uri, "data:"), ",")
+	if !ok || data == "" {
+		return ""
+	}
+	if strings.HasSuffix(meta, ";base64") {
+		if _, err := base64.StdEncoding.DecodeString(data); err != nil {
+			return ""
+		}
+		return data
+	}
+	decoded, err := url.PathUnescape(data)
+	if err != nil {
+		return ""
+	}
+	return base64.StdEncoding.EncodeToString([]byte(decoded))
+}
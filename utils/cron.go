@@ -0,0 +1,128 @@
+package utils
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Cron 五段式的定时规则：分 时 日 月 周，支持 *、逗号列表、a-b 范围和 /n 步长，
+// 也可以写 @hourly、@daily、@weekly、@monthly。日和周都指定了时，满足一个就行，和 crontab 一样
+type Cron struct {
+	minute, hour, day, month, weekday uint64
+	anyDay, anyWeekday                bool
+}
+
+var cronDescriptors = map[string]string{
+	"@hourly":  "0 * * * *",
+	"@daily":   "0 0 * * *",
+	"@weekly":  "0 0 * * 0",
+	"@monthly": "0 0 1 * *",
+}
+
+func ParseCron(spec string) (*Cron, error) {
+	spec = strings.TrimSpace(spec)
+	if expanded, ok := cronDescriptors[spec]; ok {
+		spec = expanded
+	}
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("定时规则要有 5 段（分 时 日 月 周）: %s", spec)
+	}
+	bounds := [5][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 7}}
+	var sets [5]uint64
+	for i, field := range fields {
+		set, err := parseCronField(field, bounds[i][0], bounds[i][1])
+		if err != nil {
+			return nil, fmt.Errorf("定时规则的第 %d 段 %s 不对: %s", i+1, field, err)
+		}
+		sets[i] = set
+	}
+	// 周日可以写 0 也可以写 7
+	if sets[4]&(1<<7) != 0 {
+		sets[4] |= 1
+	}
+	return &Cron{
+		minute:     sets[0],
+		hour:       sets[1],
+		day:        sets[2],
+		month:      sets[3],
+		weekday:    sets[4],
+		anyDay:     fields[2] == "*",
+		anyWeekday: fields[4] == "*",
+	}, nil
+}
+
+func parseCronField(field string, min int, max int) (uint64, error) {
+	var set uint64
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			n, err := strconv.Atoi(part[i+1:])
+			if err != nil || n < 1 {
+				return 0, fmt.Errorf("步长无效")
+			}
+			step, part = n, part[:i]
+		}
+		start, end := min, max
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			if start, err = strconv.Atoi(bounds[0]); err != nil {
+				return 0, fmt.Errorf("不是数字")
+			}
+			end = start
+			if len(bounds) == 2 {
+				if end, err = strconv.Atoi(bounds[1]); err != nil {
+					return 0, fmt.Errorf("不是数字")
+				}
+			} else if step > 1 {
+				// 5/15 表示从 5 开始每 15 一次
+				end = max
+			}
+		}
+		if start < min || end > max || start > end {
+			return 0, fmt.Errorf("超出范围 %d-%d", min, max)
+		}
+		for i := start; i <= end; i += step {
+			set |= 1 << uint(i)
+		}
+	}
+	return set, nil
+}
+
+func (c *Cron) matchDay(t time.Time) bool {
+	day := c.day&(1<<uint(t.Day())) != 0
+	weekday := c.weekday&(1<<uint(t.Weekday())) != 0
+	switch {
+	case c.anyDay && c.anyWeekday:
+		return true
+	case c.anyDay:
+		return weekday
+	case c.anyWeekday:
+		return day
+	}
+	return day || weekday
+}
+
+// Next 在 after 之后第一个满足规则的时间，精确到分钟。5 年内都没有时返回零值，比如 2 月 30 日
+func (c *Cron) Next(after time.Time) time.Time {
+	t := after.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		switch {
+		case c.month&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !c.matchDay(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case c.hour&(1<<uint(t.Hour())) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		case c.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
@@ -0,0 +1,162 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+)
+
+// 超过这么多格就不算最长公共子序列了，直接当成整段替换，免得超长的文本占满内存
+const maxDiffCells = 4000000
+
+type diffLine struct {
+	kind byte // ' ' 没变，'-' 删除，'+' 新增
+	text string
+}
+
+// UnifiedDiff 按行比较两段文本，返回 unified diff 格式的差异，context 是每处改动前后保留的行数。
+// 没有差异时返回空字符串
+func UnifiedDiff(before string, after string, context int) string {
+	if before == after {
+		return ""
+	}
+	lines := diffLines(splitDiffLines(before), splitDiffLines(after))
+
+	// 每一行之前两边各有多少行，用来算 @@ 里的行号
+	beforePos := make([]int, len(lines)+1)
+	afterPos := make([]int, len(lines)+1)
+	for i, line := range lines {
+		beforePos[i+1] = beforePos[i]
+		afterPos[i+1] = afterPos[i]
+		if line.kind != '+' {
+			beforePos[i+1]++
+		}
+		if line.kind != '-' {
+			afterPos[i+1]++
+		}
+	}
+
+	var builder strings.Builder
+	for i := 0; i < len(lines); {
+		for i < len(lines) && lines[i].kind == ' ' {
+			i++
+		}
+		if i == len(lines) {
+			break
+		}
+		// 两处改动之间没变的行不超过 2*context 时合成一段
+		end := i
+		for j := i; j < len(lines); j++ {
+			if lines[j].kind != ' ' {
+				end = j
+			} else if j-end > 2*context {
+				break
+			}
+		}
+		start := i - context
+		if start < 0 {
+			start = 0
+		}
+		stop := end + context + 1
+		if stop > len(lines) {
+			stop = len(lines)
+		}
+		builder.WriteString(fmt.Sprintf("@@ -%s +%s @@\n",
+			diffRange(beforePos[start], beforePos[stop]-beforePos[start]),
+			diffRange(afterPos[start], afterPos[stop]-afterPos[start])))
+		for _, line := range lines[start:stop] {
+			builder.WriteByte(line.kind)
+			builder.WriteString(line.text)
+			builder.WriteByte('\n')
+		}
+		i = stop
+	}
+	return builder.String()
+}
+
+func splitDiffLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
+
+// unified diff 的行号从 1 开始，没有行时写前一行的行号
+func diffRange(start int, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	if count == 1 {
+		return fmt.Sprintf("%d", start+1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
+
+// 先去掉相同的开头和结尾，中间按最长公共子序列对齐
+func diffLines(a []string, b []string) []diffLine {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	result := make([]diffLine, 0, len(a)+len(b))
+	for _, text := range a[:prefix] {
+		result = append(result, diffLine{' ', text})
+	}
+	result = append(result, diffMiddle(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix])...)
+	for _, text := range a[len(a)-suffix:] {
+		result = append(result, diffLine{' ', text})
+	}
+	return result
+}
+
+func diffMiddle(a []string, b []string) []diffLine {
+	result := make([]diffLine, 0, len(a)+len(b))
+	if (len(a)+1)*(len(b)+1) > maxDiffCells {
+		for _, text := range a {
+			result = append(result, diffLine{'-', text})
+		}
+		for _, text := range b {
+			result = append(result, diffLine{'+', text})
+		}
+		return result
+	}
+	// lcs[i][j] 是 a[i:] 和 b[j:] 的最长公共子序列长度
+	width := len(b) + 1
+	lcs := make([]int32, (len(a)+1)*width)
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i*width+j] = lcs[(i+1)*width+j+1] + 1
+			} else if lcs[(i+1)*width+j] >= lcs[i*width+j+1] {
+				lcs[i*width+j] = lcs[(i+1)*width+j]
+			} else {
+				lcs[i*width+j] = lcs[i*width+j+1]
+			}
+		}
+	}
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			result = append(result, diffLine{' ', a[i]})
+			i++
+			j++
+		case lcs[(i+1)*width+j] >= lcs[i*width+j+1]:
+			result = append(result, diffLine{'-', a[i]})
+			i++
+		default:
+			result = append(result, diffLine{'+', b[j]})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		result = append(result, diffLine{'-', a[i]})
+	}
+	for ; j < len(b); j++ {
+		result = append(result, diffLine{'+', b[j]})
+	}
+	return result
+}
@@ -0,0 +1,145 @@
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// 口令不对，或者文件被改过、不完整
+var ErrDecrypt = errors.New("口令错误或者文件已损坏")
+
+// 加密文件的格式：魔数、scrypt 的盐、nonce 前缀，后面是一块块的 AES-256-GCM 密文。
+// 每块的 nonce 是前缀加块序号，最后一块的附加数据不一样，截掉末尾的块也能发现
+var encryptMagic = []byte("VANNAV\x00\x01")
+
+const (
+	encryptSaltSize   = 16
+	encryptPrefixSize = 4
+	encryptChunkSize  = 64 * 1024
+	encryptHeaderSize = 8 + encryptSaltSize + encryptPrefixSize
+)
+
+// 用口令派生密钥，scrypt 的参数是推荐的交互式参数，大约 32MB 内存
+func encryptKey(passphrase string, salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key([]byte(passphrase), salt, 1<<15, 8, 1, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func encryptNonce(prefix []byte, counter uint64) []byte {
+	nonce := make([]byte, 12)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint64(nonce[encryptPrefixSize:], counter)
+	return nonce
+}
+
+// 附加数据是文件头加上是不是最后一块
+func encryptAdditional(header []byte, last bool) []byte {
+	flag := byte(0)
+	if last {
+		flag = 1
+	}
+	return append(append([]byte{}, header...), flag)
+}
+
+// IsEncrypted 看文件开头是不是 EncryptStream 写出来的
+func IsEncrypted(head []byte) bool {
+	return bytes.HasPrefix(head, encryptMagic)
+}
+
+// EncryptStream 用口令加密 src 写到 dst，不用把整个文件读进内存
+func EncryptStream(dst io.Writer, src io.Reader, passphrase string) error {
+	header := make([]byte, encryptHeaderSize)
+	copy(header, encryptMagic)
+	if _, err := rand.Read(header[len(encryptMagic):]); err != nil {
+		return err
+	}
+	aead, err := encryptKey(passphrase, header[len(encryptMagic):len(encryptMagic)+encryptSaltSize])
+	if err != nil {
+		return err
+	}
+	if _, err = dst.Write(header); err != nil {
+		return err
+	}
+	prefix := header[len(encryptMagic)+encryptSaltSize:]
+
+	// 多读一块才知道当前这块是不是最后一块
+	current := make([]byte, encryptChunkSize)
+	next := make([]byte, encryptChunkSize)
+	n, err := io.ReadFull(src, current)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return err
+	}
+	for counter := uint64(0); ; counter++ {
+		last := n < encryptChunkSize
+		m := 0
+		if !last {
+			m, err = io.ReadFull(src, next)
+			if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+				return err
+			}
+			last = m == 0
+		}
+		sealed := aead.Seal(nil, encryptNonce(prefix, counter), current[:n], encryptAdditional(header, last))
+		if _, err = dst.Write(sealed); err != nil {
+			return err
+		}
+		if last {
+			return nil
+		}
+		current, next, n = next, current, m
+	}
+}
+
+// DecryptStream 解密 EncryptStream 写出来的内容，口令不对或者内容不完整时返回 ErrDecrypt。
+// 出错时 dst 里可能已经写了一部分，调用方要丢掉
+func DecryptStream(dst io.Writer, src io.Reader, passphrase string) error {
+	reader := bufio.NewReader(src)
+	header := make([]byte, encryptHeaderSize)
+	if _, err := io.ReadFull(reader, header); err != nil || !IsEncrypted(header) {
+		return ErrDecrypt
+	}
+	aead, err := encryptKey(passphrase, header[len(encryptMagic):len(encryptMagic)+encryptSaltSize])
+	if err != nil {
+		return err
+	}
+	prefix := header[len(encryptMagic)+encryptSaltSize:]
+
+	chunk := make([]byte, encryptChunkSize+aead.Overhead())
+	for counter := uint64(0); ; counter++ {
+		n, err := io.ReadFull(reader, chunk)
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return ErrDecrypt
+		}
+		last := err == io.ErrUnexpectedEOF
+		if !last {
+			if _, peekErr := reader.Peek(1); peekErr == io.EOF {
+				last = true
+			}
+		}
+		plain, err := aead.Open(chunk[:0], encryptNonce(prefix, counter), chunk[:n], encryptAdditional(header, last))
+		if err != nil {
+			return ErrDecrypt
+		}
+		if _, err = dst.Write(plain); err != nil {
+			return err
+		}
+		if last {
+			return nil
+		}
+	}
+}
@@ -2,7 +2,16 @@ package utils
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -14,55 +23,292 @@ import (
 func RandomJWTKey() string {
 	bytes := make([]byte, 32)
 	if _, err := rand.Read(bytes); err != nil {
-		logger.LogError("生成随机密钥失败: %v", err)
-		return "fallback_secret_key_12345"
+		// 拿不到随机数就别启动了，固定的密钥比起不来更危险
+		panic(fmt.Sprintf("生成随机密钥失败: %v", err))
 	}
 	return hex.EncodeToString(bytes)
 }
 
-// JTW 密钥
-var jwtSecret = []byte("boy_next_door")
+const jwtKeyFileName = "jwt_keys.json"
 
-func init() {
-	jwtSecret = []byte(RandomJWTKey())
-	logger.LogInfo("jwtSecret Setted: %s", jwtSecret)
+// 轮换后旧密钥还能继续验证的时间
+var JWTKeyGracePeriod = time.Hour * 24 * 7
+
+type JWTKey struct {
+	Kid       string    `json:"kid"`
+	Secret    string    `json:"secret,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+	// 只有轮换下来的旧密钥才有，过期后不再接受它签的 token
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+}
+
+type jwtKeyStore struct {
+	Current  JWTKey   `json:"current"`
+	Previous []JWTKey `json:"previous"`
 }
 
-// 签名一个 JTW
-func SignJWT(user types.User) (string, error) {
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+var (
+	jwtKeyLock sync.RWMutex
+	jwtKeys    jwtKeyStore
+	jwtKeyPath string
+	// 密钥来自配置，不写文件，也不能在线轮换
+	jwtKeyFromConfig bool
+)
+
+// kid 用密钥的指纹，不泄露密钥本身，环境变量给的密钥也能算出来
+func jwtKid(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:8])
+}
+
+func newJWTKey(secret string) JWTKey {
+	return JWTKey{
+		Kid:       jwtKid(secret),
+		Secret:    secret,
+		CreatedAt: time.Now(),
+	}
+}
+
+// 初始化 JWT 密钥：优先用配置里的 secret 和 previous，多实例部署时可以共用同一个；
+// 否则读数据目录下的密钥文件，没有就生成一个
+func InitJWTKey(dir string, secret string, previous []string) error {
+	jwtKeyLock.Lock()
+	defer jwtKeyLock.Unlock()
+
+	if secret != "" {
+		jwtKeyFromConfig = true
+		jwtKeys = jwtKeyStore{Current: newJWTKey(secret)}
+		for _, s := range previous {
+			if s = strings.TrimSpace(s); s != "" {
+				jwtKeys.Previous = append(jwtKeys.Previous, newJWTKey(s))
+			}
+		}
+		logger.LogInfo("使用配置中的 JWT 密钥, kid: %s", jwtKeys.Current.Kid)
+		return nil
+	}
+
+	PathExistsOrCreate(dir)
+	jwtKeyPath = filepath.Join(dir, jwtKeyFileName)
+	content, err := ioutil.ReadFile(jwtKeyPath)
+	if err == nil {
+		var store jwtKeyStore
+		if err := json.Unmarshal(content, &store); err != nil {
+			return fmt.Errorf("解析 JWT 密钥文件失败: %v", err)
+		}
+		if store.Current.Secret == "" {
+			return fmt.Errorf("JWT 密钥文件 %s 中没有当前密钥", jwtKeyPath)
+		}
+		jwtKeys = store
+		logger.LogInfo("已加载 JWT 密钥, kid: %s", jwtKeys.Current.Kid)
+		return nil
+	}
+	if !os.IsNotExist(err) {
+		return fmt.Errorf("读取 JWT 密钥文件失败: %v", err)
+	}
+
+	jwtKeys = jwtKeyStore{Current: newJWTKey(RandomJWTKey())}
+	if err := saveJWTKeys(); err != nil {
+		return err
+	}
+	logger.LogInfo("已生成新的 JWT 密钥, kid: %s", jwtKeys.Current.Kid)
+	return nil
+}
+
+// 先写临时文件再改名，避免写一半把密钥弄丢
+func saveJWTKeys() error {
+	content, err := json.MarshalIndent(jwtKeys, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmpPath := jwtKeyPath + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, content, 0600); err != nil {
+		return fmt.Errorf("写入 JWT 密钥文件失败: %v", err)
+	}
+	return os.Rename(tmpPath, jwtKeyPath)
+}
+
+// 轮换密钥：新 token 用新密钥签，旧密钥在宽限期内还能验证
+func RotateJWTKey() (JWTKey, error) {
+	jwtKeyLock.Lock()
+	defer jwtKeyLock.Unlock()
+
+	if jwtKeyFromConfig {
+		return JWTKey{}, errors.New("JWT 密钥来自配置，请修改 auth.jwtSecret（VAN_NAV_JWT_SECRET）后重启，并把旧值放到 auth.jwtPreviousSecret（VAN_NAV_JWT_PREVIOUS_SECRET）")
+	}
+	now := time.Now()
+	expiresAt := now.Add(JWTKeyGracePeriod)
+	old := jwtKeys.Current
+	old.ExpiresAt = &expiresAt
+
+	// 顺便清理已经过期的旧密钥
+	previous := []JWTKey{old}
+	for _, key := range jwtKeys.Previous {
+		if key.ExpiresAt == nil || key.ExpiresAt.After(now) {
+			previous = append(previous, key)
+		}
+	}
+	backup := jwtKeys
+	jwtKeys = jwtKeyStore{Current: newJWTKey(RandomJWTKey()), Previous: previous}
+	if err := saveJWTKeys(); err != nil {
+		jwtKeys = backup
+		return JWTKey{}, err
+	}
+	logger.LogInfo("JWT 密钥已轮换, 新 kid: %s, 旧 kid: %s 将于 %s 失效", jwtKeys.Current.Kid, old.Kid, expiresAt.Format("2006-01-02 15:04:05"))
+	return publicJWTKey(jwtKeys.Current), nil
+}
+
+// 去掉密钥本身，给接口展示用
+func publicJWTKey(key JWTKey) JWTKey {
+	key.Secret = ""
+	return key
+}
+
+// 列出当前和仍在宽限期内的密钥（不含密钥内容）
+func GetJWTKeys() []JWTKey {
+	jwtKeyLock.RLock()
+	defer jwtKeyLock.RUnlock()
+	now := time.Now()
+	result := []JWTKey{publicJWTKey(jwtKeys.Current)}
+	for _, key := range jwtKeys.Previous {
+		if key.ExpiresAt == nil || key.ExpiresAt.After(now) {
+			result = append(result, publicJWTKey(key))
+		}
+	}
+	return result
+}
+
+func currentJWTKey() JWTKey {
+	jwtKeyLock.RLock()
+	defer jwtKeyLock.RUnlock()
+	return jwtKeys.Current
+}
+
+// 根据 kid 找验证用的密钥，没带 kid 的按当前密钥算
+func findJWTKey(kid string) ([]byte, error) {
+	jwtKeyLock.RLock()
+	defer jwtKeyLock.RUnlock()
+	if kid == "" || kid == jwtKeys.Current.Kid {
+		return []byte(jwtKeys.Current.Secret), nil
+	}
+	for _, key := range jwtKeys.Previous {
+		if key.Kid != kid {
+			continue
+		}
+		if key.ExpiresAt != nil && key.ExpiresAt.Before(time.Now()) {
+			return nil, errors.New("签名密钥已过期")
+		}
+		return []byte(key.Secret), nil
+	}
+	return nil, errors.New("未知的签名密钥")
+}
+
+func signWithCurrentKey(claims jwt.MapClaims) (string, error) {
+	key := currentJWTKey()
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	token.Header["kid"] = key.Kid
+	return token.SignedString([]byte(key.Secret))
+}
+
+// 登录会话的有效期
+var SessionLifetime = time.Hour * 24 * 30
+
+// 生成会话 id，作为 jwt 的 jti
+func GenerateJti() string {
+	bytes := make([]byte, 16)
+	if _, err := rand.Read(bytes); err != nil {
+		panic(fmt.Sprintf("生成会话 id 失败: %v", err))
+	}
+	return hex.EncodeToString(bytes)
+}
+
+// 签名一个 JTW，jti 对应 nav_session 表里的一条会话
+func SignJWT(user types.User, jti string, expiresAt time.Time) (string, error) {
+	return signWithCurrentKey(jwt.MapClaims{
 		"name": user.Name,
 		"id":   user.Id,
-		"exp":  time.Now().Add(time.Hour * 24 * 30).Unix(),
+		"jti":  jti,
+		"exp":  expiresAt.Unix(),
 	})
-	tokenString, err := token.SignedString([]byte(jwtSecret))
-	return tokenString, err
 }
 
-// 签名一个 JTW
-func SignJWTForAPI(tokenName string, tokenId int) (string, error) {
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"name": tokenName,
-		"id":   tokenId,
-		"exp":  time.Now().Add(time.Hour * 24 * 365 * 100).Unix(),
+// 开启两步验证的用户密码正确后先拿到预登录 token，只能用来提交验证码
+var PreAuthLifetime = time.Minute * 5
+
+// 带 purpose 的 token 都不是登录会话，中间件会拒绝
+const PreAuthPurpose = "totp"
+
+func SignPreAuthJWT(user types.User) (string, error) {
+	return signWithCurrentKey(jwt.MapClaims{
+		"id":      user.Id,
+		"purpose": PreAuthPurpose,
+		"exp":     time.Now().Add(PreAuthLifetime).Unix(),
+	})
+}
+
+// 校验预登录 token，返回用户 id
+func ParsePreAuthJWT(tokenString string) (int, error) {
+	token, err := ParseJWT(tokenString)
+	if err != nil || !token.Valid {
+		return 0, errors.New("预登录已失效，请重新登录")
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || claims["purpose"] != PreAuthPurpose {
+		return 0, errors.New("预登录已失效，请重新登录")
+	}
+	id, _ := claims["id"].(float64)
+	return int(id), nil
+}
+
+// 分享链接的 token，jti 对应 nav_share 表里的一条记录，吊销后立即失效
+const SharePurpose = "share"
+
+func SignShareJWT(id string, expiresAt time.Time) (string, error) {
+	return signWithCurrentKey(jwt.MapClaims{
+		"jti":     id,
+		"purpose": SharePurpose,
+		"exp":     expiresAt.Unix(),
 	})
-	tokenString, err := token.SignedString([]byte(jwtSecret))
-	return tokenString, err
+}
+
+// 校验分享 token 的签名和有效期，返回分享 id
+func ParseShareJWT(tokenString string) (string, error) {
+	token, err := ParseJWT(tokenString)
+	if err != nil || !token.Valid {
+		return "", errors.New("分享链接无效或已过期")
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || claims["purpose"] != SharePurpose {
+		return "", errors.New("分享链接无效或已过期")
+	}
+	id, _ := claims["jti"].(string)
+	if id == "" {
+		return "", errors.New("分享链接无效或已过期")
+	}
+	return id, nil
 }
 
 // 解密一个 JTW
 func ParseJWT(tokenString string) (*jwt.Token, error) {
 	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (i interface{}, e error) {
-		return jwtSecret, nil
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("不支持的签名算法: %v", token.Header["alg"])
+		}
+		kid, _ := token.Header["kid"].(string)
+		return findJWTKey(kid)
 	})
 	return token, err
 }
 
+// 是否已登录，能看到隐藏的内容。登录信息由 middleware.Authenticate 解析后放在上下文里，
+// api token 需要有 read 权限
 func IsLogin(c *gin.Context) bool {
-	rawToken := c.Request.Header.Get("Authorization")
-	if rawToken == "" {
-		return false
+	if _, ok := c.Get("uid"); ok {
+		return true
+	}
+	for _, scope := range c.GetStringSlice("scopes") {
+		if scope == types.ScopeAll || scope == types.ScopeRead {
+			return true
+		}
 	}
-	token, err := ParseJWT(rawToken)
-	return err == nil && token.Valid
+	return false
 }
@@ -0,0 +1,44 @@
+package utils
+
+import (
+	"crypto/subtle"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// 密码哈希的版本前缀，以后换算法时靠它区分
+const passwordHashPrefix = "$vn1$"
+
+// 用户不存在时也跑一遍 bcrypt，避免通过响应时间判断用户名是否存在
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("van-nav-dummy-password"), bcrypt.DefaultCost)
+
+// 对明文密码做哈希，结果带版本前缀
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return passwordHashPrefix + string(hash), nil
+}
+
+// 是否已经是哈希过的密码
+func IsPasswordHashed(stored string) bool {
+	return strings.HasPrefix(stored, passwordHashPrefix)
+}
+
+// 校验密码。第二个返回值表示库里存的还是明文，校验通过后需要升级成哈希
+func VerifyPassword(stored string, password string) (ok bool, needUpgrade bool) {
+	if IsPasswordHashed(stored) {
+		hash := strings.TrimPrefix(stored, passwordHashPrefix)
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil, false
+	}
+	// 老数据是明文
+	ok = stored != "" && subtle.ConstantTimeCompare([]byte(stored), []byte(password)) == 1
+	return ok, ok
+}
+
+// 用户不存在时调用，消耗和正常校验差不多的时间
+func FakeVerifyPassword(password string) {
+	bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(password))
+}
@@ -0,0 +1,259 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// S3Client 兼容 S3 的对象存储（AWS S3、MinIO、Cloudflare R2 等），只实现上传、列出和删除，
+// 请求用 AWS Signature Version 4 签名
+type S3Client struct {
+	Endpoint  string // 形如 https://s3.amazonaws.com 或 http://127.0.0.1:9000
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	// 为 true 时用 endpoint/bucket/key 的形式，MinIO 一般要这样；否则用 bucket.endpoint/key
+	PathStyle bool
+	Client    *http.Client
+}
+
+const s3TimeLayout = "20060102T150405Z"
+
+func (s *S3Client) httpClient() *http.Client {
+	if s.Client != nil {
+		return s.Client
+	}
+	return http.DefaultClient
+}
+
+// 对象的地址，key 为空时是 bucket 本身
+func (s *S3Client) objectUrl(key string) (*url.URL, error) {
+	u, err := url.Parse(strings.TrimSuffix(s.Endpoint, "/"))
+	if err != nil {
+		return nil, err
+	}
+	if s.PathStyle {
+		u.Path += "/" + s.Bucket + "/" + key
+	} else {
+		u.Host = s.Bucket + "." + u.Host
+		u.Path += "/" + key
+	}
+	return u, nil
+}
+
+// S3 要求的编码：除了字母、数字和 -_.~ 都要编码，路径里的 / 保留
+func s3Escape(value string, keepSlash bool) string {
+	var builder strings.Builder
+	for _, b := range []byte(value) {
+		switch {
+		case 'A' <= b && b <= 'Z', 'a' <= b && b <= 'z', '0' <= b && b <= '9',
+			b == '-', b == '_', b == '.', b == '~', keepSlash && b == '/':
+			builder.WriteByte(b)
+		default:
+			fmt.Fprintf(&builder, "%%%02X", b)
+		}
+	}
+	return builder.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// 给请求签名，payloadHash 是请求体的 sha256，十六进制
+func (s *S3Client) sign(req *http.Request, payloadHash string, now time.Time) {
+	amzDate := now.UTC().Format(s3TimeLayout)
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	// 签名的头：host 和所有 x-amz-*，按名称排序
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		lower := strings.ToLower(name)
+		if strings.HasPrefix(lower, "x-amz-") || lower == "content-type" {
+			headers[lower] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	// 查询参数按名称排序，名称和值都要编码
+	query := req.URL.Query()
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	params := make([]string, 0, len(keys))
+	for _, key := range keys {
+		values := query[key]
+		sort.Strings(values)
+		for _, value := range values {
+			params = append(params, s3Escape(key, false)+"="+s3Escape(value, false))
+		}
+	}
+
+	path := req.URL.Path
+	if path == "" {
+		path = "/"
+	}
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		s3Escape(path, true),
+		strings.Join(params, "&"),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + s.Region + "/s3/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+	key := hmacSHA256([]byte("AWS4"+s.SecretKey), date)
+	key = hmacSHA256(key, s.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.AccessKey, scope, signedHeaders, signature))
+}
+
+// 发请求，不是 2xx 时把 S3 返回的错误信息带上
+func (s *S3Client) do(req *http.Request, payloadHash string) (*http.Response, error) {
+	s.sign(req, payloadHash, time.Now())
+	res, err := s.httpClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode/100 != 2 {
+		defer res.Body.Close()
+		var s3Error struct {
+			Code    string `xml:"Code"`
+			Message string `xml:"Message"`
+		}
+		body, _ := ioutil.ReadAll(io.LimitReader(res.Body, 64*1024))
+		if xml.Unmarshal(body, &s3Error) == nil && s3Error.Code != "" {
+			return nil, fmt.Errorf("S3 返回 %d %s: %s", res.StatusCode, s3Error.Code, s3Error.Message)
+		}
+		return nil, fmt.Errorf("S3 返回 %d", res.StatusCode)
+	}
+	return res, nil
+}
+
+// 空请求体的 sha256
+const s3EmptyHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// PutFile 把本地文件上传成 key
+func (s *S3Client) PutFile(key string, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	size, err := io.Copy(hash, file)
+	if err != nil {
+		return err
+	}
+	if _, err = file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	u, err := s.objectUrl(key)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPut, u.String(), file)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-Type", "application/octet-stream")
+	res, err := s.do(req, hex.EncodeToString(hash.Sum(nil)))
+	if err != nil {
+		return err
+	}
+	return res.Body.Close()
+}
+
+// List 列出以 prefix 开头的所有 key
+func (s *S3Client) List(prefix string) ([]string, error) {
+	keys := make([]string, 0)
+	token := ""
+	for {
+		u, err := s.objectUrl("")
+		if err != nil {
+			return nil, err
+		}
+		query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		u.RawQuery = strings.ReplaceAll(query.Encode(), "+", "%20")
+		req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+		if err != nil {
+			return nil, err
+		}
+		res, err := s.do(req, s3EmptyHash)
+		if err != nil {
+			return nil, err
+		}
+		var result struct {
+			Contents []struct {
+				Key string `xml:"Key"`
+			} `xml:"Contents"`
+			IsTruncated           bool   `xml:"IsTruncated"`
+			NextContinuationToken string `xml:"NextContinuationToken"`
+		}
+		err = xml.NewDecoder(res.Body).Decode(&result)
+		res.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		for _, content := range result.Contents {
+			keys = append(keys, content.Key)
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return keys, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+func (s *S3Client) Delete(key string) error {
+	u, err := s.objectUrl(key)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodDelete, u.String(), nil)
+	if err != nil {
+		return err
+	}
+	res, err := s.do(req, s3EmptyHash)
+	if err != nil {
+		return err
+	}
+	return res.Body.Close()
+}
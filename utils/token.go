@@ -0,0 +1,26 @@
+package utils
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+)
+
+// api token 的前缀，方便在日志和代码仓库里认出来
+const apiTokenPrefix = "vn_"
+
+// 生成一个新的 api token 明文，只在创建时返回一次
+func GenerateApiToken() string {
+	bytes := make([]byte, 32)
+	if _, err := rand.Read(bytes); err != nil {
+		panic(fmt.Sprintf("生成 api token 失败: %v", err))
+	}
+	return apiTokenPrefix + hex.EncodeToString(bytes)
+}
+
+// 库里只存 token 的哈希。token 本身是高熵随机串，用 sha256 就够了
+func HashApiToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,35 @@
+package utils
+
+import (
+	"net"
+	"net/url"
+	"strings"
+)
+
+// NormalizeUrl 把网址整理成判断重复用的样子：不区分 http 和 https，域名不区分大小写，
+// 去掉默认端口、末尾的 / 和 # 后面的部分，没写协议的当成 http。解析不了的去掉首尾空格原样返回
+func NormalizeUrl(raw string) string {
+	raw = strings.TrimSpace(raw)
+	u, err := url.Parse(raw)
+	if err == nil && u.Scheme == "" && u.Host == "" {
+		u, err = url.Parse("http://" + raw)
+	}
+	if err != nil || u.Host == "" {
+		return raw
+	}
+	scheme := strings.ToLower(u.Scheme)
+	if scheme == "https" {
+		scheme = "http"
+	}
+	host := strings.ToLower(u.Hostname())
+	if port := u.Port(); port != "" && port != "80" && port != "443" {
+		host = net.JoinHostPort(host, port)
+	} else if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	result := scheme + "://" + host + strings.TrimRight(u.EscapedPath(), "/")
+	if u.RawQuery != "" {
+		result += "?" + u.RawQuery
+	}
+	return result
+}
@@ -4,7 +4,9 @@ import (
 	"crypto/tls"
 	"database/sql"
 	"encoding/base64"
+	"errors"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"os"
 	"runtime/debug"
@@ -38,6 +40,64 @@ func In(target string, str_array []string) bool {
 	return false
 }
 
+// 按逗号拆分并去掉空白和空项
+func SplitAndTrim(str string) []string {
+	results := make([]string, 0)
+	for _, item := range strings.Split(str, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			results = append(results, item)
+		}
+	}
+	return results
+}
+
+// 解析 IP 或网段列表，单个 IP 当成 /32（IPv6 是 /128）
+func ParseNetworks(items []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(items))
+	for _, item := range items {
+		cidr := item
+		if !strings.Contains(cidr, "/") {
+			if strings.Contains(cidr, ":") {
+				cidr += "/128"
+			} else {
+				cidr += "/32"
+			}
+		}
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, errors.New("无效的 IP 或网段: " + item)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// ip 是否在其中一个网段里
+func ContainsIP(networks []*net.IPNet, ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// UnixToTime 把库里存的 unix 秒转成时间，NULL 返回 nil
+func UnixToTime(value sql.NullInt64) *time.Time {
+	if !value.Valid {
+		return nil
+	}
+	t := time.Unix(value.Int64, 0)
+	return &t
+}
+
+// 下载图标的超时时间
+var ImgFetchTimeout = 10 * time.Second
+
 func GetImgBase64FromUrl(url string) string {
 	imgUrl := url
 	//获取远端图片
@@ -51,6 +111,7 @@ func GetImgBase64FromUrl(url string) string {
 		Transport: &http.Transport{
 			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
 		},
+		Timeout: ImgFetchTimeout,
 	}
 	res, err := client.Do(req)
 	if err != nil {
@@ -0,0 +1,143 @@
+package utils
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+)
+
+// WebDAVClient 往 WebDAV 的一个目录里上传、列出和删除文件，用 basic 认证
+type WebDAVClient struct {
+	Url      string // 目录的地址，形如 https://dav.example.com/backups/
+	Username string
+	Password string
+	Client   *http.Client
+}
+
+func (w *WebDAVClient) httpClient() *http.Client {
+	if w.Client != nil {
+		return w.Client
+	}
+	return http.DefaultClient
+}
+
+// name 为空时是目录本身
+func (w *WebDAVClient) fileUrl(name string) string {
+	return strings.TrimSuffix(w.Url, "/") + "/" + url.PathEscape(name)
+}
+
+func (w *WebDAVClient) do(method string, target string, body io.Reader, size int64, header map[string]string) (*http.Response, error) {
+	req, err := http.NewRequest(method, target, body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.ContentLength = size
+	}
+	if w.Username != "" || w.Password != "" {
+		req.SetBasicAuth(w.Username, w.Password)
+	}
+	for name, value := range header {
+		req.Header.Set(name, value)
+	}
+	return w.httpClient().Do(req)
+}
+
+func webdavError(method string, res *http.Response) error {
+	defer res.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(res.Body, 64*1024))
+	return fmt.Errorf("WebDAV %s 返回 %d", method, res.StatusCode)
+}
+
+// 目录不存在时创建，已经存在时服务器返回 405
+func (w *WebDAVClient) ensureDir() error {
+	res, err := w.do("MKCOL", strings.TrimSuffix(w.Url, "/")+"/", nil, 0, nil)
+	if err != nil {
+		return err
+	}
+	if res.StatusCode/100 == 2 || res.StatusCode == http.StatusMethodNotAllowed {
+		res.Body.Close()
+		return nil
+	}
+	return webdavError("MKCOL", res)
+}
+
+// PutFile 把本地文件上传到目录下，名称是 name
+func (w *WebDAVClient) PutFile(name string, filePath string) error {
+	if err := w.ensureDir(); err != nil {
+		return err
+	}
+	file, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	res, err := w.do(http.MethodPut, w.fileUrl(name), file, info.Size(), map[string]string{"Content-Type": "application/octet-stream"})
+	if err != nil {
+		return err
+	}
+	if res.StatusCode/100 != 2 {
+		return webdavError("PUT", res)
+	}
+	return res.Body.Close()
+}
+
+// List 目录下的文件名，不包括子目录
+func (w *WebDAVClient) List() ([]string, error) {
+	body := `<?xml version="1.0" encoding="utf-8"?><D:propfind xmlns:D="DAV:"><D:prop><D:resourcetype/></D:prop></D:propfind>`
+	res, err := w.do("PROPFIND", strings.TrimSuffix(w.Url, "/")+"/", strings.NewReader(body), int64(len(body)),
+		map[string]string{"Depth": "1", "Content-Type": "application/xml; charset=utf-8"})
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode == http.StatusNotFound {
+		res.Body.Close()
+		return []string{}, nil
+	}
+	if res.StatusCode != http.StatusMultiStatus {
+		return nil, webdavError("PROPFIND", res)
+	}
+	defer res.Body.Close()
+	var result struct {
+		Responses []struct {
+			Href       string    `xml:"href"`
+			Collection *struct{} `xml:"propstat>prop>resourcetype>collection"`
+		} `xml:"response"`
+	}
+	if err = xml.NewDecoder(res.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	names := make([]string, 0)
+	for _, response := range result.Responses {
+		if response.Collection != nil {
+			continue
+		}
+		href, err := url.PathUnescape(response.Href)
+		if err != nil {
+			href = response.Href
+		}
+		names = append(names, path.Base(strings.TrimSuffix(href, "/")))
+	}
+	return names, nil
+}
+
+func (w *WebDAVClient) Delete(name string) error {
+	res, err := w.do(http.MethodDelete, w.fileUrl(name), nil, 0, nil)
+	if err != nil {
+		return err
+	}
+	if res.StatusCode/100 != 2 && res.StatusCode != http.StatusNotFound {
+		return webdavError("DELETE", res)
+	}
+	return res.Body.Close()
+}
@@ -0,0 +1,105 @@
+package utils
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"golang.org/x/net/webdav"
+)
+
+// 内存里的 WebDAV 服务器，要 basic 认证
+func newTestWebDAV(t *testing.T) (*httptest.Server, webdav.FileSystem) {
+	fs := webdav.NewMemFS()
+	handler := &webdav.Handler{FileSystem: fs, LockSystem: webdav.NewMemLS()}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, password, ok := r.BasicAuth(); !ok || user != "nav" || password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+	return server, fs
+}
+
+func writeTestFile(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestWebDAVClient(t *testing.T) {
+	server, fs := newTestWebDAV(t)
+	client := &WebDAVClient{Url: server.URL + "/backups/", Username: "nav", Password: "secret"}
+
+	// 目录还不存在
+	names, err := client.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 0 {
+		t.Fatalf("空目录里有 %v", names)
+	}
+
+	// 第一次上传时创建目录，之后 MKCOL 返回 405 也能继续上传
+	path := writeTestFile(t, "backup")
+	for _, name := range []string{"nav-1.db", "备份 2.db"} {
+		if err = client.PutFile(name, path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	info, err := fs.Stat(context.Background(), "/backups/备份 2.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != int64(len("backup")) {
+		t.Fatalf("上传的文件有 %d 字节", info.Size())
+	}
+
+	// 子目录不算
+	if err = fs.Mkdir(context.Background(), "/backups/sub", 0755); err != nil {
+		t.Fatal(err)
+	}
+	names, err = client.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(names)
+	if !reflect.DeepEqual(names, []string{"nav-1.db", "备份 2.db"}) {
+		t.Fatalf("列出的文件是 %v", names)
+	}
+
+	if err = client.Delete("备份 2.db"); err != nil {
+		t.Fatal(err)
+	}
+	// 已经不存在的也算删除成功
+	if err = client.Delete("备份 2.db"); err != nil {
+		t.Fatal(err)
+	}
+	names, err = client.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(names, []string{"nav-1.db"}) {
+		t.Fatalf("删除后列出的文件是 %v", names)
+	}
+}
+
+func TestWebDAVClientUnauthorized(t *testing.T) {
+	server, _ := newTestWebDAV(t)
+	client := &WebDAVClient{Url: server.URL + "/backups", Username: "nav", Password: "wrong"}
+	if err := client.PutFile("nav-1.db", writeTestFile(t, "backup")); err == nil {
+		t.Fatal("密码不对时上传应该失败")
+	}
+	if _, err := client.List(); err == nil {
+		t.Fatal("密码不对时列出应该失败")
+	}
+}